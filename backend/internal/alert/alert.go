@@ -0,0 +1,143 @@
+// Package alert notifies accounts when their number of users crosses one of their usage alert thresholds
+package alert
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/ibeckermayer/teleport-interview/backend/internal/database"
+	"github.com/ibeckermayer/teleport-interview/backend/internal/model"
+	"github.com/ibeckermayer/teleport-interview/backend/internal/notify"
+)
+
+// Alerter checks accounts' usage against their alert thresholds. The app should only ever create one
+// of these and pass it around as a pointer
+type Alerter struct {
+	db       *database.Database
+	notifier notify.Notifier
+
+	locks sync.Map // account ID to the *sync.Mutex that serializes its checks
+
+	mtx    sync.Mutex
+	failed map[string]bool // accounts with alerts whose notifications couldn't be sent, to be checked again by Retry
+}
+
+// NewAlerter creates a new *Alerter that sends notifications through notifier
+func NewAlerter(db *database.Database, notifier notify.Notifier) *Alerter {
+	return &Alerter{db: db, notifier: notifier, failed: make(map[string]bool)}
+}
+
+// Check compares accountID's current number of users to its plan's limit and notifies the account's
+// owners of each threshold that has been crossed since it was last checked. Should be called
+// whenever an account's number of users or plan changes.
+//
+// An alert is only marked as fired once its notification has been sent to every owner. If sending fails,
+// it's left unfired and the account is checked again by Retry, so an owner that was already notified
+// may be notified twice, but none are missed.
+func (a *Alerter) Check(accountID string) error {
+	// Checks of the same account are serialized so that they don't both send an alert before either marks it fired
+	lock, _ := a.locks.LoadOrStore(accountID, &sync.Mutex{})
+	lock.(*sync.Mutex).Lock()
+	defer lock.(*sync.Mutex).Unlock()
+
+	account, err := a.db.GetAccount(accountID)
+	if err == sql.ErrNoRows {
+		// The account has been purged, there's nobody left to notify
+		a.setFailed(accountID, false)
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	totalUsers, err := a.db.CountUsers(accountID)
+	if err != nil {
+		return err
+	}
+
+	maxUsers := model.PlanMaxUsers[account.Plan]
+	due, err := a.db.DueUsageAlerts(accountID, totalUsers, maxUsers)
+	if err != nil {
+		return err
+	}
+
+	if len(due) == 0 {
+		a.setFailed(accountID, false)
+		return nil
+	}
+	members, err := a.db.GetMembers(accountID)
+	if err != nil {
+		return err
+	}
+
+	for _, alert := range due {
+		for _, m := range members {
+			if m.Role != model.RoleOwner {
+				continue
+			}
+			if err := a.notifier.Notify(usageNotification(account, m.Email, alert.Percent, totalUsers, maxUsers)); err != nil {
+				a.setFailed(accountID, true)
+				return err
+			}
+		}
+		if _, err := a.db.FireUsageAlert(accountID, alert.Percent, time.Now()); err != nil {
+			return err
+		}
+	}
+
+	a.setFailed(accountID, false)
+	return nil
+}
+
+// setFailed records whether accountID has alerts whose notifications couldn't be sent
+func (a *Alerter) setFailed(accountID string, failed bool) {
+	a.mtx.Lock()
+	defer a.mtx.Unlock()
+	if failed {
+		a.failed[accountID] = true
+	} else {
+		delete(a.failed, accountID)
+	}
+}
+
+// Retry checks every account whose notifications couldn't be sent when it was last checked, returning the
+// first error. Accounts that fail again stay queued.
+func (a *Alerter) Retry() error {
+	a.mtx.Lock()
+	accountIDs := make([]string, 0, len(a.failed))
+	for accountID := range a.failed {
+		accountIDs = append(accountIDs, accountID)
+	}
+	a.mtx.Unlock()
+
+	var firstErr error
+	for _, accountID := range accountIDs {
+		if err := a.Check(accountID); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// CheckAsync runs Check in the background, logging any error, so that callers handling requests
+// don't wait on the notifier
+func (a *Alerter) CheckAsync(accountID string) {
+	go func() {
+		if err := a.Check(accountID); err != nil {
+			log.Printf("failed to check usage alerts for account_id=%v: %v", accountID, err)
+		}
+	}()
+}
+
+func usageNotification(account model.Account, to string, percent, totalUsers, maxUsers int) notify.Notification {
+	subject := fmt.Sprintf("Your account has reached %v%% of its user limit", percent)
+	body := fmt.Sprintf("Your account now has %v users, %v%% of the %v users allowed on the %v plan.\n", totalUsers, percent, maxUsers, account.Plan)
+	if totalUsers > maxUsers {
+		body += "Users beyond the limit are inactive until you upgrade your plan.\n"
+	} else if account.Plan == model.FREE {
+		body += "Upgrade to the ENTERPRISE plan from your dashboard to raise the limit.\n"
+	}
+	return notify.Notification{To: to, Subject: subject, Body: body}
+}
@@ -0,0 +1,74 @@
+package alert
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/ibeckermayer/teleport-interview/backend/internal/auth"
+	"github.com/ibeckermayer/teleport-interview/backend/internal/database"
+	"github.com/ibeckermayer/teleport-interview/backend/internal/notify"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// flakyNotifier fails to send notifications while down, and records those it sends
+type flakyNotifier struct {
+	down bool
+	sent []notify.Notification
+}
+
+func (fn *flakyNotifier) Notify(n notify.Notification) error {
+	if fn.down {
+		return errors.New("notifier is down")
+	}
+	fn.sent = append(fn.sent, n)
+	return nil
+}
+
+func TestCheckRetriesFailedNotifications(t *testing.T) {
+	db, err := database.New(database.Config{Env: "test", File: filepath.Join(t.TempDir(), "test.db"), PasswordHasher: auth.PasswordHasher{BcryptCost: 4}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.CreateAccount("acct", "owner@example.com", "correct horse battery staple"); err != nil {
+		t.Fatal(err)
+	}
+	// A single user reaches 1% of any plan's limit
+	if err := db.SetUsageAlerts("acct", []int{1}); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, _, err := db.CreateUser("u1", "acct"); err != nil {
+		t.Fatal(err)
+	}
+
+	notifier := &flakyNotifier{down: true}
+	a := NewAlerter(db, notifier)
+	if err := a.Check("acct"); err == nil {
+		t.Fatal("expected the check to fail while the notifier is down")
+	}
+	if alerts, err := db.GetUsageAlerts("acct"); err != nil || alerts[0].Fired {
+		t.Fatalf("expected the alert not to be marked fired when it couldn't be sent but got %+v, %v", alerts, err)
+	}
+
+	notifier.down = false
+	if err := a.Retry(); err != nil {
+		t.Fatal(err)
+	}
+	if len(notifier.sent) != 1 || notifier.sent[0].To != "owner@example.com" {
+		t.Fatalf("expected the owner to be notified once the notifier was back but got %+v", notifier.sent)
+	}
+	if alerts, err := db.GetUsageAlerts("acct"); err != nil || !alerts[0].Fired {
+		t.Fatalf("expected the alert to be marked fired once it was sent but got %+v, %v", alerts, err)
+	}
+
+	// Once sent, the alert isn't sent again, and the account isn't retried
+	if err := a.Check("acct"); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.Retry(); err != nil {
+		t.Fatal(err)
+	}
+	if len(notifier.sent) != 1 {
+		t.Fatalf("expected the alert to only be sent once but got %+v", notifier.sent)
+	}
+}
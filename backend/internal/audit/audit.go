@@ -0,0 +1,178 @@
+// Package audit records security relevant events (logins, logouts, rejected credentials and account changes)
+// to an append-only log. Every event carries a SHA-256 hash of its own fields and of the previous event's hash,
+// so changing, removing or reordering any event breaks the chain from that point on, which Verify detects.
+package audit
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/ibeckermayer/teleport-interview/backend/internal/model"
+	"github.com/ibeckermayer/teleport-interview/backend/internal/util"
+)
+
+const (
+	// maxFieldLength is the longest actor, detail or user agent recorded, longer ones are truncated
+	maxFieldLength = 256
+
+	// verifyBatchSize is how many events Verify reads at a time
+	verifyBatchSize = 1000
+)
+
+// ErrChainBroken is returned by Verify when an event's hash doesn't match its contents or the event before it
+var ErrChainBroken = errors.New("audit log hash chain is broken")
+
+// Store is where a Log keeps its events
+type Store interface {
+	// LastAuditEvent returns the most recent event, or sql.ErrNoRows if there are none
+	LastAuditEvent() (model.AuditEvent, error)
+	InsertAuditEvent(e model.AuditEvent) error
+	// GetAuditEventsAfter returns up to limit events with a seq greater than seq, oldest first
+	GetAuditEventsAfter(seq int64, limit int) ([]model.AuditEvent, error)
+}
+
+// Entry is what's recorded about an attempt, the rest of an event is filled in by Record
+type Entry struct {
+	AccountID string // empty if the attempt couldn't be tied to an account
+	Actor     string
+	Action    model.AuditAction
+	Outcome   model.AuditOutcome
+	Detail    string
+}
+
+// Log is an append-only, hash chained audit log. Appends are serialized so that each event is chained to
+// the one before it, so the app should only ever create one of these and pass it around as a pointer.
+// A nil *Log records nothing.
+type Log struct {
+	store Store
+	mtx   sync.Mutex // serializes appends, and protects head and verified
+
+	head     *model.AuditEvent // the last event appended, nil until it's been read from store
+	verified model.AuditEvent  // the last event in the chain the last time Verify checked it
+}
+
+// NewLog creates a new *Log that keeps its events in store
+func NewLog(store Store) *Log {
+	return &Log{store: store}
+}
+
+// truncate shortens s to at most maxFieldLength bytes
+func truncate(s string) string {
+	if len(s) > maxFieldLength {
+		return s[:maxFieldLength]
+	}
+	return s
+}
+
+// Record appends an event for e, made by the client that sent r. Failing to record an event is logged
+// but shouldn't fail the request that caused it.
+func (l *Log) Record(r *http.Request, e Entry) {
+	if l == nil {
+		return
+	}
+	event := model.AuditEvent{
+		AccountID: e.AccountID,
+		Actor:     truncate(e.Actor),
+		Action:    e.Action,
+		Outcome:   e.Outcome,
+		Detail:    truncate(e.Detail),
+		IP:        util.ClientIP(r),
+		UserAgent: truncate(r.UserAgent()),
+		CreatedAt: time.Now().UTC(),
+	}
+	if _, err := l.Append(event); err != nil {
+		log.Printf("failed to record %v %v audit event for account_id=%v: %v", e.Action, e.Outcome, e.AccountID, err)
+	}
+}
+
+// Append chains e to the last event in the log and saves it, returning it with its Seq, PrevHash and Hash set
+func (l *Log) Append(e model.AuditEvent) (model.AuditEvent, error) {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+
+	if l.head == nil {
+		last, err := l.store.LastAuditEvent()
+		if err != nil && err != sql.ErrNoRows {
+			return model.AuditEvent{}, err
+		}
+		l.head = &last
+	}
+
+	e.Seq = l.head.Seq + 1
+	e.PrevHash = l.head.Hash
+	e.Hash = Hash(e)
+	if err := l.store.InsertAuditEvent(e); err != nil {
+		// The head may be stale if something else appended, so read it again next time
+		l.head = nil
+		return model.AuditEvent{}, err
+	}
+	l.head = &e
+	return e, nil
+}
+
+// writeField writes s to h prefixed with its length, so that no two different sets of fields hash the same
+func writeField(h hash.Hash, s string) {
+	fmt.Fprintf(h, "%d:%s\n", len(s), s)
+}
+
+// Hash returns the hex encoded SHA-256 of e's fields, including its PrevHash but not its Hash
+func Hash(e model.AuditEvent) string {
+	h := sha256.New()
+	fmt.Fprintf(h, "%d\n", e.Seq)
+	writeField(h, e.AccountID)
+	writeField(h, e.Actor)
+	writeField(h, string(e.Action))
+	writeField(h, string(e.Outcome))
+	writeField(h, e.Detail)
+	writeField(h, e.IP)
+	writeField(h, e.UserAgent)
+	writeField(h, e.CreatedAt.UTC().Format(time.RFC3339Nano))
+	writeField(h, e.PrevHash)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// Verify checks the hash chain of every event in the log, returning the last event it checked. Returns an error
+// wrapping ErrChainBroken at the first event that doesn't match, or if the last event a previous call checked
+// is no longer in the chain. Removing the most recent events can only be detected that way, by comparing them
+// with a copy kept elsewhere, so callers should also log what Verify returns.
+func (l *Log) Verify() (model.AuditEvent, error) {
+	l.mtx.Lock()
+	verified := l.verified
+	l.mtx.Unlock()
+
+	prev := model.AuditEvent{}
+	for {
+		events, err := l.store.GetAuditEventsAfter(prev.Seq, verifyBatchSize)
+		if err != nil {
+			return prev, err
+		}
+		for _, e := range events {
+			if e.Seq != prev.Seq+1 || e.PrevHash != prev.Hash || Hash(e) != e.Hash {
+				return prev, fmt.Errorf("%w at seq=%v", ErrChainBroken, e.Seq)
+			}
+			if e.Seq == verified.Seq && e.Hash != verified.Hash {
+				return prev, fmt.Errorf("%w at seq=%v, it was replaced since it was last verified", ErrChainBroken, e.Seq)
+			}
+			prev = e
+		}
+		if len(events) < verifyBatchSize {
+			break
+		}
+	}
+	if prev.Seq < verified.Seq {
+		return prev, fmt.Errorf("%w, events after seq=%v were removed", ErrChainBroken, prev.Seq)
+	}
+
+	l.mtx.Lock()
+	l.verified = prev
+	l.mtx.Unlock()
+	return prev, nil
+}
@@ -0,0 +1,128 @@
+package audit
+
+import (
+	"database/sql"
+	"errors"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/ibeckermayer/teleport-interview/backend/internal/model"
+)
+
+// memoryStore is a Store backed by a slice, which tests can tamper with directly
+type memoryStore struct {
+	events []model.AuditEvent
+}
+
+func (s *memoryStore) LastAuditEvent() (model.AuditEvent, error) {
+	if len(s.events) == 0 {
+		return model.AuditEvent{}, sql.ErrNoRows
+	}
+	return s.events[len(s.events)-1], nil
+}
+
+func (s *memoryStore) InsertAuditEvent(e model.AuditEvent) error {
+	s.events = append(s.events, e)
+	return nil
+}
+
+func (s *memoryStore) GetAuditEventsAfter(seq int64, limit int) ([]model.AuditEvent, error) {
+	events := []model.AuditEvent{}
+	for _, e := range s.events {
+		if e.Seq > seq && len(events) < limit {
+			events = append(events, e)
+		}
+	}
+	return events, nil
+}
+
+func newTestLog(t *testing.T, n int) (*Log, *memoryStore) {
+	t.Helper()
+	store := &memoryStore{}
+	l := NewLog(store)
+	r := httptest.NewRequest("POST", "/api/login", nil)
+	r.Header.Set("User-Agent", "audit-test")
+	for i := 0; i < n; i++ {
+		l.Record(r, Entry{AccountID: "account", Actor: "a@example.com", Action: model.AuditLogin, Outcome: model.AuditFailure, Detail: "wrong password"})
+	}
+	if len(store.events) != n {
+		t.Fatalf("expected %v events, got %v", n, len(store.events))
+	}
+	return l, store
+}
+
+func TestRecord(t *testing.T) {
+	_, store := newTestLog(t, 3)
+
+	for i, e := range store.events {
+		if e.Seq != int64(i+1) {
+			t.Fatalf("expected seq %v, got %v", i+1, e.Seq)
+		}
+		if e.IP == "" || e.UserAgent != "audit-test" {
+			t.Fatalf("expected the request's IP and user agent, got %q and %q", e.IP, e.UserAgent)
+		}
+		if e.Hash != Hash(e) {
+			t.Fatalf("expected event %v's hash to match its contents", e.Seq)
+		}
+	}
+	if store.events[0].PrevHash != "" || store.events[1].PrevHash != store.events[0].Hash {
+		t.Fatal("expected each event to be chained to the one before it")
+	}
+
+	// A nil log records nothing rather than panicking
+	var l *Log
+	l.Record(httptest.NewRequest("GET", "/", nil), Entry{})
+}
+
+func TestAppendContinuesChain(t *testing.T) {
+	_, store := newTestLog(t, 2)
+
+	// A new log over the same store, e.g. after a restart, continues from the last event
+	l := NewLog(store)
+	e, err := l.Append(model.AuditEvent{Action: model.AuditLogout, Outcome: model.AuditSuccess})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.Seq != 3 || e.PrevHash != store.events[1].Hash {
+		t.Fatalf("expected seq 3 chained to seq 2, got seq %v", e.Seq)
+	}
+	if _, err := l.Verify(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestVerify(t *testing.T) {
+	tests := []struct {
+		name   string
+		tamper func(s *memoryStore)
+	}{
+		{"changed field", func(s *memoryStore) { s.events[1].Outcome = model.AuditSuccess }},
+		{"changed field and hash", func(s *memoryStore) {
+			s.events[1].Detail = "nothing to see here"
+			s.events[1].Hash = Hash(s.events[1])
+		}},
+		{"removed event", func(s *memoryStore) { s.events = append(s.events[:1], s.events[2:]...) }},
+		{"reordered events", func(s *memoryStore) { s.events[1], s.events[2] = s.events[2], s.events[1] }},
+		{"removed latest events", func(s *memoryStore) { s.events = s.events[:2] }},
+		{"replaced latest event", func(s *memoryStore) {
+			last := &s.events[len(s.events)-1]
+			last.Detail = "nothing to see here"
+			last.Hash = Hash(*last)
+		}},
+	}
+	for _, tt := range tests {
+		l, store := newTestLog(t, 4)
+		head, err := l.Verify()
+		if err != nil {
+			t.Fatalf("%v: expected an untouched log to verify, got %v", tt.name, err)
+		}
+		if head.Seq != 4 {
+			t.Fatalf("%v: expected head at seq 4, got %v", tt.name, head.Seq)
+		}
+
+		tt.tamper(store)
+		if _, err := l.Verify(); !errors.Is(err, ErrChainBroken) {
+			t.Errorf("%v: expected ErrChainBroken, got %v", tt.name, err)
+		}
+	}
+}
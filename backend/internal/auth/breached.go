@@ -0,0 +1,143 @@
+package auth
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha1"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// BreachChecker reports whether a password is known to have appeared in a data breach
+type BreachChecker interface {
+	Breached(password string) (bool, error)
+}
+
+// hashPrefixLength is the length of the SHA-1 hex prefix a range lookup is made by, the same as the
+// Have I Been Pwned range API (https://haveibeenpwned.com/API/v3#SearchingPwnedPasswordsByRange)
+const hashPrefixLength = 5
+
+// HashPrefixFile is a BreachChecker backed by a local copy of the Have I Been Pwned passwords list in its
+// "ordered by hash" SHA-1 format: one "HASH:COUNT" line per password, sorted by hash. The file can be many
+// gigabytes, so it's binary searched in place rather than loaded into memory. Like the range API, lookups are
+// made by the first 5 hex characters of a password's hash and the rest of the hash is compared by the caller,
+// so a remote range service could stand in for the file without changing how passwords are checked.
+type HashPrefixFile struct {
+	f    *os.File
+	size int64
+}
+
+// OpenHashPrefixFile opens the passwords list at path
+func OpenHashPrefixFile(path string) (*HashPrefixFile, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &HashPrefixFile{f, info.Size()}, nil
+}
+
+// Close closes the file
+func (h *HashPrefixFile) Close() error {
+	return h.f.Close()
+}
+
+// lineStart returns the offset of the first line starting at or after off
+func (h *HashPrefixFile) lineStart(off int64) (int64, error) {
+	if off == 0 {
+		return 0, nil
+	}
+	// The line starts after the first newline at or after off-1
+	buf := make([]byte, 128)
+	for pos := off - 1; pos < h.size; pos += int64(len(buf)) {
+		n, err := h.f.ReadAt(buf, pos)
+		if i := bytes.IndexByte(buf[:n], '\n'); i >= 0 {
+			return pos + int64(i) + 1, nil
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return 0, err
+		}
+	}
+	return h.size, nil
+}
+
+// prefixAt returns the hash prefix of the line starting at off, or "" if off is the end of the file
+func (h *HashPrefixFile) prefixAt(off int64) (string, error) {
+	buf := make([]byte, hashPrefixLength)
+	n, err := h.f.ReadAt(buf, off)
+	if err != nil && err != io.EOF {
+		return "", err
+	}
+	return strings.ToUpper(string(buf[:n])), nil
+}
+
+// Range returns the hash suffixes (upper case hex) of every password in the file whose SHA-1 hash starts with prefix
+func (h *HashPrefixFile) Range(prefix string) ([]string, error) {
+	prefix = strings.ToUpper(prefix)
+	if len(prefix) != hashPrefixLength {
+		return nil, fmt.Errorf("hash prefix must be %v characters", hashPrefixLength)
+	}
+
+	// Find the first line whose prefix is >= prefix. Whether the line starting at or after an offset is
+	// >= prefix only ever goes from false to true as the offset grows, so it can be binary searched.
+	lo, hi := int64(0), h.size
+	for lo < hi {
+		mid := lo + (hi-lo)/2
+		start, err := h.lineStart(mid)
+		if err != nil {
+			return nil, err
+		}
+		p, err := h.prefixAt(start)
+		if err != nil {
+			return nil, err
+		}
+		if start == h.size || p >= prefix {
+			hi = mid
+		} else {
+			lo = mid + 1
+		}
+	}
+	start, err := h.lineStart(lo)
+	if err != nil {
+		return nil, err
+	}
+
+	var suffixes []string
+	scanner := bufio.NewScanner(io.NewSectionReader(h.f, start, h.size-start))
+	for scanner.Scan() {
+		line := strings.ToUpper(strings.TrimSpace(scanner.Text()))
+		if !strings.HasPrefix(line, prefix) {
+			break
+		}
+		hash := line
+		if i := strings.IndexByte(line, ':'); i >= 0 {
+			hash = line[:i]
+		}
+		suffixes = append(suffixes, hash[hashPrefixLength:])
+	}
+	return suffixes, scanner.Err()
+}
+
+// Breached reports whether password is in the file
+func (h *HashPrefixFile) Breached(password string) (bool, error) {
+	hash := fmt.Sprintf("%X", sha1.Sum([]byte(password)))
+	suffixes, err := h.Range(hash[:hashPrefixLength])
+	if err != nil {
+		return false, err
+	}
+	for _, suffix := range suffixes {
+		if suffix == hash[hashPrefixLength:] {
+			return true, nil
+		}
+	}
+	return false, nil
+}
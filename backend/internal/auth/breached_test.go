@@ -0,0 +1,88 @@
+package auth
+
+import (
+	"crypto/sha1"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// writeHashPrefixFile writes a passwords list in the "ordered by hash" format containing passwords plus
+// enough filler hashes to make the binary search do some work, and returns its path
+func writeHashPrefixFile(t *testing.T, passwords ...string) string {
+	var lines []string
+	for _, p := range passwords {
+		lines = append(lines, fmt.Sprintf("%X:%v", sha1.Sum([]byte(p)), len(p)))
+	}
+	for i := 0; i < 5000; i++ {
+		lines = append(lines, fmt.Sprintf("%X:1", sha1.Sum([]byte(fmt.Sprint("filler", i)))))
+	}
+	sort.Strings(lines)
+
+	f, err := ioutil.TempFile("", "pwned-*.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, err := f.WriteString(strings.Join(lines, "\r\n") + "\r\n"); err != nil {
+		t.Fatal(err)
+	}
+	return f.Name()
+}
+
+func TestHashPrefixFileBreached(t *testing.T) {
+	breached := []string{"password", "hunter2", "correct horse battery staple"}
+	path := writeHashPrefixFile(t, breached...)
+	defer os.Remove(path)
+
+	h, err := OpenHashPrefixFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer h.Close()
+
+	for _, p := range breached {
+		if ok, err := h.Breached(p); err != nil || !ok {
+			t.Fatalf("expected %q to be breached, got %v %v", p, ok, err)
+		}
+	}
+	for _, p := range []string{"not breached", "filler", ""} {
+		if ok, err := h.Breached(p); err != nil || ok {
+			t.Fatalf("expected %q not to be breached, got %v %v", p, ok, err)
+		}
+	}
+}
+
+func TestHashPrefixFileRange(t *testing.T) {
+	path := writeHashPrefixFile(t)
+	defer os.Remove(path)
+
+	h, err := OpenHashPrefixFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer h.Close()
+
+	// Every filler hash is found by its prefix, including the first and last lines of the file
+	for i := 0; i < 5000; i++ {
+		hash := fmt.Sprintf("%X", sha1.Sum([]byte(fmt.Sprint("filler", i))))
+		suffixes, err := h.Range(strings.ToLower(hash[:hashPrefixLength]))
+		if err != nil {
+			t.Fatal(err)
+		}
+		found := false
+		for _, s := range suffixes {
+			found = found || s == hash[hashPrefixLength:]
+		}
+		if !found {
+			t.Fatalf("expected %v in range %v", hash, suffixes)
+		}
+	}
+
+	if _, err := h.Range("ABC"); err == nil {
+		t.Fatal("expected an error for a short prefix")
+	}
+}
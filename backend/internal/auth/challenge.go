@@ -0,0 +1,137 @@
+package auth
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/ibeckermayer/teleport-interview/backend/internal/model"
+)
+
+var (
+	// ErrChallengeDNE is returned when a caller attempts to complete a login challenge that doesn't exist,
+	// has expired or has had too many failed attempts
+	ErrChallengeDNE = errors.New("the login challenge does not exist")
+)
+
+// MaxChallengeAttempts is how many wrong codes a login challenge accepts before it's discarded and
+// the user has to enter their password again
+const MaxChallengeAttempts = 5
+
+// ChallengeID is a 32 byte, base64 encoded, cryptographically secure random string
+type ChallengeID string
+
+// Challenge is the second step of a login by a member with two-factor authentication enabled.
+// It's created once the password has been checked and must be completed with a code before a Session is created.
+type Challenge struct {
+	ChallengeID ChallengeID
+	Member      model.Member
+	Expires     time.Time
+	Attempts    int // failed attempts so far
+}
+
+// ChallengeStore is an in-memory store of pending login challenges. Like SessionManager,
+// the app should only ever create one of these and pass it around as a pointer
+type ChallengeStore struct {
+	store   map[ChallengeID]Challenge
+	timeout time.Duration // absolute timeout for individual challenges
+	mtx     sync.Mutex    // mutex for store
+}
+
+// NewChallengeStore creates a new *ChallengeStore
+func NewChallengeStore(timeout time.Duration) *ChallengeStore {
+	return &ChallengeStore{
+		store:   make(map[ChallengeID]Challenge),
+		timeout: timeout,
+	}
+}
+
+// CreateChallenge creates a new login challenge for member, expiring cs.timeout from now.
+// It will return an error if the system's secure random number generator fails to function correctly.
+func (cs *ChallengeStore) CreateChallenge(member model.Member) (Challenge, error) {
+	s, err := generateRandomString(32)
+	if err != nil {
+		return Challenge{}, err
+	}
+
+	c := Challenge{ChallengeID(s), member, time.Now().Add(cs.timeout), 0}
+
+	cs.mtx.Lock()
+	defer cs.mtx.Unlock()
+	cs.store[c.ChallengeID] = c
+
+	return c, nil
+}
+
+// GetChallenge gets a challenge by ChallengeID if it exists and isn't expired, otherwise it returns
+// an empty Challenge and ErrChallengeDNE
+func (cs *ChallengeStore) GetChallenge(cid ChallengeID) (Challenge, error) {
+	cs.mtx.Lock()
+	defer cs.mtx.Unlock()
+
+	c, ok := cs.store[cid]
+	if !ok {
+		return Challenge{}, ErrChallengeDNE
+	}
+	if time.Now().After(c.Expires) {
+		delete(cs.store, cid)
+		return Challenge{}, ErrChallengeDNE
+	}
+	return c, nil
+}
+
+// FailChallenge records a wrong code for a challenge, discarding it once it has had MaxChallengeAttempts
+// failures. Returns the number of attempts remaining.
+func (cs *ChallengeStore) FailChallenge(cid ChallengeID) int {
+	cs.mtx.Lock()
+	defer cs.mtx.Unlock()
+
+	c, ok := cs.store[cid]
+	if !ok {
+		return 0
+	}
+	c.Attempts++
+	if c.Attempts >= MaxChallengeAttempts {
+		delete(cs.store, cid)
+		return 0
+	}
+	cs.store[cid] = c
+	return MaxChallengeAttempts - c.Attempts
+}
+
+// CompleteChallenge deletes a challenge so that it can't be used again. Returns false if the challenge
+// wasn't found, in which case a concurrent request already completed or discarded it and the caller should
+// not continue.
+func (cs *ChallengeStore) CompleteChallenge(cid ChallengeID) bool {
+	cs.mtx.Lock()
+	defer cs.mtx.Unlock()
+
+	_, ok := cs.store[cid]
+	delete(cs.store, cid)
+	return ok
+}
+
+// DeleteMemberChallenges deletes every pending challenge for memberID, so that logins that already
+// got past the password step have to start over
+func (cs *ChallengeStore) DeleteMemberChallenges(memberID string) {
+	cs.mtx.Lock()
+	defer cs.mtx.Unlock()
+
+	for cid, c := range cs.store {
+		if c.Member.MemberID == memberID {
+			delete(cs.store, cid)
+		}
+	}
+}
+
+// Prune deletes every challenge that expired before now
+func (cs *ChallengeStore) Prune(now time.Time) {
+	cs.mtx.Lock()
+	defer cs.mtx.Unlock()
+
+	for cid, c := range cs.store {
+		if now.After(c.Expires) {
+			delete(cs.store, cid)
+		}
+	}
+}
@@ -0,0 +1,56 @@
+package auth
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ibeckermayer/teleport-interview/backend/internal/model"
+)
+
+func TestChallengeAttempts(t *testing.T) {
+	cs := NewChallengeStore(time.Minute)
+	c, err := cs.CreateChallenge(model.Member{MemberID: "memberID", AccountID: "accountID"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 1; i < MaxChallengeAttempts; i++ {
+		if remaining := cs.FailChallenge(c.ChallengeID); remaining != MaxChallengeAttempts-i {
+			t.Fatalf("expected %v attempts remaining but got %v", MaxChallengeAttempts-i, remaining)
+		}
+	}
+	if _, err := cs.GetChallenge(c.ChallengeID); err != nil {
+		t.Fatal(err)
+	}
+	cs.FailChallenge(c.ChallengeID)
+	if _, err := cs.GetChallenge(c.ChallengeID); err != ErrChallengeDNE {
+		t.Fatal("expected challenge to be discarded after too many failed attempts")
+	}
+}
+
+func TestCompleteChallenge(t *testing.T) {
+	cs := NewChallengeStore(time.Minute)
+	c, _ := cs.CreateChallenge(model.Member{MemberID: "memberID", AccountID: "accountID"})
+
+	if !cs.CompleteChallenge(c.ChallengeID) {
+		t.Fatal("expected challenge to be completed")
+	}
+	if cs.CompleteChallenge(c.ChallengeID) {
+		t.Fatal("a challenge should only be completed once")
+	}
+}
+
+func TestChallengeExpires(t *testing.T) {
+	cs := NewChallengeStore(-time.Second)
+	c, _ := cs.CreateChallenge(model.Member{MemberID: "memberID", AccountID: "accountID"})
+
+	if _, err := cs.GetChallenge(c.ChallengeID); err != ErrChallengeDNE {
+		t.Fatal("expected challenge to have expired")
+	}
+
+	c, _ = cs.CreateChallenge(model.Member{MemberID: "memberID", AccountID: "accountID"})
+	cs.Prune(time.Now())
+	if len(cs.store) != 0 {
+		t.Fatal("expected expired challenge to be pruned")
+	}
+}
@@ -0,0 +1,37 @@
+package auth
+
+// commonPasswords are some of the most common passwords and words people base passwords on, most
+// common first. EstimateStrength treats them as the first guesses an attacker would make.
+var commonPasswords = []string{
+	"password", "123456", "123456789", "12345678", "12345", "qwerty", "1234567", "111111", "1234567890",
+	"123123", "abc123", "1234", "password1", "iloveyou", "1q2w3e4r", "000000", "qwerty123", "zaq12wsx",
+	"dragon", "sunshine", "princess", "letmein", "654321", "monkey", "27653", "1qaz2wsx", "123321",
+	"qwertyuiop", "superman", "asdfghjkl", "trustno1", "welcome", "login", "admin", "master", "hello",
+	"freedom", "whatever", "qazwsx", "football", "baseball", "shadow", "michael", "jennifer", "jordan",
+	"hunter", "ranger", "buster", "soccer", "harley", "batman", "andrew", "tigger", "charlie", "robert",
+	"thomas", "hockey", "daniel", "starwars", "klaster", "112233", "george", "computer", "michelle",
+	"jessica", "pepper", "zxcvbnm", "555555", "131313", "access", "ashley", "love", "summer", "winter",
+	"spring", "autumn", "secret", "cookie", "flower", "orange", "purple", "pokemon", "cheese", "killer",
+	"maggie", "ginger", "joshua", "amanda", "matthew", "nicole", "yankees", "cowboys", "eagles", "dallas",
+	"austin", "thunder", "taylor", "matrix", "mustang", "corvette", "ferrari", "porsche", "mercedes",
+	"internet", "service", "google", "facebook", "apple", "samsung", "changeme", "default", "guest",
+	"root", "test", "user", "pass", "passw0rd", "p@ssw0rd", "letmein1", "welcome1", "admin123",
+	"qwerty1", "iloveyou1", "monkey1", "dragon1", "baby", "angel", "lovely", "family", "friends",
+	"forever", "heaven", "happy", "money", "blessed", "jesus", "god", "love123", "hello123", "abcdef",
+	"abcd1234", "a1b2c3", "asdf", "asdfgh", "zxcv", "qwer", "1qaz", "q1w2e3r4", "azerty", "correct",
+	"horse", "battery", "staple", "dashboard", "account", "company", "teleport", "gravitational",
+	"enterprise", "startup", "secure", "security", "private", "office", "work", "home", "school",
+	"january", "february", "march", "april", "may", "june", "july", "august", "september", "october",
+	"november", "december", "monday", "tuesday", "wednesday", "thursday", "friday", "saturday", "sunday",
+}
+
+// commonRank maps each of commonPasswords to its rank, starting at 1
+var commonRank = func() map[string]int {
+	m := make(map[string]int, len(commonPasswords))
+	for i, p := range commonPasswords {
+		if _, ok := m[p]; !ok {
+			m[p] = i + 1
+		}
+	}
+	return m
+}()
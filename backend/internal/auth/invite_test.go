@@ -0,0 +1,52 @@
+package auth
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestInviteToken(t *testing.T) {
+	secret, err := NewInviteSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	is := NewInviteSigner(secret)
+	now := time.Now()
+	token := is.Token("inviteID", now.Add(time.Hour))
+
+	inviteID, err := is.Check(token, now)
+	if err != nil || inviteID != "inviteID" {
+		t.Fatalf("expected inviteID, got %q, %v", inviteID, err)
+	}
+	if _, err := is.Check(token, now.Add(2*time.Hour)); err != ErrInviteExpired {
+		t.Fatalf("expected ErrInviteExpired, got %v", err)
+	}
+
+	other, _ := NewInviteSecret()
+	if _, err := NewInviteSigner(other).Check(token, now); err != ErrBadInviteToken {
+		t.Fatalf("expected a token signed with another secret to fail with ErrBadInviteToken, got %v", err)
+	}
+}
+
+func TestInviteTokenTampered(t *testing.T) {
+	is := NewInviteSigner([]byte("secret"))
+	now := time.Now()
+	token := is.Token("inviteID", now.Add(time.Hour))
+	parts := strings.Split(token, ".")
+
+	// A later expiry with the original signature
+	longer := strings.Split(is.Token("inviteID", now.Add(time.Hour*24*365)), ".")[0]
+	for _, bad := range []string{
+		"",
+		"no-dot",
+		parts[0] + "." + parts[1] + ".extra",
+		longer + "." + parts[1],
+		parts[0] + ".!!!",
+		strings.Split(is.Token("otherID", now.Add(time.Hour)), ".")[0] + "." + parts[1],
+	} {
+		if _, err := is.Check(bad, now); err != ErrBadInviteToken {
+			t.Fatalf("expected %q to fail with ErrBadInviteToken, got %v", bad, err)
+		}
+	}
+}
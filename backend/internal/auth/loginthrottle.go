@@ -0,0 +1,90 @@
+package auth
+
+import (
+	"strings"
+	"time"
+
+	"github.com/ibeckermayer/teleport-interview/backend/internal/model"
+)
+
+// LoginThrottleConfig configures how failed logins slow down and eventually lock out further attempts.
+// The first FreeFailures failures for a key cost nothing; each failure after that doubles the delay
+// before the next attempt is allowed, starting at BaseDelay and capped at MaxDelay. Once a key reaches its
+// lockout threshold it is locked out for LockoutDuration. Failures older than LockoutDuration are forgotten.
+type LoginThrottleConfig struct {
+	FreeFailures          int
+	BaseDelay             time.Duration
+	MaxDelay              time.Duration
+	EmailLockoutThreshold int // failures per email address before it's locked out
+	IPLockoutThreshold    int // failures per client IP before it's locked out; higher since many people can share an IP
+	LockoutDuration       time.Duration
+}
+
+// EmailThrottleKey returns the LoginThrottle key for an email address
+func EmailThrottleKey(email string) string {
+	return "email:" + strings.ToLower(strings.TrimSpace(email))
+}
+
+// IPThrottleKey returns the LoginThrottle key for a client IP
+func IPThrottleKey(ip string) string {
+	return "ip:" + ip
+}
+
+func (c LoginThrottleConfig) lockoutThreshold(key string) int {
+	if strings.HasPrefix(key, "ip:") {
+		return c.IPLockoutThreshold
+	}
+	return c.EmailLockoutThreshold
+}
+
+// stale reports whether t's failures are old enough to be forgotten
+func (c LoginThrottleConfig) stale(t model.LoginThrottle, now time.Time) bool {
+	locked := t.LockedUntil != nil && now.Before(*t.LockedUntil)
+	return !locked && now.Sub(t.LastFailureAt) > c.LockoutDuration
+}
+
+// delay returns how long after the last failure another attempt is allowed for a key with failures failures
+func (c LoginThrottleConfig) delay(failures int) time.Duration {
+	if failures <= c.FreeFailures {
+		return 0
+	}
+	d := c.BaseDelay
+	for i := c.FreeFailures + 1; i < failures && d < c.MaxDelay; i++ {
+		d *= 2
+	}
+	if d > c.MaxDelay {
+		d = c.MaxDelay
+	}
+	return d
+}
+
+// RetryAfter returns how long the client must wait before its next login attempt is allowed,
+// or zero if it may try now
+func (c LoginThrottleConfig) RetryAfter(t model.LoginThrottle, now time.Time) time.Duration {
+	if t.Failures == 0 || c.stale(t, now) {
+		return 0
+	}
+	if t.LockedUntil != nil && now.Before(*t.LockedUntil) {
+		return t.LockedUntil.Sub(now)
+	}
+	if next := t.LastFailureAt.Add(c.delay(t.Failures)); now.Before(next) {
+		return next.Sub(now)
+	}
+	return 0
+}
+
+// RecordFailure returns t updated with a failed login attempt at now, locking it out if it has
+// reached its lockout threshold
+func (c LoginThrottleConfig) RecordFailure(t model.LoginThrottle, now time.Time) model.LoginThrottle {
+	if c.stale(t, now) {
+		t.Failures = 0
+		t.LockedUntil = nil
+	}
+	t.Failures++
+	t.LastFailureAt = now
+	if t.Failures >= c.lockoutThreshold(t.Key) {
+		lockedUntil := now.Add(c.LockoutDuration)
+		t.LockedUntil = &lockedUntil
+	}
+	return t
+}
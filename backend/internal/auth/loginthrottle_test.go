@@ -0,0 +1,90 @@
+package auth
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ibeckermayer/teleport-interview/backend/internal/model"
+)
+
+var testThrottleConfig = LoginThrottleConfig{
+	FreeFailures:          3,
+	BaseDelay:             time.Second,
+	MaxDelay:              4 * time.Second,
+	EmailLockoutThreshold: 10,
+	IPLockoutThreshold:    20,
+	LockoutDuration:       15 * time.Minute,
+}
+
+func TestFreeFailures(t *testing.T) {
+	now := time.Now()
+	throttle := model.LoginThrottle{Key: EmailThrottleKey("dev@goteleport.com")}
+	for i := 0; i < testThrottleConfig.FreeFailures; i++ {
+		throttle = testThrottleConfig.RecordFailure(throttle, now)
+		if wait := testThrottleConfig.RetryAfter(throttle, now); wait != 0 {
+			t.Fatalf("expected no delay after %v failures but got %v", throttle.Failures, wait)
+		}
+	}
+}
+
+func TestProgressiveDelay(t *testing.T) {
+	now := time.Now()
+	throttle := model.LoginThrottle{Key: EmailThrottleKey("dev@goteleport.com"), Failures: testThrottleConfig.FreeFailures, LastFailureAt: now}
+
+	for _, expected := range []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 4 * time.Second} {
+		throttle = testThrottleConfig.RecordFailure(throttle, now)
+		if wait := testThrottleConfig.RetryAfter(throttle, now); wait != expected {
+			t.Fatalf("expected a delay of %v after %v failures but got %v", expected, throttle.Failures, wait)
+		}
+		if wait := testThrottleConfig.RetryAfter(throttle, now.Add(expected)); wait != 0 {
+			t.Fatalf("expected no delay once %v passed but got %v", expected, wait)
+		}
+	}
+}
+
+func TestLockout(t *testing.T) {
+	now := time.Now()
+	throttle := model.LoginThrottle{Key: EmailThrottleKey("dev@goteleport.com")}
+	for i := 0; i < testThrottleConfig.EmailLockoutThreshold; i++ {
+		throttle = testThrottleConfig.RecordFailure(throttle, now)
+	}
+
+	if throttle.LockedUntil == nil {
+		t.Fatal("expected email to be locked out")
+	}
+	if wait := testThrottleConfig.RetryAfter(throttle, now.Add(time.Minute)); wait != 14*time.Minute {
+		t.Fatalf("expected a delay of %v but got %v", 14*time.Minute, wait)
+	}
+	if wait := testThrottleConfig.RetryAfter(throttle, now.Add(testThrottleConfig.LockoutDuration+time.Second)); wait != 0 {
+		t.Fatalf("expected lockout to have expired but got a delay of %v", wait)
+	}
+
+	// The next failure after the lockout expires starts counting from scratch
+	throttle = testThrottleConfig.RecordFailure(throttle, now.Add(2*testThrottleConfig.LockoutDuration))
+	if throttle.Failures != 1 || throttle.LockedUntil != nil {
+		t.Fatalf("expected stale failures to be forgotten but got %+v", throttle)
+	}
+}
+
+func TestIPLockoutThreshold(t *testing.T) {
+	now := time.Now()
+	throttle := model.LoginThrottle{Key: IPThrottleKey("127.0.0.1")}
+	for i := 0; i < testThrottleConfig.EmailLockoutThreshold; i++ {
+		throttle = testThrottleConfig.RecordFailure(throttle, now)
+	}
+	if throttle.LockedUntil != nil {
+		t.Fatal("IP locked out at the email threshold")
+	}
+	for i := testThrottleConfig.EmailLockoutThreshold; i < testThrottleConfig.IPLockoutThreshold; i++ {
+		throttle = testThrottleConfig.RecordFailure(throttle, now)
+	}
+	if throttle.LockedUntil == nil {
+		t.Fatal("expected IP to be locked out")
+	}
+}
+
+func TestEmailThrottleKeyNormalized(t *testing.T) {
+	if EmailThrottleKey(" Dev@GoTeleport.com") != EmailThrottleKey("dev@goteleport.com") {
+		t.Fatal("email throttle keys should be case and whitespace insensitive")
+	}
+}
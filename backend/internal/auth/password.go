@@ -2,8 +2,13 @@ package auth
 
 import (
 	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
 	"fmt"
+	"strings"
 
+	"golang.org/x/crypto/argon2"
 	"golang.org/x/crypto/bcrypt"
 )
 
@@ -19,14 +24,166 @@ func CheckKeyHash(key Key, hash string) bool {
 	return HashKey(key) == hash
 }
 
-// HashPassword returns a stringified password hash
-func HashPassword(password string) (string, error) {
-	bytes, err := bcrypt.GenerateFromPassword([]byte(password), 14)
-	return string(bytes), err
+// Password hashing algorithms a PasswordHasher can use. Hashes are self-describing (bcrypt's "$2a$<cost>$..."
+// and argon2id's PHC string format "$argon2id$v=19$m=<KiB>,t=<passes>,p=<lanes>$<salt>$<key>"), so hashes made
+// with any algorithm and parameters can always be checked, whatever the PasswordHasher is currently configured with.
+const (
+	Bcrypt   = "bcrypt"
+	Argon2id = "argon2id"
+)
+
+// Defaults for PasswordHasher fields left as zero. The argon2id parameters are the second recommended option
+// from https://tools.ietf.org/html/draft-irtf-cfrg-argon2-13#section-4
+const (
+	DefaultBcryptCost    = bcrypt.DefaultCost
+	DefaultArgon2Time    = 3
+	DefaultArgon2Memory  = 64 * 1024 // KiB
+	DefaultArgon2Threads = 4
+
+	argon2SaltLength = 16
+	argon2KeyLength  = 32
+)
+
+var errUnknownPasswordHash = errors.New("unrecognized password hash format")
+
+// PasswordHasher hashes passwords with a configurable algorithm and parameters. Raising the parameters (or
+// switching algorithm) doesn't invalidate existing hashes: NeedsRehash reports which ones should be replaced
+// the next time the password is known, i.e. when the account logs in.
+type PasswordHasher struct {
+	Algorithm     string // Bcrypt or Argon2id; default Bcrypt
+	BcryptCost    int    // default DefaultBcryptCost
+	Argon2Time    uint32 // passes over memory; default DefaultArgon2Time
+	Argon2Memory  uint32 // KiB; default DefaultArgon2Memory
+	Argon2Threads uint8  // default DefaultArgon2Threads
+}
+
+// withDefaults returns ph with zero fields set to their defaults
+func (ph PasswordHasher) withDefaults() PasswordHasher {
+	if ph.Algorithm == "" {
+		ph.Algorithm = Bcrypt
+	}
+	if ph.BcryptCost == 0 {
+		ph.BcryptCost = DefaultBcryptCost
+	}
+	if ph.Argon2Time == 0 {
+		ph.Argon2Time = DefaultArgon2Time
+	}
+	if ph.Argon2Memory == 0 {
+		ph.Argon2Memory = DefaultArgon2Memory
+	}
+	if ph.Argon2Threads == 0 {
+		ph.Argon2Threads = DefaultArgon2Threads
+	}
+	return ph
+}
+
+// Validate returns an error if ph's algorithm or parameters can't be used
+func (ph PasswordHasher) Validate() error {
+	ph = ph.withDefaults()
+	switch ph.Algorithm {
+	case Bcrypt:
+		if ph.BcryptCost < bcrypt.MinCost || ph.BcryptCost > bcrypt.MaxCost {
+			return fmt.Errorf("bcrypt cost must be between %v and %v", bcrypt.MinCost, bcrypt.MaxCost)
+		}
+	case Argon2id:
+		if ph.Argon2Memory < 8*uint32(ph.Argon2Threads) {
+			return fmt.Errorf("argon2id memory must be at least 8KiB per thread")
+		}
+	default:
+		return fmt.Errorf("unknown password hashing algorithm %q, must be %q or %q", ph.Algorithm, Bcrypt, Argon2id)
+	}
+	return nil
 }
 
-// CheckPasswordHash checks whether a plaintext password is represented by hash
+// Hash returns a self-describing hash of password
+func (ph PasswordHasher) Hash(password string) (string, error) {
+	ph = ph.withDefaults()
+	switch ph.Algorithm {
+	case Bcrypt:
+		bytes, err := bcrypt.GenerateFromPassword([]byte(password), ph.BcryptCost)
+		return string(bytes), err
+	case Argon2id:
+		salt, err := generateRandomBytes(argon2SaltLength)
+		if err != nil {
+			return "", err
+		}
+		key := argon2.IDKey([]byte(password), salt, ph.Argon2Time, ph.Argon2Memory, ph.Argon2Threads, argon2KeyLength)
+		params := argon2Params{argon2.Version, ph.Argon2Memory, ph.Argon2Time, ph.Argon2Threads}
+		return params.encode(salt, key), nil
+	default:
+		return "", fmt.Errorf("unknown password hashing algorithm %q", ph.Algorithm)
+	}
+}
+
+// NeedsRehash reports whether hash was made with a different algorithm than ph would use, or with weaker
+// parameters, in which case it should be replaced with a new hash of the password once the password is
+// known. Hashes made with stronger parameters are kept, so lowering them never weakens existing hashes.
+func (ph PasswordHasher) NeedsRehash(hash string) bool {
+	ph = ph.withDefaults()
+	switch ph.Algorithm {
+	case Bcrypt:
+		cost, err := bcrypt.Cost([]byte(hash))
+		return err != nil || cost < ph.BcryptCost
+	case Argon2id:
+		params, _, key, err := decodeArgon2Hash(hash)
+		// The number of threads doesn't change how hard the hash is to crack, only how long it takes to make
+		return err != nil || len(key) != argon2KeyLength || params.version != argon2.Version ||
+			params.memory < ph.Argon2Memory || params.time < ph.Argon2Time
+	default:
+		return false
+	}
+}
+
+// CheckPasswordHash checks whether a plaintext password is represented by hash, which can have been
+// made with any algorithm and parameters
 func CheckPasswordHash(password, hash string) bool {
+	if strings.HasPrefix(hash, "$"+Argon2id+"$") {
+		params, salt, key, err := decodeArgon2Hash(hash)
+		if err != nil {
+			return false
+		}
+		other := argon2.IDKey([]byte(password), salt, params.time, params.memory, params.threads, uint32(len(key)))
+		return subtle.ConstantTimeCompare(key, other) == 1
+	}
 	err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
 	return err == nil
 }
+
+type argon2Params struct {
+	version int
+	memory  uint32
+	time    uint32
+	threads uint8
+}
+
+func (p argon2Params) encode(salt, key []byte) string {
+	return fmt.Sprintf("$%v$v=%v$m=%v,t=%v,p=%v$%v$%v", Argon2id, p.version, p.memory, p.time, p.threads,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key))
+}
+
+// decodeArgon2Hash parses an argon2id hash in PHC string format
+func decodeArgon2Hash(hash string) (params argon2Params, salt, key []byte, err error) {
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 || parts[0] != "" || parts[1] != Argon2id {
+		return params, nil, nil, errUnknownPasswordHash
+	}
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &params.version); err != nil {
+		return params, nil, nil, errUnknownPasswordHash
+	}
+	if params.version != argon2.Version {
+		return params, nil, nil, fmt.Errorf("unsupported argon2 version %v", params.version)
+	}
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &params.memory, &params.time, &params.threads); err != nil {
+		return params, nil, nil, errUnknownPasswordHash
+	}
+	if params.time == 0 || params.threads == 0 {
+		return params, nil, nil, errUnknownPasswordHash
+	}
+	if salt, err = base64.RawStdEncoding.DecodeString(parts[4]); err != nil {
+		return params, nil, nil, errUnknownPasswordHash
+	}
+	if key, err = base64.RawStdEncoding.DecodeString(parts[5]); err != nil || len(key) == 0 {
+		return params, nil, nil, errUnknownPasswordHash
+	}
+	return params, salt, key, nil
+}
@@ -0,0 +1,120 @@
+package auth
+
+import (
+	"strings"
+	"testing"
+)
+
+// Cheap parameters so the tests run quickly
+var (
+	testBcrypt   = PasswordHasher{Algorithm: Bcrypt, BcryptCost: 4}
+	testArgon2id = PasswordHasher{Algorithm: Argon2id, Argon2Time: 1, Argon2Memory: 64, Argon2Threads: 1}
+)
+
+func TestPasswordHasher(t *testing.T) {
+	for _, ph := range []PasswordHasher{testBcrypt, testArgon2id} {
+		hash, err := ph.Hash("correct horse")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !CheckPasswordHash("correct horse", hash) {
+			t.Fatalf("expected %v to match its password", hash)
+		}
+		if CheckPasswordHash("correct horsf", hash) {
+			t.Fatalf("expected %v not to match a different password", hash)
+		}
+		if ph.NeedsRehash(hash) {
+			t.Fatalf("expected %v not to need rehashing by the hasher that made it", hash)
+		}
+
+		other, err := ph.Hash("correct horse")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if other == hash {
+			t.Fatal("expected hashes of the same password to be salted differently")
+		}
+	}
+
+	argon2Hash, err := testArgon2id.Hash("correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(argon2Hash, "$argon2id$v=19$m=64,t=1,p=1$") {
+		t.Fatalf("expected a PHC format hash, got %v", argon2Hash)
+	}
+}
+
+func TestPasswordHasherNeedsRehash(t *testing.T) {
+	bcryptHash, err := testBcrypt.Hash("correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	argon2Hash, err := testArgon2id.Hash("correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	strongBcryptHash, err := PasswordHasher{Algorithm: Bcrypt, BcryptCost: 6}.Hash("correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	strongArgon2Hash, err := PasswordHasher{Algorithm: Argon2id, Argon2Time: 2, Argon2Memory: 128, Argon2Threads: 1}.Hash("correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		ph       PasswordHasher
+		hash     string
+		expected bool
+	}{
+		{PasswordHasher{Algorithm: Bcrypt, BcryptCost: 5}, bcryptHash, true},
+		{testArgon2id, bcryptHash, true},
+		{testBcrypt, argon2Hash, true},
+		{PasswordHasher{Algorithm: Argon2id, Argon2Time: 2, Argon2Memory: 64, Argon2Threads: 1}, argon2Hash, true},
+		{PasswordHasher{Algorithm: Argon2id, Argon2Time: 1, Argon2Memory: 128, Argon2Threads: 1}, argon2Hash, true},
+		{PasswordHasher{Algorithm: Argon2id, Argon2Time: 1, Argon2Memory: 64, Argon2Threads: 2}, argon2Hash, false},
+		{testBcrypt, "not a hash", true},
+		{PasswordHasher{}, bcryptHash, true}, // the default cost isn't 4
+		// Hashes stronger than the hasher's parameters are never downgraded
+		{testBcrypt, strongBcryptHash, false},
+		{PasswordHasher{Algorithm: Bcrypt, BcryptCost: 5}, strongBcryptHash, false},
+		{testArgon2id, strongArgon2Hash, false},
+		{PasswordHasher{Algorithm: Argon2id, Argon2Time: 3, Argon2Memory: 64, Argon2Threads: 1}, strongArgon2Hash, true},
+	}
+	for i, c := range cases {
+		if got := c.ph.NeedsRehash(c.hash); got != c.expected {
+			t.Fatalf("case %v: expected NeedsRehash to be %v, got %v", i, c.expected, got)
+		}
+	}
+}
+
+func TestCheckPasswordHashMalformed(t *testing.T) {
+	for _, hash := range []string{
+		"",
+		"$argon2id$",
+		"$argon2id$v=19$m=64,t=1,p=1$c2FsdHNhbHQ",
+		"$argon2id$v=18$m=64,t=1,p=1$c2FsdHNhbHQ$a2V5",
+		"$argon2id$v=19$m=64,t=0,p=1$c2FsdHNhbHQ$a2V5",
+		"$argon2id$v=19$m=64,t=1,p=1$!!!$a2V5",
+	} {
+		if CheckPasswordHash("", hash) {
+			t.Fatalf("expected malformed hash %q not to match", hash)
+		}
+	}
+}
+
+func TestPasswordHasherValidate(t *testing.T) {
+	valid := []PasswordHasher{{}, testBcrypt, testArgon2id, {Algorithm: Argon2id}}
+	for _, ph := range valid {
+		if err := ph.Validate(); err != nil {
+			t.Fatalf("expected %+v to be valid, got %v", ph, err)
+		}
+	}
+	invalid := []PasswordHasher{{Algorithm: "md5"}, {BcryptCost: 3}, {BcryptCost: 32}, {Algorithm: Argon2id, Argon2Memory: 7, Argon2Threads: 1}}
+	for _, ph := range invalid {
+		if err := ph.Validate(); err == nil {
+			t.Fatalf("expected %+v to be invalid", ph)
+		}
+	}
+}
@@ -0,0 +1,57 @@
+package auth
+
+import (
+	"fmt"
+	"strings"
+)
+
+// PolicyError is returned by PasswordPolicy.Check when a password isn't allowed. Its message is
+// safe to show to the user.
+type PolicyError string
+
+func (pe PolicyError) Error() string {
+	return string(pe)
+}
+
+var (
+	// ErrPasswordIsEmail is returned for passwords that are the account's email address
+	ErrPasswordIsEmail = PolicyError("password must not be your email address")
+
+	// ErrPasswordTooWeak is returned for passwords that EstimateStrength scores below the policy's MinStrength
+	ErrPasswordTooWeak = PolicyError("password is too easy to guess, try a longer one or a few unrelated words")
+
+	// ErrPasswordBreached is returned for passwords that have appeared in a data breach
+	ErrPasswordBreached = PolicyError("password has appeared in a data breach and can't be used, choose a different one")
+)
+
+// PasswordPolicy decides which passwords accounts may set, at signup, password reset and password change.
+// The zero value only disallows using the account's email address as its password.
+type PasswordPolicy struct {
+	MinLength   int           // shortest password allowed, in characters
+	MinStrength int           // lowest EstimateStrength score allowed, from 0 to 4
+	Breached    BreachChecker // passwords it reports as breached aren't allowed; nil skips the check
+}
+
+// Check returns a PolicyError if password isn't allowed for the account with email, or another error if
+// the policy couldn't be checked, in which case the password shouldn't be accepted either
+func (p PasswordPolicy) Check(password, email string) error {
+	if len([]rune(password)) < p.MinLength {
+		return PolicyError(fmt.Sprintf("password must be at least %v characters", p.MinLength))
+	}
+	if strings.EqualFold(strings.TrimSpace(password), strings.TrimSpace(email)) {
+		return ErrPasswordIsEmail
+	}
+	if EstimateStrength(password, email) < p.MinStrength {
+		return ErrPasswordTooWeak
+	}
+	if p.Breached != nil {
+		breached, err := p.Breached.Breached(password)
+		if err != nil {
+			return err
+		}
+		if breached {
+			return ErrPasswordBreached
+		}
+	}
+	return nil
+}
@@ -0,0 +1,34 @@
+package auth
+
+import (
+	"os"
+	"testing"
+)
+
+func TestPasswordPolicy(t *testing.T) {
+	path := writeHashPrefixFile(t, "hX9$kq2!Lm")
+	defer os.Remove(path)
+	breached, err := OpenHashPrefixFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer breached.Close()
+
+	policy := PasswordPolicy{MinLength: 8, MinStrength: 2, Breached: breached}
+	cases := map[string]error{
+		"short":                   PolicyError("password must be at least 8 characters"),
+		"Dev@GoTeleport.com":      ErrPasswordIsEmail,
+		"password1":               ErrPasswordTooWeak,
+		"hX9$kq2!Lm":              ErrPasswordBreached,
+		"horse-staple-lamp-river": nil,
+	}
+	for password, expected := range cases {
+		if err := policy.Check(password, "dev@goteleport.com"); err != expected {
+			t.Fatalf("expected %v for %q but got %v", expected, password, err)
+		}
+	}
+
+	if err := (PasswordPolicy{}).Check("dev@goteleport.com", "dev@goteleport.com"); err != ErrPasswordIsEmail {
+		t.Fatal("the zero value policy should still disallow the email address")
+	}
+}
@@ -9,6 +9,7 @@ import (
 	"sync"
 	"time"
 
+	"github.com/ibeckermayer/teleport-interview/backend/internal/audit"
 	"github.com/ibeckermayer/teleport-interview/backend/internal/model"
 	"github.com/ibeckermayer/teleport-interview/backend/internal/util"
 )
@@ -26,11 +27,21 @@ type contextKey string
 
 var theContextKey = contextKey("teleport-interview-auth")
 
-// Session is an individual user's session
+// Session is an individual member's session
 type Session struct {
-	SessionID SessionID
-	Account   model.Account
-	Expires   time.Time
+	SessionID      SessionID
+	Account        model.Account
+	Member         model.Member // who logged in, and with which Role
+	Expires        time.Time
+	ImpersonatedBy string // StaffActor of the staff using the session as Member, empty if Member logged in themselves
+}
+
+// Actor identifies who is using the session in the audit log
+func (s Session) Actor() string {
+	if s.ImpersonatedBy != "" {
+		return s.ImpersonatedBy + " as " + s.Member.Email
+	}
+	return s.Member.Email
 }
 
 // SessionManager is an in-memory session store. The app should only ever create one
@@ -39,29 +50,45 @@ type SessionManager struct {
 	store   map[SessionID]Session
 	timeout time.Duration // absolute timeout for individual sessions
 	mtx     sync.RWMutex  // mutex for store
+	audit   *audit.Log    // where WithSessionAuth records rejected session tokens
 
 	// contextKey is the key used to set and retrieve session data from a context.Context
 	contextKey contextKey
 }
 
-// NewSessionManager creates a new *SessionManager
-func NewSessionManager(timeout time.Duration) *SessionManager {
+// NewSessionManager creates a new *SessionManager. al may be nil if rejected session tokens shouldn't be audited.
+func NewSessionManager(timeout time.Duration, al *audit.Log) *SessionManager {
 	return &SessionManager{
 		store:      make(map[SessionID]Session),
 		timeout:    timeout,
+		audit:      al,
 		contextKey: theContextKey}
 }
 
-// CreateSession creates a new session in the SessionManager's store, indexed by a
+// CreateSession creates a new session for member of account in the SessionManager's store, indexed by a
 // new randomly generated SessionID, and expiring sm.timeout from the time it's created.
 // It will return an error if the system's secure random number generator fails to function correctly.
-func (sm *SessionManager) CreateSession(account model.Account) (Session, error) {
+func (sm *SessionManager) CreateSession(account model.Account, member model.Member) (Session, error) {
+	return sm.createSession(Session{Account: account, Member: member, Expires: time.Now().Add(sm.timeout)})
+}
+
+// CreateImpersonationSession creates a new session for staff to use as member of account, which expires after
+// timeout or sm.timeout, whichever is sooner. Requests made with it are audited as staffActor.
+// It will return an error if the system's secure random number generator fails to function correctly.
+func (sm *SessionManager) CreateImpersonationSession(account model.Account, member model.Member, staffActor string, timeout time.Duration) (Session, error) {
+	if timeout > sm.timeout {
+		timeout = sm.timeout
+	}
+	return sm.createSession(Session{Account: account, Member: member, Expires: time.Now().Add(timeout), ImpersonatedBy: staffActor})
+}
+
+// createSession stores s under a new randomly generated SessionID
+func (sm *SessionManager) createSession(s Session) (Session, error) {
 	sid, err := newSessionID()
 	if err != nil {
 		return Session{}, err
 	}
-
-	s := Session{sid, account, time.Now().Add(sm.timeout)}
+	s.SessionID = sid
 
 	sm.mtx.Lock()
 	defer sm.mtx.Unlock()
@@ -77,6 +104,55 @@ func (sm *SessionManager) UpdateSession(session Session) {
 	sm.store[session.SessionID] = session
 }
 
+// UpdateAccount replaces the cached Account in every session belonging to account.AccountID.
+// Should be called whenever an account is changed outside of the request that owns the session.
+func (sm *SessionManager) UpdateAccount(account model.Account) {
+	sm.mtx.Lock()
+	defer sm.mtx.Unlock()
+	for sid, session := range sm.store {
+		if session.Account.AccountID == account.AccountID {
+			session.Account = account
+			sm.store[sid] = session
+		}
+	}
+}
+
+// DeleteAccountSessions deletes every session belonging to any member of accountID, logging the whole
+// account out everywhere. Returns the number of sessions deleted.
+func (sm *SessionManager) DeleteAccountSessions(accountID string) int {
+	sm.mtx.Lock()
+	defer sm.mtx.Unlock()
+	n := 0
+	for sid, session := range sm.store {
+		if session.Account.AccountID == accountID {
+			delete(sm.store, sid)
+			n++
+		}
+	}
+	return n
+}
+
+// DeleteMemberSessions deletes every session belonging to memberID, logging the member out
+// everywhere. Returns the number of sessions deleted.
+func (sm *SessionManager) DeleteMemberSessions(memberID string) int {
+	return sm.DeleteOtherSessions(memberID, "")
+}
+
+// DeleteOtherSessions deletes every session belonging to memberID except keep, logging the member
+// out everywhere else. Returns the number of sessions deleted.
+func (sm *SessionManager) DeleteOtherSessions(memberID string, keep SessionID) int {
+	sm.mtx.Lock()
+	defer sm.mtx.Unlock()
+	n := 0
+	for sid, session := range sm.store {
+		if session.Member.MemberID == memberID && sid != keep {
+			delete(sm.store, sid)
+			n++
+		}
+	}
+	return n
+}
+
 // getSession gets a session by sessionID if it exists and isn't expired, otherwise
 // it returns an empty Session object and a non-nil error
 func (sm *SessionManager) getSession(sid SessionID) (Session, error) {
@@ -158,12 +234,14 @@ func getSessionID(r *http.Request) (SessionID, error) {
 
 // WithSessionAuth is a middlewear function for protecting handlers for routes that
 // require the user to be authenticated. If the user has an
+// Sessions of accounts that aren't active are refused with a 403.
 func (sm *SessionManager) WithSessionAuth(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		sessionID, err := getSessionID(r)
 		if err != nil {
 			// Could not get sessionID, return 401
 			log.Println(err)
+			sm.audit.Record(r, audit.Entry{Action: model.AuditSessionAuth, Outcome: model.AuditFailure, Detail: err.Error()})
 			util.ErrorJSON(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
 			return
 		}
@@ -172,10 +250,20 @@ func (sm *SessionManager) WithSessionAuth(next http.Handler) http.Handler {
 		if err != nil {
 			// Session does not exist or timed out
 			log.Println(err)
+			sm.audit.Record(r, audit.Entry{Action: model.AuditSessionAuth, Outcome: model.AuditFailure, Detail: err.Error()})
 			util.ErrorJSON(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
 			return
 		}
 
+		if !session.Account.Active() {
+			// Suspending an account deletes its sessions, but one may have been created while it was
+			log.Printf("refused session of member_id=%v, account_id=%v is %v", session.Member.MemberID, session.Account.AccountID, session.Account.Status)
+			sm.audit.Record(r, audit.Entry{AccountID: session.Account.AccountID, Actor: session.Actor(), Action: model.AuditSessionAuth, Outcome: model.AuditFailure,
+				Detail: "account " + string(session.Account.Status)})
+			util.ErrorJSON(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+			return
+		}
+
 		// Valid session exists, add it to the context
 		ctxWithSession := context.WithValue(r.Context(), sm.contextKey, session)
 
@@ -184,3 +272,30 @@ func (sm *SessionManager) WithSessionAuth(next http.Handler) http.Handler {
 		next.ServeHTTP(w, rWithSession)
 	})
 }
+
+// RequirePermission is a middlewear function for protecting handlers so that only members whose Role is
+// granted perm may call them. Responds 403 to anyone else. Must be wrapped with WithSessionAuth.
+func (sm *SessionManager) RequirePermission(perm model.Permission, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		session, err := sm.FromContext(r.Context())
+		if err != nil {
+			log.Println(err)
+			util.ErrorJSON(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+
+		if !session.Member.Role.Can(perm) {
+			log.Printf("member_id=%v with role %v lacks %v to call %v %v", session.Member.MemberID, session.Member.Role, perm, r.Method, r.URL.Path)
+			util.ErrorJSON(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+			return
+		}
+		// Staff can look at what a customer sees, but can't act as them, e.g. by inviting themselves
+		if session.ImpersonatedBy != "" && !perm.ReadOnly() {
+			log.Printf("%v can't use %v to call %v %v, impersonation is read-only", session.Actor(), perm, r.Method, r.URL.Path)
+			util.ErrorJSON(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
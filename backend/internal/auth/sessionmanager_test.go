@@ -9,17 +9,17 @@ import (
 	"github.com/ibeckermayer/teleport-interview/backend/internal/model"
 )
 
+var testMember = model.Member{MemberID: "memberID", AccountID: "accountID", Role: model.RoleOwner}
+
 func initTestSessionManager(timestr string) (*SessionManager, Session, error) {
 	to, _ := time.ParseDuration(timestr)
-	sm := NewSessionManager(to)
+	sm := NewSessionManager(to, nil)
 	acct := model.Account{
-		AccountID:    "accountID",
-		Plan:         "",
-		Email:        "",
-		PasswordHash: "",
-		CreatedAt:    time.Now(),
-		UpdatedAt:    time.Now()}
-	sess, err := sm.CreateSession(acct)
+		AccountID: "accountID",
+		Plan:      "",
+		CreatedAt: time.Now(),
+		UpdatedAt: time.Now()}
+	sess, err := sm.CreateSession(acct, testMember)
 	return sm, sess, err
 }
 
@@ -103,17 +103,15 @@ func TestUpdateSession(t *testing.T) {
 func TestTimeout(t *testing.T) {
 	// Set super short timeout
 	to, _ := time.ParseDuration("1ns")
-	sm := NewSessionManager(to)
+	sm := NewSessionManager(to, nil)
 
 	acct := model.Account{
-		AccountID:    "accountID",
-		Plan:         "",
-		Email:        "",
-		PasswordHash: "",
-		CreatedAt:    time.Now(),
-		UpdatedAt:    time.Now()}
+		AccountID: "accountID",
+		Plan:      "",
+		CreatedAt: time.Now(),
+		UpdatedAt: time.Now()}
 
-	sess, err := sm.CreateSession(acct)
+	sess, err := sm.CreateSession(acct, testMember)
 
 	mux := http.NewServeMux()
 	mux.Handle("/test", sm.WithSessionAuth(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -136,19 +134,55 @@ func TestTimeout(t *testing.T) {
 	}
 }
 
+func TestInactiveAccount(t *testing.T) {
+	sm, sess, err := initTestSessionManager("12h")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/test", sm.WithSessionAuth(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		return
+	})))
+
+	ts := httptest.NewTLSServer(mux)
+	defer ts.Close()
+
+	for _, tc := range []struct {
+		status model.AccountStatus
+		want   int
+	}{
+		{model.AccountSuspended, http.StatusForbidden},
+		{model.AccountPendingDeletion, http.StatusForbidden},
+		{model.AccountActive, http.StatusOK},
+	} {
+		acct := sess.Account
+		acct.Status = tc.status
+		sm.UpdateAccount(acct)
+
+		req, _ := http.NewRequest("GET", ts.URL+"/test", nil)
+		req.Header.Set("Authorization", "Bearer "+string(sess.SessionID))
+		resp, err := ts.Client().Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.StatusCode != tc.want {
+			t.Fatalf("%v account: expected %v but got %v", tc.status, tc.want, resp.StatusCode)
+		}
+	}
+}
+
 func TestWrongSessionID(t *testing.T) {
 	to, _ := time.ParseDuration("12h")
-	sm := NewSessionManager(to)
+	sm := NewSessionManager(to, nil)
 
 	acct := model.Account{
-		AccountID:    "accountID",
-		Plan:         "",
-		Email:        "",
-		PasswordHash: "",
-		CreatedAt:    time.Now(),
-		UpdatedAt:    time.Now()}
+		AccountID: "accountID",
+		Plan:      "",
+		CreatedAt: time.Now(),
+		UpdatedAt: time.Now()}
 
-	_, err := sm.CreateSession(acct)
+	_, err := sm.CreateSession(acct, testMember)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -201,17 +235,15 @@ func TestBadlyFormattedAuthHeader(t *testing.T) {
 
 func TestNoAuthHeader(t *testing.T) {
 	to, _ := time.ParseDuration("12h")
-	sm := NewSessionManager(to)
+	sm := NewSessionManager(to, nil)
 
 	acct := model.Account{
-		AccountID:    "accountID",
-		Plan:         "",
-		Email:        "",
-		PasswordHash: "",
-		CreatedAt:    time.Now(),
-		UpdatedAt:    time.Now()}
+		AccountID: "accountID",
+		Plan:      "",
+		CreatedAt: time.Now(),
+		UpdatedAt: time.Now()}
 
-	_, err := sm.CreateSession(acct)
+	_, err := sm.CreateSession(acct, testMember)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -234,3 +266,185 @@ func TestNoAuthHeader(t *testing.T) {
 		t.Fatalf("expected %v but got %v", http.StatusUnauthorized, resp.StatusCode)
 	}
 }
+
+func TestUpdateAccount(t *testing.T) {
+	sm, sess, err := initTestSessionManager("12h")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	other, err := sm.CreateSession(model.Account{AccountID: "otherAccountID"}, model.Member{MemberID: "otherMemberID", AccountID: "otherAccountID"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	acct := sess.Account
+	acct.Plan = model.FREE
+	sm.UpdateAccount(acct)
+
+	checkSess, err := sm.getSession(sess.SessionID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if checkSess.Account.Plan != model.FREE {
+		t.Fatalf("expected plan %v but got %v", model.FREE, checkSess.Account.Plan)
+	}
+
+	checkOther, err := sm.getSession(other.SessionID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if checkOther.Account.Plan != "" {
+		t.Fatal("UpdateAccount modified a session belonging to another account")
+	}
+}
+
+func TestDeleteAccountSessions(t *testing.T) {
+	sm, sess, err := initTestSessionManager("12h")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	second, err := sm.CreateSession(sess.Account, model.Member{MemberID: "secondMemberID", AccountID: sess.Account.AccountID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := sm.CreateSession(model.Account{AccountID: "otherAccountID"}, model.Member{MemberID: "otherMemberID", AccountID: "otherAccountID"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if n := sm.DeleteAccountSessions(sess.Account.AccountID); n != 2 {
+		t.Fatalf("expected 2 sessions to be deleted but got %v", n)
+	}
+	for _, sid := range []SessionID{sess.SessionID, second.SessionID} {
+		if _, err := sm.getSession(sid); err != ErrSessionDNE {
+			t.Fatal("expected the account's sessions to be deleted")
+		}
+	}
+	if _, err := sm.getSession(other.SessionID); err != nil {
+		t.Fatal("DeleteAccountSessions deleted a session belonging to another account")
+	}
+}
+
+func TestDeleteOtherSessions(t *testing.T) {
+	sm, sess, err := initTestSessionManager("12h")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	second, err := sm.CreateSession(sess.Account, sess.Member)
+	if err != nil {
+		t.Fatal(err)
+	}
+	colleague, err := sm.CreateSession(sess.Account, model.Member{MemberID: "colleagueMemberID", AccountID: sess.Account.AccountID})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if n := sm.DeleteOtherSessions(sess.Member.MemberID, sess.SessionID); n != 1 {
+		t.Fatalf("expected 1 session to be deleted but got %v", n)
+	}
+	if _, err := sm.getSession(sess.SessionID); err != nil {
+		t.Fatal("DeleteOtherSessions deleted the session it was told to keep")
+	}
+	if _, err := sm.getSession(second.SessionID); err != ErrSessionDNE {
+		t.Fatal("expected the member's other session to be deleted")
+	}
+	if _, err := sm.getSession(colleague.SessionID); err != nil {
+		t.Fatal("DeleteOtherSessions deleted a session belonging to another member")
+	}
+}
+
+func TestRequirePermission(t *testing.T) {
+	sm := NewSessionManager(12*time.Hour, nil)
+
+	mux := http.NewServeMux()
+	mux.Handle("/plan", sm.WithSessionAuth(sm.RequirePermission(model.PermPlanChange, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		return
+	}))))
+	mux.Handle("/members", sm.WithSessionAuth(sm.RequirePermission(model.PermMembersManage, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		return
+	}))))
+	mux.Handle("/metrics", sm.WithSessionAuth(sm.RequirePermission(model.PermMetricsRead, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		return
+	}))))
+
+	ts := httptest.NewTLSServer(mux)
+	defer ts.Close()
+
+	for _, tc := range []struct {
+		role         model.Role
+		impersonated bool
+		path         string
+		expected     int
+	}{
+		{model.RoleOwner, false, "/plan", http.StatusOK},
+		{model.RoleOwner, false, "/members", http.StatusOK},
+		{model.RoleOwner, false, "/metrics", http.StatusOK},
+		{model.RoleAdmin, false, "/plan", http.StatusOK},
+		{model.RoleAdmin, false, "/members", http.StatusForbidden},
+		{model.RoleAdmin, false, "/metrics", http.StatusOK},
+		{model.RoleViewer, false, "/plan", http.StatusForbidden},
+		{model.RoleViewer, false, "/members", http.StatusForbidden},
+		{model.RoleViewer, false, "/metrics", http.StatusOK},
+		{model.Role("unknown"), false, "/metrics", http.StatusForbidden},
+		// Impersonation is read-only, whatever the member's role
+		{model.RoleOwner, true, "/plan", http.StatusForbidden},
+		{model.RoleOwner, true, "/members", http.StatusForbidden},
+		{model.RoleOwner, true, "/metrics", http.StatusOK},
+		{model.RoleViewer, true, "/metrics", http.StatusOK},
+	} {
+		account := model.Account{AccountID: "accountID"}
+		member := model.Member{MemberID: string(tc.role), AccountID: "accountID", Role: tc.role}
+		var sess Session
+		var err error
+		if tc.impersonated {
+			sess, err = sm.CreateImpersonationSession(account, member, "staff:support@example.com", time.Hour)
+		} else {
+			sess, err = sm.CreateSession(account, member)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		req, _ := http.NewRequest("GET", ts.URL+tc.path, nil)
+		req.Header.Set("Authorization", "Bearer "+string(sess.SessionID))
+		resp, err := ts.Client().Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if resp.StatusCode != tc.expected {
+			t.Fatalf("expected %v for %v (impersonated: %v) calling %v but got %v", tc.expected, tc.role, tc.impersonated, tc.path, resp.StatusCode)
+		}
+	}
+}
+
+func TestCreateImpersonationSession(t *testing.T) {
+	sm := NewSessionManager(30*time.Minute, nil)
+	member := model.Member{MemberID: "memberID", AccountID: "accountID", Email: "member@example.com", Role: model.RoleOwner}
+
+	sess, err := sm.CreateSession(model.Account{AccountID: "accountID"}, member)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sess.Actor() != "member@example.com" {
+		t.Fatalf("expected the member's own session to be audited as them, got %q", sess.Actor())
+	}
+
+	staff := StaffActor(model.Staff{Email: "support@example.com"})
+	sess, err = sm.CreateImpersonationSession(model.Account{AccountID: "accountID"}, member, staff, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sess.Actor() != "staff:support@example.com as member@example.com" {
+		t.Fatalf("expected an impersonation session to be audited as the staff, got %q", sess.Actor())
+	}
+	if sess.Expires.After(time.Now().Add(30 * time.Minute)) {
+		t.Fatalf("expected an impersonation session to last no longer than the session timeout, expires %v", sess.Expires)
+	}
+	if got, err := sm.getSession(sess.SessionID); err != nil || got.ImpersonatedBy != staff {
+		t.Fatalf("expected the stored session to remember who is impersonating, got %+v, %v", got, err)
+	}
+}
@@ -0,0 +1,42 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+)
+
+// SigningSecretSize is the size in bytes of the secrets signing keys are derived from
+const SigningSecretSize = 32
+
+// NewSigningSecret creates a new secret to derive signing keys from. It will return an error if the system's
+// secure random number generator fails to function correctly, in which case the caller should not continue.
+func NewSigningSecret() ([]byte, error) {
+	return generateRandomBytes(SigningSecretSize)
+}
+
+// NewSigningKeyID creates a new ID for an API key's signing key. It will return an error if the system's
+// secure random number generator fails to function correctly, in which case the caller should not continue.
+func NewSigningKeyID() (string, error) {
+	return generateRandomString(16)
+}
+
+// SigningKeys derives the keys that requests authenticated with an API key are signed with (see package
+// signing). A key is the HMAC-SHA256 of the API key's hash and its signing key ID, keyed with the server's
+// secret. The server only stores the ID, and derives the key again to check a signature, so someone who can
+// read the database but doesn't have the secret can't sign requests.
+type SigningKeys struct {
+	secret []byte
+}
+
+// NewSigningKeys creates a new SigningKeys that derives keys from secret
+func NewSigningKeys(secret []byte) SigningKeys {
+	return SigningKeys{secret}
+}
+
+// Key returns the hex encoded signing key for the API key whose hash is keyHash and signing key ID is signingKeyID
+func (sk SigningKeys) Key(keyHash, signingKeyID string) string {
+	mac := hmac.New(sha256.New, sk.secret)
+	mac.Write([]byte("signing-key\n" + keyHash + "\n" + signingKeyID))
+	return hex.EncodeToString(mac.Sum(nil))
+}
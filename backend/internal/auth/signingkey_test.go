@@ -0,0 +1,33 @@
+package auth
+
+import "testing"
+
+func TestSigningKeys(t *testing.T) {
+	secret, err := NewSigningSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherSecret, err := NewSigningSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sk := NewSigningKeys(secret)
+	keyHash := HashKey(Key("key"))
+
+	key := sk.Key(keyHash, "id")
+	if sk.Key(keyHash, "id") != key {
+		t.Fatal("expected the same signing key to be derived every time")
+	}
+	for name, other := range map[string]string{
+		"another signing key ID": sk.Key(keyHash, "other"),
+		"another API key":        sk.Key(HashKey(Key("other")), "id"),
+		"another secret":         NewSigningKeys(otherSecret).Key(keyHash, "id"),
+	} {
+		if other == key {
+			t.Errorf("expected %v to derive a different signing key", name)
+		}
+	}
+	if key == keyHash {
+		t.Fatal("expected the signing key not to be the stored key hash")
+	}
+}
@@ -0,0 +1,29 @@
+package auth
+
+import (
+	"context"
+	"errors"
+
+	"github.com/ibeckermayer/teleport-interview/backend/internal/model"
+)
+
+var staffContextKey = contextKey("teleport-interview-staff")
+
+// ContextWithStaff returns a copy of ctx carrying staff, for the middlewear that authenticates admin API requests
+func ContextWithStaff(ctx context.Context, staff model.Staff) context.Context {
+	return context.WithValue(ctx, staffContextKey, staff)
+}
+
+// StaffFromContext gets the member of staff who made an admin API request from its context
+func StaffFromContext(ctx context.Context) (model.Staff, error) {
+	staff, ok := ctx.Value(staffContextKey).(model.Staff)
+	if !ok {
+		return model.Staff{}, errors.New("type assertion from context.Context value to model.Staff failed")
+	}
+	return staff, nil
+}
+
+// StaffActor identifies a member of staff in the audit log and plan history
+func StaffActor(staff model.Staff) string {
+	return "staff:" + staff.Email
+}
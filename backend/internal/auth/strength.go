@@ -0,0 +1,266 @@
+package auth
+
+import (
+	"math"
+	"strings"
+	"unicode"
+)
+
+// EstimateStrength returns a score from 0 (trivially guessable) to 4 (very hard to guess) for password, in the
+// style of zxcvbn (https://github.com/dropbox/zxcvbn). The password is split into the sequence of patterns that
+// would take an attacker the fewest guesses to find: common passwords and words (including l33t and capitalized
+// variations), userInputs like the account's email address, repeated characters, sequences like "abc" or "987",
+// keyboard rows like "qwerty", and any remaining characters guessed by brute force. The score is based on the
+// total number of guesses.
+func EstimateStrength(password string, userInputs ...string) int {
+	return strengthScore(estimateGuessesLog10(password, userInputs))
+}
+
+// strengthScore converts log10 of the number of guesses needed to find a password into a score,
+// using the same thresholds as zxcvbn
+func strengthScore(log10Guesses float64) int {
+	switch {
+	case log10Guesses < 3:
+		return 0 // risky password: too guessable
+	case log10Guesses < 6:
+		return 1 // modest protection from throttled online attacks
+	case log10Guesses < 8:
+		return 2 // some protection from unthrottled online attacks
+	case log10Guesses < 10:
+		return 3 // moderate protection from an offline slow-hash scenario
+	default:
+		return 4 // strong protection from an offline slow-hash scenario
+	}
+}
+
+const (
+	// bruteforceLog10 is log10 of the guesses per character not covered by any other pattern
+	bruteforceLog10 = 1
+
+	// minMatchLength is the shortest dictionary word, sequence or repeat that's matched
+	minMatchLength = 3
+)
+
+// l33tTable maps common l33t substitutions back to the letter they stand for
+var l33tTable = map[rune]rune{
+	'4': 'a', '@': 'a', '8': 'b', '(': 'c', '3': 'e', '6': 'g', '1': 'i', '!': 'i',
+	'|': 'l', '0': 'o', '$': 's', '5': 's', '7': 't', '+': 't', '2': 'z',
+}
+
+// keyboardRows are the rows of a qwerty keyboard, unshifted and shifted
+var keyboardRows = []string{
+	"`1234567890-=", "qwertyuiop[]\\", "asdfghjkl;'", "zxcvbnm,./",
+	"~!@#$%^&*()_+", "QWERTYUIOP{}|", "ASDFGHJKL:\"", "ZXCVBNM<>?",
+}
+
+// match is a pattern covering password[i:j] that takes 10^log10Guesses guesses to find
+type match struct {
+	i, j         int
+	log10Guesses float64
+}
+
+// estimateGuessesLog10 returns log10 of the fewest guesses needed to find password, by finding the cheapest
+// sequence of non-overlapping matches covering it
+func estimateGuessesLog10(password string, userInputs []string) float64 {
+	runes := []rune(password)
+	n := len(runes)
+	if n == 0 {
+		return 0
+	}
+
+	matches := dictionaryMatches(runes, userDictionary(userInputs))
+	matches = append(matches, repeatMatches(runes)...)
+	matches = append(matches, sequenceMatches(runes)...)
+	matches = append(matches, keyboardMatches(runes)...)
+
+	byStart := make([][]match, n)
+	for _, m := range matches {
+		byStart[m.i] = append(byStart[m.i], m)
+	}
+
+	// best[k] is the fewest log10 guesses to cover runes[:k]
+	best := make([]float64, n+1)
+	for k := 1; k <= n; k++ {
+		best[k] = math.Inf(1)
+	}
+	for k := 0; k < n; k++ {
+		if g := best[k] + bruteforceLog10; g < best[k+1] {
+			best[k+1] = g
+		}
+		for _, m := range byStart[k] {
+			if g := best[k] + m.log10Guesses; g < best[m.j] {
+				best[m.j] = g
+			}
+		}
+	}
+	return best[n]
+}
+
+// userDictionary ranks the parts of userInputs (e.g. "dev" and "goteleport" from "dev@goteleport.com")
+// as the most likely words of all, since an attacker targeting the account would try them first
+func userDictionary(userInputs []string) map[string]int {
+	dict := map[string]int{}
+	rank := 1
+	for _, input := range userInputs {
+		input = strings.ToLower(input)
+		words := append([]string{input}, strings.FieldsFunc(input, func(r rune) bool {
+			return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+		})...)
+		for _, w := range words {
+			if _, ok := dict[w]; !ok && len(w) >= minMatchLength {
+				dict[w] = rank
+				rank++
+			}
+		}
+	}
+	return dict
+}
+
+// dictionaryMatches finds substrings of runes that are common passwords, common words or user inputs,
+// allowing for capitalization and l33t substitutions
+func dictionaryMatches(runes []rune, userDict map[string]int) []match {
+	lower := []rune(strings.ToLower(string(runes)))
+	unl33t := make([]rune, len(lower))
+	for k, r := range lower {
+		if sub, ok := l33tTable[r]; ok {
+			unl33t[k] = sub
+		} else {
+			unl33t[k] = r
+		}
+	}
+
+	var matches []match
+	for i := range runes {
+		for j := i + minMatchLength; j <= len(runes); j++ {
+			for _, candidate := range [][]rune{lower[i:j], unl33t[i:j]} {
+				word := string(candidate)
+				rank, ok := userDict[word]
+				if !ok {
+					rank, ok = commonRank[word]
+				}
+				if !ok {
+					continue
+				}
+				g := math.Log10(float64(rank)) + uppercaseVariationsLog10(runes[i:j])
+				if string(candidate) != string(lower[i:j]) {
+					g += l33tVariationsLog10(lower[i:j])
+				}
+				matches = append(matches, match{i, j, math.Max(g, 0)})
+			}
+		}
+	}
+	return matches
+}
+
+// uppercaseVariationsLog10 returns log10 of how many capitalizations of word an attacker would try before this one
+func uppercaseVariationsLog10(word []rune) float64 {
+	upper, lower := 0, 0
+	for _, r := range word {
+		if unicode.IsUpper(r) {
+			upper++
+		} else if unicode.IsLower(r) {
+			lower++
+		}
+	}
+	switch {
+	case upper == 0:
+		return 0
+	case lower == 0 || (upper == 1 && unicode.IsUpper(word[0])) || (upper == 1 && unicode.IsUpper(word[len(word)-1])):
+		// ALL CAPS, Capitalized or capitalizeD are the first variations tried
+		return math.Log10(2)
+	default:
+		return math.Log10(binomial(upper+lower, upper))
+	}
+}
+
+// l33tVariationsLog10 returns log10 of how many l33t variations of word an attacker would try before this one
+func l33tVariationsLog10(word []rune) float64 {
+	subbed := 0
+	for _, r := range word {
+		if _, ok := l33tTable[r]; ok {
+			subbed++
+		}
+	}
+	return math.Log10(binomial(len(word), subbed) + 1)
+}
+
+func binomial(n, k int) float64 {
+	r := 1.0
+	for i := 1; i <= k; i++ {
+		r = r * float64(n-k+i) / float64(i)
+	}
+	return r
+}
+
+// repeatMatches finds runs of the same character, like "aaaa"
+func repeatMatches(runes []rune) []match {
+	var matches []match
+	for i := 0; i < len(runes); {
+		j := i + 1
+		for j < len(runes) && runes[j] == runes[i] {
+			j++
+		}
+		if j-i >= minMatchLength {
+			matches = append(matches, match{i, j, math.Log10(float64(10 * (j - i)))})
+		}
+		i = j
+	}
+	return matches
+}
+
+// sequenceMatches finds runs of characters that go up or down by one, like "abcd" or "9876"
+func sequenceMatches(runes []rune) []match {
+	var matches []match
+	for i := 0; i < len(runes)-1; {
+		delta := runes[i+1] - runes[i]
+		j := i + 1
+		for j < len(runes) && runes[j]-runes[j-1] == delta {
+			j++
+		}
+		if (delta == 1 || delta == -1) && j-i >= minMatchLength {
+			base := 26.0
+			switch {
+			case strings.ContainsRune("aAzZ019", runes[i]):
+				base = 4 // obvious starting points are tried first
+			case unicode.IsDigit(runes[i]):
+				base = 10
+			}
+			matches = append(matches, match{i, j, math.Log10(base * float64(j-i) * 2)})
+		}
+		if j-i >= 2 {
+			i = j - 1
+		} else {
+			i = j
+		}
+	}
+	return matches
+}
+
+// keyboardMatches finds runs of adjacent keys on a row of a qwerty keyboard, like "qwerty" or "lkjh"
+func keyboardMatches(runes []rune) []match {
+	var matches []match
+	for _, row := range keyboardRows {
+		rowRunes := []rune(row)
+		pos := map[rune]int{}
+		for k, r := range rowRunes {
+			pos[r] = k
+		}
+		for i := 0; i < len(runes); {
+			j := i + 1
+			for j < len(runes) {
+				a, aok := pos[runes[j-1]]
+				b, bok := pos[runes[j]]
+				if !aok || !bok || (b-a != 1 && a-b != 1) {
+					break
+				}
+				j++
+			}
+			if j-i >= minMatchLength+1 {
+				// Any starting key on the keyboard, in either direction
+				matches = append(matches, match{i, j, math.Log10(float64(len(rowRunes)*len(keyboardRows)*2) * float64(j-i))})
+			}
+			i = j
+		}
+	}
+	return matches
+}
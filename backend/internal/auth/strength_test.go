@@ -0,0 +1,19 @@
+package auth
+
+import "testing"
+
+func TestEstimateStrength(t *testing.T) {
+	weak := []string{"password", "P@ssw0rd", "qwertyuiop", "aaaaaaaaaa", "abcdefgh", "12345678", "dev@goteleport.com1"}
+	for _, p := range weak {
+		if score := EstimateStrength(p, "dev@goteleport.com"); score > 1 {
+			t.Fatalf("expected %q to be weak but it scored %v", p, score)
+		}
+	}
+
+	strong := []string{"hX9$kq2!Lm", "horse-staple-lamp-river", "fakeiot-test-login"}
+	for _, p := range strong {
+		if score := EstimateStrength(p, "dev@goteleport.com"); score < 3 {
+			t.Fatalf("expected %q to be strong but it scored %v", p, score)
+		}
+	}
+}
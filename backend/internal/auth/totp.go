@@ -0,0 +1,158 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// TOTP parameters (https://tools.ietf.org/html/rfc6238). These are the defaults every common
+// authenticator app assumes, so they're fixed rather than configurable.
+const (
+	TOTPDigits = 6
+	TOTPPeriod = 30 * time.Second
+
+	// totpSkew is how many time steps either side of the current one are accepted, to allow for clock drift
+	totpSkew = 1
+
+	// totpSecretSize is the size in bytes of generated TOTP secrets, as recommended by RFC 4226
+	totpSecretSize = 20
+)
+
+// TOTPIssuer is shown alongside the account's email in authenticator apps
+const TOTPIssuer = "teleport-interview"
+
+var totpEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// NewTOTPSecret creates a new base32 encoded TOTP secret. It will return an error if the system's
+// secure random number generator fails to function correctly, in which case the caller should not continue.
+func NewTOTPSecret() (string, error) {
+	b, err := generateRandomBytes(totpSecretSize)
+	if err != nil {
+		return "", err
+	}
+	return totpEncoding.EncodeToString(b), nil
+}
+
+// TOTPURI returns the otpauth:// URI for a secret, which authenticator apps can import (usually from a QR code)
+// (https://github.com/google/google-authenticator/wiki/Key-Uri-Format)
+func TOTPURI(email, secret string) string {
+	v := url.Values{}
+	v.Set("secret", secret)
+	v.Set("issuer", TOTPIssuer)
+	v.Set("algorithm", "SHA1")
+	v.Set("digits", fmt.Sprint(TOTPDigits))
+	v.Set("period", fmt.Sprint(int(TOTPPeriod.Seconds())))
+	u := url.URL{
+		Scheme:   "otpauth",
+		Host:     "totp",
+		Path:     "/" + TOTPIssuer + ":" + email,
+		RawQuery: v.Encode(),
+	}
+	return u.String()
+}
+
+// TOTPStep returns the TOTP time step t falls in
+func TOTPStep(t time.Time) int64 {
+	return t.Unix() / int64(TOTPPeriod.Seconds())
+}
+
+// totpCode computes the code for a time step (the HOTP algorithm from https://tools.ietf.org/html/rfc4226
+// with the time step as the counter)
+func totpCode(key []byte, step int64) string {
+	msg := make([]byte, 8)
+	binary.BigEndian.PutUint64(msg, uint64(step))
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg)
+	sum := mac.Sum(nil)
+
+	// Dynamic truncation
+	offset := sum[len(sum)-1] & 0xf
+	bin := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+
+	mod := uint32(1)
+	for i := 0; i < TOTPDigits; i++ {
+		mod *= 10
+	}
+	return fmt.Sprintf("%0*d", TOTPDigits, bin%mod)
+}
+
+func decodeTOTPSecret(secret string) ([]byte, error) {
+	return totpEncoding.DecodeString(strings.ToUpper(strings.TrimRight(secret, "=")))
+}
+
+// TOTPCode returns the code for secret at time t
+func TOTPCode(secret string, t time.Time) (string, error) {
+	key, err := decodeTOTPSecret(secret)
+	if err != nil {
+		return "", err
+	}
+	return totpCode(key, TOTPStep(t)), nil
+}
+
+// CheckTOTPCode checks code against secret at time now, accepting codes from one time step either side
+// to allow for clock drift. Codes from steps at or before lastUsedStep are rejected so that a code can't
+// be used twice. Returns the time step the code matched, which the caller should store as the new lastUsedStep.
+func CheckTOTPCode(secret, code string, now time.Time, lastUsedStep int64) (int64, bool) {
+	key, err := decodeTOTPSecret(secret)
+	if err != nil || len(code) != TOTPDigits {
+		return 0, false
+	}
+	current := TOTPStep(now)
+	for step := current - totpSkew; step <= current+totpSkew; step++ {
+		if step <= lastUsedStep {
+			continue
+		}
+		if subtle.ConstantTimeCompare([]byte(totpCode(key, step)), []byte(code)) == 1 {
+			return step, true
+		}
+	}
+	return 0, false
+}
+
+// recoveryCodeAlphabet leaves out characters that are easily confused with one another (0/O, 1/I/L)
+const recoveryCodeAlphabet = "23456789ABCDEFGHJKMNPQRSTUVWXYZ"
+
+// RecoveryCodeCount is how many recovery codes an account is given when it enables TOTP
+const RecoveryCodeCount = 10
+
+// NewRecoveryCodes creates n random recovery codes of the form "XXXXX-XXXXX". It will return an error if the
+// system's secure random number generator fails to function correctly, in which case the caller should not continue.
+func NewRecoveryCodes(n int) ([]string, error) {
+	codes := make([]string, n)
+	for i := range codes {
+		b, err := generateRandomBytes(10)
+		if err != nil {
+			return nil, err
+		}
+		code := make([]byte, 0, 11)
+		for j, c := range b {
+			if j == 5 {
+				code = append(code, '-')
+			}
+			// 256 isn't a multiple of len(recoveryCodeAlphabet), so this is very slightly biased, which
+			// is fine for ~49 bits of entropy per code
+			code = append(code, recoveryCodeAlphabet[int(c)%len(recoveryCodeAlphabet)])
+		}
+		codes[i] = string(code)
+	}
+	return codes, nil
+}
+
+// HashRecoveryCode returns the hash a recovery code is stored as. Codes are compared case-insensitively and
+// ignoring whitespace and dashes, since people will type them in by hand.
+func HashRecoveryCode(code string) string {
+	normalized := strings.Map(func(r rune) rune {
+		if r == '-' || r == ' ' || r == '\t' {
+			return -1
+		}
+		return r
+	}, strings.ToUpper(code))
+	return HashKey(Key(normalized))
+}
@@ -0,0 +1,93 @@
+package auth
+
+import (
+	"encoding/base32"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// rfc6238Secret is the SHA1 key from the test vectors in https://tools.ietf.org/html/rfc6238#appendix-B
+var rfc6238Secret = base32.StdEncoding.EncodeToString([]byte("12345678901234567890"))
+
+func TestTOTPCodeRFC6238(t *testing.T) {
+	// The RFC's vectors are 8 digits, TOTPDigits codes are their last 6
+	vectors := map[int64]string{
+		59:         "287082",
+		1111111109: "081804",
+		1111111111: "050471",
+		1234567890: "005924",
+		2000000000: "279037",
+	}
+	for unix, expected := range vectors {
+		code, err := TOTPCode(rfc6238Secret, time.Unix(unix, 0))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if code != expected {
+			t.Fatalf("expected code %v at %v but got %v", expected, unix, code)
+		}
+	}
+}
+
+func TestCheckTOTPCode(t *testing.T) {
+	secret, err := NewTOTPSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	code, _ := TOTPCode(secret, now)
+
+	step, ok := CheckTOTPCode(secret, code, now, 0)
+	if !ok || step != TOTPStep(now) {
+		t.Fatal("expected the current code to be accepted")
+	}
+	if _, ok := CheckTOTPCode(secret, code, now.Add(TOTPPeriod), 0); !ok {
+		t.Fatal("expected the previous step's code to be accepted")
+	}
+	if _, ok := CheckTOTPCode(secret, code, now.Add(3*TOTPPeriod), 0); ok {
+		t.Fatal("expected an old code to be rejected")
+	}
+	if _, ok := CheckTOTPCode(secret, code, now, step); ok {
+		t.Fatal("expected a used code to be rejected")
+	}
+	if _, ok := CheckTOTPCode(secret, "", now, 0); ok {
+		t.Fatal("expected an empty code to be rejected")
+	}
+}
+
+func TestTOTPURI(t *testing.T) {
+	u, err := url.Parse(TOTPURI("dev@goteleport.com", "SECRET"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u.Scheme != "otpauth" || u.Host != "totp" || u.Path != "/"+TOTPIssuer+":dev@goteleport.com" {
+		t.Fatalf("unexpected URI %v", u)
+	}
+	if q := u.Query(); q.Get("secret") != "SECRET" || q.Get("issuer") != TOTPIssuer || q.Get("digits") != "6" || q.Get("period") != "30" {
+		t.Fatalf("unexpected URI parameters %v", q)
+	}
+}
+
+func TestRecoveryCodes(t *testing.T) {
+	codes, err := NewRecoveryCodes(RecoveryCodeCount)
+	if err != nil {
+		t.Fatal(err)
+	}
+	seen := map[string]bool{}
+	for _, code := range codes {
+		if len(code) != 11 || code[5] != '-' {
+			t.Fatalf("unexpected recovery code format %q", code)
+		}
+		seen[code] = true
+	}
+	if len(seen) != RecoveryCodeCount {
+		t.Fatal("expected recovery codes to be unique")
+	}
+
+	typed := strings.ToLower(strings.Replace(codes[0], "-", " ", 1))
+	if HashRecoveryCode(typed) != HashRecoveryCode(codes[0]) {
+		t.Fatal("recovery codes should be case, space and dash insensitive")
+	}
+}
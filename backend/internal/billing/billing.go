@@ -0,0 +1,125 @@
+// Package billing turns the daily usage snapshots recorded in the database into monthly invoices.
+// It does no I/O of its own beyond formatting, so invoices can be (re)computed entirely offline.
+package billing
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/pborman/uuid"
+
+	"github.com/ibeckermayer/teleport-interview/backend/internal/model"
+)
+
+// Period returns the billing period (calendar month, UTC) containing t, formatted as model.PeriodFormat
+func Period(t time.Time) string {
+	return t.UTC().Format(model.PeriodFormat)
+}
+
+// daysIn returns the number of days in the billing period
+func daysIn(period string) (int64, error) {
+	start, err := time.Parse(model.PeriodFormat, period)
+	if err != nil {
+		return 0, err
+	}
+	return int64(start.AddDate(0, 1, 0).Sub(start).Hours() / 24), nil
+}
+
+type lineKey struct {
+	plan    model.Plan
+	onTrial bool
+}
+
+// BuildInvoice computes accountID's invoice for period from the usage snapshots taken during that period.
+// Each plan the account was on gets its own line item, charged per active user per day at the plan's
+// monthly per-user price; days spent on a trial get a line item with a zero price.
+func BuildInvoice(accountID, period string, snapshots []model.UsageSnapshot, now time.Time) (model.Invoice, error) {
+	days, err := daysIn(period)
+	if err != nil {
+		return model.Invoice{}, err
+	}
+
+	userDays := make(map[lineKey]int64)
+	for _, s := range snapshots {
+		userDays[lineKey{s.Plan, s.OnTrial}] += s.ActiveUsers
+	}
+
+	keys := make([]lineKey, 0, len(userDays))
+	for k := range userDays {
+		keys = append(keys, k)
+	}
+	// Order line items deterministically: by plan, then paid before trial
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].plan != keys[j].plan {
+			return keys[i].plan < keys[j].plan
+		}
+		return !keys[i].onTrial && keys[j].onTrial
+	})
+
+	inv := model.Invoice{
+		InvoiceID: uuid.New(),
+		AccountID: accountID,
+		Period:    period,
+		CreatedAt: now,
+		LineItems: []model.InvoiceLineItem{},
+	}
+	for _, k := range keys {
+		item := model.InvoiceLineItem{
+			LineItemID: uuid.New(),
+			InvoiceID:  inv.InvoiceID,
+			Plan:       k.plan,
+			OnTrial:    k.onTrial,
+			UserDays:   userDays[k],
+		}
+		if k.onTrial {
+			item.Description = fmt.Sprintf("%v plan trial, active users", k.plan)
+		} else {
+			item.Description = fmt.Sprintf("%v plan, active users", k.plan)
+			item.UnitPriceCents = model.PlanUserMonthlyPriceCents[k.plan]
+		}
+		// Prorate the monthly unit price by day, rounding half up to the nearest cent
+		item.AmountCents = (2*item.UserDays*item.UnitPriceCents + days) / (2 * days)
+		inv.TotalCents += item.AmountCents
+		inv.LineItems = append(inv.LineItems, item)
+	}
+
+	return inv, nil
+}
+
+// formatCents formats an amount of cents as a decimal number of dollars, e.g. 12345 as "123.45"
+func formatCents(cents int64) string {
+	sign := ""
+	if cents < 0 {
+		sign = "-"
+		cents = -cents
+	}
+	return fmt.Sprintf("%v%d.%02d", sign, cents/100, cents%100)
+}
+
+// WriteCSV writes inv as CSV to w: a header row, one row per line item, and a final total row
+func WriteCSV(w io.Writer, inv model.Invoice) error {
+	cw := csv.NewWriter(w)
+	rows := [][]string{{"invoice_id", "account_id", "period", "description", "plan", "user_days", "unit_price", "amount"}}
+	for _, item := range inv.LineItems {
+		rows = append(rows, []string{
+			inv.InvoiceID,
+			inv.AccountID,
+			inv.Period,
+			item.Description,
+			string(item.Plan),
+			strconv.FormatInt(item.UserDays, 10),
+			formatCents(item.UnitPriceCents),
+			formatCents(item.AmountCents),
+		})
+	}
+	rows = append(rows, []string{inv.InvoiceID, inv.AccountID, inv.Period, "Total", "", "", "", formatCents(inv.TotalCents)})
+
+	if err := cw.WriteAll(rows); err != nil {
+		return err
+	}
+	return cw.Error()
+}
@@ -0,0 +1,81 @@
+package billing
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ibeckermayer/teleport-interview/backend/internal/model"
+)
+
+func TestBuildInvoice(t *testing.T) {
+	// September has 30 days: 10 days at 100 active FREE users, then 10 days at 300 users on an ENTERPRISE trial,
+	// then 10 days at 100 users on ENTERPRISE
+	snapshots := []model.UsageSnapshot{}
+	for d := 0; d < 10; d++ {
+		snapshots = append(snapshots, model.UsageSnapshot{Plan: model.FREE, ActiveUsers: 100})
+	}
+	for d := 0; d < 10; d++ {
+		snapshots = append(snapshots, model.UsageSnapshot{Plan: model.ENTERPRISE, OnTrial: true, ActiveUsers: 300})
+	}
+	for d := 0; d < 10; d++ {
+		snapshots = append(snapshots, model.UsageSnapshot{Plan: model.ENTERPRISE, ActiveUsers: 100})
+	}
+
+	inv, err := BuildInvoice("accountID", "2026-09", snapshots, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(inv.LineItems) != 3 {
+		t.Fatalf("expected 3 line items but got %v", len(inv.LineItems))
+	}
+	enterprise, trial, free := inv.LineItems[0], inv.LineItems[1], inv.LineItems[2]
+	// 1000 user days at $1 per user month over a 30 day month
+	if enterprise.Plan != model.ENTERPRISE || enterprise.OnTrial || enterprise.UserDays != 1000 || enterprise.AmountCents != 3333 {
+		t.Fatalf("unexpected ENTERPRISE line item %+v", enterprise)
+	}
+	if !trial.OnTrial || trial.UserDays != 3000 || trial.AmountCents != 0 {
+		t.Fatalf("unexpected trial line item %+v", trial)
+	}
+	// The FREE plan is free
+	if free.Plan != model.FREE || free.UserDays != 1000 || free.UnitPriceCents != 0 || free.AmountCents != 0 {
+		t.Fatalf("unexpected FREE line item %+v", free)
+	}
+	if inv.TotalCents != 3333 {
+		t.Fatalf("expected total of 3333 but got %v", inv.TotalCents)
+	}
+}
+
+func TestBuildInvoiceBadPeriod(t *testing.T) {
+	if _, err := BuildInvoice("accountID", "September", nil, time.Now()); err == nil {
+		t.Fatal("expected an error for an improperly formatted period")
+	}
+}
+
+func TestWriteCSV(t *testing.T) {
+	inv := model.Invoice{
+		InvoiceID:  "invoiceID",
+		AccountID:  "accountID",
+		Period:     "2026-09",
+		TotalCents: 105,
+		LineItems:  []model.InvoiceLineItem{{Description: "ENTERPRISE plan, active users", Plan: model.ENTERPRISE, UserDays: 31, UnitPriceCents: 100, AmountCents: 105}},
+	}
+
+	var buf bytes.Buffer
+	if err := WriteCSV(&buf, inv); err != nil {
+		t.Fatal(err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("expected 3 lines but got %v", len(lines))
+	}
+	if lines[1] != `invoiceID,accountID,2026-09,"ENTERPRISE plan, active users",ENTERPRISE,31,1.00,1.05` {
+		t.Fatalf("unexpected line item row %q", lines[1])
+	}
+	if lines[2] != "invoiceID,accountID,2026-09,Total,,,,1.05" {
+		t.Fatalf("unexpected total row %q", lines[2])
+	}
+}
@@ -1,37 +1,42 @@
 package database
 
 import (
+	"database/sql"
+	"strings"
 	"time"
 
-	"github.com/ibeckermayer/teleport-interview/backend/internal/auth"
 	"github.com/ibeckermayer/teleport-interview/backend/internal/model"
+	"github.com/jmoiron/sqlx"
+	"github.com/pborman/uuid"
 )
 
-func (db *Database) insertAccount(a *model.Account) error {
-	_, err := db.db.NamedExec("INSERT INTO account (account_id, plan, email, password_hash, created_at, updated_at) VALUES (:account_id, :plan, :email, :password_hash, :created_at, :updated_at)", a)
+func insertAccount(tx *sqlx.Tx, a *model.Account) error {
+	_, err := tx.NamedExec("INSERT INTO account (account_id, plan, created_at, updated_at, trial_ends_at, status) VALUES (:account_id, :plan, :created_at, :updated_at, :trial_ends_at, :status)", a)
 	return err
 }
 
-// UpgradeAccount upgrades an account from the FREE to the ENTERPRISE plan. It also updates
-// any users in that account that were previously inactive to active. Returns the total number of users
+// UpgradeAccount upgrades an account from the FREE to the ENTERPRISE plan, ending any trial the account
+// was on and recording the change in the account's plan history as made by changedBy. It also updates
+// any users in that account that were previously inactive to active, except those a member deactivated. Returns the total number of users
 // for the given accountID for ease of use by the UpgradeHandler.
 // TODO: handle case when there wind up being more users than the ENTERPRISE plan allows
-func (db *Database) UpgradeAccount(accountID string) (int, error) {
+func (db *Database) UpgradeAccount(accountID, changedBy string) (int, error) {
 	createUserUpgradeAccountLock.Lock()
 	defer createUserUpgradeAccountLock.Unlock()
 
+	now := time.Now()
 	tx, err := db.db.Begin()
 	if err != nil {
 		return 0, err
 	}
 	// Update the account
-	_, err = tx.Exec("UPDATE account SET plan=$1 WHERE account_id=$2", model.ENTERPRISE, accountID)
+	_, err = changePlan(tx, accountID, model.ENTERPRISE, model.PlanChangeUpgrade, changedBy, now)
 	if err != nil {
 		tx.Rollback()
 		return 0, err
 	}
 
-	_, err = tx.Exec("UPDATE user SET is_active=$1 WHERE is_active=$2 AND account_id=$3", true, false, accountID)
+	_, err = tx.Exec("UPDATE user SET is_active=$1, updated_at=$2 WHERE is_active=$3 AND account_id=$4 AND deactivated_at IS NULL", true, now, false, accountID)
 	if err != nil {
 		tx.Rollback()
 		return 0, err
@@ -53,27 +58,290 @@ func (db *Database) GetAccount(accountID string) (model.Account, error) {
 	return a, err
 }
 
-// GetAccountByEmail retrieves an Account from the database by email address
-func (db *Database) GetAccountByEmail(email string) (model.Account, error) {
-	a := model.Account{}
-	err := db.db.Get(&a, "SELECT * FROM account WHERE email=$1", email)
-	return a, err
-}
-
-// CreateAccount creates a new account and saves it in the database
+// CreateAccount creates a new account, with a member who owns it and logs in with email and password, and the
+// default usage alerts, and saves it all in the database in one transaction. Returns an auth.PolicyError if
+// password isn't allowed by the configured PasswordPolicy, or ErrEmailTaken if another member already uses email.
 func (db *Database) CreateAccount(accountID, email, password string) error {
-	passwordHash, err := auth.HashPassword(password)
+	if err := db.cfg.PasswordPolicy.Check(password, email); err != nil {
+		return err
+	}
+	passwordHash, err := db.cfg.PasswordHasher.Hash(password)
 	if err != nil {
 		return err
 	}
+	now := time.Now()
 	account := &model.Account{
+		AccountID: accountID,
+		Plan:      model.FREE,
+		CreatedAt: now,
+		UpdatedAt: now,
+		Status:    model.AccountActive,
+	}
+	if db.cfg.TrialDuration > 0 {
+		// New accounts start on a trial of the ENTERPRISE plan
+		trialEndsAt := account.CreatedAt.Add(db.cfg.TrialDuration)
+		account.Plan = model.ENTERPRISE
+		account.TrialEndsAt = &trialEndsAt
+	}
+	owner := &model.Member{
+		MemberID:     uuid.New(),
 		AccountID:    accountID,
-		Plan:         model.FREE,
 		Email:        email,
 		PasswordHash: passwordHash,
-		CreatedAt:    time.Now(),
-		UpdatedAt:    time.Now(),
+		Role:         model.RoleOwner,
+		CreatedAt:    now,
+		UpdatedAt:    now,
+	}
+
+	tx, err := db.db.Beginx()
+	if err != nil {
+		return err
 	}
-	err = db.insertAccount(account)
+	if err := insertAccount(tx, account); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err := insertMember(tx, owner); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err := insertPlanHistory(tx.Tx, &model.AccountPlanHistory{
+		PlanHistoryID: uuid.New(),
+		AccountID:     accountID,
+		NewPlan:       account.Plan,
+		Reason:        model.PlanChangeCreated,
+		ChangedBy:     email,
+		ChangedAt:     now,
+	}); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err := setUsageAlerts(tx, accountID, model.DefaultUsageAlertPercents); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// ExpireTrials reverts every account whose trial ended before now to the FREE plan, deactivating
+// any users beyond the FREE plan's limit in the order CreateUser would have (oldest users stay active).
+// Returns the updated accounts so that callers can refresh any cached copies.
+func (db *Database) ExpireTrials(now time.Time) ([]model.Account, error) {
+	trials := []model.Account{}
+	if err := db.db.Select(&trials, "SELECT * FROM account WHERE trial_ends_at IS NOT NULL"); err != nil {
+		return nil, err
+	}
+
+	expired := []model.Account{}
+	for _, account := range trials {
+		if now.Before(*account.TrialEndsAt) {
+			continue
+		}
+		ok, err := db.expireTrial(account.AccountID, now)
+		if err != nil {
+			return expired, err
+		}
+		if !ok {
+			// Account was upgraded since it was selected
+			continue
+		}
+		account, err = db.GetAccount(account.AccountID)
+		if err != nil {
+			return expired, err
+		}
+		expired = append(expired, account)
+	}
+
+	return expired, nil
+}
+
+// expireTrial moves accountID from its trial to the FREE plan. Returns false if the account
+// is no longer on a trial.
+func (db *Database) expireTrial(accountID string, now time.Time) (bool, error) {
+	createUserUpgradeAccountLock.Lock()
+	defer createUserUpgradeAccountLock.Unlock()
+
+	tx, err := db.db.Begin()
+	if err != nil {
+		return false, err
+	}
+
+	var onTrial bool
+	if err := tx.QueryRow("SELECT trial_ends_at IS NOT NULL FROM account WHERE account_id=$1", accountID).Scan(&onTrial); err != nil || !onTrial {
+		tx.Rollback()
+		return false, err
+	}
+
+	if _, err := changePlan(tx, accountID, model.FREE, model.PlanChangeTrialExpired, model.PlanChangedBySystem, now); err != nil {
+		tx.Rollback()
+		return false, err
+	}
+
+	if err := applyPlanLimit(tx, accountID, model.FREE, now); err != nil {
+		tx.Rollback()
+		return false, err
+	}
+
+	return true, tx.Commit()
+}
+
+// applyPlanLimit fits an account's active users to PlanMaxUsers[plan]: if there are too many, the newest are
+// deactivated, and if there are free seats, promoteUsers fills them. Without manual changes this leaves the oldest
+// users active, which is how CreateUser would have assigned them had the account always been on plan.
+// Only users whose is_active value changes have their updated_at set to now.
+// Callers should hold createUserUpgradeAccountLock.
+func applyPlanLimit(tx *sql.Tx, accountID string, plan model.Plan, now time.Time) error {
+	_, err := tx.Exec(`UPDATE user SET is_active=0, updated_at=$1
+		WHERE account_id=$2 AND is_active AND user_id NOT IN (
+			SELECT user_id FROM user WHERE account_id=$2 AND is_active ORDER BY created_at, rowid LIMIT $3)`,
+		now, accountID, model.PlanMaxUsers[plan])
+	if err != nil {
+		return err
+	}
+	_, err = promoteUsers(tx, accountID, plan, now)
 	return err
 }
+
+// promoteUsers activates an account's oldest inactive users until it has PlanMaxUsers[plan] active users or
+// none are left waiting. Users a member deactivated aren't waiting, they stay inactive until a member
+// activates them again, and neither are users the account's inactivity policy would expire, until they're seen
+// again. Returns the IDs of the users promoted. Callers should hold createUserUpgradeAccountLock.
+func promoteUsers(tx *sql.Tx, accountID string, plan model.Plan, now time.Time) ([]string, error) {
+	rows, err := tx.Query(`SELECT user_id FROM user WHERE account_id=$1 AND NOT is_active AND deactivated_at IS NULL
+		AND NOT EXISTS (SELECT 1 FROM account a WHERE a.account_id=$1 AND a.inactivity_days > 0
+			AND julianday(COALESCE(user.last_seen_at, user.created_at)) < julianday($2) - a.inactivity_days)
+		ORDER BY created_at, rowid
+		LIMIT max(0, $3 - (SELECT COUNT(*) FROM user WHERE account_id=$1 AND is_active))`,
+		accountID, now, model.PlanMaxUsers[plan])
+	if err != nil {
+		return nil, err
+	}
+	promoted := []string{}
+	for rows.Next() {
+		var userID string
+		if err := rows.Scan(&userID); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		promoted = append(promoted, userID)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for _, userID := range promoted {
+		if _, err := tx.Exec("UPDATE user SET is_active=1, updated_at=$1 WHERE user_id=$2", now, userID); err != nil {
+			return nil, err
+		}
+	}
+	return promoted, nil
+}
+
+// AccountSummary is an account along with how many users it has, for the admin API
+type AccountSummary struct {
+	model.Account
+	TotalUsers  int `db:"total_users"`
+	ActiveUsers int `db:"active_users"`
+}
+
+// accountSummarySQL selects AccountSummary rows, to be followed by a WHERE clause on the account table "a"
+const accountSummarySQL = `SELECT a.*,
+	(SELECT COUNT(*) FROM user WHERE user.account_id=a.account_id) AS total_users,
+	(SELECT COUNT(*) FROM user WHERE user.account_id=a.account_id AND user.is_active) AS active_users
+	FROM account a `
+
+// SearchAccounts finds up to limit accounts with a member whose email address contains email, ignoring case,
+// newest first
+func (db *Database) SearchAccounts(email string, limit int) ([]AccountSummary, error) {
+	pattern := "%" + strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(email) + "%"
+	accounts := []AccountSummary{}
+	err := db.db.Select(&accounts, accountSummarySQL+`WHERE a.account_id IN (SELECT account_id FROM member WHERE email LIKE $1 ESCAPE '\')
+		ORDER BY a.created_at DESC, a.rowid DESC LIMIT $2`, pattern, limit)
+	return accounts, err
+}
+
+// GetAccountSummary retrieves an AccountSummary from the database by accountID
+func (db *Database) GetAccountSummary(accountID string) (AccountSummary, error) {
+	a := AccountSummary{}
+	err := db.db.Get(&a, accountSummarySQL+"WHERE a.account_id=$1", accountID)
+	return a, err
+}
+
+// ForcePlan moves accountID to plan whatever plan it's on, ending any trial, and recording the change in the
+// account's plan history as made by changedBy. Users beyond the plan's limit are deactivated, and users within
+// it activated, in the order CreateUser would have. Returns the updated account.
+func (db *Database) ForcePlan(accountID string, plan model.Plan, changedBy string, now time.Time) (model.Account, error) {
+	createUserUpgradeAccountLock.Lock()
+	defer createUserUpgradeAccountLock.Unlock()
+
+	tx, err := db.db.Begin()
+	if err != nil {
+		return model.Account{}, err
+	}
+
+	if _, err := changePlan(tx, accountID, plan, model.PlanChangeAdmin, changedBy, now); err != nil {
+		tx.Rollback()
+		return model.Account{}, err
+	}
+
+	if err := applyPlanLimit(tx, accountID, plan, now); err != nil {
+		tx.Rollback()
+		return model.Account{}, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return model.Account{}, err
+	}
+	return db.GetAccount(accountID)
+}
+
+// SetAccountStatus moves accountID to status, to be purged after deleteAfter if status is
+// model.AccountPendingDeletion (deleteAfter is ignored otherwise). Returns the updated account, or
+// sql.ErrNoRows if there's no such account.
+func (db *Database) SetAccountStatus(accountID string, status model.AccountStatus, deleteAfter time.Time, now time.Time) (model.Account, error) {
+	var after *time.Time
+	if status == model.AccountPendingDeletion {
+		after = &deleteAfter
+	}
+	res, err := db.db.Exec("UPDATE account SET status=$1, delete_after=$2, updated_at=$3 WHERE account_id=$4", status, after, now, accountID)
+	if err != nil {
+		return model.Account{}, err
+	}
+	if n, err := res.RowsAffected(); err != nil || n == 0 {
+		if err == nil {
+			err = sql.ErrNoRows
+		}
+		return model.Account{}, err
+	}
+	return db.GetAccount(accountID)
+}
+
+// PurgeAccounts deletes every account pending deletion whose DeleteAfter is before now. Deleting an account
+// cascades to everything it owns: its members, users, metrics, API keys, devices, invoices, webhooks and so on.
+// Only its audit events are kept. Returns the IDs of the accounts purged, which may be some of them if err
+// isn't nil.
+func (db *Database) PurgeAccounts(now time.Time) ([]string, error) {
+	due := []string{}
+	err := db.db.Select(&due, "SELECT account_id FROM account WHERE status=$1 AND julianday(delete_after) < julianday($2)",
+		model.AccountPendingDeletion, now)
+	if err != nil {
+		return nil, err
+	}
+
+	purged := []string{}
+	for _, accountID := range due {
+		// Checked again in case the deletion was cancelled since the select
+		res, err := db.db.Exec("DELETE FROM account WHERE account_id=$1 AND status=$2 AND julianday(delete_after) < julianday($3)",
+			accountID, model.AccountPendingDeletion, now)
+		if err != nil {
+			return purged, err
+		}
+		if n, err := res.RowsAffected(); err != nil {
+			return purged, err
+		} else if n > 0 {
+			purged = append(purged, accountID)
+		}
+	}
+	return purged, nil
+}
@@ -0,0 +1,197 @@
+package database
+
+import (
+	"database/sql"
+	"testing"
+	"time"
+
+	"github.com/ibeckermayer/teleport-interview/backend/internal/auth"
+	"github.com/ibeckermayer/teleport-interview/backend/internal/model"
+)
+
+func TestCreateAccount(t *testing.T) {
+	db := newTestDB(t)
+	if err := db.CreateAccount("first", "owner@example.com", "correct horse battery staple"); err != nil {
+		t.Fatal(err)
+	}
+	alerts, err := db.GetUsageAlerts("first")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(alerts) != len(model.DefaultUsageAlertPercents) {
+		t.Fatalf("expected the default usage alerts but got %+v", alerts)
+	}
+
+	// Nothing is left behind when the account can't be created
+	if err := db.CreateAccount("second", "owner@example.com", "correct horse battery staple"); err != ErrEmailTaken {
+		t.Fatalf("expected ErrEmailTaken but got %v", err)
+	}
+	if _, err := db.GetAccount("second"); err != sql.ErrNoRows {
+		t.Fatalf("expected no account but got %v", err)
+	}
+	if alerts, err := db.GetUsageAlerts("second"); err != nil || len(alerts) != 0 {
+		t.Fatalf("expected no usage alerts but got %+v, %v", alerts, err)
+	}
+}
+
+// populateAccount stores something in every table an account owns rows of, directly or through its members,
+// users, webhooks or invoices
+func populateAccount(t *testing.T, db *Database, accountID string) {
+	t.Helper()
+	now := time.Now().UTC()
+	owner, err := db.GetMemberByEmail(accountID + "@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := auth.NewKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	webhook, err := db.CreateWebhook(accountID, "https://example.com/hook", "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, step := range []func() error{
+		func() error { return db.CreateAPIkey(key, accountID) },
+		func() error {
+			return db.CreateInvite(model.Invite{InviteID: accountID + "-invite", AccountID: accountID, Email: accountID + "-admin@example.com",
+				Role: model.RoleAdmin, InvitedBy: owner.MemberID, CreatedAt: now, ExpiresAt: now.Add(time.Hour)})
+		},
+		func() error {
+			_, err := db.AcceptInvite(accountID+"-invite", owner.PasswordHash, now)
+			return err
+		},
+		func() error {
+			return db.CreatePasswordReset(model.PasswordReset{TokenHash: accountID + "-reset", MemberID: owner.MemberID, CreatedAt: now, ExpiresAt: now.Add(time.Hour)})
+		},
+		func() error {
+			return db.CreateEmailChange(model.EmailChange{TokenHash: accountID + "-change", MemberID: owner.MemberID, NewEmail: accountID + "-new@example.com",
+				CreatedAt: now, ExpiresAt: now.Add(time.Hour)})
+		},
+		func() error {
+			_, err := db.SetPendingTOTP(owner.MemberID, "secret", now)
+			return err
+		},
+		func() error {
+			_, err := db.EnableTOTP(owner.MemberID, 1, []string{accountID + "-code"}, now)
+			return err
+		},
+		func() error {
+			_, _, _, err := db.CreateUser(accountID+"-user", accountID)
+			return err
+		},
+		func() error {
+			seen := now.AddDate(0, 0, -30)
+			return db.CreateMetric(model.Metric{AccountID: accountID, UserID: accountID + "-user", Timestamp: seen, EventType: model.MetricLogin}, seen)
+		},
+		func() error {
+			_, err := db.SetInactivityDays(accountID, 7, now)
+			return err
+		},
+		func() error {
+			_, err := db.ExpireInactiveUsers(now)
+			return err
+		},
+		func() error {
+			return db.CreateEvent(accountID, model.EventUserCreated, map[string]string{"user_id": accountID + "-user"})
+		},
+		func() error {
+			deliveries, err := db.GetDeliveries(accountID, 1)
+			if err != nil || len(deliveries) != 1 || deliveries[0].WebhookID != webhook.WebhookID {
+				t.Fatalf("expected a delivery to the webhook but got %+v, %v", deliveries, err)
+			}
+			return db.RecordWebhookAttempt(deliveries[0], model.WebhookAttempt{AttemptID: accountID + "-attempt", DeliveryID: deliveries[0].DeliveryID,
+				StatusCode: 500, AttemptedAt: now})
+		},
+		func() error {
+			_, err := db.CreateDeviceCA(accountID, "cert", "key", now)
+			return err
+		},
+		func() error {
+			return db.CreateDevice(model.Device{Serial: accountID + "-serial", AccountID: accountID, Name: "device", CreatedAt: now, ExpiresAt: now.Add(time.Hour)})
+		},
+		func() error { return db.SnapshotUsage(now) },
+		func() error {
+			return db.CreateInvoice(model.Invoice{InvoiceID: accountID + "-invoice", AccountID: accountID, Period: "2020-01", CreatedAt: now,
+				LineItems: []model.InvoiceLineItem{{LineItemID: accountID + "-item", InvoiceID: accountID + "-invoice", Plan: model.FREE}}})
+		},
+		func() error {
+			return db.InsertAuditEvent(model.AuditEvent{Seq: 1, AccountID: accountID, Actor: "system", Action: model.AuditAdminSuspend,
+				Outcome: model.AuditSuccess, CreatedAt: now})
+		},
+	} {
+		if err := step(); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// tableCounts returns how many rows each table has
+func tableCounts(t *testing.T, db *Database) map[string]int {
+	t.Helper()
+	tables := []string{}
+	if err := db.db.Select(&tables, "SELECT name FROM sqlite_master WHERE type='table'"); err != nil {
+		t.Fatal(err)
+	}
+	counts := map[string]int{}
+	for _, table := range tables {
+		var n int
+		if err := db.db.Get(&n, "SELECT count(*) FROM "+table); err != nil {
+			t.Fatal(err)
+		}
+		counts[table] = n
+	}
+	return counts
+}
+
+func TestPurgeAccounts(t *testing.T) {
+	now := time.Now().UTC()
+	tests := []struct {
+		name        string
+		status      model.AccountStatus
+		deleteAfter time.Time
+		wantPurged  bool
+	}{
+		{name: "pending deletion past its grace period", status: model.AccountPendingDeletion, deleteAfter: now.Add(-time.Minute), wantPurged: true},
+		{name: "pending deletion within its grace period", status: model.AccountPendingDeletion, deleteAfter: now.Add(time.Minute)},
+		{name: "suspended", status: model.AccountSuspended, deleteAfter: now.Add(-time.Minute)},
+		{name: "active", status: model.AccountActive, deleteAfter: now.Add(-time.Minute)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := newTestDB(t)
+			accountID := newTestAccount(t, db, model.FREE)
+			populateAccount(t, db, accountID)
+			before := tableCounts(t, db)
+			for table, n := range before {
+				// Neither belongs to an account
+				if n == 0 && table != "staff" && table != "login_throttle" {
+					t.Fatalf("expected populateAccount to have stored something in %v", table)
+				}
+			}
+			if _, err := db.SetAccountStatus(accountID, tt.status, tt.deleteAfter, now); err != nil {
+				t.Fatal(err)
+			}
+
+			purged, err := db.PurgeAccounts(now)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.wantPurged != (len(purged) == 1) {
+				t.Fatalf("got purged accounts %v, want purged %v", purged, tt.wantPurged)
+			}
+
+			for table, n := range tableCounts(t, db) {
+				switch {
+				case !tt.wantPurged && n != before[table]:
+					t.Errorf("expected the account's %v rows to be kept but went from %v to %v", table, before[table], n)
+				case tt.wantPurged && table == "audit_event" && n != before[table]:
+					t.Errorf("expected the account's audit events to be kept but went from %v to %v", before[table], n)
+				case tt.wantPurged && table != "audit_event" && n != 0:
+					t.Errorf("expected the account's %v rows to be purged but %v are left", table, n)
+				}
+			}
+		})
+	}
+}
@@ -1,6 +1,8 @@
 package database
 
 import (
+	"database/sql"
+
 	"github.com/ibeckermayer/teleport-interview/backend/internal/auth"
 	"github.com/ibeckermayer/teleport-interview/backend/internal/model"
 )
@@ -24,3 +26,37 @@ func (db *Database) CreateAPIkey(key auth.Key, accountID string) error {
 	apikey := &model.APIkey{KeyHash: keyHash, AccountID: accountID}
 	return db.insertAPIkey(apikey)
 }
+
+// ResetAPIkey replaces accountID's API keys with key, so that devices using the old one are refused. The new
+// key has no signing key until one is issued with SetSigningKey.
+func (db *Database) ResetAPIkey(key auth.Key, accountID string) error {
+	tx, err := db.db.Begin()
+	if err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM apikey WHERE account_id=$1", accountID); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if _, err := tx.Exec("INSERT INTO apikey (key_hash, account_id) VALUES ($1, $2)", auth.HashKey(key), accountID); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// SetSigningKey gives accountID's API key a new signing key ID, so that requests signed with its previous signing
+// key are refused, and sets whether it requires signed requests. Returns the updated key, or sql.ErrNoRows if
+// the account has no API key.
+func (db *Database) SetSigningKey(accountID, signingKeyID string, requireSigned bool) (model.APIkey, error) {
+	res, err := db.db.Exec("UPDATE apikey SET signing_key_id=$1, require_signed=$2 WHERE account_id=$3", signingKeyID, requireSigned, accountID)
+	if err != nil {
+		return model.APIkey{}, err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return model.APIkey{}, err
+	} else if n == 0 {
+		return model.APIkey{}, sql.ErrNoRows
+	}
+	return db.GetAPIkey(accountID)
+}
@@ -0,0 +1,52 @@
+package database
+
+import (
+	"time"
+
+	"github.com/ibeckermayer/teleport-interview/backend/internal/model"
+)
+
+// LastAuditEvent retrieves the most recent audit event. Returns sql.ErrNoRows if there are none.
+func (db *Database) LastAuditEvent() (model.AuditEvent, error) {
+	e := model.AuditEvent{}
+	err := db.db.Get(&e, "SELECT * FROM audit_event ORDER BY seq DESC LIMIT 1")
+	return e, err
+}
+
+// InsertAuditEvent appends an audit event. Its Seq, PrevHash and Hash must already have been set by the audit log.
+func (db *Database) InsertAuditEvent(e model.AuditEvent) error {
+	_, err := db.db.NamedExec(`INSERT INTO audit_event
+		(seq, account_id, actor, action, outcome, detail, ip, user_agent, created_at, prev_hash, hash)
+		VALUES (:seq, :account_id, :actor, :action, :outcome, :detail, :ip, :user_agent, :created_at, :prev_hash, :hash)`, e)
+	return err
+}
+
+// GetAuditEventsAfter retrieves up to limit audit events with a seq greater than seq, oldest first, across all accounts
+func (db *Database) GetAuditEventsAfter(seq int64, limit int) ([]model.AuditEvent, error) {
+	events := []model.AuditEvent{}
+	err := db.db.Select(&events, "SELECT * FROM audit_event WHERE seq > $1 ORDER BY seq LIMIT $2", seq, limit)
+	return events, err
+}
+
+// AuditFilter narrows down the audit events GetAuditEvents returns. Zero fields don't filter.
+type AuditFilter struct {
+	Action  model.AuditAction
+	Outcome model.AuditOutcome
+	Since   *time.Time // only events at or after Since
+	Until   *time.Time // only events before Until
+	Before  int64      // only events with a seq less than Before, for fetching the next page
+}
+
+// GetAuditEvents retrieves up to limit of an account's audit events matching filter, most recent first
+func (db *Database) GetAuditEvents(accountID string, filter AuditFilter, limit int) ([]model.AuditEvent, error) {
+	events := []model.AuditEvent{}
+	err := db.db.Select(&events, `SELECT * FROM audit_event WHERE account_id=$1
+		AND ($2 = '' OR action=$2)
+		AND ($3 = '' OR outcome=$3)
+		AND ($4 IS NULL OR julianday(created_at) >= julianday($4))
+		AND ($5 IS NULL OR julianday(created_at) < julianday($5))
+		AND ($6 = 0 OR seq < $6)
+		ORDER BY seq DESC LIMIT $7`,
+		accountID, filter.Action, filter.Outcome, filter.Since, filter.Until, filter.Before, limit)
+	return events, err
+}
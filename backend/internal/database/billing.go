@@ -0,0 +1,91 @@
+package database
+
+import (
+	"time"
+
+	"github.com/ibeckermayer/teleport-interview/backend/internal/model"
+)
+
+// SnapshotUsage records the billable state of every account for the UTC day containing now. Calling it
+// again on the same day replaces that day's snapshot, so the last snapshot of each day is the one billed.
+func (db *Database) SnapshotUsage(now time.Time) error {
+	dayStart := now.UTC().Truncate(24 * time.Hour)
+	dayEnd := dayStart.Add(24 * time.Hour)
+
+	_, err := db.db.Exec(`INSERT OR REPLACE INTO usage_snapshot
+		(account_id, day, plan, on_trial, active_users, total_users, seen_users, created_at)
+		SELECT a.account_id, $1, a.plan, a.trial_ends_at IS NOT NULL,
+			(SELECT count(*) FROM user u WHERE u.account_id=a.account_id AND u.is_active),
+			(SELECT count(*) FROM user u WHERE u.account_id=a.account_id),
+			(SELECT count(DISTINCT m.user_id) FROM metric m WHERE m.account_id=a.account_id
+				AND julianday(m.timestamp) >= julianday($2) AND julianday(m.timestamp) < julianday($3) AND m.event_type!=$4),
+			$5
+		FROM account a`,
+		dayStart.Format(model.DayFormat), dayStart, dayEnd, model.MetricLoginFailure, now)
+	return err
+}
+
+// GetUsageSnapshots retrieves accountID's snapshots for the billing period (formatted as model.PeriodFormat), oldest first
+func (db *Database) GetUsageSnapshots(accountID, period string) ([]model.UsageSnapshot, error) {
+	snapshots := []model.UsageSnapshot{}
+	err := db.db.Select(&snapshots, "SELECT * FROM usage_snapshot WHERE account_id=$1 AND substr(day, 1, 7)=$2 ORDER BY day", accountID, period)
+	return snapshots, err
+}
+
+// UninvoicedPeriod is an account and billing period that has usage snapshots but no invoice
+type UninvoicedPeriod struct {
+	AccountID string `db:"account_id"`
+	Period    string `db:"period"`
+}
+
+// GetUninvoicedPeriods finds every account/period pair before the period `before` that has usage snapshots but no invoice yet
+func (db *Database) GetUninvoicedPeriods(before string) ([]UninvoicedPeriod, error) {
+	periods := []UninvoicedPeriod{}
+	err := db.db.Select(&periods, `SELECT DISTINCT s.account_id, substr(s.day, 1, 7) AS period FROM usage_snapshot s
+		WHERE substr(s.day, 1, 7) < $1 AND NOT EXISTS (
+			SELECT 1 FROM invoice i WHERE i.account_id=s.account_id AND i.period=substr(s.day, 1, 7))
+		ORDER BY period, s.account_id`, before)
+	return periods, err
+}
+
+// CreateInvoice saves an invoice and its line items in a single transaction
+func (db *Database) CreateInvoice(inv model.Invoice) error {
+	tx, err := db.db.Beginx()
+	if err != nil {
+		return err
+	}
+
+	if _, err := tx.NamedExec("INSERT INTO invoice (invoice_id, account_id, period, total_cents, created_at) VALUES (:invoice_id, :account_id, :period, :total_cents, :created_at)", inv); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	for _, item := range inv.LineItems {
+		if _, err := tx.NamedExec("INSERT INTO invoice_line_item (line_item_id, invoice_id, description, plan, on_trial, user_days, unit_price_cents, amount_cents) VALUES (:line_item_id, :invoice_id, :description, :plan, :on_trial, :user_days, :unit_price_cents, :amount_cents)", item); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// GetInvoices retrieves accountID's invoices, without their line items, most recent period first
+func (db *Database) GetInvoices(accountID string) ([]model.Invoice, error) {
+	invoices := []model.Invoice{}
+	err := db.db.Select(&invoices, "SELECT * FROM invoice WHERE account_id=$1 ORDER BY period DESC", accountID)
+	return invoices, err
+}
+
+// GetInvoice retrieves an invoice with its line items. The accountID must match the invoice's,
+// so that accounts can only ever see their own invoices. Returns sql.ErrNoRows if no such invoice exists.
+func (db *Database) GetInvoice(accountID, invoiceID string) (model.Invoice, error) {
+	inv := model.Invoice{}
+	if err := db.db.Get(&inv, "SELECT * FROM invoice WHERE invoice_id=$1 AND account_id=$2", invoiceID, accountID); err != nil {
+		return inv, err
+	}
+
+	inv.LineItems = []model.InvoiceLineItem{}
+	err := db.db.Select(&inv.LineItems, "SELECT * FROM invoice_line_item WHERE invoice_id=$1 ORDER BY rowid", invoiceID)
+	return inv, err
+}
@@ -0,0 +1,188 @@
+package database
+
+import (
+	"database/sql"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/ibeckermayer/teleport-interview/backend/internal/billing"
+	"github.com/ibeckermayer/teleport-interview/backend/internal/model"
+)
+
+func TestSnapshotUsage(t *testing.T) {
+	now := time.Date(2020, 3, 15, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name        string
+		metrics     []model.Metric
+		wantSeen    int64
+		wantActive  int64
+		wantTotal   int64
+		createUsers []string
+	}{
+		{
+			name:     "no metrics",
+			wantSeen: 0,
+		},
+		{
+			name: "metrics during the day",
+			metrics: []model.Metric{
+				{UserID: "a", Timestamp: now.Add(-time.Hour), EventType: model.MetricLogin},
+				{UserID: "a", Timestamp: now.Add(time.Hour), EventType: model.MetricLogout},
+				{UserID: "b", Timestamp: now.Truncate(24 * time.Hour), EventType: model.MetricLogin},
+			},
+			createUsers: []string{"a", "b"},
+			wantSeen:    2,
+			wantActive:  2,
+			wantTotal:   2,
+		},
+		{
+			name: "failed logins and other days aren't seen",
+			metrics: []model.Metric{
+				{UserID: "a", Timestamp: now, EventType: model.MetricLogin},
+				{UserID: "b", Timestamp: now, EventType: model.MetricLoginFailure},
+				{UserID: "c", Timestamp: now.Add(-24 * time.Hour), EventType: model.MetricLogin},
+				{UserID: "d", Timestamp: now.Truncate(24 * time.Hour).Add(24 * time.Hour), EventType: model.MetricLogin},
+			},
+			createUsers: []string{"a", "c"},
+			wantSeen:    1,
+			wantActive:  2,
+			wantTotal:   2,
+		},
+		{
+			name: "other timezones",
+			metrics: []model.Metric{
+				{UserID: "a", Timestamp: now.In(time.FixedZone("UTC-10", -10*60*60)), EventType: model.MetricLogin},
+			},
+			wantSeen: 1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := newTestDB(t)
+			accountID := newTestAccount(t, db, model.FREE)
+			for _, m := range tt.metrics {
+				m.AccountID = accountID
+				if err := db.CreateMetric(m, now); err != nil {
+					t.Fatal(err)
+				}
+			}
+			for _, userID := range tt.createUsers {
+				if _, _, _, err := db.CreateUser(userID, accountID); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			if err := db.SnapshotUsage(now); err != nil {
+				t.Fatal(err)
+			}
+			snapshots, err := db.GetUsageSnapshots(accountID, now.Format(model.PeriodFormat))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(snapshots) != 1 {
+				t.Fatalf("got %v snapshots, want 1", len(snapshots))
+			}
+			s := snapshots[0]
+			if s.Day != now.Format(model.DayFormat) || s.Plan != model.FREE {
+				t.Errorf("got day %v plan %v, want %v %v", s.Day, s.Plan, now.Format(model.DayFormat), model.FREE)
+			}
+			if s.SeenUsers != tt.wantSeen || s.ActiveUsers != tt.wantActive || s.TotalUsers != tt.wantTotal {
+				t.Errorf("got seen=%v active=%v total=%v, want seen=%v active=%v total=%v",
+					s.SeenUsers, s.ActiveUsers, s.TotalUsers, tt.wantSeen, tt.wantActive, tt.wantTotal)
+			}
+		})
+	}
+}
+
+func TestInvoices(t *testing.T) {
+	db := newTestDB(t)
+	accountID := newTestAccount(t, db, model.FREE)
+	for _, userID := range []string{"a", "b"} {
+		if _, _, _, err := db.CreateUser(userID, accountID); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, day := range []time.Time{
+		time.Date(2020, 2, 10, 12, 0, 0, 0, time.UTC),
+		time.Date(2020, 2, 11, 12, 0, 0, 0, time.UTC),
+		time.Date(2020, 3, 5, 12, 0, 0, 0, time.UTC),
+	} {
+		if err := db.SnapshotUsage(day); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	uninvoiced := func(before string) []string {
+		t.Helper()
+		periods, err := db.GetUninvoicedPeriods(before)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := []string{}
+		for _, p := range periods {
+			if p.AccountID != accountID {
+				t.Fatalf("got a period of another account: %+v", p)
+			}
+			got = append(got, p.Period)
+		}
+		return got
+	}
+	for _, tt := range []struct {
+		before string
+		want   []string
+	}{
+		{"2020-02", []string{}},
+		{"2020-03", []string{"2020-02"}},
+		{"2020-04", []string{"2020-02", "2020-03"}},
+	} {
+		if got := uninvoiced(tt.before); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("uninvoiced periods before %v: got %v, want %v", tt.before, got, tt.want)
+		}
+	}
+
+	snapshots, err := db.GetUsageSnapshots(accountID, "2020-02")
+	if err != nil {
+		t.Fatal(err)
+	}
+	inv, err := billing.BuildInvoice(accountID, "2020-02", snapshots, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.CreateInvoice(inv); err != nil {
+		t.Fatal(err)
+	}
+	if got := uninvoiced("2020-04"); !reflect.DeepEqual(got, []string{"2020-03"}) {
+		t.Errorf("expected only 2020-03 to be left to invoice but got %v", got)
+	}
+
+	// A period is only ever invoiced once, and a failed invoice leaves nothing behind
+	again, err := billing.BuildInvoice(accountID, "2020-02", snapshots, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.CreateInvoice(again); err == nil {
+		t.Error("expected a second invoice for the period to be refused")
+	}
+	var lineItems int
+	if err := db.db.Get(&lineItems, "SELECT count(*) FROM invoice_line_item WHERE invoice_id=$1", again.InvoiceID); err != nil || lineItems != 0 {
+		t.Errorf("expected the refused invoice to have no line items but got %v, %v", lineItems, err)
+	}
+
+	invoices, err := db.GetInvoices(accountID)
+	if err != nil || len(invoices) != 1 || invoices[0].InvoiceID != inv.InvoiceID || invoices[0].TotalCents != inv.TotalCents {
+		t.Fatalf("expected the invoice to be listed but got %+v, %v", invoices, err)
+	}
+	got, err := db.GetInvoice(accountID, inv.InvoiceID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got.LineItems) != 1 || got.LineItems[0].UserDays != 4 || got.LineItems[0].Plan != model.FREE ||
+		got.LineItems[0].AmountCents != inv.LineItems[0].AmountCents {
+		t.Errorf("expected one FREE line item for 4 user days but got %+v", got.LineItems)
+	}
+	if _, err := db.GetInvoice("other", inv.InvoiceID); err != sql.ErrNoRows {
+		t.Errorf("expected another account not to see the invoice but got %v", err)
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"log"
 	"os"
 	"sync"
+	"time"
 
 	"github.com/ibeckermayer/teleport-interview/backend/internal/auth"
 	"github.com/ibeckermayer/teleport-interview/backend/internal/model"
@@ -19,8 +20,16 @@ var createUserUpgradeAccountLock = sync.Mutex{}
 // Config is a database config object.
 // Env determines whether the production or development database is created/used;
 // if \"dev\", the app will seed the database with sample data for manual testing.
+// TrialDuration is how long newly created accounts get the ENTERPRISE plan for free;
+// zero disables trials.
+// PasswordPolicy decides which passwords CreateAccount accepts, and PasswordHasher how it hashes them.
+// File is where the database is stored, "./teleport-interview-<Env>.db" if empty.
 type Config struct {
-	Env string
+	Env            string
+	File           string
+	TrialDuration  time.Duration
+	PasswordPolicy auth.PasswordPolicy
+	PasswordHasher auth.PasswordHasher
 }
 
 // Database is a handle to the database layer
@@ -32,12 +41,19 @@ type Database struct {
 // New creates a new *Database and initializes it's schema.
 // Set filldb to true to fill the database with some fake data (for development purposes).
 func New(cfg Config) (*Database, error) {
-	dbfile := "./teleport-interview-" + cfg.Env + ".db"
+	dbfile := cfg.File
+	if dbfile == "" {
+		dbfile = "./teleport-interview-" + cfg.Env + ".db"
+	}
 	if cfg.Env == "dev" {
 		// Reset db for every dev restart
 		os.Remove(dbfile)
 	}
-	sqlxdb, err := sqlx.Open("sqlite3", dbfile)
+	if err := migrate(dbfile); err != nil {
+		return nil, err
+	}
+	// SQLite only enforces foreign keys, and so only cascades deletes, on connections that turn them on
+	sqlxdb, err := sqlx.Open("sqlite3", dbfile+"?_foreign_keys=1")
 	if err != nil {
 		return nil, err
 	}
@@ -61,6 +77,14 @@ func (db *Database) init() error {
 		return err
 	}
 
+	if _, err := db.db.Exec(model.MemberTableSQL); err != nil {
+		return err
+	}
+
+	if _, err := db.db.Exec(model.InviteTableSQL); err != nil {
+		return err
+	}
+
 	if _, err := db.db.Exec(model.APIkeyTableSQL); err != nil {
 		return err
 	}
@@ -73,22 +97,105 @@ func (db *Database) init() error {
 		return err
 	}
 
+	if _, err := db.db.Exec(model.UserExpiryTableSQL); err != nil {
+		return err
+	}
+
+	if _, err := db.db.Exec(model.AccountPlanHistoryTableSQL); err != nil {
+		return err
+	}
+
+	if _, err := db.db.Exec(model.UsageSnapshotTableSQL); err != nil {
+		return err
+	}
+
+	if _, err := db.db.Exec(model.InvoiceTableSQL); err != nil {
+		return err
+	}
+
+	if _, err := db.db.Exec(model.InvoiceLineItemTableSQL); err != nil {
+		return err
+	}
+
+	if _, err := db.db.Exec(model.WebhookTableSQL); err != nil {
+		return err
+	}
+
+	if _, err := db.db.Exec(model.WebhookDeliveryTableSQL); err != nil {
+		return err
+	}
+
+	if _, err := db.db.Exec(model.WebhookAttemptTableSQL); err != nil {
+		return err
+	}
+
+	if _, err := db.db.Exec(model.UsageAlertTableSQL); err != nil {
+		return err
+	}
+
+	if _, err := db.db.Exec(model.LoginThrottleTableSQL); err != nil {
+		return err
+	}
+
+	if _, err := db.db.Exec(model.MemberTOTPTableSQL); err != nil {
+		return err
+	}
+
+	if _, err := db.db.Exec(model.RecoveryCodeTableSQL); err != nil {
+		return err
+	}
+
+	if _, err := db.db.Exec(model.PasswordResetTableSQL); err != nil {
+		return err
+	}
+
+	if _, err := db.db.Exec(model.EmailChangeTableSQL); err != nil {
+		return err
+	}
+
+	if _, err := db.db.Exec(model.DeviceCATableSQL); err != nil {
+		return err
+	}
+
+	if _, err := db.db.Exec(model.DeviceTableSQL); err != nil {
+		return err
+	}
+
+	if _, err := db.db.Exec(model.AuditEventTableSQL); err != nil {
+		return err
+	}
+
+	if _, err := db.db.Exec(model.StaffTableSQL); err != nil {
+		return err
+	}
+
 	if db.cfg.Env == "dev" {
 		// Fill the db with data for development purposes
-		devNamePwd := "dev@goteleport.com"
-		fakeiotTestNamePwd := "test@goteleport.com"
+		devEmail, devPassword := "dev@goteleport.com", "dev-dashboard-login"
+		fakeiotTestEmail, fakeiotTestPassword := "test@goteleport.com", "fakeiot-test-login"
 		devAcctID := uuid.New()
 		fakeiotTestAcctID := "testacct-0000-0000-0000-000000000000"
 		devKey, _ := auth.NewKey()
 		fakeiotTestKey, _ := auth.NewKey()
 
-		db.CreateAccount(devAcctID, devNamePwd, devNamePwd)
-		db.CreateAccount(fakeiotTestAcctID, fakeiotTestNamePwd, fakeiotTestNamePwd)
+		if err := db.CreateAccount(devAcctID, devEmail, devPassword); err != nil {
+			return err
+		}
+		if err := db.CreateAccount(fakeiotTestAcctID, fakeiotTestEmail, fakeiotTestPassword); err != nil {
+			return err
+		}
 		db.CreateAPIkey(devKey, devAcctID)
 		db.CreateAPIkey(fakeiotTestKey, fakeiotTestAcctID)
 
-		log.Printf("Created dev account with account_id=%v, username/pwd=%v, and token=%v", devAcctID, devNamePwd, devKey)
-		log.Printf("Created fakeiot test account with account_id=%v, username/pwd=%v, and token=%v", fakeiotTestAcctID, fakeiotTestNamePwd, fakeiotTestKey)
+		devStaffEmail := "support@goteleport.com"
+		devStaffKey, _ := auth.NewKey()
+		if _, err := db.CreateStaff(devStaffEmail, devStaffKey); err != nil {
+			return err
+		}
+
+		log.Printf("Created dev account with account_id=%v, email=%v, password=%v, and token=%v", devAcctID, devEmail, devPassword, devKey)
+		log.Printf("Created fakeiot test account with account_id=%v, email=%v, password=%v, and token=%v", fakeiotTestAcctID, fakeiotTestEmail, fakeiotTestPassword, fakeiotTestKey)
+		log.Printf("Created dev staff with email=%v and admin API key=%v", devStaffEmail, devStaffKey)
 
 	}
 
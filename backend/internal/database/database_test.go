@@ -0,0 +1,54 @@
+package database
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ibeckermayer/teleport-interview/backend/internal/auth"
+	"github.com/ibeckermayer/teleport-interview/backend/internal/model"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// newTestDB creates an empty database in a temporary directory that's removed when the test ends
+func newTestDB(t *testing.T) *Database {
+	t.Helper()
+	db, err := New(Config{Env: "test", File: filepath.Join(t.TempDir(), "test.db"), PasswordHasher: auth.PasswordHasher{BcryptCost: 4}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.db.Close() })
+	return db
+}
+
+// newTestAccount creates an account on plan, with no trial, and returns its ID
+func newTestAccount(t *testing.T, db *Database, plan model.Plan) string {
+	t.Helper()
+	accountID := "acct-" + t.Name()
+	if err := db.CreateAccount(accountID, accountID+"@example.com", "correct horse battery staple"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.ForcePlan(accountID, plan, "test", time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	return accountID
+}
+
+// setPlanMaxUsers lowers the plans' user limits to free and enterprise until the test ends, so that tests don't
+// need hundreds of users to fill them
+func setPlanMaxUsers(t *testing.T, free, enterprise int) {
+	t.Helper()
+	old := model.PlanMaxUsers
+	model.PlanMaxUsers = map[model.Plan]int{model.FREE: free, model.ENTERPRISE: enterprise}
+	t.Cleanup(func() { model.PlanMaxUsers = old })
+}
+
+// activeUsers returns the IDs of accountID's active users, oldest first
+func activeUsers(t *testing.T, db *Database, accountID string) []string {
+	t.Helper()
+	active := []string{}
+	if err := db.db.Select(&active, "SELECT user_id FROM user WHERE account_id=$1 AND is_active ORDER BY created_at, rowid", accountID); err != nil {
+		t.Fatal(err)
+	}
+	return active
+}
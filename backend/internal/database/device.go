@@ -0,0 +1,65 @@
+package database
+
+import (
+	"time"
+
+	"github.com/ibeckermayer/teleport-interview/backend/internal/model"
+)
+
+// GetDeviceCA retrieves an account's device CA. Returns sql.ErrNoRows if the account has never registered a device.
+func (db *Database) GetDeviceCA(accountID string) (model.DeviceCA, error) {
+	ca := model.DeviceCA{}
+	err := db.db.Get(&ca, "SELECT * FROM device_ca WHERE account_id=$1", accountID)
+	return ca, err
+}
+
+// CreateDeviceCA saves a new device CA for an account unless it already has one, and returns whichever
+// CA the account ends up with, so that concurrent callers all use the same CA
+func (db *Database) CreateDeviceCA(accountID, certPEM, keyPEM string, now time.Time) (model.DeviceCA, error) {
+	if _, err := db.db.Exec("INSERT OR IGNORE INTO device_ca (account_id, cert_pem, key_pem, created_at) VALUES ($1, $2, $3, $4)",
+		accountID, certPEM, keyPEM, now); err != nil {
+		return model.DeviceCA{}, err
+	}
+	return db.GetDeviceCA(accountID)
+}
+
+// CreateDevice saves a newly issued device certificate
+func (db *Database) CreateDevice(d model.Device) error {
+	_, err := db.db.NamedExec(`INSERT INTO device (serial, account_id, name, created_at, expires_at)
+		VALUES (:serial, :account_id, :name, :created_at, :expires_at)`, d)
+	return err
+}
+
+// GetDevice retrieves a device by its certificate's serial number
+func (db *Database) GetDevice(serial string) (model.Device, error) {
+	d := model.Device{}
+	err := db.db.Get(&d, "SELECT * FROM device WHERE serial=$1", serial)
+	return d, err
+}
+
+// GetDevices retrieves all of an account's devices, newest first
+func (db *Database) GetDevices(accountID string) ([]model.Device, error) {
+	devices := []model.Device{}
+	err := db.db.Select(&devices, "SELECT * FROM device WHERE account_id=$1 ORDER BY julianday(created_at) DESC", accountID)
+	return devices, err
+}
+
+// GetRevokedDevices retrieves an account's revoked devices whose certificates haven't expired yet,
+// which are the ones its CRL has to list
+func (db *Database) GetRevokedDevices(accountID string, now time.Time) ([]model.Device, error) {
+	devices := []model.Device{}
+	err := db.db.Select(&devices, `SELECT * FROM device WHERE account_id=$1 AND revoked_at IS NOT NULL
+		AND julianday(expires_at) > julianday($2) ORDER BY julianday(revoked_at)`, accountID, now)
+	return devices, err
+}
+
+// RevokeDevice revokes one of an account's devices. Returns false if the account has no such device
+// or it was already revoked.
+func (db *Database) RevokeDevice(accountID, serial string, now time.Time) (bool, error) {
+	res, err := db.db.Exec("UPDATE device SET revoked_at=$1 WHERE serial=$2 AND account_id=$3 AND revoked_at IS NULL", now, serial, accountID)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
@@ -0,0 +1,114 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/ibeckermayer/teleport-interview/backend/internal/model"
+	"github.com/jmoiron/sqlx"
+)
+
+// ErrEmailTaken is returned when a member would be created with, or have their email changed to, an email
+// address another member already uses
+var ErrEmailTaken = errors.New("the email address is already in use")
+
+// emailTaken reports whether any member uses email
+func emailTaken(q sqlx.Queryer, email string) (bool, error) {
+	var count int
+	err := sqlx.Get(q, &count, "SELECT COUNT(*) FROM member WHERE email=$1", email)
+	return count > 0, err
+}
+
+// CreateEmailChange saves a new request to change a member's email address. Returns ErrEmailTaken if
+// another member already uses the new address.
+func (db *Database) CreateEmailChange(ec model.EmailChange) error {
+	taken, err := emailTaken(db.db, ec.NewEmail)
+	if err != nil {
+		return err
+	}
+	if taken {
+		return ErrEmailTaken
+	}
+	_, err = db.db.NamedExec("INSERT INTO email_change (token_hash, member_id, new_email, created_at, expires_at) VALUES (:token_hash, :member_id, :new_email, :created_at, :expires_at)", ec)
+	return err
+}
+
+// CountEmailChanges returns the number of a member's email change tokens that are unused and unexpired at now
+func (db *Database) CountEmailChanges(memberID string, now time.Time) (int, error) {
+	var count int
+	err := db.db.Get(&count, "SELECT COUNT(*) FROM email_change WHERE member_id=$1 AND used_at IS NULL AND julianday(expires_at) > julianday($2)",
+		memberID, now)
+	return count, err
+}
+
+// ConfirmEmailChange changes the email address of memberID to the one requested with the email change token
+// with hash tokenHash, if that token belongs to the member and is unused and unexpired at now. Every other
+// outstanding email change for the member is deleted. Returns the updated member and the old email address,
+// sql.ErrNoRows if the token isn't valid, or ErrEmailTaken if another member started using the new address
+// after the change was requested.
+func (db *Database) ConfirmEmailChange(memberID, tokenHash string, now time.Time) (model.Member, string, error) {
+	tx, err := db.db.Beginx()
+	if err != nil {
+		return model.Member{}, "", err
+	}
+
+	ec := model.EmailChange{}
+	err = tx.Get(&ec, "SELECT * FROM email_change WHERE token_hash=$1 AND member_id=$2 AND used_at IS NULL AND julianday(expires_at) > julianday($3)",
+		tokenHash, memberID, now)
+	if err != nil {
+		tx.Rollback()
+		return model.Member{}, "", err
+	}
+
+	member := model.Member{}
+	if err := tx.Get(&member, "SELECT * FROM member WHERE member_id=$1", memberID); err != nil {
+		tx.Rollback()
+		return model.Member{}, "", err
+	}
+	oldEmail := member.Email
+
+	taken, err := emailTaken(tx, ec.NewEmail)
+	if err != nil {
+		tx.Rollback()
+		return model.Member{}, "", err
+	}
+	if taken {
+		tx.Rollback()
+		return model.Member{}, "", ErrEmailTaken
+	}
+
+	// Guarded by used_at so that concurrent requests with the same token can't both succeed
+	res, err := tx.Exec("UPDATE email_change SET used_at=$1 WHERE token_hash=$2 AND used_at IS NULL", now, tokenHash)
+	if err != nil {
+		tx.Rollback()
+		return model.Member{}, "", err
+	}
+	if n, err := res.RowsAffected(); err != nil || n == 0 {
+		tx.Rollback()
+		if err == nil {
+			err = sql.ErrNoRows
+		}
+		return model.Member{}, "", err
+	}
+
+	if _, err := tx.Exec("DELETE FROM email_change WHERE member_id=$1 AND token_hash != $2", memberID, tokenHash); err != nil {
+		tx.Rollback()
+		return model.Member{}, "", err
+	}
+
+	if _, err := tx.Exec("UPDATE member SET email=$1, updated_at=$2 WHERE member_id=$3", ec.NewEmail, now, memberID); err != nil {
+		tx.Rollback()
+		return model.Member{}, "", err
+	}
+	member.Email = ec.NewEmail
+	member.UpdatedAt = now
+
+	return member, oldEmail, tx.Commit()
+}
+
+// DeleteExpiredEmailChanges deletes email change tokens that expired or were used before before
+func (db *Database) DeleteExpiredEmailChanges(before time.Time) error {
+	_, err := db.db.Exec("DELETE FROM email_change WHERE julianday(expires_at) < julianday($1) OR julianday(used_at) < julianday($1)", before)
+	return err
+}
@@ -0,0 +1,145 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/ibeckermayer/teleport-interview/backend/internal/model"
+	"github.com/pborman/uuid"
+)
+
+// ErrInvitePending is returned when an invite would be created for an email address that already has a
+// pending invite to the same account
+var ErrInvitePending = errors.New("the email address already has a pending invite")
+
+// CreateInvite saves a new invite. Returns ErrEmailTaken if a member already uses the invite's email address,
+// or ErrInvitePending if it already has a pending invite to the account at inv.CreatedAt.
+func (db *Database) CreateInvite(inv model.Invite) error {
+	tx, err := db.db.Beginx()
+	if err != nil {
+		return err
+	}
+
+	taken, err := emailTaken(tx, inv.Email)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	if taken {
+		tx.Rollback()
+		return ErrEmailTaken
+	}
+
+	var pending int
+	err = tx.Get(&pending, `SELECT COUNT(*) FROM invite WHERE account_id=$1 AND email=$2
+		AND accepted_at IS NULL AND revoked_at IS NULL AND julianday(expires_at) > julianday($3)`, inv.AccountID, inv.Email, inv.CreatedAt)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	if pending > 0 {
+		tx.Rollback()
+		return ErrInvitePending
+	}
+
+	_, err = tx.NamedExec(`INSERT INTO invite (invite_id, account_id, email, role, invited_by, created_at, expires_at)
+		VALUES (:invite_id, :account_id, :email, :role, :invited_by, :created_at, :expires_at)`, inv)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// CountPendingInvites returns the number of an account's invites that are pending at now
+func (db *Database) CountPendingInvites(accountID string, now time.Time) (int, error) {
+	var count int
+	err := db.db.Get(&count, `SELECT COUNT(*) FROM invite WHERE account_id=$1
+		AND accepted_at IS NULL AND revoked_at IS NULL AND julianday(expires_at) > julianday($2)`, accountID, now)
+	return count, err
+}
+
+// GetPendingInvites retrieves an account's invites that are pending at now, newest first
+func (db *Database) GetPendingInvites(accountID string, now time.Time) ([]model.Invite, error) {
+	invites := []model.Invite{}
+	err := db.db.Select(&invites, `SELECT * FROM invite WHERE account_id=$1
+		AND accepted_at IS NULL AND revoked_at IS NULL AND julianday(expires_at) > julianday($2)
+		ORDER BY created_at DESC, rowid DESC`, accountID, now)
+	return invites, err
+}
+
+// GetPendingInvite retrieves the invite with inviteID if it's pending at now, otherwise it returns sql.ErrNoRows
+func (db *Database) GetPendingInvite(inviteID string, now time.Time) (model.Invite, error) {
+	inv := model.Invite{}
+	err := db.db.Get(&inv, `SELECT * FROM invite WHERE invite_id=$1
+		AND accepted_at IS NULL AND revoked_at IS NULL AND julianday(expires_at) > julianday($2)`, inviteID, now)
+	return inv, err
+}
+
+// RevokeInvite revokes accountID's pending invite with inviteID so that it can't be accepted. Returns false
+// if the account has no such pending invite.
+func (db *Database) RevokeInvite(accountID, inviteID string, now time.Time) (bool, error) {
+	res, err := db.db.Exec(`UPDATE invite SET revoked_at=$1 WHERE invite_id=$2 AND account_id=$3
+		AND accepted_at IS NULL AND revoked_at IS NULL AND julianday(expires_at) > julianday($1)`, now, inviteID, accountID)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// AcceptInvite creates a member from the invite with inviteID, if it's pending at now, who logs in with the
+// invite's email address and a password with passwordHash. Returns the new member, sql.ErrNoRows if the invite
+// isn't pending, or ErrEmailTaken if someone became a member with its email address after it was sent.
+func (db *Database) AcceptInvite(inviteID, passwordHash string, now time.Time) (model.Member, error) {
+	tx, err := db.db.Beginx()
+	if err != nil {
+		return model.Member{}, err
+	}
+
+	inv := model.Invite{}
+	err = tx.Get(&inv, `SELECT * FROM invite WHERE invite_id=$1
+		AND accepted_at IS NULL AND revoked_at IS NULL AND julianday(expires_at) > julianday($2)`, inviteID, now)
+	if err != nil {
+		tx.Rollback()
+		return model.Member{}, err
+	}
+
+	// Guarded by accepted_at so that concurrent requests with the same invite can't both succeed
+	res, err := tx.Exec("UPDATE invite SET accepted_at=$1 WHERE invite_id=$2 AND accepted_at IS NULL", now, inviteID)
+	if err != nil {
+		tx.Rollback()
+		return model.Member{}, err
+	}
+	if n, err := res.RowsAffected(); err != nil || n == 0 {
+		tx.Rollback()
+		if err == nil {
+			err = sql.ErrNoRows
+		}
+		return model.Member{}, err
+	}
+
+	member := model.Member{
+		MemberID:     uuid.New(),
+		AccountID:    inv.AccountID,
+		Email:        inv.Email,
+		PasswordHash: passwordHash,
+		Role:         inv.Role,
+		CreatedAt:    now,
+		UpdatedAt:    now,
+	}
+	if err := insertMember(tx, &member); err != nil {
+		tx.Rollback()
+		return model.Member{}, err
+	}
+
+	return member, tx.Commit()
+}
+
+// DeleteExpiredInvites deletes invites that expired, were accepted or were revoked before before
+func (db *Database) DeleteExpiredInvites(before time.Time) error {
+	_, err := db.db.Exec(`DELETE FROM invite WHERE julianday(expires_at) < julianday($1)
+		OR julianday(accepted_at) < julianday($1) OR julianday(revoked_at) < julianday($1)`, before)
+	return err
+}
@@ -0,0 +1,76 @@
+package database
+
+import (
+	"database/sql"
+	"sync"
+	"time"
+
+	"github.com/ibeckermayer/teleport-interview/backend/internal/auth"
+	"github.com/ibeckermayer/teleport-interview/backend/internal/model"
+)
+
+// loginThrottleLock serializes changes to the login_throttle table, so that a failure being recorded can't
+// be lost to, or undo, another change made between reading and writing the row
+var loginThrottleLock = sync.Mutex{}
+
+// GetLoginThrottle retrieves the failed login attempts for key. Returns a LoginThrottle with no
+// failures if none have been recorded.
+func (db *Database) GetLoginThrottle(key string) (model.LoginThrottle, error) {
+	t := model.LoginThrottle{}
+	err := db.db.Get(&t, "SELECT * FROM login_throttle WHERE throttle_key=$1", key)
+	if err == sql.ErrNoRows {
+		return model.LoginThrottle{Key: key}, nil
+	}
+	return t, err
+}
+
+// UpdateLoginThrottle replaces the failed login attempts for key with update's result, in one step with
+// respect to every other change to them, returning the new attempts. It relies on loginThrottleLock rather than a
+// transaction, since SQLite refuses to upgrade a transaction's read lock while another connection waits to write.
+func (db *Database) UpdateLoginThrottle(key string, update func(model.LoginThrottle) model.LoginThrottle) (model.LoginThrottle, error) {
+	loginThrottleLock.Lock()
+	defer loginThrottleLock.Unlock()
+
+	t, err := db.GetLoginThrottle(key)
+	if err != nil {
+		return model.LoginThrottle{}, err
+	}
+	t = update(t)
+	_, err = db.db.NamedExec("INSERT OR REPLACE INTO login_throttle (throttle_key, failures, last_failure_at, locked_until) VALUES (:throttle_key, :failures, :last_failure_at, :locked_until)", t)
+	if err != nil {
+		return model.LoginThrottle{}, err
+	}
+	return t, nil
+}
+
+// DeleteLoginThrottle forgets the failed login attempts for key, unlocking it. Returns false if there were none.
+func (db *Database) DeleteLoginThrottle(key string) (bool, error) {
+	loginThrottleLock.Lock()
+	defer loginThrottleLock.Unlock()
+	res, err := db.db.Exec("DELETE FROM login_throttle WHERE throttle_key=$1", key)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// DeleteStaleLoginThrottles forgets failed login attempts for keys that aren't locked out and haven't failed since before
+func (db *Database) DeleteStaleLoginThrottles(before time.Time) error {
+	loginThrottleLock.Lock()
+	defer loginThrottleLock.Unlock()
+	_, err := db.db.Exec(`DELETE FROM login_throttle WHERE julianday(last_failure_at) < julianday($1)
+		AND (locked_until IS NULL OR julianday(locked_until) < julianday($1))`, before)
+	return err
+}
+
+// UnlockLogin forgets the failed login attempts for an email address or client IP, lifting any lockout.
+// Returns false if there were none.
+func (db *Database) UnlockLogin(emailOrIP string) (bool, error) {
+	unlockedEmail, err := db.DeleteLoginThrottle(auth.EmailThrottleKey(emailOrIP))
+	if err != nil {
+		return false, err
+	}
+	unlockedIP, err := db.DeleteLoginThrottle(auth.IPThrottleKey(emailOrIP))
+	return unlockedEmail || unlockedIP, err
+}
@@ -0,0 +1,82 @@
+package database
+
+import (
+	"time"
+
+	"github.com/ibeckermayer/teleport-interview/backend/internal/model"
+	"github.com/jmoiron/sqlx"
+)
+
+// insertMember saves a new member. Returns ErrEmailTaken if another member already uses m's email.
+func insertMember(tx *sqlx.Tx, m *model.Member) error {
+	taken, err := emailTaken(tx, m.Email)
+	if err != nil {
+		return err
+	}
+	if taken {
+		return ErrEmailTaken
+	}
+	_, err = tx.NamedExec(`INSERT INTO member (member_id, account_id, email, password_hash, role, created_at, updated_at)
+		VALUES (:member_id, :account_id, :email, :password_hash, :role, :created_at, :updated_at)`, m)
+	return err
+}
+
+// GetMember retrieves a Member from the database by memberID
+func (db *Database) GetMember(memberID string) (model.Member, error) {
+	m := model.Member{}
+	err := db.db.Get(&m, "SELECT * FROM member WHERE member_id=$1", memberID)
+	return m, err
+}
+
+// GetMemberByEmail retrieves a Member from the database by email address
+func (db *Database) GetMemberByEmail(email string) (model.Member, error) {
+	m := model.Member{}
+	err := db.db.Get(&m, "SELECT * FROM member WHERE email=$1", email)
+	return m, err
+}
+
+// GetMembers retrieves all of an account's members, oldest first
+func (db *Database) GetMembers(accountID string) ([]model.Member, error) {
+	members := []model.Member{}
+	err := db.db.Select(&members, "SELECT * FROM member WHERE account_id=$1 ORDER BY created_at, rowid", accountID)
+	return members, err
+}
+
+// SetPassword replaces a member's password hash and deletes any outstanding password reset tokens for them,
+// since they were requested for the old password. Returns the updated member.
+func (db *Database) SetPassword(memberID, passwordHash string, now time.Time) (model.Member, error) {
+	tx, err := db.db.Beginx()
+	if err != nil {
+		return model.Member{}, err
+	}
+
+	if _, err := tx.Exec("UPDATE member SET password_hash=$1, updated_at=$2 WHERE member_id=$3", passwordHash, now, memberID); err != nil {
+		tx.Rollback()
+		return model.Member{}, err
+	}
+
+	if _, err := tx.Exec("DELETE FROM password_reset WHERE member_id=$1", memberID); err != nil {
+		tx.Rollback()
+		return model.Member{}, err
+	}
+
+	member := model.Member{}
+	if err := tx.Get(&member, "SELECT * FROM member WHERE member_id=$1", memberID); err != nil {
+		tx.Rollback()
+		return model.Member{}, err
+	}
+
+	return member, tx.Commit()
+}
+
+// RehashPassword replaces a member's password hash with newHash, a hash of the same password made with
+// different parameters, as long as it's still oldHash. Unlike SetPassword, it doesn't count as a change
+// to the member. Returns false if the password was changed in the meantime.
+func (db *Database) RehashPassword(memberID, oldHash, newHash string) (bool, error) {
+	res, err := db.db.Exec("UPDATE member SET password_hash=$1 WHERE member_id=$2 AND password_hash=$3", newHash, memberID, oldHash)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
@@ -8,19 +8,135 @@ import (
 	"github.com/ibeckermayer/teleport-interview/backend/internal/model"
 )
 
-func (db *Database) insertMetric(m *model.Metric) error {
-	_, err := db.db.NamedExec("INSERT INTO metric (metric_id, account_id, user_id, timestamp) VALUES (:metric_id, :account_id, :user_id, :timestamp)", m)
-	return err
+// CreateMetric adds metric to the "metric" table in the database with a new MetricID, and if its event type
+// means the user was active, marks its user, if it has been created, as last seen at receivedAt. A user the
+// account's inactivity policy expired waits for a seat again once it's seen, so free seats are filled straight
+// away, recording the promotions like the inactivity job does.
+func (db *Database) CreateMetric(metric model.Metric, receivedAt time.Time) error {
+	metric.MetricID = uuid.New()
+	if metric.Attributes == "" {
+		metric.Attributes = "{}"
+	}
+	if metric.EventType.Seen() {
+		createUserUpgradeAccountLock.Lock()
+		defer createUserUpgradeAccountLock.Unlock()
+	}
+
+	tx, err := db.db.Beginx()
+	if err != nil {
+		return err
+	}
+	_, err = tx.NamedExec(`INSERT INTO metric (metric_id, account_id, user_id, timestamp, event_type, device_id, ip, attributes)
+		VALUES (:metric_id, :account_id, :user_id, :timestamp, :event_type, :device_id, :ip, :attributes)`, &metric)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	if !metric.EventType.Seen() {
+		return tx.Commit()
+	}
+
+	_, err = tx.Exec("UPDATE user SET last_seen_at=$1 WHERE user_id=$2 AND account_id=$3", receivedAt, metric.UserID, metric.AccountID)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	var waiting bool
+	err = tx.Get(&waiting, "SELECT EXISTS (SELECT 1 FROM user WHERE user_id=$1 AND account_id=$2 AND NOT is_active AND deactivated_at IS NULL)",
+		metric.UserID, metric.AccountID)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	if !waiting {
+		return tx.Commit()
+	}
+
+	var plan model.Plan
+	if err := tx.Get(&plan, "SELECT plan FROM account WHERE account_id=$1", metric.AccountID); err != nil {
+		tx.Rollback()
+		return err
+	}
+	promoted, err := promoteUsers(tx.Tx, metric.AccountID, plan, receivedAt)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	changes := []model.UserExpiry{}
+	for _, userID := range promoted {
+		changes = append(changes, model.UserExpiry{UserExpiryID: uuid.New(), AccountID: metric.AccountID, UserID: userID,
+			Action: model.UserPromoted, CreatedAt: receivedAt})
+	}
+	if err := insertUserExpiries(tx, changes); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// MetricFilter narrows down the metrics GetMetrics and CountMetrics look at. Zero fields don't filter.
+type MetricFilter struct {
+	EventType model.MetricEventType
+	UserID    string
+	DeviceID  string
+	IP        string
+	Since     *time.Time // only metrics timestamped at or after Since
+	Until     *time.Time // only metrics timestamped before Until
+	Before    string     // only metrics after the one with this MetricID in GetMetrics' order, for fetching the next page
+}
+
+// metricFilterSQL is the WHERE clause applying a MetricFilter, taking the account ID and the filter's fields in
+// order as $1 to $8
+const metricFilterSQL = `WHERE account_id=$1
+	AND ($2 = '' OR event_type=$2)
+	AND ($3 = '' OR user_id=$3)
+	AND ($4 = '' OR device_id=$4)
+	AND ($5 = '' OR ip=$5)
+	AND ($6 IS NULL OR julianday(timestamp) >= julianday($6))
+	AND ($7 IS NULL OR julianday(timestamp) < julianday($7))
+	AND ($8 = '' OR julianday(timestamp) < (SELECT julianday(timestamp) FROM metric WHERE metric_id=$8)
+		OR (julianday(timestamp) = (SELECT julianday(timestamp) FROM metric WHERE metric_id=$8) AND metric_id < $8))`
+
+// metricFilterArgs are the arguments for metricFilterSQL
+func metricFilterArgs(accountID string, filter MetricFilter) []interface{} {
+	return []interface{}{accountID, filter.EventType, filter.UserID, filter.DeviceID, filter.IP, filter.Since, filter.Until, filter.Before}
+}
+
+// GetMetrics retrieves up to limit of an account's metrics matching filter, most recently timestamped first
+func (db *Database) GetMetrics(accountID string, filter MetricFilter, limit int) ([]model.Metric, error) {
+	metrics := []model.Metric{}
+	err := db.db.Select(&metrics, "SELECT * FROM metric "+metricFilterSQL+" ORDER BY julianday(timestamp) DESC, metric_id DESC LIMIT $9",
+		append(metricFilterArgs(accountID, filter), limit)...)
+	return metrics, err
+}
+
+// MetricCounts summarizes the metrics matching a MetricFilter
+type MetricCounts struct {
+	Events map[model.MetricEventType]int // the number of metrics of each event type, including types with none
+	Users  int                           // the number of distinct users they were sent for
 }
 
-// CreateMetric adds a new metric to the "metric" table in the database
-func (db *Database) CreateMetric(accountID, userID string, timestamp time.Time) error {
-	metric := &model.Metric{
-		MetricID:  uuid.New(),
-		AccountID: accountID,
-		UserID:    userID,
-		Timestamp: timestamp,
+// CountMetrics summarizes an account's metrics matching filter. filter.Before is ignored.
+func (db *Database) CountMetrics(accountID string, filter MetricFilter) (MetricCounts, error) {
+	filter.Before = ""
+	args := metricFilterArgs(accountID, filter)
+
+	counts := MetricCounts{Events: make(map[model.MetricEventType]int)}
+	for t := range model.MetricAttributeSchemas {
+		counts.Events[t] = 0
+	}
+
+	rows := []struct {
+		EventType model.MetricEventType `db:"event_type"`
+		Count     int                   `db:"count"`
+	}{}
+	if err := db.db.Select(&rows, "SELECT event_type, count(*) AS count FROM metric "+metricFilterSQL+" GROUP BY event_type", args...); err != nil {
+		return counts, err
+	}
+	for _, r := range rows {
+		counts.Events[r.EventType] = r.Count
 	}
 
-	return db.insertMetric(metric)
+	err := db.db.Get(&counts.Users, "SELECT count(DISTINCT user_id) FROM metric "+metricFilterSQL, args...)
+	return counts, err
 }
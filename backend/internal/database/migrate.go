@@ -0,0 +1,527 @@
+package database
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/pborman/uuid"
+)
+
+// migrations take the schema from one version to the next: migrations[v] migrates a database at version v.
+// The version is kept in SQLite's user_version, which is 0 both in new databases and in databases created
+// before there were migrations, i.e. with the original schema. New databases are created with the latest
+// schema straight from the model package instead, so a change to a table's SQL there needs a migration here
+// making the same change to existing databases. Migrations run before init, so they only see the tables
+// their version had, and they spell out the SQL of that version rather than using the model package's.
+var migrations = []func(tx *sqlx.Tx) error{
+	addTrialEndsAt,
+	addDefaultUsageAlerts,
+	addMembers,
+	addDisabledAt,
+	addForeignKeys,
+	addDeactivatedAt,
+	addUserExpiry,
+	addMetricEvents,
+	addSigningKeys,
+}
+
+// schemaVersion is the version of the schema in the model package
+var schemaVersion = len(migrations)
+
+// migrate brings the schema of the database in dbfile up to schemaVersion, one migration per transaction.
+// Rebuilding a table means dropping it, which would cascade to every row referencing it, so migrations run
+// on their own connection with foreign keys off, and are checked against them before they're committed.
+func migrate(dbfile string) error {
+	mdb, err := sqlx.Open("sqlite3", dbfile)
+	if err != nil {
+		return err
+	}
+	defer mdb.Close()
+	mdb.SetMaxOpenConns(1)
+
+	var version, tables int
+	if err := mdb.Get(&version, "PRAGMA user_version"); err != nil {
+		return err
+	}
+	if err := mdb.Get(&tables, "SELECT count(*) FROM sqlite_master WHERE type='table' AND name='account'"); err != nil {
+		return err
+	}
+	if tables == 0 {
+		// A new database, init creates the latest schema
+		_, err := mdb.Exec(fmt.Sprintf("PRAGMA user_version=%d", schemaVersion))
+		return err
+	}
+	if version > schemaVersion {
+		return fmt.Errorf("the database schema is at version %v, newer than this server's %v", version, schemaVersion)
+	}
+
+	for ; version < schemaVersion; version++ {
+		tx, err := mdb.Beginx()
+		if err != nil {
+			return err
+		}
+		if err := migrations[version](tx); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("migrating the database schema from version %v: %v", version, err)
+		}
+		violations := []struct {
+			Table  string `db:"table"`
+			RowID  *int64 `db:"rowid"`
+			Parent string `db:"parent"`
+			FKID   int    `db:"fkid"`
+		}{}
+		if err := tx.Select(&violations, "PRAGMA foreign_key_check"); err != nil {
+			tx.Rollback()
+			return err
+		}
+		if len(violations) > 0 {
+			tx.Rollback()
+			return fmt.Errorf("migrating the database schema from version %v: %v rows of %v reference a missing %v",
+				version, len(violations), violations[0].Table, violations[0].Parent)
+		}
+		if _, err := tx.Exec(fmt.Sprintf("PRAGMA user_version=%d", version+1)); err != nil {
+			tx.Rollback()
+			return err
+		}
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+		log.Printf("migrated the database schema to version %v", version+1)
+	}
+	return nil
+}
+
+// tableColumns returns the names of table's columns, none if it doesn't exist
+func tableColumns(tx *sqlx.Tx, table string) (map[string]bool, error) {
+	names := []string{}
+	if err := tx.Select(&names, "SELECT name FROM pragma_table_info($1)", table); err != nil {
+		return nil, err
+	}
+	columns := make(map[string]bool, len(names))
+	for _, name := range names {
+		columns[name] = true
+	}
+	return columns, nil
+}
+
+// rebuildTable replaces table with one created by createSQL, which may also create its indexes, copying over
+// the columns the two have in common. It's how SQLite tables have columns dropped or their constraints changed.
+func rebuildTable(tx *sqlx.Tx, table, createSQL string) error {
+	oldColumns, err := tableColumns(tx, table)
+	if err != nil {
+		return err
+	}
+	// The old table's indexes would keep their names through the rename, which createSQL may need
+	indexes := []string{}
+	if err := tx.Select(&indexes, "SELECT name FROM sqlite_master WHERE type='index' AND tbl_name=$1 AND sql IS NOT NULL", table); err != nil {
+		return err
+	}
+	for _, index := range indexes {
+		if _, err := tx.Exec(fmt.Sprintf("DROP INDEX %v", index)); err != nil {
+			return err
+		}
+	}
+	// legacy_alter_table stops SQLite rewriting other tables' foreign keys to follow the rename
+	if _, err := tx.Exec("PRAGMA legacy_alter_table=ON"); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(fmt.Sprintf("ALTER TABLE %v RENAME TO %v_old", table, table)); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(createSQL); err != nil {
+		return err
+	}
+	newColumns, err := tableColumns(tx, table)
+	if err != nil {
+		return err
+	}
+	common := []string{}
+	for column := range newColumns {
+		if oldColumns[column] {
+			common = append(common, column)
+		}
+	}
+	columns := strings.Join(common, ", ")
+	if _, err := tx.Exec(fmt.Sprintf("INSERT INTO %v (%v) SELECT %v FROM %v_old", table, columns, columns, table)); err != nil {
+		return err
+	}
+	_, err = tx.Exec(fmt.Sprintf("DROP TABLE %v_old", table))
+	return err
+}
+
+// addTrialEndsAt migrates the original schema to version 1, adding account.trial_ends_at. Existing accounts
+// aren't on a trial.
+func addTrialEndsAt(tx *sqlx.Tx) error {
+	_, err := tx.Exec("ALTER TABLE account ADD COLUMN trial_ends_at DATETIME")
+	return err
+}
+
+// addDefaultUsageAlerts migrates version 1 to 2, giving existing accounts the usage alerts new accounts start with
+func addDefaultUsageAlerts(tx *sqlx.Tx) error {
+	_, err := tx.Exec(`CREATE TABLE IF NOT EXISTS usage_alert (
+		account_id CHARACTER(36) NOT NULL,
+		percent INTEGER NOT NULL,
+		fired INTEGER NOT NULL,
+		fired_at DATETIME,
+		PRIMARY KEY (account_id, percent));
+		INSERT OR IGNORE INTO usage_alert (account_id, percent, fired) SELECT account_id, 80, 0 FROM account;
+		INSERT OR IGNORE INTO usage_alert (account_id, percent, fired) SELECT account_id, 100, 0 FROM account;`)
+	return err
+}
+
+// addMembers migrates version 2 to 3. Each account had a single login, its email and password_hash, which
+// becomes its owner member, and the tables of things done with that login move from account_id to member_id.
+// Those tables were added after the original schema, so they may not exist yet.
+func addMembers(tx *sqlx.Tx) error {
+	owners := []struct {
+		AccountID    string    `db:"account_id"`
+		Email        string    `db:"email"`
+		PasswordHash string    `db:"password_hash"`
+		CreatedAt    time.Time `db:"created_at"`
+		UpdatedAt    time.Time `db:"updated_at"`
+	}{}
+	if err := tx.Select(&owners, "SELECT account_id, email, password_hash, created_at, updated_at FROM account"); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`CREATE TABLE member (
+		member_id CHARACTER(36) PRIMARY KEY,
+		account_id CHARACTER(36) NOT NULL,
+		email VARCHAR(320) UNIQUE NOT NULL,
+		password_hash CHARACTER(60) NOT NULL,
+		role VARCHAR(10) NOT NULL,
+		created_at DATETIME NOT NULL,
+		updated_at DATETIME NOT NULL);
+		CREATE INDEX member_account_id ON member (account_id);`); err != nil {
+		return err
+	}
+	for _, o := range owners {
+		_, err := tx.Exec("INSERT INTO member (member_id, account_id, email, password_hash, role, created_at, updated_at) VALUES ($1, $2, $3, $4, 'owner', $5, $6)",
+			uuid.New(), o.AccountID, o.Email, o.PasswordHash, o.CreatedAt, o.UpdatedAt)
+		if err != nil {
+			return err
+		}
+	}
+	if err := rebuildTable(tx, "account", `CREATE TABLE account (
+		account_id CHARACTER(36) PRIMARY KEY,
+		plan VARCHAR(50) NOT NULL,
+		created_at DATETIME NOT NULL,
+		updated_at DATETIME NOT NULL,
+		trial_ends_at DATETIME);`); err != nil {
+		return err
+	}
+
+	tables := []struct{ name, createSQL string }{
+		{"recovery_code", `CREATE TABLE recovery_code (
+		member_id CHARACTER(36) NOT NULL,
+		code_hash CHARACTER(64) NOT NULL,
+		created_at DATETIME NOT NULL,
+		used_at DATETIME,
+		PRIMARY KEY (member_id, code_hash));`},
+		{"password_reset", `CREATE TABLE password_reset (
+		token_hash CHARACTER(64) PRIMARY KEY,
+		member_id CHARACTER(36) NOT NULL,
+		created_at DATETIME NOT NULL,
+		expires_at DATETIME NOT NULL,
+		used_at DATETIME);`},
+		{"email_change", `CREATE TABLE email_change (
+		token_hash CHARACTER(64) PRIMARY KEY,
+		member_id CHARACTER(36) NOT NULL,
+		new_email VARCHAR(320) NOT NULL,
+		created_at DATETIME NOT NULL,
+		expires_at DATETIME NOT NULL,
+		used_at DATETIME);`},
+	}
+	for _, table := range tables {
+		columns, err := tableColumns(tx, table.name)
+		if err != nil {
+			return err
+		}
+		if len(columns) == 0 {
+			continue
+		}
+		_, err = tx.Exec(fmt.Sprintf(`ALTER TABLE %v ADD COLUMN member_id CHARACTER(36);
+			UPDATE %v SET member_id=(SELECT member_id FROM member WHERE member.account_id=%v.account_id);
+			DELETE FROM %v WHERE member_id IS NULL;`, table.name, table.name, table.name, table.name))
+		if err != nil {
+			return err
+		}
+		if err := rebuildTable(tx, table.name, table.createSQL); err != nil {
+			return err
+		}
+	}
+
+	columns, err := tableColumns(tx, "account_totp")
+	if err != nil || len(columns) == 0 {
+		return err
+	}
+	_, err = tx.Exec(`CREATE TABLE member_totp (
+		member_id CHARACTER(36) PRIMARY KEY,
+		secret VARCHAR(64) NOT NULL,
+		enabled BOOLEAN NOT NULL,
+		last_used_step INTEGER NOT NULL,
+		created_at DATETIME NOT NULL,
+		enabled_at DATETIME);
+		INSERT INTO member_totp (member_id, secret, enabled, last_used_step, created_at, enabled_at)
+		SELECT member.member_id, secret, enabled, last_used_step, account_totp.created_at, enabled_at
+		FROM account_totp JOIN member ON member.account_id=account_totp.account_id;
+		DROP TABLE account_totp;`)
+	return err
+}
+
+// addDisabledAt migrates version 3 to 4, adding account.disabled_at. Existing accounts aren't disabled.
+func addDisabledAt(tx *sqlx.Tx) error {
+	_, err := tx.Exec("ALTER TABLE account ADD COLUMN disabled_at DATETIME")
+	return err
+}
+
+// addForeignKeys migrates version 4 to 5. Every table with an account_id, or the ID of something an account
+// has, gets a foreign key on it that cascades deletes, and accounts a status in place of disabled_at. No table
+// had foreign keys before, so rows referencing something that doesn't exist could never have been used, and
+// are deleted rather than break them. Tables this version doesn't have yet are left for init to create.
+func addForeignKeys(tx *sqlx.Tx) error {
+	columns, err := tableColumns(tx, "account")
+	if err != nil {
+		return err
+	}
+	if columns["disabled_at"] {
+		_, err := tx.Exec(`ALTER TABLE account ADD COLUMN status VARCHAR(20) NOT NULL DEFAULT 'active';
+			UPDATE account SET status='suspended' WHERE disabled_at IS NOT NULL;`)
+		if err != nil {
+			return err
+		}
+	}
+
+	// Each table comes after the one it references, which has already had its orphans deleted
+	tables := []struct{ name, parent, column, createSQL string }{
+		{"account", "", "", `CREATE TABLE account (
+			account_id CHARACTER(36) PRIMARY KEY,
+			plan VARCHAR(50) NOT NULL,
+			created_at DATETIME NOT NULL,
+			updated_at DATETIME NOT NULL,
+			trial_ends_at DATETIME,
+			status VARCHAR(20) NOT NULL DEFAULT 'active',
+			delete_after DATETIME);`},
+		{"member", "account", "account_id", `CREATE TABLE member (
+			member_id CHARACTER(36) PRIMARY KEY,
+			account_id CHARACTER(36) NOT NULL REFERENCES account(account_id) ON DELETE CASCADE,
+			email VARCHAR(320) UNIQUE NOT NULL,
+			password_hash CHARACTER(60) NOT NULL,
+			role VARCHAR(10) NOT NULL,
+			created_at DATETIME NOT NULL,
+			updated_at DATETIME NOT NULL);
+		CREATE INDEX member_account_id ON member (account_id);`},
+		{"invite", "account", "account_id", `CREATE TABLE invite (
+			invite_id CHARACTER(36) PRIMARY KEY,
+			account_id CHARACTER(36) NOT NULL REFERENCES account(account_id) ON DELETE CASCADE,
+			email VARCHAR(320) NOT NULL,
+			role VARCHAR(10) NOT NULL,
+			invited_by CHARACTER(36) NOT NULL,
+			created_at DATETIME NOT NULL,
+			expires_at DATETIME NOT NULL,
+			accepted_at DATETIME,
+			revoked_at DATETIME);
+		CREATE INDEX invite_account_id ON invite (account_id);`},
+		{"apikey", "account", "account_id", `CREATE TABLE apikey (
+			key_hash CHARACTER(64) PRIMARY KEY,
+			account_id CHARACTER(36) REFERENCES account(account_id) ON DELETE CASCADE);`},
+		{"metric", "account", "account_id", `CREATE TABLE metric (
+			metric_id CHARACTER(36) PRIMARY KEY,
+			account_id CHARACTER(36) REFERENCES account(account_id) ON DELETE CASCADE,
+			user_id CHARACTER(36),
+			timestamp DATETIME
+		);
+		CREATE INDEX metric_account_id ON metric (account_id);`},
+		{"user", "account", "account_id", `CREATE TABLE user (
+			user_id CHARACTER(36) PRIMARY KEY,
+			account_id CHARACTER(36) REFERENCES account(account_id) ON DELETE CASCADE,
+			is_active INTEGER,
+			created_at DATETIME,
+			updated_at DATETIME
+		);
+		CREATE INDEX user_account_id ON user (account_id);`},
+		{"account_plan_history", "account", "account_id", `CREATE TABLE account_plan_history (
+			plan_history_id CHARACTER(36) PRIMARY KEY,
+			account_id CHARACTER(36) NOT NULL REFERENCES account(account_id) ON DELETE CASCADE,
+			old_plan VARCHAR(50) NOT NULL,
+			new_plan VARCHAR(50) NOT NULL,
+			reason VARCHAR(50) NOT NULL,
+			changed_by VARCHAR(320) NOT NULL,
+			changed_at DATETIME NOT NULL);`},
+		{"usage_snapshot", "account", "account_id", `CREATE TABLE usage_snapshot (
+			account_id CHARACTER(36) NOT NULL REFERENCES account(account_id) ON DELETE CASCADE,
+			day CHARACTER(10) NOT NULL,
+			plan VARCHAR(50) NOT NULL,
+			on_trial INTEGER NOT NULL,
+			active_users INTEGER NOT NULL,
+			total_users INTEGER NOT NULL,
+			seen_users INTEGER NOT NULL,
+			created_at DATETIME NOT NULL,
+			PRIMARY KEY (account_id, day));`},
+		{"invoice", "account", "account_id", `CREATE TABLE invoice (
+			invoice_id CHARACTER(36) PRIMARY KEY,
+			account_id CHARACTER(36) NOT NULL REFERENCES account(account_id) ON DELETE CASCADE,
+			period CHARACTER(7) NOT NULL,
+			total_cents INTEGER NOT NULL,
+			created_at DATETIME NOT NULL,
+			UNIQUE (account_id, period));`},
+		{"invoice_line_item", "invoice", "invoice_id", `CREATE TABLE invoice_line_item (
+			line_item_id CHARACTER(36) PRIMARY KEY,
+			invoice_id CHARACTER(36) NOT NULL REFERENCES invoice(invoice_id) ON DELETE CASCADE,
+			description VARCHAR(200) NOT NULL,
+			plan VARCHAR(50) NOT NULL,
+			on_trial INTEGER NOT NULL,
+			user_days INTEGER NOT NULL,
+			unit_price_cents INTEGER NOT NULL,
+			amount_cents INTEGER NOT NULL);`},
+		{"webhook", "account", "account_id", `CREATE TABLE webhook (
+			webhook_id CHARACTER(36) PRIMARY KEY,
+			account_id CHARACTER(36) NOT NULL REFERENCES account(account_id) ON DELETE CASCADE,
+			url VARCHAR(2048) NOT NULL,
+			secret VARCHAR(64) NOT NULL,
+			created_at DATETIME NOT NULL);`},
+		{"webhook_delivery", "account", "account_id", `CREATE TABLE webhook_delivery (
+			delivery_id CHARACTER(36) PRIMARY KEY,
+			event_id CHARACTER(36) NOT NULL,
+			webhook_id CHARACTER(36) NOT NULL,
+			account_id CHARACTER(36) NOT NULL REFERENCES account(account_id) ON DELETE CASCADE,
+			event_type VARCHAR(50) NOT NULL,
+			payload TEXT NOT NULL,
+			status VARCHAR(20) NOT NULL,
+			attempts INTEGER NOT NULL,
+			next_attempt_at DATETIME NOT NULL,
+			last_error TEXT NOT NULL,
+			created_at DATETIME NOT NULL,
+			delivered_at DATETIME);`},
+		{"webhook_attempt", "webhook_delivery", "delivery_id", `CREATE TABLE webhook_attempt (
+			attempt_id CHARACTER(36) PRIMARY KEY,
+			delivery_id CHARACTER(36) NOT NULL REFERENCES webhook_delivery(delivery_id) ON DELETE CASCADE,
+			status_code INTEGER NOT NULL,
+			error TEXT NOT NULL,
+			attempted_at DATETIME NOT NULL);
+		CREATE INDEX webhook_attempt_delivery_id ON webhook_attempt (delivery_id);`},
+		{"usage_alert", "account", "account_id", `CREATE TABLE usage_alert (
+			account_id CHARACTER(36) NOT NULL REFERENCES account(account_id) ON DELETE CASCADE,
+			percent INTEGER NOT NULL,
+			fired INTEGER NOT NULL,
+			fired_at DATETIME,
+			PRIMARY KEY (account_id, percent));`},
+		{"member_totp", "member", "member_id", `CREATE TABLE member_totp (
+			member_id CHARACTER(36) PRIMARY KEY REFERENCES member(member_id) ON DELETE CASCADE,
+			secret VARCHAR(64) NOT NULL,
+			enabled BOOLEAN NOT NULL,
+			last_used_step INTEGER NOT NULL,
+			created_at DATETIME NOT NULL,
+			enabled_at DATETIME);`},
+		{"recovery_code", "member", "member_id", `CREATE TABLE recovery_code (
+			member_id CHARACTER(36) NOT NULL REFERENCES member(member_id) ON DELETE CASCADE,
+			code_hash CHARACTER(64) NOT NULL,
+			created_at DATETIME NOT NULL,
+			used_at DATETIME,
+			PRIMARY KEY (member_id, code_hash));`},
+		{"password_reset", "member", "member_id", `CREATE TABLE password_reset (
+			token_hash CHARACTER(64) PRIMARY KEY,
+			member_id CHARACTER(36) NOT NULL REFERENCES member(member_id) ON DELETE CASCADE,
+			created_at DATETIME NOT NULL,
+			expires_at DATETIME NOT NULL,
+			used_at DATETIME);`},
+		{"email_change", "member", "member_id", `CREATE TABLE email_change (
+			token_hash CHARACTER(64) PRIMARY KEY,
+			member_id CHARACTER(36) NOT NULL REFERENCES member(member_id) ON DELETE CASCADE,
+			new_email VARCHAR(320) NOT NULL,
+			created_at DATETIME NOT NULL,
+			expires_at DATETIME NOT NULL,
+			used_at DATETIME);`},
+		{"device_ca", "account", "account_id", `CREATE TABLE device_ca (
+			account_id CHARACTER(36) PRIMARY KEY REFERENCES account(account_id) ON DELETE CASCADE,
+			cert_pem TEXT NOT NULL,
+			key_pem TEXT NOT NULL,
+			created_at DATETIME NOT NULL);`},
+		{"device", "account", "account_id", `CREATE TABLE device (
+			serial VARCHAR(32) PRIMARY KEY,
+			account_id CHARACTER(36) NOT NULL REFERENCES account(account_id) ON DELETE CASCADE,
+			name VARCHAR(64) NOT NULL,
+			created_at DATETIME NOT NULL,
+			expires_at DATETIME NOT NULL,
+			revoked_at DATETIME);`},
+	}
+	for _, table := range tables {
+		columns, err := tableColumns(tx, table.name)
+		if err != nil {
+			return err
+		}
+		if len(columns) == 0 {
+			continue
+		}
+		if err := rebuildTable(tx, table.name, table.createSQL); err != nil {
+			return err
+		}
+		if table.parent == "" {
+			continue
+		}
+		res, err := tx.Exec(fmt.Sprintf("DELETE FROM %v WHERE %v IS NOT NULL AND %v NOT IN (SELECT %v FROM %v)",
+			table.name, table.column, table.column, table.column, table.parent))
+		if err != nil {
+			return err
+		}
+		if n, _ := res.RowsAffected(); n > 0 {
+			log.Printf("deleted %v %v rows whose %v doesn't exist", n, table.name, table.column)
+		}
+	}
+	return nil
+}
+
+// addDeactivatedAt migrates version 5 to 6, adding user.deactivated_at. No existing user was deactivated by a
+// member, whether or not it's active.
+func addDeactivatedAt(tx *sqlx.Tx) error {
+	columns, err := tableColumns(tx, "user")
+	if err != nil || len(columns) == 0 {
+		return err
+	}
+	_, err = tx.Exec("ALTER TABLE user ADD COLUMN deactivated_at DATETIME")
+	return err
+}
+
+// addUserExpiry migrates version 6 to 7, adding account.inactivity_days and user.last_seen_at. Existing accounts
+// don't expire their users, and existing users haven't been seen since it was tracked.
+func addUserExpiry(tx *sqlx.Tx) error {
+	if _, err := tx.Exec("ALTER TABLE account ADD COLUMN inactivity_days INTEGER NOT NULL DEFAULT 0"); err != nil {
+		return err
+	}
+	columns, err := tableColumns(tx, "user")
+	if err != nil || len(columns) == 0 {
+		return err
+	}
+	_, err = tx.Exec("ALTER TABLE user ADD COLUMN last_seen_at DATETIME")
+	return err
+}
+
+// addMetricEvents migrates version 7 to 8, adding metric.event_type, device_id, ip and attributes. Every
+// existing metric was a login, sent before devices or IPs were recorded.
+func addMetricEvents(tx *sqlx.Tx) error {
+	columns, err := tableColumns(tx, "metric")
+	if err != nil || len(columns) == 0 {
+		return err
+	}
+	_, err = tx.Exec(`ALTER TABLE metric ADD COLUMN event_type VARCHAR(20) NOT NULL DEFAULT 'login';
+		ALTER TABLE metric ADD COLUMN device_id VARCHAR(64) NOT NULL DEFAULT '';
+		ALTER TABLE metric ADD COLUMN ip VARCHAR(45) NOT NULL DEFAULT '';
+		ALTER TABLE metric ADD COLUMN attributes TEXT NOT NULL DEFAULT '{}';
+		CREATE INDEX metric_account_event_type ON metric (account_id, event_type);`)
+	return err
+}
+
+// addSigningKeys migrates version 8 to 9, adding apikey.signing_key_id and require_signed. Existing keys were
+// signed with their key hash, which anyone who can read the database has, so they get no signing key until the
+// account issues one, and keep accepting bearer requests.
+func addSigningKeys(tx *sqlx.Tx) error {
+	columns, err := tableColumns(tx, "apikey")
+	if err != nil || len(columns) == 0 {
+		return err
+	}
+	_, err = tx.Exec(`ALTER TABLE apikey ADD COLUMN signing_key_id VARCHAR(24) NOT NULL DEFAULT '';
+		ALTER TABLE apikey ADD COLUMN require_signed BOOLEAN NOT NULL DEFAULT 0;`)
+	return err
+}
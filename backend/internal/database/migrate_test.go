@@ -0,0 +1,277 @@
+package database
+
+import (
+	"database/sql"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+
+	"github.com/ibeckermayer/teleport-interview/backend/internal/auth"
+	"github.com/ibeckermayer/teleport-interview/backend/internal/model"
+)
+
+// originalSchemaSQL is the schema databases had before there were migrations
+const originalSchemaSQL = `CREATE TABLE account (
+	account_id CHARACTER(36) PRIMARY KEY,
+	plan VARCHAR(50) NOT NULL,
+	email VARCHAR(320) UNIQUE NOT NULL,
+	password_hash CHARACTER(60) NOT NULL,
+	created_at DATETIME NOT NULL,
+	updated_at DATETIME NOT NULL);
+CREATE TABLE apikey (
+	key_hash CHARACTER(64) PRIMARY KEY,
+	account_id CHARACTER(36));
+CREATE TABLE metric (
+	metric_id CHARACTER(36) PRIMARY KEY,
+	account_id CHARACTER(36),
+	user_id CHARACTER(36),
+	timestamp DATETIME);
+CREATE TABLE user (
+	user_id CHARACTER(36) PRIMARY KEY,
+	account_id CHARACTER(36),
+	is_active INTEGER,
+	created_at DATETIME,
+	updated_at DATETIME);`
+
+func TestMigrateOriginalSchema(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "test.db")
+	original, err := sqlx.Open("sqlite3", file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	passwordHash, err := auth.PasswordHasher{BcryptCost: 4}.Hash("correct horse battery staple")
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now().UTC()
+	for _, stmt := range []struct {
+		query string
+		args  []interface{}
+	}{
+		{originalSchemaSQL, nil},
+		{"INSERT INTO account VALUES ($1, $2, $3, $4, $5, $6)", []interface{}{"acct", model.ENTERPRISE, "owner@example.com", passwordHash, now, now}},
+		{"INSERT INTO apikey VALUES ($1, $2)", []interface{}{"keyhash", "acct"}},
+		{"INSERT INTO user VALUES ($1, $2, $3, $4, $5)", []interface{}{"user", "acct", true, now, now}},
+		{"INSERT INTO metric VALUES ($1, $2, $3, $4)", []interface{}{"metric", "acct", "user", now}},
+		// Nothing stopped rows being saved for accounts that don't exist
+		{"INSERT INTO metric VALUES ($1, $2, $3, $4)", []interface{}{"orphan", "missing", "user", now}},
+	} {
+		if _, err := original.Exec(stmt.query, stmt.args...); err != nil {
+			t.Fatal(err)
+		}
+	}
+	original.Close()
+
+	db, err := New(Config{Env: "test", File: file})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.db.Close()
+
+	var version int
+	if err := db.db.Get(&version, "PRAGMA user_version"); err != nil || version != schemaVersion {
+		t.Fatalf("expected the schema to be at version %v but got %v, %v", schemaVersion, version, err)
+	}
+	account, err := db.GetAccount("acct")
+	if err != nil || account.Plan != model.ENTERPRISE || account.Status != model.AccountActive {
+		t.Fatalf("expected the account to have kept its plan and be active but got %+v, %v", account, err)
+	}
+	if expired, err := db.ExpireTrials(now); err != nil || len(expired) != 0 {
+		t.Fatalf("expected the account not to be on a trial but got %+v, %v", expired, err)
+	}
+	owner, err := db.GetMemberByEmail("owner@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if owner.AccountID != "acct" || owner.Role != model.RoleOwner || !auth.CheckPasswordHash("correct horse battery staple", owner.PasswordHash) {
+		t.Fatalf("expected the account's login to have become its owner but got %+v", owner)
+	}
+	if alerts, err := db.GetUsageAlerts("acct"); err != nil || len(alerts) != len(model.DefaultUsageAlertPercents) {
+		t.Fatalf("expected the default usage alerts but got %+v, %v", alerts, err)
+	}
+	if user, err := db.SetUserActive("acct", "user", false, now); err != nil || user.DeactivatedAt == nil {
+		t.Fatalf("expected the account's user to be deactivated but got %+v, %v", user, err)
+	}
+	if metrics, err := db.GetMetrics("acct", MetricFilter{}, 10); err != nil || len(metrics) != 1 || metrics[0].EventType != model.MetricLogin {
+		t.Fatalf("expected the account's metric to be kept as a login but got %+v, %v", metrics, err)
+	}
+	if apikey, err := db.GetAPIkey("acct"); err != nil || apikey.KeyHash != "keyhash" || apikey.SigningKeyID != "" || apikey.RequireSigned {
+		t.Fatalf("expected the account's API key to be kept without a signing key but got %+v, %v", apikey, err)
+	}
+	var orphans int
+	if err := db.db.Get(&orphans, "SELECT count(*) FROM metric WHERE account_id='missing'"); err != nil || orphans != 0 {
+		t.Fatalf("expected metrics of missing accounts to be deleted but got %v, %v", orphans, err)
+	}
+
+	// The rebuilt tables have their foreign keys, so purging the account deletes everything it had
+	if _, err := db.db.Exec("DELETE FROM account WHERE account_id='acct'"); err != nil {
+		t.Fatal(err)
+	}
+	for _, table := range []string{"member", "apikey", "metric", "user", "usage_alert"} {
+		var n int
+		if err := db.db.Get(&n, "SELECT count(*) FROM "+table); err != nil || n != 0 {
+			t.Fatalf("expected the account's %v rows to be deleted with it but got %v, %v", table, n, err)
+		}
+	}
+
+	// Opening a migrated database again doesn't migrate it again
+	db.db.Close()
+	if db, err = New(Config{Env: "test", File: file}); err != nil {
+		t.Fatal(err)
+	}
+	db.db.Close()
+}
+
+func TestMigrateMembers(t *testing.T) {
+	// A database from before members, with two-factor authentication set up and a password reset pending
+	file := filepath.Join(t.TempDir(), "test.db")
+	old, err := sqlx.Open("sqlite3", file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now().UTC()
+	for _, stmt := range []struct {
+		query string
+		args  []interface{}
+	}{
+		{`CREATE TABLE account (
+			account_id CHARACTER(36) PRIMARY KEY,
+			plan VARCHAR(50) NOT NULL,
+			email VARCHAR(320) UNIQUE NOT NULL,
+			password_hash CHARACTER(60) NOT NULL,
+			created_at DATETIME NOT NULL,
+			updated_at DATETIME NOT NULL,
+			trial_ends_at DATETIME);
+		CREATE TABLE account_totp (
+			account_id CHARACTER(36) PRIMARY KEY,
+			secret VARCHAR(64) NOT NULL,
+			enabled BOOLEAN NOT NULL,
+			last_used_step INTEGER NOT NULL,
+			created_at DATETIME NOT NULL,
+			enabled_at DATETIME);
+		CREATE TABLE recovery_code (
+			account_id CHARACTER(36) NOT NULL,
+			code_hash CHARACTER(64) NOT NULL,
+			created_at DATETIME NOT NULL,
+			used_at DATETIME,
+			PRIMARY KEY (account_id, code_hash));
+		CREATE TABLE password_reset (
+			token_hash CHARACTER(64) PRIMARY KEY,
+			account_id CHARACTER(36) NOT NULL,
+			created_at DATETIME NOT NULL,
+			expires_at DATETIME NOT NULL,
+			used_at DATETIME);
+		PRAGMA user_version=2;`, nil},
+		{"INSERT INTO account VALUES ($1, $2, $3, $4, $5, $6, NULL)", []interface{}{"acct", model.FREE, "owner@example.com", "passwordhash", now, now}},
+		{"INSERT INTO account_totp VALUES ($1, $2, $3, $4, $5, $6)", []interface{}{"acct", "SECRET", true, 0, now, now}},
+		{"INSERT INTO recovery_code VALUES ($1, $2, $3, NULL)", []interface{}{"acct", "codehash", now}},
+		{"INSERT INTO password_reset VALUES ($1, $2, $3, $4, NULL)", []interface{}{"tokenhash", "acct", now, now.Add(time.Hour)}},
+	} {
+		if _, err := old.Exec(stmt.query, stmt.args...); err != nil {
+			t.Fatal(err)
+		}
+	}
+	old.Close()
+
+	db, err := New(Config{Env: "test", File: file})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.db.Close()
+
+	owner, err := db.GetMemberByEmail("owner@example.com")
+	if err != nil || owner.AccountID != "acct" || owner.Role != model.RoleOwner {
+		t.Fatalf("expected the account's login to have become its owner but got %+v, %v", owner, err)
+	}
+	if totp, err := db.GetTOTP(owner.MemberID); err != nil || totp.Secret != "SECRET" || !totp.Enabled {
+		t.Errorf("expected the owner to have kept two-factor authentication but got %+v, %v", totp, err)
+	}
+	if n, err := db.CountRecoveryCodes(owner.MemberID); err != nil || n != 1 {
+		t.Errorf("expected the owner to have kept its recovery code but got %v, %v", n, err)
+	}
+	if pr, err := db.GetPasswordReset("tokenhash", now); err != nil || pr.MemberID != owner.MemberID {
+		t.Errorf("expected the password reset to be the owner's but got %+v, %v", pr, err)
+	}
+}
+
+func TestMigrateForeignKeys(t *testing.T) {
+	// A database from before foreign keys, with a disabled account, and a member of an account that was
+	// never created who has recovery codes
+	file := filepath.Join(t.TempDir(), "test.db")
+	old, err := sqlx.Open("sqlite3", file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now().UTC()
+	for _, stmt := range []struct {
+		query string
+		args  []interface{}
+	}{
+		{`CREATE TABLE account (
+			account_id CHARACTER(36) PRIMARY KEY,
+			plan VARCHAR(50) NOT NULL,
+			created_at DATETIME NOT NULL,
+			updated_at DATETIME NOT NULL,
+			trial_ends_at DATETIME,
+			disabled_at DATETIME);
+		CREATE TABLE member (
+			member_id CHARACTER(36) PRIMARY KEY,
+			account_id CHARACTER(36) NOT NULL,
+			email VARCHAR(320) UNIQUE NOT NULL,
+			password_hash CHARACTER(60) NOT NULL,
+			role VARCHAR(10) NOT NULL,
+			created_at DATETIME NOT NULL,
+			updated_at DATETIME NOT NULL);
+		CREATE INDEX member_account_id ON member (account_id);
+		CREATE TABLE recovery_code (
+			member_id CHARACTER(36) NOT NULL,
+			code_hash CHARACTER(64) NOT NULL,
+			created_at DATETIME NOT NULL,
+			used_at DATETIME,
+			PRIMARY KEY (member_id, code_hash));
+		PRAGMA user_version=4;`, nil},
+		{"INSERT INTO account VALUES ($1, $2, $3, $4, NULL, $5)", []interface{}{"acct", model.FREE, now, now, now}},
+		{"INSERT INTO member VALUES ($1, $2, $3, $4, $5, $6, $7)", []interface{}{"owner", "acct", "owner@example.com", "passwordhash", model.RoleOwner, now, now}},
+		{"INSERT INTO member VALUES ($1, $2, $3, $4, $5, $6, $7)", []interface{}{"orphan", "missing", "orphan@example.com", "passwordhash", model.RoleOwner, now, now}},
+		{"INSERT INTO recovery_code VALUES ($1, $2, $3, NULL)", []interface{}{"owner", "codehash", now}},
+		{"INSERT INTO recovery_code VALUES ($1, $2, $3, NULL)", []interface{}{"orphan", "codehash", now}},
+	} {
+		if _, err := old.Exec(stmt.query, stmt.args...); err != nil {
+			t.Fatal(err)
+		}
+	}
+	old.Close()
+
+	db, err := New(Config{Env: "test", File: file})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.db.Close()
+
+	if account, err := db.GetAccount("acct"); err != nil || account.Status != model.AccountSuspended {
+		t.Errorf("expected the disabled account to be suspended but got %+v, %v", account, err)
+	}
+	if n, err := db.CountRecoveryCodes("owner"); err != nil || n != 1 {
+		t.Errorf("expected the owner to have kept its recovery code but got %v, %v", n, err)
+	}
+	if _, err := db.GetMemberByEmail("orphan@example.com"); err != sql.ErrNoRows {
+		t.Errorf("expected the member of a missing account to be deleted but got %v", err)
+	}
+	if n, err := db.CountRecoveryCodes("orphan"); err != nil || n != 0 {
+		t.Errorf("expected the deleted member's recovery codes to be deleted but got %v, %v", n, err)
+	}
+	var indexes int
+	if err := db.db.Get(&indexes, "SELECT count(*) FROM sqlite_master WHERE type='index' AND name='member_account_id'"); err != nil || indexes != 1 {
+		t.Errorf("expected member to have kept its index but got %v, %v", indexes, err)
+	}
+}
+
+func TestNewDatabaseIsAtLatestVersion(t *testing.T) {
+	db := newTestDB(t)
+	var version int
+	if err := db.db.Get(&version, "PRAGMA user_version"); err != nil || version != schemaVersion {
+		t.Fatalf("expected a new database to be at version %v but got %v, %v", schemaVersion, version, err)
+	}
+}
@@ -0,0 +1,85 @@
+package database
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/ibeckermayer/teleport-interview/backend/internal/model"
+)
+
+// CountPasswordResets returns the number of a member's password reset tokens that are unused and unexpired at now
+func (db *Database) CountPasswordResets(memberID string, now time.Time) (int, error) {
+	var count int
+	err := db.db.Get(&count, "SELECT COUNT(*) FROM password_reset WHERE member_id=$1 AND used_at IS NULL AND julianday(expires_at) > julianday($2)",
+		memberID, now)
+	return count, err
+}
+
+// CreatePasswordReset saves a new password reset token
+func (db *Database) CreatePasswordReset(pr model.PasswordReset) error {
+	_, err := db.db.NamedExec("INSERT INTO password_reset (token_hash, member_id, created_at, expires_at) VALUES (:token_hash, :member_id, :created_at, :expires_at)", pr)
+	return err
+}
+
+// GetPasswordReset retrieves the password reset token with hash tokenHash if it's unused and unexpired at now,
+// otherwise it returns sql.ErrNoRows
+func (db *Database) GetPasswordReset(tokenHash string, now time.Time) (model.PasswordReset, error) {
+	pr := model.PasswordReset{}
+	err := db.db.Get(&pr, "SELECT * FROM password_reset WHERE token_hash=$1 AND used_at IS NULL AND julianday(expires_at) > julianday($2)", tokenHash, now)
+	return pr, err
+}
+
+// ResetPassword sets the password hash of the member the password reset token with hash tokenHash belongs to,
+// if that token is unused and unexpired at now. Every other outstanding token for the member is deleted so
+// that none of them can be used afterwards. Returns the member, or sql.ErrNoRows if the token isn't valid.
+func (db *Database) ResetPassword(tokenHash, passwordHash string, now time.Time) (model.Member, error) {
+	tx, err := db.db.Beginx()
+	if err != nil {
+		return model.Member{}, err
+	}
+
+	pr := model.PasswordReset{}
+	err = tx.Get(&pr, "SELECT * FROM password_reset WHERE token_hash=$1 AND used_at IS NULL AND julianday(expires_at) > julianday($2)", tokenHash, now)
+	if err != nil {
+		tx.Rollback()
+		return model.Member{}, err
+	}
+
+	// Guarded by used_at so that concurrent requests with the same token can't both succeed
+	res, err := tx.Exec("UPDATE password_reset SET used_at=$1 WHERE token_hash=$2 AND used_at IS NULL", now, tokenHash)
+	if err != nil {
+		tx.Rollback()
+		return model.Member{}, err
+	}
+	if n, err := res.RowsAffected(); err != nil || n == 0 {
+		tx.Rollback()
+		if err == nil {
+			err = sql.ErrNoRows
+		}
+		return model.Member{}, err
+	}
+
+	if _, err := tx.Exec("DELETE FROM password_reset WHERE member_id=$1 AND token_hash != $2", pr.MemberID, tokenHash); err != nil {
+		tx.Rollback()
+		return model.Member{}, err
+	}
+
+	if _, err := tx.Exec("UPDATE member SET password_hash=$1, updated_at=$2 WHERE member_id=$3", passwordHash, now, pr.MemberID); err != nil {
+		tx.Rollback()
+		return model.Member{}, err
+	}
+
+	member := model.Member{}
+	if err := tx.Get(&member, "SELECT * FROM member WHERE member_id=$1", pr.MemberID); err != nil {
+		tx.Rollback()
+		return model.Member{}, err
+	}
+
+	return member, tx.Commit()
+}
+
+// DeleteExpiredPasswordResets deletes password reset tokens that expired or were used before before
+func (db *Database) DeleteExpiredPasswordResets(before time.Time) error {
+	_, err := db.db.Exec("DELETE FROM password_reset WHERE julianday(expires_at) < julianday($1) OR julianday(used_at) < julianday($1)", before)
+	return err
+}
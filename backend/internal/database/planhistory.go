@@ -0,0 +1,47 @@
+package database
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/pborman/uuid"
+
+	"github.com/ibeckermayer/teleport-interview/backend/internal/model"
+)
+
+// insertPlanHistory records a plan change as part of the transaction tx that makes the change
+func insertPlanHistory(tx *sql.Tx, h *model.AccountPlanHistory) error {
+	_, err := tx.Exec("INSERT INTO account_plan_history (plan_history_id, account_id, old_plan, new_plan, reason, changed_by, changed_at) VALUES ($1, $2, $3, $4, $5, $6, $7)",
+		h.PlanHistoryID, h.AccountID, h.OldPlan, h.NewPlan, h.Reason, h.ChangedBy, h.ChangedAt)
+	return err
+}
+
+// changePlan sets an account's plan to newPlan, clears any trial it was on, and records the change in
+// the account_plan_history table, all as part of tx. Returns the account's plan prior to the change.
+func changePlan(tx *sql.Tx, accountID string, newPlan model.Plan, reason model.PlanChangeReason, changedBy string, now time.Time) (model.Plan, error) {
+	var oldPlan model.Plan
+	if err := tx.QueryRow("SELECT plan FROM account WHERE account_id=$1", accountID).Scan(&oldPlan); err != nil {
+		return "", err
+	}
+
+	if _, err := tx.Exec("UPDATE account SET plan=$1, trial_ends_at=NULL, updated_at=$2 WHERE account_id=$3", newPlan, now, accountID); err != nil {
+		return "", err
+	}
+
+	return oldPlan, insertPlanHistory(tx, &model.AccountPlanHistory{
+		PlanHistoryID: uuid.New(),
+		AccountID:     accountID,
+		OldPlan:       oldPlan,
+		NewPlan:       newPlan,
+		Reason:        reason,
+		ChangedBy:     changedBy,
+		ChangedAt:     now,
+	})
+}
+
+// GetPlanHistory retrieves every recorded plan change for accountID, most recent first
+func (db *Database) GetPlanHistory(accountID string) ([]model.AccountPlanHistory, error) {
+	history := []model.AccountPlanHistory{}
+	err := db.db.Select(&history, "SELECT * FROM account_plan_history WHERE account_id=$1 ORDER BY changed_at DESC, rowid DESC", accountID)
+	return history, err
+}
@@ -0,0 +1,127 @@
+package database
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/ibeckermayer/teleport-interview/backend/internal/model"
+)
+
+func TestPlanHistory(t *testing.T) {
+	setPlanMaxUsers(t, 2, 4)
+	db := newTestDB(t)
+	// The trial has ended by the time ExpireTrials runs
+	db.cfg.TrialDuration = time.Nanosecond
+	if err := db.CreateAccount("acct", "owner@example.com", "correct horse battery staple"); err != nil {
+		t.Fatal(err)
+	}
+	account, err := db.GetAccount("acct")
+	if err != nil || account.Plan != model.ENTERPRISE || account.TrialEndsAt == nil {
+		t.Fatalf("expected the account to start on an ENTERPRISE trial but got %+v, %v", account, err)
+	}
+	for _, userID := range []string{"u0", "u1", "u2"} {
+		if _, _, _, err := db.CreateUser(userID, "acct"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	steps := []struct {
+		name        string
+		change      func() error
+		wantPlan    model.Plan
+		wantActive  []string
+		wantUpdated bool // whether the account's updated_at changes
+	}{
+		{
+			name: "trial expires",
+			change: func() error {
+				expired, err := db.ExpireTrials(time.Now())
+				if err == nil && len(expired) != 1 {
+					t.Errorf("expected 1 trial to expire but got %+v", expired)
+				}
+				return err
+			},
+			wantPlan:    model.FREE,
+			wantActive:  []string{"u0", "u1"},
+			wantUpdated: true,
+		},
+		{
+			name: "expired trials aren't expired again",
+			change: func() error {
+				expired, err := db.ExpireTrials(time.Now())
+				if err == nil && len(expired) != 0 {
+					t.Errorf("expected no trials to expire but got %+v", expired)
+				}
+				return err
+			},
+			wantPlan:   model.FREE,
+			wantActive: []string{"u0", "u1"},
+		},
+		{
+			name: "owner upgrades",
+			change: func() error {
+				_, err := db.UpgradeAccount("acct", "owner@example.com")
+				return err
+			},
+			wantPlan:    model.ENTERPRISE,
+			wantActive:  []string{"u0", "u1", "u2"},
+			wantUpdated: true,
+		},
+		{
+			name: "staff move the account back",
+			change: func() error {
+				_, err := db.ForcePlan("acct", model.FREE, "staff:support@example.com", time.Now())
+				return err
+			},
+			wantPlan:    model.FREE,
+			wantActive:  []string{"u0", "u1"},
+			wantUpdated: true,
+		},
+	}
+	for _, step := range steps {
+		before := account.UpdatedAt
+		if err := step.change(); err != nil {
+			t.Fatalf("%v: %v", step.name, err)
+		}
+		if account, err = db.GetAccount("acct"); err != nil {
+			t.Fatal(err)
+		}
+		if account.Plan != step.wantPlan || account.TrialEndsAt != nil {
+			t.Errorf("%v: got plan %v with trial ending %v, want %v without a trial", step.name, account.Plan, account.TrialEndsAt, step.wantPlan)
+		}
+		if updated := !account.UpdatedAt.Equal(before); updated != step.wantUpdated {
+			t.Errorf("%v: got updated_at %v after %v, want it changed %v", step.name, account.UpdatedAt, before, step.wantUpdated)
+		}
+		if active := activeUsers(t, db, "acct"); !reflect.DeepEqual(active, step.wantActive) {
+			t.Errorf("%v: got active users %v, want %v", step.name, active, step.wantActive)
+		}
+	}
+
+	history, err := db.GetPlanHistory("acct")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []model.AccountPlanHistory{
+		{OldPlan: model.ENTERPRISE, NewPlan: model.FREE, Reason: model.PlanChangeAdmin, ChangedBy: "staff:support@example.com"},
+		{OldPlan: model.FREE, NewPlan: model.ENTERPRISE, Reason: model.PlanChangeUpgrade, ChangedBy: "owner@example.com"},
+		{OldPlan: model.ENTERPRISE, NewPlan: model.FREE, Reason: model.PlanChangeTrialExpired, ChangedBy: model.PlanChangedBySystem},
+		{OldPlan: "", NewPlan: model.ENTERPRISE, Reason: model.PlanChangeCreated, ChangedBy: "owner@example.com"},
+	}
+	if len(history) != len(want) {
+		t.Fatalf("got %v plan changes, want %v: %+v", len(history), len(want), history)
+	}
+	for i, h := range history {
+		if h.AccountID != "acct" || h.OldPlan != want[i].OldPlan || h.NewPlan != want[i].NewPlan ||
+			h.Reason != want[i].Reason || h.ChangedBy != want[i].ChangedBy {
+			t.Errorf("plan change %v: got %+v, want %+v", i, h, want[i])
+		}
+	}
+	// An account that fails to be created doesn't leave its first plan change behind
+	if err := db.CreateAccount("other", "owner@example.com", "correct horse battery staple"); err == nil {
+		t.Fatal("expected creating an account with a taken email address to fail")
+	}
+	if history, err := db.GetPlanHistory("other"); err != nil || len(history) != 0 {
+		t.Errorf("expected the account that wasn't created to have no plan history but got %+v, %v", history, err)
+	}
+}
@@ -0,0 +1,29 @@
+package database
+
+import (
+	"time"
+
+	"github.com/pborman/uuid"
+
+	"github.com/ibeckermayer/teleport-interview/backend/internal/auth"
+	"github.com/ibeckermayer/teleport-interview/backend/internal/model"
+)
+
+// CreateStaff adds a member of staff with email, who calls the admin API with key
+func (db *Database) CreateStaff(email string, key auth.Key) (model.Staff, error) {
+	staff := model.Staff{
+		StaffID:   uuid.New(),
+		Email:     email,
+		KeyHash:   auth.HashKey(key),
+		CreatedAt: time.Now(),
+	}
+	_, err := db.db.NamedExec("INSERT INTO staff (staff_id, email, key_hash, created_at) VALUES (:staff_id, :email, :key_hash, :created_at)", staff)
+	return staff, err
+}
+
+// GetStaffByKeyHash retrieves the member of staff whose key hashes to keyHash
+func (db *Database) GetStaffByKeyHash(keyHash string) (model.Staff, error) {
+	staff := model.Staff{}
+	err := db.db.Get(&staff, "SELECT * FROM staff WHERE key_hash=$1", keyHash)
+	return staff, err
+}
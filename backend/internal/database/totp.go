@@ -0,0 +1,108 @@
+package database
+
+import (
+	"time"
+
+	"github.com/ibeckermayer/teleport-interview/backend/internal/model"
+)
+
+// GetTOTP retrieves a member's TOTP secret. Returns sql.ErrNoRows if the member has never enrolled.
+func (db *Database) GetTOTP(memberID string) (model.MemberTOTP, error) {
+	t := model.MemberTOTP{}
+	err := db.db.Get(&t, "SELECT * FROM member_totp WHERE member_id=$1", memberID)
+	return t, err
+}
+
+// SetPendingTOTP saves a new TOTP secret for a member, replacing any secret that hasn't been verified yet.
+// Returns false without changing anything if the member already has TOTP enabled.
+func (db *Database) SetPendingTOTP(memberID, secret string, now time.Time) (bool, error) {
+	res, err := db.db.Exec(`INSERT INTO member_totp (member_id, secret, enabled, last_used_step, created_at) VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (member_id) DO UPDATE SET secret=excluded.secret, last_used_step=excluded.last_used_step, created_at=excluded.created_at
+		WHERE NOT enabled`, memberID, secret, false, 0, now)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// EnableTOTP enables a member's pending TOTP secret once its first code (from time step step) has been
+// verified, replacing any recovery codes the member had with codeHashes. Returns false without changing
+// anything if the member has no pending secret.
+func (db *Database) EnableTOTP(memberID string, step int64, codeHashes []string, now time.Time) (bool, error) {
+	tx, err := db.db.Begin()
+	if err != nil {
+		return false, err
+	}
+
+	res, err := tx.Exec("UPDATE member_totp SET enabled=$1, last_used_step=$2, enabled_at=$3 WHERE member_id=$4 AND NOT enabled",
+		true, step, now, memberID)
+	if err != nil {
+		tx.Rollback()
+		return false, err
+	}
+	if n, err := res.RowsAffected(); err != nil || n == 0 {
+		tx.Rollback()
+		return false, err
+	}
+
+	if _, err := tx.Exec("DELETE FROM recovery_code WHERE member_id=$1", memberID); err != nil {
+		tx.Rollback()
+		return false, err
+	}
+	for _, hash := range codeHashes {
+		if _, err := tx.Exec("INSERT INTO recovery_code (member_id, code_hash, created_at) VALUES ($1, $2, $3)", memberID, hash, now); err != nil {
+			tx.Rollback()
+			return false, err
+		}
+	}
+
+	return true, tx.Commit()
+}
+
+// UseTOTPStep records that a code from time step step has been accepted for a member, so that it and any
+// earlier codes can't be used again. Returns false if a code from step or later was already used, in which
+// case the code being checked is a replay and must be rejected.
+func (db *Database) UseTOTPStep(memberID string, step int64) (bool, error) {
+	res, err := db.db.Exec("UPDATE member_totp SET last_used_step=$1 WHERE member_id=$2 AND last_used_step < $1", step, memberID)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// UseRecoveryCode marks a member's unused recovery code with hash codeHash as used. Returns false if
+// there's no such unused code.
+func (db *Database) UseRecoveryCode(memberID, codeHash string, now time.Time) (bool, error) {
+	res, err := db.db.Exec("UPDATE recovery_code SET used_at=$1 WHERE member_id=$2 AND code_hash=$3 AND used_at IS NULL", now, memberID, codeHash)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// CountRecoveryCodes returns the number of unused recovery codes a member has left
+func (db *Database) CountRecoveryCodes(memberID string) (int, error) {
+	var count int
+	err := db.db.Get(&count, "SELECT COUNT(*) FROM recovery_code WHERE member_id=$1 AND used_at IS NULL", memberID)
+	return count, err
+}
+
+// DeleteTOTP disables two-factor authentication for a member, deleting its secret and recovery codes
+func (db *Database) DeleteTOTP(memberID string) error {
+	tx, err := db.db.Begin()
+	if err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM recovery_code WHERE member_id=$1", memberID); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM member_totp WHERE member_id=$1", memberID); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,91 @@
+package database
+
+import (
+	"time"
+
+	"github.com/jmoiron/sqlx"
+
+	"github.com/ibeckermayer/teleport-interview/backend/internal/model"
+)
+
+// GetUsageAlerts retrieves accountID's usage alert thresholds in ascending order
+func (db *Database) GetUsageAlerts(accountID string) ([]model.UsageAlert, error) {
+	alerts := []model.UsageAlert{}
+	err := db.db.Select(&alerts, "SELECT * FROM usage_alert WHERE account_id=$1 ORDER BY percent", accountID)
+	return alerts, err
+}
+
+// SetUsageAlerts replaces accountID's usage alert thresholds with percents. Thresholds that
+// accountID already had keep their fired state, so re-saving them won't cause a repeat notification.
+func (db *Database) SetUsageAlerts(accountID string, percents []int) error {
+	tx, err := db.db.Beginx()
+	if err != nil {
+		return err
+	}
+	if err := setUsageAlerts(tx, accountID, percents); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// setUsageAlerts replaces accountID's usage alert thresholds with percents within tx, see SetUsageAlerts
+func setUsageAlerts(tx *sqlx.Tx, accountID string, percents []int) error {
+	// Remove thresholds that aren't in percents
+	query, args := "DELETE FROM usage_alert WHERE account_id=?", []interface{}{accountID}
+	if len(percents) > 0 {
+		var err error
+		query, args, err = sqlx.In(query+" AND percent NOT IN (?)", accountID, percents)
+		if err != nil {
+			return err
+		}
+	}
+	if _, err := tx.Exec(query, args...); err != nil {
+		return err
+	}
+
+	for _, p := range percents {
+		if _, err := tx.Exec("INSERT OR IGNORE INTO usage_alert (account_id, percent, fired) VALUES ($1, $2, $3)", accountID, p, false); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// DueUsageAlerts updates accountID's usage alerts for an account that has totalUsers users out of a limit of
+// maxUsers, and returns those whose threshold has been reached but haven't fired. Fired alerts whose threshold is
+// no longer reached are re-armed. Due alerts are only marked as fired by FireUsageAlert, once they've been sent.
+func (db *Database) DueUsageAlerts(accountID string, totalUsers, maxUsers int) ([]model.UsageAlert, error) {
+	tx, err := db.db.Beginx()
+	if err != nil {
+		return nil, err
+	}
+
+	// Re-arm alerts whose threshold is no longer reached, i.e. totalUsers < percent% of maxUsers
+	_, err = tx.Exec("UPDATE usage_alert SET fired=$1, fired_at=NULL WHERE account_id=$2 AND fired AND $3 * 100 < percent * $4",
+		false, accountID, totalUsers, maxUsers)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	due := []model.UsageAlert{}
+	err = tx.Select(&due, "SELECT * FROM usage_alert WHERE account_id=$1 AND NOT fired AND $2 * 100 >= percent * $3 ORDER BY percent",
+		accountID, totalUsers, maxUsers)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	return due, tx.Commit()
+}
+
+// FireUsageAlert marks accountID's usage alert at percent as fired at now. Returns false if it had already fired.
+func (db *Database) FireUsageAlert(accountID string, percent int, now time.Time) (bool, error) {
+	res, err := db.db.Exec("UPDATE usage_alert SET fired=$1, fired_at=$2 WHERE account_id=$3 AND percent=$4 AND NOT fired", true, now, accountID, percent)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
@@ -1,6 +1,7 @@
 package database
 
 import (
+	"database/sql"
 	"errors"
 	"time"
 
@@ -10,6 +11,10 @@ import (
 var (
 	// ErrOrphanedUser is returned if caller attempts to create a user associated with an account_id that DNE.
 	ErrOrphanedUser = errors.New("attempted to create an orphaned user")
+
+	// ErrNoFreeSeats is returned if caller attempts to activate a user of an account that already has as many
+	// active users as its plan allows.
+	ErrNoFreeSeats = errors.New("the account has as many active users as its plan allows")
 )
 
 // GetUser retrieves a user from the database by user_id. Returns an error if user DNE.
@@ -27,35 +32,191 @@ func (db *Database) CountUsers(accountID string) (int, error) {
 }
 
 // CreateUser creates a new user with userID associated with accountID. Determines
-// whether the new User is active based on if the associated account has reached the
-// user limit on its current plan.
-func (db *Database) CreateUser(userID, accountID string) error {
+// whether the new User is active based on if the associated account's active users have
+// reached the user limit on its current plan. Returns the new User along with the account's plan and
+// total number of users (including the new one) at the time it was created.
+func (db *Database) CreateUser(userID, accountID string) (model.User, model.Plan, int, error) {
 	createUserUpgradeAccountLock.Lock()
 	defer createUserUpgradeAccountLock.Unlock()
 
 	account, err := db.GetAccount(accountID)
 	if err != nil {
 		// Could not find account, don't create orphaned user
-		return err
+		return model.User{}, "", 0, err
 	}
 
 	count, err := db.CountUsers(accountID)
 	if err != nil {
-		return err
+		return model.User{}, "", 0, err
+	}
+	var active int
+	if err := db.db.Get(&active, "SELECT count(*) FROM user WHERE account_id=$1 AND is_active", accountID); err != nil {
+		return model.User{}, "", 0, err
 	}
 
+	now := time.Now()
 	user := &model.User{
-		UserID:    userID,
-		AccountID: accountID,
-		IsActive:  count+1 <= model.PlanMaxUsers[account.Plan],
-		CreatedAt: time.Now(),
-		UpdatedAt: time.Now(),
+		UserID:     userID,
+		AccountID:  accountID,
+		IsActive:   active < model.PlanMaxUsers[account.Plan],
+		CreatedAt:  now,
+		UpdatedAt:  now,
+		LastSeenAt: &now,
 	}
 
-	return db.insertUser(user)
+	return *user, account.Plan, count + 1, db.insertUser(user)
 }
 
 func (db *Database) insertUser(u *model.User) error {
-	_, err := db.db.NamedExec("INSERT INTO user (user_id, account_id, is_active, created_at, updated_at) VALUES (:user_id, :account_id, :is_active, :created_at, :updated_at)", u)
+	_, err := db.db.NamedExec("INSERT INTO user (user_id, account_id, is_active, created_at, updated_at, last_seen_at) VALUES (:user_id, :account_id, :is_active, :created_at, :updated_at, :last_seen_at)", u)
 	return err
 }
+
+// UserExport is everything stored about a user_id by an account
+type UserExport struct {
+	User     *model.User // nil if the user belongs to another account or was never created
+	Metrics  []model.Metric
+	Expiries []model.UserExpiry
+}
+
+// ExportUser retrieves everything stored about userID by accountID: its user row, its metrics and what the
+// inactivity job did to it, each oldest first. Returns sql.ErrNoRows if there's nothing.
+func (db *Database) ExportUser(accountID, userID string) (UserExport, error) {
+	export := UserExport{}
+	u := model.User{}
+	err := db.db.Get(&u, "SELECT * FROM user WHERE user_id=$1 AND account_id=$2", userID, accountID)
+	if err == nil {
+		export.User = &u
+	} else if err != sql.ErrNoRows {
+		return UserExport{}, err
+	}
+
+	export.Metrics = []model.Metric{}
+	err = db.db.Select(&export.Metrics, "SELECT * FROM metric WHERE account_id=$1 AND user_id=$2 ORDER BY timestamp, rowid", accountID, userID)
+	if err != nil {
+		return UserExport{}, err
+	}
+
+	export.Expiries = []model.UserExpiry{}
+	err = db.db.Select(&export.Expiries, "SELECT * FROM user_expiry WHERE account_id=$1 AND user_id=$2 ORDER BY created_at, rowid", accountID, userID)
+	if err != nil {
+		return UserExport{}, err
+	}
+
+	if export.User == nil && len(export.Metrics) == 0 && len(export.Expiries) == 0 {
+		return UserExport{}, sql.ErrNoRows
+	}
+	return export, nil
+}
+
+// EraseUser deletes everything stored about userID by accountID, its user row, its metrics and what the
+// inactivity job did to it. If the user was
+// active, the seat it frees is taken by the oldest user waiting for one.
+// Returns the number of metrics deleted, or sql.ErrNoRows if there was nothing to delete.
+func (db *Database) EraseUser(accountID, userID string, now time.Time) (int64, error) {
+	createUserUpgradeAccountLock.Lock()
+	defer createUserUpgradeAccountLock.Unlock()
+
+	tx, err := db.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+
+	res, err := tx.Exec("DELETE FROM metric WHERE account_id=$1 AND user_id=$2", accountID, userID)
+	if err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+	metrics, err := res.RowsAffected()
+	if err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+
+	if _, err := tx.Exec("DELETE FROM user_expiry WHERE account_id=$1 AND user_id=$2", accountID, userID); err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+
+	res, err = tx.Exec("DELETE FROM user WHERE user_id=$1 AND account_id=$2", userID, accountID)
+	if err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+	users, err := res.RowsAffected()
+	if err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+	if users == 0 && metrics == 0 {
+		tx.Rollback()
+		return 0, sql.ErrNoRows
+	}
+
+	var plan model.Plan
+	if err := tx.QueryRow("SELECT plan FROM account WHERE account_id=$1", accountID).Scan(&plan); err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+	if err := applyPlanLimit(tx, accountID, plan, now); err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+
+	return metrics, tx.Commit()
+}
+
+// SetUserActive activates or deactivates one of accountID's users on behalf of a member. Deactivated users stay
+// inactive until a member activates them again, and the seat they free is taken by the oldest user waiting for
+// one. Activating a user fails with ErrNoFreeSeats if the account's plan has none. Returns the updated user, or
+// sql.ErrNoRows if accountID has no such user.
+func (db *Database) SetUserActive(accountID, userID string, active bool, now time.Time) (model.User, error) {
+	createUserUpgradeAccountLock.Lock()
+	defer createUserUpgradeAccountLock.Unlock()
+
+	tx, err := db.db.Beginx()
+	if err != nil {
+		return model.User{}, err
+	}
+
+	user := model.User{}
+	if err := tx.Get(&user, "SELECT * FROM user WHERE user_id=$1 AND account_id=$2", userID, accountID); err != nil {
+		tx.Rollback()
+		return model.User{}, err
+	}
+	var plan model.Plan
+	if err := tx.Get(&plan, "SELECT plan FROM account WHERE account_id=$1", accountID); err != nil {
+		tx.Rollback()
+		return model.User{}, err
+	}
+
+	if active {
+		if !user.IsActive {
+			var count int
+			if err := tx.Get(&count, "SELECT count(*) FROM user WHERE account_id=$1 AND is_active", accountID); err != nil {
+				tx.Rollback()
+				return model.User{}, err
+			}
+			if count >= model.PlanMaxUsers[plan] {
+				tx.Rollback()
+				return model.User{}, ErrNoFreeSeats
+			}
+		}
+		_, err = tx.Exec("UPDATE user SET is_active=1, deactivated_at=NULL, updated_at=$1 WHERE user_id=$2", now, userID)
+	} else {
+		_, err = tx.Exec("UPDATE user SET is_active=0, deactivated_at=$1, updated_at=$1 WHERE user_id=$2", now, userID)
+		if err == nil {
+			_, err = promoteUsers(tx.Tx, accountID, plan, now)
+		}
+	}
+	if err != nil {
+		tx.Rollback()
+		return model.User{}, err
+	}
+
+	if err := tx.Get(&user, "SELECT * FROM user WHERE user_id=$1", userID); err != nil {
+		tx.Rollback()
+		return model.User{}, err
+	}
+	return user, tx.Commit()
+}
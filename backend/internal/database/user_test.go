@@ -0,0 +1,207 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/ibeckermayer/teleport-interview/backend/internal/model"
+)
+
+func TestSeats(t *testing.T) {
+	setPlanMaxUsers(t, 2, 4)
+	setActive := func(userID string, active bool) func(*Database, string) error {
+		return func(db *Database, accountID string) error {
+			_, err := db.SetUserActive(accountID, userID, active, time.Now())
+			return err
+		}
+	}
+
+	tests := []struct {
+		name       string
+		plan       model.Plan
+		users      int // created in order as u0, u1, ...
+		changes    []func(db *Database, accountID string) error
+		wantErr    error // returned by the last change
+		wantActive []string
+	}{
+		{
+			name:       "new users wait once the plan is full",
+			plan:       model.FREE,
+			users:      3,
+			wantActive: []string{"u0", "u1"},
+		},
+		{
+			name:       "deactivating a user promotes the oldest waiting user",
+			plan:       model.FREE,
+			users:      4,
+			changes:    []func(*Database, string) error{setActive("u0", false)},
+			wantActive: []string{"u1", "u2"},
+		},
+		{
+			name:       "activating a user needs a free seat",
+			plan:       model.FREE,
+			users:      3,
+			changes:    []func(*Database, string) error{setActive("u2", true)},
+			wantErr:    ErrNoFreeSeats,
+			wantActive: []string{"u0", "u1"},
+		},
+		{
+			name:       "deactivated users aren't promoted",
+			plan:       model.FREE,
+			users:      3,
+			changes:    []func(*Database, string) error{setActive("u2", false), setActive("u0", false)},
+			wantActive: []string{"u1"},
+		},
+		{
+			name:       "deactivated users can be activated into a free seat",
+			plan:       model.FREE,
+			users:      2,
+			changes:    []func(*Database, string) error{setActive("u0", false), setActive("u0", true)},
+			wantActive: []string{"u0", "u1"},
+		},
+		{
+			name:  "upgrading activates waiting users",
+			plan:  model.FREE,
+			users: 3,
+			changes: []func(*Database, string) error{func(db *Database, accountID string) error {
+				_, err := db.UpgradeAccount(accountID, "owner@example.com")
+				return err
+			}},
+			wantActive: []string{"u0", "u1", "u2"},
+		},
+		{
+			name:  "upgrading doesn't activate deactivated users",
+			plan:  model.FREE,
+			users: 3,
+			changes: []func(*Database, string) error{setActive("u2", false), func(db *Database, accountID string) error {
+				_, err := db.UpgradeAccount(accountID, "owner@example.com")
+				return err
+			}},
+			wantActive: []string{"u0", "u1"},
+		},
+		{
+			name:  "moving to a smaller plan deactivates the newest users",
+			plan:  model.ENTERPRISE,
+			users: 4,
+			changes: []func(*Database, string) error{func(db *Database, accountID string) error {
+				_, err := db.ForcePlan(accountID, model.FREE, "staff:support@example.com", time.Now())
+				return err
+			}},
+			wantActive: []string{"u0", "u1"},
+		},
+		{
+			name:  "erasing an active user frees its seat",
+			plan:  model.FREE,
+			users: 3,
+			changes: []func(*Database, string) error{func(db *Database, accountID string) error {
+				_, err := db.EraseUser(accountID, "u0", time.Now())
+				return err
+			}},
+			wantActive: []string{"u1", "u2"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := newTestDB(t)
+			accountID := newTestAccount(t, db, tt.plan)
+			for i := 0; i < tt.users; i++ {
+				if _, _, _, err := db.CreateUser(fmt.Sprintf("u%v", i), accountID); err != nil {
+					t.Fatal(err)
+				}
+			}
+			var err error
+			for _, change := range tt.changes {
+				if err = change(db, accountID); err != nil {
+					break
+				}
+			}
+			if err != tt.wantErr {
+				t.Fatalf("got error %v, want %v", err, tt.wantErr)
+			}
+			if active := activeUsers(t, db, accountID); !reflect.DeepEqual(active, tt.wantActive) {
+				t.Errorf("got active users %v, want %v", active, tt.wantActive)
+			}
+		})
+	}
+}
+
+func TestEraseUser(t *testing.T) {
+	tests := []struct {
+		name        string
+		userID      string
+		wantErr     error
+		wantMetrics int64
+	}{
+		{name: "user with metrics and expiries", userID: "expired", wantMetrics: 2},
+		{name: "user without metrics", userID: "silent", wantMetrics: 0},
+		{name: "metrics without a user", userID: "uncreated", wantMetrics: 1},
+		{name: "nothing stored", userID: "missing", wantErr: sql.ErrNoRows},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := newTestDB(t)
+			accountID := newTestAccount(t, db, model.FREE)
+			if err := db.CreateAccount("other", "other@example.com", "correct horse battery staple"); err != nil {
+				t.Fatal(err)
+			}
+			now := time.Now().UTC()
+			for _, userID := range []string{"expired", "silent", "kept"} {
+				if _, _, _, err := db.CreateUser(userID, accountID); err != nil {
+					t.Fatal(err)
+				}
+			}
+			for _, m := range []model.Metric{
+				{AccountID: accountID, UserID: "expired", Timestamp: now.AddDate(0, 0, -30), EventType: model.MetricLogin},
+				{AccountID: accountID, UserID: "expired", Timestamp: now.AddDate(0, 0, -30), EventType: model.MetricLogout},
+				{AccountID: accountID, UserID: "uncreated", Timestamp: now, EventType: model.MetricLoginFailure},
+				{AccountID: accountID, UserID: "kept", Timestamp: now, EventType: model.MetricLogin},
+				// The same user_ids sent by another account are that account's
+				{AccountID: "other", UserID: "expired", Timestamp: now, EventType: model.MetricLoginFailure},
+				{AccountID: "other", UserID: "uncreated", Timestamp: now, EventType: model.MetricLoginFailure},
+			} {
+				if err := db.CreateMetric(m, m.Timestamp); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if _, err := db.SetInactivityDays(accountID, 7, now); err != nil {
+				t.Fatal(err)
+			}
+			if changes, err := db.ExpireInactiveUsers(now); err != nil || len(changes) != 1 {
+				t.Fatalf("expected the expired user to be expired but got %+v, %v", changes, err)
+			}
+
+			metrics, err := db.EraseUser(accountID, tt.userID, now)
+			if err != tt.wantErr {
+				t.Fatalf("got error %v, want %v", err, tt.wantErr)
+			}
+			if metrics != tt.wantMetrics {
+				t.Errorf("got %v metrics deleted, want %v", metrics, tt.wantMetrics)
+			}
+			if _, err := db.ExportUser(accountID, tt.userID); err != sql.ErrNoRows {
+				t.Errorf("expected nothing to be left about the user but got %v", err)
+			}
+
+			// Nothing else is touched
+			for _, other := range []struct {
+				accountID, userID string
+			}{{accountID, "kept"}, {"other", "expired"}, {"other", "uncreated"}} {
+				if other.accountID == accountID && other.userID == tt.userID {
+					continue
+				}
+				if export, err := db.ExportUser(other.accountID, other.userID); err != nil || len(export.Metrics) != 1 {
+					t.Errorf("expected %v's metric for %v to be kept but got %+v, %v", other.accountID, other.userID, export, err)
+				}
+			}
+			if tt.userID != "expired" {
+				if export, err := db.ExportUser(accountID, "expired"); err != nil || export.User == nil || len(export.Expiries) != 1 {
+					t.Errorf("expected the expired user to be kept but got %+v, %v", export, err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,115 @@
+package database
+
+import (
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/pborman/uuid"
+
+	"github.com/ibeckermayer/teleport-interview/backend/internal/model"
+)
+
+// SetInactivityDays sets how many days accountID's users may go without a metric before they're expired, 0 to
+// never expire them. Returns the updated account.
+func (db *Database) SetInactivityDays(accountID string, days int, now time.Time) (model.Account, error) {
+	if _, err := db.db.Exec("UPDATE account SET inactivity_days=$1, updated_at=$2 WHERE account_id=$3", days, now, accountID); err != nil {
+		return model.Account{}, err
+	}
+	return db.GetAccount(accountID)
+}
+
+// ExpireInactiveUsers applies the inactivity policy of every active account that has one: active users that
+// haven't been seen for longer than the account's InactivityDays are deactivated, and the seats they free, or
+// any left free, are taken by the oldest users waiting for one. Expired users only wait for a seat again once
+// they're seen again. Users created before last_seen_at was tracked count as last seen
+// when they were created. Every change is recorded in the "user_expiry" table and returned, oldest first.
+func (db *Database) ExpireInactiveUsers(now time.Time) ([]model.UserExpiry, error) {
+	accounts := []model.Account{}
+	err := db.db.Select(&accounts, "SELECT * FROM account WHERE inactivity_days > 0 AND status=$1", model.AccountActive)
+	if err != nil {
+		return nil, err
+	}
+
+	changes := []model.UserExpiry{}
+	for _, account := range accounts {
+		c, err := db.expireAccountUsers(account, now)
+		if err != nil {
+			return changes, err
+		}
+		changes = append(changes, c...)
+	}
+	return changes, nil
+}
+
+// expireAccountUsers applies account's inactivity policy to its users, see ExpireInactiveUsers
+func (db *Database) expireAccountUsers(account model.Account, now time.Time) ([]model.UserExpiry, error) {
+	createUserUpgradeAccountLock.Lock()
+	defer createUserUpgradeAccountLock.Unlock()
+
+	tx, err := db.db.Beginx()
+	if err != nil {
+		return nil, err
+	}
+
+	expired := []model.User{}
+	err = tx.Select(&expired, `SELECT * FROM user WHERE account_id=$1 AND is_active
+		AND julianday(COALESCE(last_seen_at, created_at)) < julianday($2) - $3 ORDER BY created_at, rowid`,
+		account.AccountID, now, account.InactivityDays)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	changes := []model.UserExpiry{}
+	for _, u := range expired {
+		if _, err := tx.Exec("UPDATE user SET is_active=0, updated_at=$1 WHERE user_id=$2", now, u.UserID); err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+		lastSeen := u.LastSeenAt
+		if lastSeen == nil {
+			lastSeen = &u.CreatedAt
+		}
+		changes = append(changes, model.UserExpiry{UserExpiryID: uuid.New(), AccountID: account.AccountID, UserID: u.UserID,
+			Action: model.UserExpired, LastSeenAt: lastSeen, CreatedAt: now})
+	}
+
+	promoted, err := promoteUsers(tx.Tx, account.AccountID, account.Plan, now)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	for _, userID := range promoted {
+		changes = append(changes, model.UserExpiry{UserExpiryID: uuid.New(), AccountID: account.AccountID, UserID: userID,
+			Action: model.UserPromoted, CreatedAt: now})
+	}
+
+	if len(changes) == 0 {
+		tx.Rollback()
+		return nil, nil
+	}
+	if err := insertUserExpiries(tx, changes); err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	return changes, tx.Commit()
+}
+
+// insertUserExpiries records changes in the "user_expiry" table
+func insertUserExpiries(tx *sqlx.Tx, changes []model.UserExpiry) error {
+	for i := range changes {
+		_, err := tx.NamedExec(`INSERT INTO user_expiry (user_expiry_id, account_id, user_id, action, last_seen_at, created_at)
+			VALUES (:user_expiry_id, :account_id, :user_id, :action, :last_seen_at, :created_at)`, &changes[i])
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetUserExpiries retrieves the limit most recent changes the inactivity policy made to accountID's users, newest first
+func (db *Database) GetUserExpiries(accountID string, limit int) ([]model.UserExpiry, error) {
+	changes := []model.UserExpiry{}
+	err := db.db.Select(&changes, "SELECT * FROM user_expiry WHERE account_id=$1 ORDER BY created_at DESC, rowid DESC LIMIT $2", accountID, limit)
+	return changes, err
+}
@@ -0,0 +1,185 @@
+package database
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/ibeckermayer/teleport-interview/backend/internal/model"
+)
+
+func TestExpiredUserIsPromotedWhenSeen(t *testing.T) {
+	db := newTestDB(t)
+	accountID := newTestAccount(t, db, model.FREE)
+	now := time.Now().UTC()
+	if _, err := db.SetInactivityDays(accountID, 7, now); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, _, err := db.CreateUser("user", accountID); err != nil {
+		t.Fatal(err)
+	}
+	seen := func(at time.Time) {
+		t.Helper()
+		if err := db.CreateMetric(model.Metric{AccountID: accountID, UserID: "user", Timestamp: at, EventType: model.MetricLogin}, at); err != nil {
+			t.Fatal(err)
+		}
+	}
+	isActive := func() bool {
+		t.Helper()
+		u, err := db.GetUser("user")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return u.IsActive
+	}
+
+	seen(now.AddDate(0, 0, -30))
+	if _, err := db.ExpireInactiveUsers(now); err != nil {
+		t.Fatal(err)
+	}
+	if isActive() {
+		t.Fatal("expected the user to have been expired")
+	}
+
+	// A failed login doesn't count as seeing the user
+	if err := db.CreateMetric(model.Metric{AccountID: accountID, UserID: "user", Timestamp: now, EventType: model.MetricLoginFailure}, now); err != nil {
+		t.Fatal(err)
+	}
+	if isActive() {
+		t.Fatal("expected a failed login not to promote the user")
+	}
+
+	seen(now)
+	if !isActive() {
+		t.Fatal("expected the user to take a free seat as soon as it was seen again")
+	}
+	changes, err := db.GetUserExpiries(accountID, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changes) != 2 || changes[0].Action != model.UserPromoted || changes[1].Action != model.UserExpired {
+		t.Fatalf("expected the expiry and the promotion to be recorded but got %+v", changes)
+	}
+}
+
+func TestExpireInactiveUsers(t *testing.T) {
+	setPlanMaxUsers(t, 2, 4)
+	type user struct {
+		id          string
+		lastSeen    int // days ago
+		deactivated bool
+	}
+	tests := []struct {
+		name           string
+		inactivityDays int
+		status         model.AccountStatus
+		users          []user // created in order, so the first two take the seats
+		wantChanges    []string
+		wantActive     []string
+	}{
+		{
+			name:       "no policy",
+			status:     model.AccountActive,
+			users:      []user{{id: "a", lastSeen: 300}},
+			wantActive: []string{"a"},
+		},
+		{
+			name:           "recently seen users are kept",
+			inactivityDays: 7,
+			status:         model.AccountActive,
+			users:          []user{{id: "a", lastSeen: 6}, {id: "b", lastSeen: 0}},
+			wantActive:     []string{"a", "b"},
+		},
+		{
+			name:           "stale users are expired",
+			inactivityDays: 7,
+			status:         model.AccountActive,
+			users:          []user{{id: "a", lastSeen: 8}, {id: "b", lastSeen: 30}},
+			wantChanges:    []string{"expired a", "expired b"},
+			wantActive:     []string{},
+		},
+		{
+			name:           "freed seats go to the oldest waiting users",
+			inactivityDays: 7,
+			status:         model.AccountActive,
+			users:          []user{{id: "a", lastSeen: 30}, {id: "b", lastSeen: 1}, {id: "c", lastSeen: 2}, {id: "d", lastSeen: 1}},
+			wantChanges:    []string{"expired a", "promoted c"},
+			wantActive:     []string{"b", "c"},
+		},
+		{
+			name:           "stale waiting users aren't promoted",
+			inactivityDays: 7,
+			status:         model.AccountActive,
+			users:          []user{{id: "a", lastSeen: 30}, {id: "b", lastSeen: 1}, {id: "c", lastSeen: 30}},
+			wantChanges:    []string{"expired a"},
+			wantActive:     []string{"b"},
+		},
+		{
+			name:           "deactivated users aren't promoted",
+			inactivityDays: 7,
+			status:         model.AccountActive,
+			users:          []user{{id: "a", lastSeen: 30}, {id: "b", lastSeen: 1}, {id: "c", lastSeen: 1, deactivated: true}},
+			wantChanges:    []string{"expired a"},
+			wantActive:     []string{"b"},
+		},
+		{
+			name:           "suspended accounts are skipped",
+			inactivityDays: 7,
+			status:         model.AccountSuspended,
+			users:          []user{{id: "a", lastSeen: 30}},
+			wantActive:     []string{"a"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := newTestDB(t)
+			accountID := newTestAccount(t, db, model.FREE)
+			now := time.Now().UTC()
+			for _, u := range tt.users {
+				if _, _, _, err := db.CreateUser(u.id, accountID); err != nil {
+					t.Fatal(err)
+				}
+			}
+			for _, u := range tt.users {
+				seen := now.AddDate(0, 0, -u.lastSeen)
+				if err := db.CreateMetric(model.Metric{AccountID: accountID, UserID: u.id, Timestamp: seen, EventType: model.MetricLogin}, seen); err != nil {
+					t.Fatal(err)
+				}
+				if u.deactivated {
+					if _, err := db.SetUserActive(accountID, u.id, false, now); err != nil {
+						t.Fatal(err)
+					}
+				}
+			}
+			if _, err := db.SetInactivityDays(accountID, tt.inactivityDays, now); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := db.SetAccountStatus(accountID, tt.status, now, now); err != nil {
+				t.Fatal(err)
+			}
+
+			changes, err := db.ExpireInactiveUsers(now)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := []string{}
+			for _, c := range changes {
+				got = append(got, string(c.Action)+" "+c.UserID)
+			}
+			want := tt.wantChanges
+			if want == nil {
+				want = []string{}
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("got changes %v, want %v", got, want)
+			}
+			if recorded, err := db.GetUserExpiries(accountID, 10); err != nil || len(recorded) != len(changes) {
+				t.Errorf("expected the %v changes to be recorded but got %+v, %v", len(changes), recorded, err)
+			}
+			if active := activeUsers(t, db, accountID); !reflect.DeepEqual(active, tt.wantActive) {
+				t.Errorf("got active users %v, want %v", active, tt.wantActive)
+			}
+		})
+	}
+}
@@ -0,0 +1,158 @@
+package database
+
+import (
+	"time"
+
+	"github.com/pborman/uuid"
+
+	"github.com/ibeckermayer/teleport-interview/backend/internal/model"
+	"github.com/ibeckermayer/teleport-interview/backend/internal/webhook"
+)
+
+// CreateWebhook registers url to receive accountID's events, signed with secret
+func (db *Database) CreateWebhook(accountID, url, secret string) (model.Webhook, error) {
+	hook := model.Webhook{
+		WebhookID: uuid.New(),
+		AccountID: accountID,
+		URL:       url,
+		Secret:    secret,
+		CreatedAt: time.Now(),
+	}
+	_, err := db.db.NamedExec("INSERT INTO webhook (webhook_id, account_id, url, secret, created_at) VALUES (:webhook_id, :account_id, :url, :secret, :created_at)", hook)
+	return hook, err
+}
+
+// GetWebhooks retrieves every webhook registered by accountID, oldest first
+func (db *Database) GetWebhooks(accountID string) ([]model.Webhook, error) {
+	hooks := []model.Webhook{}
+	err := db.db.Select(&hooks, "SELECT * FROM webhook WHERE account_id=$1 ORDER BY created_at", accountID)
+	return hooks, err
+}
+
+// GetWebhook retrieves a webhook by webhookID
+func (db *Database) GetWebhook(webhookID string) (model.Webhook, error) {
+	hook := model.Webhook{}
+	err := db.db.Get(&hook, "SELECT * FROM webhook WHERE webhook_id=$1", webhookID)
+	return hook, err
+}
+
+// DeleteWebhook deletes one of accountID's webhooks and fails any of its deliveries that are still pending.
+// Returns false if accountID has no such webhook.
+func (db *Database) DeleteWebhook(accountID, webhookID string) (bool, error) {
+	tx, err := db.db.Begin()
+	if err != nil {
+		return false, err
+	}
+
+	res, err := tx.Exec("DELETE FROM webhook WHERE webhook_id=$1 AND account_id=$2", webhookID, accountID)
+	if err != nil {
+		tx.Rollback()
+		return false, err
+	}
+	if n, err := res.RowsAffected(); err != nil || n == 0 {
+		tx.Rollback()
+		return false, err
+	}
+
+	_, err = tx.Exec("UPDATE webhook_delivery SET status=$1, last_error=$2 WHERE webhook_id=$3 AND status=$4",
+		model.DeliveryFailed, "webhook deleted", webhookID, model.DeliveryPending)
+	if err != nil {
+		tx.Rollback()
+		return false, err
+	}
+
+	return true, tx.Commit()
+}
+
+// CreateEvent adds an event to the outbox of every webhook accountID has registered. The deliveries
+// are sent in the background, so a nil error only means the event was saved.
+func (db *Database) CreateEvent(accountID string, eventType model.EventType, data interface{}) error {
+	hooks, err := db.GetWebhooks(accountID)
+	if err != nil || len(hooks) == 0 {
+		return err
+	}
+
+	now := time.Now()
+	event := webhook.Event{
+		ID:        uuid.New(),
+		Type:      eventType,
+		AccountID: accountID,
+		CreatedAt: now,
+		Data:      data,
+	}
+	payload, err := webhook.MarshalEvent(event)
+	if err != nil {
+		return err
+	}
+
+	tx, err := db.db.Beginx()
+	if err != nil {
+		return err
+	}
+	for _, hook := range hooks {
+		delivery := model.WebhookDelivery{
+			DeliveryID:    uuid.New(),
+			EventID:       event.ID,
+			WebhookID:     hook.WebhookID,
+			AccountID:     accountID,
+			EventType:     eventType,
+			Payload:       payload,
+			Status:        model.DeliveryPending,
+			NextAttemptAt: now,
+			CreatedAt:     now,
+		}
+		_, err := tx.NamedExec(`INSERT INTO webhook_delivery
+			(delivery_id, event_id, webhook_id, account_id, event_type, payload, status, attempts, next_attempt_at, last_error, created_at, delivered_at)
+			VALUES (:delivery_id, :event_id, :webhook_id, :account_id, :event_type, :payload, :status, :attempts, :next_attempt_at, :last_error, :created_at, :delivered_at)`, delivery)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// GetDueDeliveries retrieves up to limit pending deliveries whose next attempt is due at now, oldest first
+func (db *Database) GetDueDeliveries(now time.Time, limit int) ([]model.WebhookDelivery, error) {
+	deliveries := []model.WebhookDelivery{}
+	err := db.db.Select(&deliveries, `SELECT * FROM webhook_delivery WHERE status=$1 AND julianday(next_attempt_at) <= julianday($2)
+		ORDER BY created_at LIMIT $3`, model.DeliveryPending, now, limit)
+	return deliveries, err
+}
+
+// RecordWebhookAttempt logs an attempt to send a delivery and saves the delivery's resulting
+// status, attempts, next_attempt_at, last_error and delivered_at
+func (db *Database) RecordWebhookAttempt(d model.WebhookDelivery, a model.WebhookAttempt) error {
+	tx, err := db.db.Beginx()
+	if err != nil {
+		return err
+	}
+
+	if _, err := tx.NamedExec("INSERT INTO webhook_attempt (attempt_id, delivery_id, status_code, error, attempted_at) VALUES (:attempt_id, :delivery_id, :status_code, :error, :attempted_at)", a); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if _, err := tx.NamedExec(`UPDATE webhook_delivery SET status=:status, attempts=:attempts, next_attempt_at=:next_attempt_at,
+		last_error=:last_error, delivered_at=:delivered_at WHERE delivery_id=:delivery_id`, d); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// GetDeliveries retrieves accountID's most recent limit deliveries, newest first
+func (db *Database) GetDeliveries(accountID string, limit int) ([]model.WebhookDelivery, error) {
+	deliveries := []model.WebhookDelivery{}
+	err := db.db.Select(&deliveries, "SELECT * FROM webhook_delivery WHERE account_id=$1 ORDER BY created_at DESC, rowid DESC LIMIT $2", accountID, limit)
+	return deliveries, err
+}
+
+// GetWebhookAttempts retrieves every attempt made to send a delivery, oldest first
+func (db *Database) GetWebhookAttempts(deliveryID string) ([]model.WebhookAttempt, error) {
+	attempts := []model.WebhookAttempt{}
+	err := db.db.Select(&attempts, "SELECT * FROM webhook_attempt WHERE delivery_id=$1 ORDER BY attempted_at, rowid", deliveryID)
+	return attempts, err
+}
@@ -0,0 +1,499 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"github.com/ibeckermayer/teleport-interview/backend/internal/alert"
+	"github.com/ibeckermayer/teleport-interview/backend/internal/audit"
+	"github.com/ibeckermayer/teleport-interview/backend/internal/auth"
+	"github.com/ibeckermayer/teleport-interview/backend/internal/database"
+	"github.com/ibeckermayer/teleport-interview/backend/internal/model"
+	"github.com/ibeckermayer/teleport-interview/backend/internal/util"
+)
+
+const (
+	// adminSearchLimit is the most accounts AdminAccountsHandler returns
+	adminSearchLimit = 50
+
+	// adminSearchMinLength is the shortest email search AdminAccountsHandler accepts, so that it can't be
+	// used to list every account
+	adminSearchMinLength = 3
+
+	// impersonationTimeout is how long a session created by AdminImpersonateHandler lasts
+	impersonationTimeout = time.Hour
+)
+
+type adminMemberJSON struct {
+	MemberID  string     `json:"memberID"`
+	Email     string     `json:"email"`
+	Role      model.Role `json:"role"`
+	CreatedAt time.Time  `json:"createdAt"`
+}
+
+type adminAccountJSON struct {
+	AccountID   string              `json:"accountID"`
+	Plan        model.Plan          `json:"plan"`
+	MaxUsers    int                 `json:"maxUsers"`
+	TotalUsers  int                 `json:"totalUsers"`
+	ActiveUsers int                 `json:"activeUsers"`
+	CreatedAt   time.Time           `json:"createdAt"`
+	TrialEndsAt *time.Time          `json:"trialEndsAt"`
+	Status      model.AccountStatus `json:"status"`
+	DeleteAfter *time.Time          `json:"deleteAfter"`
+	Members     []adminMemberJSON   `json:"members"`
+}
+
+// newAdminAccountJSON looks up the members of the account in a so that staff can see who it belongs to
+func newAdminAccountJSON(db *database.Database, a database.AccountSummary) (adminAccountJSON, error) {
+	members, err := db.GetMembers(a.AccountID)
+	if err != nil {
+		return adminAccountJSON{}, err
+	}
+	aj := adminAccountJSON{a.AccountID, a.Plan, model.PlanMaxUsers[a.Plan], a.TotalUsers, a.ActiveUsers, a.CreatedAt, a.TrialEndsAt, a.Status, a.DeleteAfter,
+		make([]adminMemberJSON, 0, len(members))}
+	for _, m := range members {
+		aj.Members = append(aj.Members, adminMemberJSON{m.MemberID, m.Email, m.Role, m.CreatedAt})
+	}
+	return aj, nil
+}
+
+// writeAdminAccount responds with accountID's adminAccountJSON, or a 404 if there's no such account
+func writeAdminAccount(w http.ResponseWriter, db *database.Database, accountID string) {
+	summary, err := db.GetAccountSummary(accountID)
+	if err == sql.ErrNoRows {
+		util.ErrorJSON(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Println(err)
+		util.ErrorJSON(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	respBody, err := newAdminAccountJSON(db, summary)
+	if err != nil {
+		log.Println(err)
+		util.ErrorJSON(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	if err := json.NewEncoder(w).Encode(respBody); err != nil {
+		log.Println(err)
+		return
+	}
+}
+
+// AdminAccountsHandler handles GET calls to "admin/api/accounts"
+type AdminAccountsHandler struct {
+	db *database.Database
+}
+
+// NewAdminAccountsHandler creates a new AdminAccountsHandler
+func NewAdminAccountsHandler(db *database.Database) *AdminAccountsHandler {
+	return &AdminAccountsHandler{db}
+}
+
+type adminAccountsResponseBody struct {
+	Accounts []adminAccountJSON `json:"accounts"`
+}
+
+// Handles "admin/api/accounts?email=<search>" GET requests, returning accounts with a member whose email address
+// contains the search, newest first. Should be wrapped with WithStaffAuth and WithAPIHeaders
+func (aah *AdminAccountsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	email := strings.TrimSpace(r.URL.Query().Get("email"))
+	if len(email) < adminSearchMinLength {
+		util.ErrorJSON(w, fmt.Sprintf("email must be at least %v characters", adminSearchMinLength), http.StatusBadRequest)
+		return
+	}
+
+	accounts, err := aah.db.SearchAccounts(email, adminSearchLimit)
+	if err != nil {
+		log.Println(err)
+		util.ErrorJSON(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	respBody := adminAccountsResponseBody{Accounts: make([]adminAccountJSON, 0, len(accounts))}
+	for _, a := range accounts {
+		aj, err := newAdminAccountJSON(aah.db, a)
+		if err != nil {
+			log.Println(err)
+			util.ErrorJSON(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+		respBody.Accounts = append(respBody.Accounts, aj)
+	}
+
+	if err := json.NewEncoder(w).Encode(respBody); err != nil {
+		log.Println(err)
+		return
+	}
+}
+
+// AdminAccountHandler handles GET calls to "admin/api/accounts/{accountID}"
+type AdminAccountHandler struct {
+	db *database.Database
+}
+
+// NewAdminAccountHandler creates a new AdminAccountHandler
+func NewAdminAccountHandler(db *database.Database) *AdminAccountHandler {
+	return &AdminAccountHandler{db}
+}
+
+// Handles "admin/api/accounts/{accountID}" GET requests. Should be wrapped with WithStaffAuth and WithAPIHeaders
+func (aah *AdminAccountHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	writeAdminAccount(w, aah.db, mux.Vars(r)["accountID"])
+}
+
+// AdminPlanHandler handles PUT calls to "admin/api/accounts/{accountID}/plan"
+type AdminPlanHandler struct {
+	sm     *auth.SessionManager
+	db     *database.Database
+	alerts *alert.Alerter
+	audit  *audit.Log
+}
+
+// NewAdminPlanHandler creates a new AdminPlanHandler
+func NewAdminPlanHandler(sm *auth.SessionManager, db *database.Database, alerts *alert.Alerter, al *audit.Log) *AdminPlanHandler {
+	return &AdminPlanHandler{sm, db, alerts, al}
+}
+
+type adminPlanRequestBody struct {
+	Plan model.Plan `json:"plan"`
+}
+
+// Handles "admin/api/accounts/{accountID}/plan" PUT requests, moving the account to a plan whatever plan it's on
+// and ending any trial. Should be wrapped with WithStaffAuth and WithAPIHeaders
+func (aph *AdminPlanHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	staff, err := auth.StaffFromContext(r.Context())
+	if err != nil {
+		log.Println(err)
+		util.ErrorJSON(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	var body adminPlanRequestBody
+	if err := util.DecodeJSONBody(w, r, &body); err != nil {
+		util.HandleJSONdecodeError(w, err)
+		return
+	}
+	if _, ok := model.PlanMaxUsers[body.Plan]; !ok {
+		util.ErrorJSON(w, "plan must be one of FREE or ENTERPRISE", http.StatusBadRequest)
+		return
+	}
+
+	accountID := mux.Vars(r)["accountID"]
+	old, err := aph.db.GetAccount(accountID)
+	if err == sql.ErrNoRows {
+		util.ErrorJSON(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Println(err)
+		util.ErrorJSON(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	account, err := aph.db.ForcePlan(accountID, body.Plan, auth.StaffActor(staff), time.Now())
+	if err != nil {
+		log.Println(err)
+		util.ErrorJSON(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	aph.sm.UpdateAccount(account)
+	aph.alerts.CheckAsync(account.AccountID)
+	log.Printf("%v moved account_id=%v from %v to %v plan", auth.StaffActor(staff), account.AccountID, old.Plan, account.Plan)
+	aph.audit.Record(r, audit.Entry{AccountID: account.AccountID, Actor: auth.StaffActor(staff), Action: model.AuditAdminPlanChange, Outcome: model.AuditSuccess,
+		Detail: fmt.Sprintf("from %v to %v", old.Plan, account.Plan)})
+
+	writeAdminAccount(w, aph.db, account.AccountID)
+}
+
+// AdminAPIkeyHandler handles POST calls to "admin/api/accounts/{accountID}/apikey"
+type AdminAPIkeyHandler struct {
+	db    *database.Database
+	audit *audit.Log
+}
+
+// NewAdminAPIkeyHandler creates a new AdminAPIkeyHandler
+func NewAdminAPIkeyHandler(db *database.Database, al *audit.Log) *AdminAPIkeyHandler {
+	return &AdminAPIkeyHandler{db, al}
+}
+
+type adminAPIkeyResponseBody struct {
+	APIkey auth.Key `json:"apikey"` // only ever returned here, staff must pass it on to the account
+}
+
+// Handles "admin/api/accounts/{accountID}/apikey" POST requests, replacing the account's API key with a new
+// one. Devices using the old key are refused from then on. Should be wrapped with WithStaffAuth and WithAPIHeaders
+func (akh *AdminAPIkeyHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	staff, err := auth.StaffFromContext(r.Context())
+	if err != nil {
+		log.Println(err)
+		util.ErrorJSON(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	accountID := mux.Vars(r)["accountID"]
+	if _, err := akh.db.GetAccount(accountID); err == sql.ErrNoRows {
+		util.ErrorJSON(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return
+	} else if err != nil {
+		log.Println(err)
+		util.ErrorJSON(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	key, err := auth.NewKey()
+	if err != nil {
+		log.Println(err)
+		util.ErrorJSON(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	if err := akh.db.ResetAPIkey(key, accountID); err != nil {
+		log.Println(err)
+		util.ErrorJSON(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	log.Printf("%v reset the API key of account_id=%v", auth.StaffActor(staff), accountID)
+	akh.audit.Record(r, audit.Entry{AccountID: accountID, Actor: auth.StaffActor(staff), Action: model.AuditAdminAPIkeyReset, Outcome: model.AuditSuccess})
+
+	if err := json.NewEncoder(w).Encode(adminAPIkeyResponseBody{key}); err != nil {
+		log.Println(err)
+		return
+	}
+}
+
+// AdminStatusHandler handles POST calls to "admin/api/accounts/{accountID}/suspend" and
+// "admin/api/accounts/{accountID}/reactivate", and DELETE calls to "admin/api/accounts/{accountID}"
+type AdminStatusHandler struct {
+	sm     *auth.SessionManager
+	db     *database.Database
+	audit  *audit.Log
+	status model.AccountStatus // the status the handler moves accounts to
+	grace  time.Duration       // how long accounts are pending deletion before they're purged
+}
+
+// NewAdminStatusHandler creates a new AdminStatusHandler which moves accounts to status. Accounts moved to
+// model.AccountPendingDeletion are purged once grace has passed.
+func NewAdminStatusHandler(sm *auth.SessionManager, db *database.Database, al *audit.Log, status model.AccountStatus, grace time.Duration) *AdminStatusHandler {
+	return &AdminStatusHandler{sm, db, al, status, grace}
+}
+
+// Handles requests that suspend, reactivate or delete an account. Suspending an account logs its members out
+// everywhere, stops them logging in, and refuses its devices' metrics. Deleting it does the same, and purges
+// the account and all of its data once the grace period has passed, unless it's reactivated first.
+// Reactivating an account cancels either. Should be wrapped with WithStaffAuth and WithAPIHeaders
+func (ash *AdminStatusHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	staff, err := auth.StaffFromContext(r.Context())
+	if err != nil {
+		log.Println(err)
+		util.ErrorJSON(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	old, err := ash.db.GetAccount(mux.Vars(r)["accountID"])
+	if err == sql.ErrNoRows {
+		util.ErrorJSON(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Println(err)
+		util.ErrorJSON(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	if old.Status == ash.status {
+		// Nothing to do, and a repeated delete mustn't push back when the account is purged
+		writeAdminAccount(w, ash.db, old.AccountID)
+		return
+	}
+	if old.Status == model.AccountPendingDeletion && ash.status == model.AccountSuspended {
+		util.ErrorJSON(w, "the account is pending deletion, reactivate it first", http.StatusConflict)
+		return
+	}
+
+	now := time.Now()
+	account, err := ash.db.SetAccountStatus(old.AccountID, ash.status, now.Add(ash.grace), now)
+	if err != nil {
+		log.Println(err)
+		util.ErrorJSON(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	var action model.AuditAction
+	var detail string
+	switch account.Status {
+	case model.AccountSuspended:
+		action = model.AuditAdminSuspend
+	case model.AccountPendingDeletion:
+		action, detail = model.AuditAdminDelete, "purge after "+account.DeleteAfter.UTC().Format(time.RFC3339)
+	default:
+		action, detail = model.AuditAdminReactivate, "was "+string(old.Status)
+	}
+	if account.Active() {
+		log.Printf("%v reactivated account_id=%v", auth.StaffActor(staff), account.AccountID)
+	} else {
+		n := ash.sm.DeleteAccountSessions(account.AccountID)
+		log.Printf("%v moved account_id=%v to %v, revoked %v sessions", auth.StaffActor(staff), account.AccountID, account.Status, n)
+	}
+	ash.audit.Record(r, audit.Entry{AccountID: account.AccountID, Actor: auth.StaffActor(staff), Action: action, Outcome: model.AuditSuccess, Detail: detail})
+
+	writeAdminAccount(w, ash.db, account.AccountID)
+}
+
+// AdminImpersonateHandler handles POST calls to "admin/api/accounts/{accountID}/impersonate"
+type AdminImpersonateHandler struct {
+	sm    *auth.SessionManager
+	db    *database.Database
+	audit *audit.Log
+}
+
+// NewAdminImpersonateHandler creates a new AdminImpersonateHandler
+func NewAdminImpersonateHandler(sm *auth.SessionManager, db *database.Database, al *audit.Log) *AdminImpersonateHandler {
+	return &AdminImpersonateHandler{sm, db, al}
+}
+
+type adminImpersonateRequestBody struct {
+	MemberID string `json:"memberID"` // optional, defaults to the account's oldest owner
+}
+
+type adminImpersonateResponseBody struct {
+	SessionID auth.SessionID `json:"sessionID"`
+	Expires   time.Time      `json:"expires"`
+}
+
+// Handles "admin/api/accounts/{accountID}/impersonate" POST requests, returning a session for the dashboard as
+// one of the account's members, so that staff can see what they see. The session is recorded in the account's
+// audit log, as is everything done with it. Should be wrapped with WithStaffAuth and WithAPIHeaders
+func (aih *AdminImpersonateHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	staff, err := auth.StaffFromContext(r.Context())
+	if err != nil {
+		log.Println(err)
+		util.ErrorJSON(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	var body adminImpersonateRequestBody
+	if err := util.DecodeJSONBody(w, r, &body); err != nil {
+		util.HandleJSONdecodeError(w, err)
+		return
+	}
+
+	account, err := aih.db.GetAccount(mux.Vars(r)["accountID"])
+	if err == sql.ErrNoRows {
+		util.ErrorJSON(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Println(err)
+		util.ErrorJSON(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	if !account.Active() {
+		util.ErrorJSON(w, fmt.Sprintf("the account is %v, reactivate it first", account.Status), http.StatusConflict)
+		return
+	}
+
+	members, err := aih.db.GetMembers(account.AccountID)
+	if err != nil {
+		log.Println(err)
+		util.ErrorJSON(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	var member *model.Member
+	for i := range members {
+		if (body.MemberID == "" && members[i].Role == model.RoleOwner) || members[i].MemberID == body.MemberID {
+			member = &members[i]
+			break
+		}
+	}
+	if member == nil {
+		util.ErrorJSON(w, "no such member of the account", http.StatusNotFound)
+		return
+	}
+
+	session, err := aih.sm.CreateImpersonationSession(account, *member, auth.StaffActor(staff), impersonationTimeout)
+	if err != nil {
+		log.Println(err)
+		util.ErrorJSON(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	log.Printf("%v is impersonating member_id=%v of account_id=%v", auth.StaffActor(staff), member.MemberID, account.AccountID)
+	aih.audit.Record(r, audit.Entry{AccountID: account.AccountID, Actor: auth.StaffActor(staff), Action: model.AuditImpersonate, Outcome: model.AuditSuccess,
+		Detail: "as " + member.Email})
+
+	w.WriteHeader(http.StatusCreated)
+	if err := json.NewEncoder(w).Encode(adminImpersonateResponseBody{session.SessionID, session.Expires}); err != nil {
+		log.Println(err)
+		return
+	}
+}
+
+// AdminUnlockLoginHandler handles POST calls to "admin/api/logins/unlock"
+type AdminUnlockLoginHandler struct {
+	db    *database.Database
+	audit *audit.Log
+}
+
+// NewAdminUnlockLoginHandler creates a new AdminUnlockLoginHandler
+func NewAdminUnlockLoginHandler(db *database.Database, al *audit.Log) *AdminUnlockLoginHandler {
+	return &AdminUnlockLoginHandler{db, al}
+}
+
+type adminUnlockLoginRequestBody struct {
+	EmailOrIP string `json:"emailOrIP"`
+}
+
+// Handles "admin/api/logins/unlock" POST requests, forgetting the failed logins for an email address or client
+// IP and lifting any lockout, like the -unlock flag. Returns a 404 if there were none. Unlocking a member's email
+// address is recorded in their account's audit log. Should be wrapped with WithStaffAuth and WithAPIHeaders
+func (aulh *AdminUnlockLoginHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	staff, err := auth.StaffFromContext(r.Context())
+	if err != nil {
+		log.Println(err)
+		util.ErrorJSON(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	var body adminUnlockLoginRequestBody
+	if err := util.DecodeJSONBody(w, r, &body); err != nil {
+		util.HandleJSONdecodeError(w, err)
+		return
+	}
+	body.EmailOrIP = strings.TrimSpace(body.EmailOrIP)
+	if body.EmailOrIP == "" {
+		util.ErrorJSON(w, "emailOrIP is required", http.StatusBadRequest)
+		return
+	}
+
+	unlocked, err := aulh.db.UnlockLogin(body.EmailOrIP)
+	if err != nil {
+		log.Println(err)
+		util.ErrorJSON(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	if !unlocked {
+		util.ErrorJSON(w, "no failed logins to unlock", http.StatusNotFound)
+		return
+	}
+
+	var accountID string
+	if member, err := aulh.db.GetMemberByEmail(body.EmailOrIP); err == nil {
+		accountID = member.AccountID
+	} else if err != sql.ErrNoRows {
+		log.Println(err)
+	}
+	log.Printf("%v unlocked logins for %v", auth.StaffActor(staff), body.EmailOrIP)
+	aulh.audit.Record(r, audit.Entry{AccountID: accountID, Actor: auth.StaffActor(staff), Action: model.AuditAdminUnlockLogin, Outcome: model.AuditSuccess,
+		Detail: body.EmailOrIP})
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,81 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"github.com/ibeckermayer/teleport-interview/backend/internal/audit"
+	"github.com/ibeckermayer/teleport-interview/backend/internal/auth"
+	"github.com/ibeckermayer/teleport-interview/backend/internal/database"
+	"github.com/ibeckermayer/teleport-interview/backend/internal/model"
+	"github.com/ibeckermayer/teleport-interview/backend/internal/util"
+)
+
+// SigningKeyHandler handles POST calls to "api/apikey/signing-key"
+type SigningKeyHandler struct {
+	sm    *auth.SessionManager
+	db    *database.Database
+	keys  auth.SigningKeys
+	audit *audit.Log
+}
+
+// NewSigningKeyHandler creates a new SigningKeyHandler that derives signing keys with keys
+func NewSigningKeyHandler(sm *auth.SessionManager, db *database.Database, keys auth.SigningKeys, al *audit.Log) *SigningKeyHandler {
+	return &SigningKeyHandler{sm, db, keys, al}
+}
+
+type signingKeyRequestBody struct {
+	RequireSigned bool `json:"requireSigned"`
+}
+
+type signingKeyResponseBody struct {
+	SigningKey    string `json:"signingKey"` // only ever returned here
+	RequireSigned bool   `json:"requireSigned"`
+}
+
+// Handles "api/apikey/signing-key" POST requests, issuing a new signing key for the account's API key and returning
+// it. Requests signed with the previous signing key are refused from then on. If requireSigned is set, requests
+// sending the API key itself are refused too. Should be wrapped with WithSessionAuth, RequirePermission and WithAPIHeaders
+func (skh *SigningKeyHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	session, err := skh.sm.FromContext(r.Context())
+	if err != nil {
+		log.Println(err)
+		util.ErrorJSON(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	var body signingKeyRequestBody
+	if err := util.DecodeJSONBody(w, r, &body); err != nil {
+		util.HandleJSONdecodeError(w, err)
+		return
+	}
+
+	signingKeyID, err := auth.NewSigningKeyID()
+	if err != nil {
+		log.Println(err)
+		util.ErrorJSON(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	apikey, err := skh.db.SetSigningKey(session.Account.AccountID, signingKeyID, body.RequireSigned)
+	if err == sql.ErrNoRows {
+		util.ErrorJSON(w, "the account has no API key", http.StatusNotFound)
+		return
+	} else if err != nil {
+		log.Println(err)
+		util.ErrorJSON(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	detail := "bearer key allowed"
+	if apikey.RequireSigned {
+		detail = "signed requests required"
+	}
+	skh.audit.Record(r, audit.Entry{AccountID: apikey.AccountID, Actor: session.Actor(), Action: model.AuditSigningKeyIssue, Outcome: model.AuditSuccess, Detail: detail})
+
+	respBody := signingKeyResponseBody{skh.keys.Key(apikey.KeyHash, apikey.SigningKeyID), apikey.RequireSigned}
+	if err := json.NewEncoder(w).Encode(respBody); err != nil {
+		log.Println(err)
+		return
+	}
+}
@@ -0,0 +1,130 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/ibeckermayer/teleport-interview/backend/internal/auth"
+	"github.com/ibeckermayer/teleport-interview/backend/internal/database"
+	"github.com/ibeckermayer/teleport-interview/backend/internal/model"
+	"github.com/ibeckermayer/teleport-interview/backend/internal/util"
+)
+
+const (
+	// defaultAuditPageSize and maxAuditPageSize are the default and largest number of events AuditGetHandler returns at once
+	defaultAuditPageSize = 50
+	maxAuditPageSize     = 200
+)
+
+// AuditGetHandler handles GET calls to "api/audit"
+type AuditGetHandler struct {
+	sm *auth.SessionManager
+	db *database.Database
+}
+
+// NewAuditGetHandler creates a new AuditGetHandler
+func NewAuditGetHandler(sm *auth.SessionManager, db *database.Database) *AuditGetHandler {
+	return &AuditGetHandler{sm, db}
+}
+
+type auditEventJSON struct {
+	Seq       int64              `json:"seq"`
+	Actor     string             `json:"actor"`
+	Action    model.AuditAction  `json:"action"`
+	Outcome   model.AuditOutcome `json:"outcome"`
+	Detail    string             `json:"detail"`
+	IP        string             `json:"ip"`
+	UserAgent string             `json:"userAgent"`
+	CreatedAt time.Time          `json:"createdAt"`
+	Hash      string             `json:"hash"`
+}
+
+type auditGetResponseBody struct {
+	Events     []auditEventJSON `json:"events"`
+	NextBefore *int64           `json:"nextBefore"` // pass as "before" to get the next page, null on the last page
+}
+
+// parseAuditQuery parses the filter and page size from an "api/audit" request's query string
+func parseAuditQuery(r *http.Request) (database.AuditFilter, int, bool) {
+	q := r.URL.Query()
+	filter := database.AuditFilter{
+		Action:  model.AuditAction(q.Get("action")),
+		Outcome: model.AuditOutcome(q.Get("outcome")),
+	}
+	if filter.Outcome != "" && filter.Outcome != model.AuditSuccess && filter.Outcome != model.AuditFailure {
+		return filter, 0, false
+	}
+	for _, p := range []struct {
+		name string
+		dst  **time.Time
+	}{{"since", &filter.Since}, {"until", &filter.Until}} {
+		if v := q.Get(p.name); v != "" {
+			t, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				return filter, 0, false
+			}
+			*p.dst = &t
+		}
+	}
+	if v := q.Get("before"); v != "" {
+		before, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || before < 1 {
+			return filter, 0, false
+		}
+		filter.Before = before
+	}
+	limit := defaultAuditPageSize
+	if v := q.Get("limit"); v != "" {
+		l, err := strconv.Atoi(v)
+		if err != nil || l < 1 || l > maxAuditPageSize {
+			return filter, 0, false
+		}
+		limit = l
+	}
+	return filter, limit, true
+}
+
+// Handles "api/audit" GET requests, returning the account's audit events most recent first, optionally filtered
+// by "action", "outcome", "since" and "until" (RFC 3339 times). Pages hold up to "limit" events (default 50,
+// at most 200); the next page is fetched with "before" set to the previous page's nextBefore.
+// Should be wrapped with WithSessionAuth and WithAPIHeaders
+func (agh *AuditGetHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	session, err := agh.sm.FromContext(r.Context())
+	if err != nil {
+		log.Println(err)
+		util.ErrorJSON(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	filter, limit, ok := parseAuditQuery(r)
+	if !ok {
+		util.ErrorJSON(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+
+	// Fetch one more than asked for to know whether there's another page
+	events, err := agh.db.GetAuditEvents(session.Account.AccountID, filter, limit+1)
+	if err != nil {
+		log.Println(err)
+		util.ErrorJSON(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	respBody := auditGetResponseBody{Events: make([]auditEventJSON, 0, limit)}
+	if len(events) > limit {
+		events = events[:limit]
+		next := events[limit-1].Seq
+		respBody.NextBefore = &next
+	}
+	for _, e := range events {
+		respBody.Events = append(respBody.Events, auditEventJSON{e.Seq, e.Actor, e.Action, e.Outcome, e.Detail, e.IP, e.UserAgent, e.CreatedAt, e.Hash})
+	}
+
+	if err := json.NewEncoder(w).Encode(respBody); err != nil {
+		log.Println(err)
+		return
+	}
+}
@@ -0,0 +1,321 @@
+package handlers
+
+import (
+	"crypto"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/gorilla/mux"
+
+	"github.com/ibeckermayer/teleport-interview/backend/internal/auth"
+	"github.com/ibeckermayer/teleport-interview/backend/internal/database"
+	"github.com/ibeckermayer/teleport-interview/backend/internal/model"
+	"github.com/ibeckermayer/teleport-interview/backend/internal/pki"
+	"github.com/ibeckermayer/teleport-interview/backend/internal/util"
+)
+
+// maxDeviceNameLength is the longest name a device can be registered with
+const maxDeviceNameLength = 64
+
+var errBadDeviceName = errors.New("device names must be 1-64 printable characters")
+
+// validateDeviceName checks that name can be used as a device certificate's common name
+func validateDeviceName(name string) error {
+	if name == "" || len(name) > maxDeviceNameLength || strings.TrimSpace(name) != name {
+		return errBadDeviceName
+	}
+	for _, r := range name {
+		if !unicode.IsPrint(r) {
+			return errBadDeviceName
+		}
+	}
+	return nil
+}
+
+// DeviceCRLURL returns where the CRL for accountID's device CA is published
+func DeviceCRLURL(publicURL, accountID string) string {
+	return publicURL + "/api/devices/crl/" + accountID
+}
+
+type deviceJSON struct {
+	Serial    string     `json:"serial"`
+	Name      string     `json:"name"`
+	CreatedAt time.Time  `json:"createdAt"`
+	ExpiresAt time.Time  `json:"expiresAt"`
+	RevokedAt *time.Time `json:"revokedAt"` // null unless the device was revoked
+}
+
+func newDeviceJSON(d model.Device) deviceJSON {
+	return deviceJSON{d.Serial, d.Name, d.CreatedAt, d.ExpiresAt, d.RevokedAt}
+}
+
+// DevicesGetHandler handles GET calls to "api/devices"
+type DevicesGetHandler struct {
+	sm *auth.SessionManager
+	db *database.Database
+}
+
+// NewDevicesGetHandler creates a new DevicesGetHandler
+func NewDevicesGetHandler(sm *auth.SessionManager, db *database.Database) *DevicesGetHandler {
+	return &DevicesGetHandler{sm, db}
+}
+
+type devicesGetResponseBody struct {
+	Devices []deviceJSON `json:"devices"`
+}
+
+// Handles "api/devices" GET requests. Should be wrapped with WithSessionAuth and WithAPIHeaders
+func (dgh *DevicesGetHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	session, err := dgh.sm.FromContext(r.Context())
+	if err != nil {
+		log.Println(err)
+		util.ErrorJSON(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	devices, err := dgh.db.GetDevices(session.Account.AccountID)
+	if err != nil {
+		log.Println(err)
+		util.ErrorJSON(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	respBody := devicesGetResponseBody{Devices: make([]deviceJSON, 0, len(devices))}
+	for _, d := range devices {
+		respBody.Devices = append(respBody.Devices, newDeviceJSON(d))
+	}
+
+	if err := json.NewEncoder(w).Encode(respBody); err != nil {
+		log.Println(err)
+		return
+	}
+}
+
+// DevicesPostHandler handles POST calls to "api/devices"
+type DevicesPostHandler struct {
+	sm        *auth.SessionManager
+	db        *database.Database
+	publicURL string
+}
+
+// NewDevicesPostHandler creates a new DevicesPostHandler. publicURL is where the server can be reached,
+// for the CRL distribution point in issued certificates.
+func NewDevicesPostHandler(sm *auth.SessionManager, db *database.Database, publicURL string) *DevicesPostHandler {
+	return &DevicesPostHandler{sm, db, publicURL}
+}
+
+type devicesPostRequestBody struct {
+	Name string `json:"name"`
+	CSR  string `json:"csr"` // optional PEM encoded certificate signing request, a key pair is generated if empty
+}
+
+type devicesPostResponseBody struct {
+	deviceJSON
+	Certificate   string `json:"certificate"`
+	PrivateKey    string `json:"privateKey,omitempty"` // only if no CSR was sent, and only ever returned here
+	CACertificate string `json:"caCertificate"`
+}
+
+// deviceCA returns accountID's device CA, creating it if the account doesn't have one yet
+func (dph *DevicesPostHandler) deviceCA(accountID string, now time.Time) (pki.CA, error) {
+	row, err := dph.db.GetDeviceCA(accountID)
+	if err == sql.ErrNoRows {
+		certPEM, keyPEM, err := pki.NewCA(accountID, now)
+		if err != nil {
+			return pki.CA{}, err
+		}
+		row, err = dph.db.CreateDeviceCA(accountID, string(certPEM), string(keyPEM), now)
+		if err != nil {
+			return pki.CA{}, err
+		}
+	} else if err != nil {
+		return pki.CA{}, err
+	}
+	return pki.ParseCA([]byte(row.CertPEM), []byte(row.KeyPEM))
+}
+
+// Handles "api/devices" POST requests, issuing a client certificate for a new device.
+// Should be wrapped with WithSessionAuth, RequirePermission and WithAPIHeaders
+func (dph *DevicesPostHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	session, err := dph.sm.FromContext(r.Context())
+	if err != nil {
+		log.Println(err)
+		util.ErrorJSON(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	var body devicesPostRequestBody
+	if err := util.DecodeJSONBody(w, r, &body); err != nil {
+		util.HandleJSONdecodeError(w, err)
+		return
+	}
+
+	if err := validateDeviceName(body.Name); err != nil {
+		util.ErrorJSON(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var pub crypto.PublicKey
+	var keyPEM []byte
+	if body.CSR != "" {
+		pub, err = pki.ParseCSR([]byte(body.CSR))
+		if err != nil {
+			log.Println(err)
+			util.ErrorJSON(w, pki.ErrBadCSR.Error(), http.StatusBadRequest)
+			return
+		}
+	} else {
+		key, pemBytes, err := pki.NewDeviceKey()
+		if err != nil {
+			log.Println(err)
+			util.ErrorJSON(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+		pub, keyPEM = key.Public(), pemBytes
+	}
+
+	accountID := session.Account.AccountID
+	now := time.Now()
+	ca, err := dph.deviceCA(accountID, now)
+	if err != nil {
+		log.Println(err)
+		util.ErrorJSON(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	certPEM, serial, err := ca.IssueDeviceCert(body.Name, pub, DeviceCRLURL(dph.publicURL, accountID), now)
+	if err != nil {
+		log.Println(err)
+		util.ErrorJSON(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	device := model.Device{
+		Serial:    pki.SerialString(serial),
+		AccountID: accountID,
+		Name:      body.Name,
+		CreatedAt: now,
+		ExpiresAt: now.Add(pki.DeviceCertValidity),
+	}
+	if err := dph.db.CreateDevice(device); err != nil {
+		log.Println(err)
+		util.ErrorJSON(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	log.Printf("issued certificate serial=%v to device %q of account_id=%v", device.Serial, device.Name, accountID)
+
+	w.WriteHeader(http.StatusCreated)
+	respBody := devicesPostResponseBody{newDeviceJSON(device), string(certPEM), string(keyPEM), ca.CertPEM()}
+	if err := json.NewEncoder(w).Encode(respBody); err != nil {
+		log.Println(err)
+		return
+	}
+}
+
+// DeviceDeleteHandler handles DELETE calls to "api/devices/{serial}"
+type DeviceDeleteHandler struct {
+	sm *auth.SessionManager
+	db *database.Database
+}
+
+// NewDeviceDeleteHandler creates a new DeviceDeleteHandler
+func NewDeviceDeleteHandler(sm *auth.SessionManager, db *database.Database) *DeviceDeleteHandler {
+	return &DeviceDeleteHandler{sm, db}
+}
+
+// Handles "api/devices/{serial}" DELETE requests, revoking the device's certificate. The device is kept so
+// that it's listed in the account's CRL until its certificate expires. Should be wrapped with WithSessionAuth,
+// RequirePermission and WithAPIHeaders
+func (ddh *DeviceDeleteHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	session, err := ddh.sm.FromContext(r.Context())
+	if err != nil {
+		log.Println(err)
+		util.ErrorJSON(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	revoked, err := ddh.db.RevokeDevice(session.Account.AccountID, mux.Vars(r)["serial"], time.Now())
+	if err != nil {
+		log.Println(err)
+		util.ErrorJSON(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	if !revoked {
+		util.ErrorJSON(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return
+	}
+	log.Printf("revoked device serial=%v of account_id=%v", mux.Vars(r)["serial"], session.Account.AccountID)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// DeviceCRLHandler handles GET calls to "api/devices/crl/{accountID}"
+type DeviceCRLHandler struct {
+	db *database.Database
+}
+
+// NewDeviceCRLHandler creates a new DeviceCRLHandler
+func NewDeviceCRLHandler(db *database.Database) *DeviceCRLHandler {
+	return &DeviceCRLHandler{db}
+}
+
+// Handles "api/devices/crl/{accountID}" GET requests, responding with a DER encoded CRL of the account's
+// revoked devices. It's public, like any CRL, and mustn't be wrapped with WithAPIHeaders since it isn't JSON.
+func (dch *DeviceCRLHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	accountID := mux.Vars(r)["accountID"]
+	row, err := dch.db.GetDeviceCA(accountID)
+	if err == sql.ErrNoRows {
+		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Println(err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	ca, err := pki.ParseCA([]byte(row.CertPEM), []byte(row.KeyPEM))
+	if err != nil {
+		log.Println(err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	now := time.Now()
+	devices, err := dch.db.GetRevokedDevices(accountID, now)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	revoked := make([]pki.Revoked, 0, len(devices))
+	for _, d := range devices {
+		serial, err := pki.ParseSerial(d.Serial)
+		if err != nil {
+			log.Println(err)
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+		revoked = append(revoked, pki.Revoked{Serial: serial, RevokedAt: *d.RevokedAt})
+	}
+
+	crl, err := ca.CreateCRL(revoked, now)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/pkix-crl")
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	if _, err := w.Write(crl); err != nil {
+		log.Println(err)
+		return
+	}
+}
@@ -0,0 +1,222 @@
+package handlers
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"net/http"
+	"net/mail"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/ibeckermayer/teleport-interview/backend/internal/auth"
+	"github.com/ibeckermayer/teleport-interview/backend/internal/database"
+	"github.com/ibeckermayer/teleport-interview/backend/internal/model"
+	"github.com/ibeckermayer/teleport-interview/backend/internal/notify"
+	"github.com/ibeckermayer/teleport-interview/backend/internal/util"
+)
+
+const (
+	// emailChangeTTL is how long an email change verification link can be used for after it's emailed
+	emailChangeTTL = 24 * time.Hour
+
+	// maxEmailChanges is the most unexpired email changes a member can have pending at once
+	maxEmailChanges = 3
+
+	// maxEmailLength is the longest email address the member table can hold
+	maxEmailLength = 320
+)
+
+// validEmail reports whether email is a bare email address, like "dev@goteleport.com"
+func validEmail(email string) bool {
+	if len(email) > maxEmailLength {
+		return false
+	}
+	addr, err := mail.ParseAddress(email)
+	return err == nil && addr.Address == email
+}
+
+// ChangeEmailHandler handles calls to "api/account/email"
+type ChangeEmailHandler struct {
+	sm        *auth.SessionManager
+	db        *database.Database
+	notifier  notify.Notifier
+	publicURL string        // the URL the app is served at, which verification links point to
+	lh        *LoginHandler // whose throttle wrong passwords count against
+}
+
+// NewChangeEmailHandler creates a new ChangeEmailHandler
+func NewChangeEmailHandler(sm *auth.SessionManager, db *database.Database, notifier notify.Notifier, publicURL string, lh *LoginHandler) *ChangeEmailHandler {
+	return &ChangeEmailHandler{sm, db, notifier, publicURL, lh}
+}
+
+type changeEmailRequestBody struct {
+	Password string `json:"password"`
+	NewEmail string `json:"newEmail"`
+}
+
+// Handles "api/account/email" POST requests, emailing a verification link to the new address. The member's
+// email isn't changed until the link is used at "api/account/email/verify". Wrong passwords count towards the
+// member's login throttle. Should be wrapped with WithSessionAuth and WithAPIHeaders
+func (ceh *ChangeEmailHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	session, err := ceh.sm.FromContext(r.Context())
+	if err != nil {
+		log.Println(err)
+		util.ErrorJSON(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	var body changeEmailRequestBody
+	if err := util.DecodeJSONBody(w, r, &body); err != nil {
+		util.HandleJSONdecodeError(w, err)
+		return
+	}
+
+	newEmail := strings.TrimSpace(body.NewEmail)
+	if !validEmail(newEmail) {
+		util.ErrorJSON(w, "invalid email address", http.StatusBadRequest)
+		return
+	}
+
+	member, err := ceh.db.GetMember(session.Member.MemberID)
+	if err != nil {
+		log.Println(err)
+		util.ErrorJSON(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	attempt := ceh.lh.beginReauth(w, r, member)
+	if attempt == nil {
+		return
+	}
+	defer attempt.end()
+	if !auth.CheckPasswordHash(body.Password, member.PasswordHash) {
+		attempt.fail()
+		util.ErrorJSON(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+		return
+	}
+	if newEmail == member.Email {
+		util.ErrorJSON(w, "new email address must be different from the current one", http.StatusBadRequest)
+		return
+	}
+
+	now := time.Now()
+	pending, err := ceh.db.CountEmailChanges(member.MemberID, now)
+	if err != nil {
+		log.Println(err)
+		util.ErrorJSON(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	if pending >= maxEmailChanges {
+		util.ErrorJSON(w, "too many email changes pending, use one of the links already sent", http.StatusTooManyRequests)
+		return
+	}
+
+	token, err := auth.NewKey()
+	if err != nil {
+		log.Println(err)
+		util.ErrorJSON(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	ec := model.EmailChange{
+		TokenHash: auth.HashKey(token),
+		MemberID:  member.MemberID,
+		NewEmail:  newEmail,
+		CreatedAt: now,
+		ExpiresAt: now.Add(emailChangeTTL),
+	}
+	err = ceh.db.CreateEmailChange(ec)
+	if err == database.ErrEmailTaken {
+		util.ErrorJSON(w, err.Error(), http.StatusConflict)
+		return
+	}
+	if err != nil {
+		log.Println(err)
+		util.ErrorJSON(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	link := ceh.publicURL + "/verify-email?token=" + url.QueryEscape(string(token))
+	notifyAsync(ceh.notifier, notify.Notification{
+		To:      newEmail,
+		Subject: "Verify your new email address",
+		Body: fmt.Sprintf("Someone asked to change the email address they log in with to this one. To confirm, log in and go to:\n\n%v\n\n"+
+			"The link expires in %v hours and can only be used once. If you didn't ask for this, you can ignore this email.",
+			link, emailChangeTTL.Hours()),
+	})
+	notifyAsync(ceh.notifier, notify.Notification{
+		To:      member.Email,
+		Subject: "Email address change requested",
+		Body: fmt.Sprintf("Someone asked to change the email address you log in with to %v. It won't change until the link sent there is used. "+
+			"If you didn't do this, change your password right away.", newEmail),
+	})
+	log.Printf("email change requested for member_id=%v", member.MemberID)
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// VerifyEmailHandler handles calls to "api/account/email/verify"
+type VerifyEmailHandler struct {
+	sm       *auth.SessionManager
+	cs       *auth.ChallengeStore
+	db       *database.Database
+	notifier notify.Notifier
+}
+
+// NewVerifyEmailHandler creates a new VerifyEmailHandler
+func NewVerifyEmailHandler(sm *auth.SessionManager, cs *auth.ChallengeStore, db *database.Database, notifier notify.Notifier) *VerifyEmailHandler {
+	return &VerifyEmailHandler{sm, cs, db, notifier}
+}
+
+type verifyEmailRequestBody struct {
+	Token string `json:"token"`
+}
+
+// Handles "api/account/email/verify" POST requests, changing the member's email address with a token from a
+// verification link. Every other session for the member is logged out. Should be wrapped with WithSessionAuth
+// and WithAPIHeaders
+func (veh *VerifyEmailHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	session, err := veh.sm.FromContext(r.Context())
+	if err != nil {
+		log.Println(err)
+		util.ErrorJSON(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	var body verifyEmailRequestBody
+	if err := util.DecodeJSONBody(w, r, &body); err != nil {
+		util.HandleJSONdecodeError(w, err)
+		return
+	}
+
+	member, oldEmail, err := veh.db.ConfirmEmailChange(session.Member.MemberID, auth.HashKey(auth.Key(body.Token)), time.Now())
+	if err == sql.ErrNoRows {
+		util.ErrorJSON(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+	if err == database.ErrEmailTaken {
+		util.ErrorJSON(w, err.Error(), http.StatusConflict)
+		return
+	}
+	if err != nil {
+		log.Println(err)
+		util.ErrorJSON(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	// Log out everywhere else and refresh this session's copy of the member
+	n := veh.sm.DeleteOtherSessions(member.MemberID, session.SessionID)
+	veh.cs.DeleteMemberChallenges(member.MemberID)
+	session.Member = member
+	veh.sm.UpdateSession(session)
+	log.Printf("email changed for member_id=%v, revoked %v other sessions", member.MemberID, n)
+
+	notifyAsync(veh.notifier, notify.Notification{
+		To:      oldEmail,
+		Subject: "Your email address was changed",
+		Body: fmt.Sprintf("The email address you log in with was just changed to %v and every other device logged in with it was logged out. "+
+			"If you didn't do this, contact support right away.", member.Email),
+	})
+
+	w.WriteHeader(http.StatusNoContent)
+}
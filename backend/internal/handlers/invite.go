@@ -0,0 +1,339 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/pborman/uuid"
+
+	"github.com/ibeckermayer/teleport-interview/backend/internal/audit"
+	"github.com/ibeckermayer/teleport-interview/backend/internal/auth"
+	"github.com/ibeckermayer/teleport-interview/backend/internal/database"
+	"github.com/ibeckermayer/teleport-interview/backend/internal/model"
+	"github.com/ibeckermayer/teleport-interview/backend/internal/notify"
+	"github.com/ibeckermayer/teleport-interview/backend/internal/util"
+)
+
+const (
+	// inviteTTL is how long an invite can be accepted for after it's emailed
+	inviteTTL = 7 * 24 * time.Hour
+
+	// maxPendingInvites is the most pending invites an account can have at once, so that "api/invites"
+	// can't be used to send unlimited email
+	maxPendingInvites = 20
+)
+
+type inviteJSON struct {
+	InviteID  string     `json:"inviteID"`
+	Email     string     `json:"email"`
+	Role      model.Role `json:"role"`
+	CreatedAt time.Time  `json:"createdAt"`
+	ExpiresAt time.Time  `json:"expiresAt"`
+}
+
+func newInviteJSON(inv model.Invite) inviteJSON {
+	return inviteJSON{inv.InviteID, inv.Email, inv.Role, inv.CreatedAt, inv.ExpiresAt}
+}
+
+// InvitesGetHandler handles GET calls to "api/invites"
+type InvitesGetHandler struct {
+	sm *auth.SessionManager
+	db *database.Database
+}
+
+// NewInvitesGetHandler creates a new InvitesGetHandler
+func NewInvitesGetHandler(sm *auth.SessionManager, db *database.Database) *InvitesGetHandler {
+	return &InvitesGetHandler{sm, db}
+}
+
+type invitesGetResponseBody struct {
+	Invites []inviteJSON `json:"invites"`
+}
+
+// Handles "api/invites" GET requests, returning the account's pending invites, newest first. Should be wrapped
+// with WithSessionAuth, RequirePermission and WithAPIHeaders
+func (igh *InvitesGetHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	session, err := igh.sm.FromContext(r.Context())
+	if err != nil {
+		log.Println(err)
+		util.ErrorJSON(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	invites, err := igh.db.GetPendingInvites(session.Account.AccountID, time.Now())
+	if err != nil {
+		log.Println(err)
+		util.ErrorJSON(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	respBody := invitesGetResponseBody{Invites: make([]inviteJSON, 0, len(invites))}
+	for _, inv := range invites {
+		respBody.Invites = append(respBody.Invites, newInviteJSON(inv))
+	}
+
+	if err := json.NewEncoder(w).Encode(respBody); err != nil {
+		log.Println(err)
+		return
+	}
+}
+
+// InvitesPostHandler handles POST calls to "api/invites"
+type InvitesPostHandler struct {
+	sm        *auth.SessionManager
+	db        *database.Database
+	signer    auth.InviteSigner
+	notifier  notify.Notifier
+	publicURL string // the URL the app is served at, which invite links point to
+	audit     *audit.Log
+}
+
+// NewInvitesPostHandler creates a new InvitesPostHandler
+func NewInvitesPostHandler(sm *auth.SessionManager, db *database.Database, signer auth.InviteSigner, notifier notify.Notifier, publicURL string, al *audit.Log) *InvitesPostHandler {
+	return &InvitesPostHandler{sm, db, signer, notifier, publicURL, al}
+}
+
+type invitesPostRequestBody struct {
+	Email string     `json:"email"`
+	Role  model.Role `json:"role"`
+}
+
+// Handles "api/invites" POST requests, emailing someone a link to join the account as a member with the given
+// role. Should be wrapped with WithSessionAuth, RequirePermission and WithAPIHeaders
+func (iph *InvitesPostHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	session, err := iph.sm.FromContext(r.Context())
+	if err != nil {
+		log.Println(err)
+		util.ErrorJSON(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	var body invitesPostRequestBody
+	if err := util.DecodeJSONBody(w, r, &body); err != nil {
+		util.HandleJSONdecodeError(w, err)
+		return
+	}
+
+	email := strings.TrimSpace(body.Email)
+	if !validEmail(email) {
+		util.ErrorJSON(w, "invalid email address", http.StatusBadRequest)
+		return
+	}
+	if !body.Role.Valid() {
+		util.ErrorJSON(w, "role must be one of owner, admin or viewer", http.StatusBadRequest)
+		return
+	}
+
+	now := time.Now()
+	pending, err := iph.db.CountPendingInvites(session.Account.AccountID, now)
+	if err != nil {
+		log.Println(err)
+		util.ErrorJSON(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	if pending >= maxPendingInvites {
+		util.ErrorJSON(w, "too many invites pending, revoke some or wait for them to be accepted", http.StatusTooManyRequests)
+		return
+	}
+
+	inv := model.Invite{
+		InviteID:  uuid.New(),
+		AccountID: session.Account.AccountID,
+		Email:     email,
+		Role:      body.Role,
+		InvitedBy: session.Member.MemberID,
+		CreatedAt: now,
+		ExpiresAt: now.Add(inviteTTL),
+	}
+	err = iph.db.CreateInvite(inv)
+	if err == database.ErrEmailTaken || err == database.ErrInvitePending {
+		util.ErrorJSON(w, err.Error(), http.StatusConflict)
+		return
+	}
+	if err != nil {
+		log.Println(err)
+		util.ErrorJSON(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	link := iph.publicURL + "/accept-invite?token=" + url.QueryEscape(iph.signer.Token(inv.InviteID, inv.ExpiresAt))
+	notifyAsync(iph.notifier, notify.Notification{
+		To:      inv.Email,
+		Subject: "You've been invited to a dashboard",
+		Body: fmt.Sprintf("%v invited you to join their account's dashboard as %v %v. To choose a password and log in, go to:\n\n%v\n\n"+
+			"The link expires in %v days and can only be used once. If you weren't expecting this, you can ignore this email.",
+			session.Member.Email, article(inv.Role), inv.Role, link, inviteTTL.Hours()/24),
+	})
+	log.Printf("member_id=%v invited a new %v to account_id=%v, invite_id=%v", session.Member.MemberID, inv.Role, inv.AccountID, inv.InviteID)
+	iph.audit.Record(r, audit.Entry{AccountID: inv.AccountID, Actor: session.Actor(), Action: model.AuditInvite, Outcome: model.AuditSuccess,
+		Detail: fmt.Sprintf("%v as %v", inv.Email, inv.Role)})
+
+	w.WriteHeader(http.StatusCreated)
+	if err := json.NewEncoder(w).Encode(newInviteJSON(inv)); err != nil {
+		log.Println(err)
+		return
+	}
+}
+
+// article returns the indefinite article to use before role in a sentence
+func article(role model.Role) string {
+	if strings.IndexAny(string(role), "aeiou") == 0 {
+		return "an"
+	}
+	return "a"
+}
+
+// InviteDeleteHandler handles DELETE calls to "api/invites/{inviteID}"
+type InviteDeleteHandler struct {
+	sm    *auth.SessionManager
+	db    *database.Database
+	audit *audit.Log
+}
+
+// NewInviteDeleteHandler creates a new InviteDeleteHandler
+func NewInviteDeleteHandler(sm *auth.SessionManager, db *database.Database, al *audit.Log) *InviteDeleteHandler {
+	return &InviteDeleteHandler{sm, db, al}
+}
+
+// Handles "api/invites/{inviteID}" DELETE requests, revoking a pending invite so that its link no longer works.
+// Should be wrapped with WithSessionAuth, RequirePermission and WithAPIHeaders
+func (idh *InviteDeleteHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	session, err := idh.sm.FromContext(r.Context())
+	if err != nil {
+		log.Println(err)
+		util.ErrorJSON(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	inviteID := mux.Vars(r)["inviteID"]
+	revoked, err := idh.db.RevokeInvite(session.Account.AccountID, inviteID, time.Now())
+	if err != nil {
+		log.Println(err)
+		util.ErrorJSON(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	if !revoked {
+		util.ErrorJSON(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return
+	}
+
+	idh.audit.Record(r, audit.Entry{AccountID: session.Account.AccountID, Actor: session.Actor(), Action: model.AuditInviteRevoke, Outcome: model.AuditSuccess,
+		Detail: "invite_id=" + inviteID})
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// InviteAcceptHandler handles calls to "api/invites/accept"
+type InviteAcceptHandler struct {
+	sm     *auth.SessionManager
+	db     *database.Database
+	signer auth.InviteSigner
+	policy auth.PasswordPolicy
+	hasher auth.PasswordHasher
+	audit  *audit.Log
+}
+
+// NewInviteAcceptHandler creates a new InviteAcceptHandler
+func NewInviteAcceptHandler(sm *auth.SessionManager, db *database.Database, signer auth.InviteSigner, policy auth.PasswordPolicy, hasher auth.PasswordHasher, al *audit.Log) *InviteAcceptHandler {
+	return &InviteAcceptHandler{sm, db, signer, policy, hasher, al}
+}
+
+type inviteAcceptRequestBody struct {
+	Token    string `json:"token"`
+	Password string `json:"password"`
+}
+
+// Handles "api/invites/accept" POST requests, creating a member from the invite with a token from "api/invites"
+// and logging them in. Should be wrapped with WithAPIHeaders
+func (iah *InviteAcceptHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var body inviteAcceptRequestBody
+	if err := util.DecodeJSONBody(w, r, &body); err != nil {
+		util.HandleJSONdecodeError(w, err)
+		return
+	}
+
+	// Check the token's signature and the invite before hashing the password, which is deliberately slow
+	inviteID, err := iah.signer.Check(body.Token, time.Now())
+	if err != nil {
+		util.ErrorJSON(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+	inv, err := iah.db.GetPendingInvite(inviteID, time.Now())
+	if err == sql.ErrNoRows {
+		util.ErrorJSON(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		log.Println(err)
+		util.ErrorJSON(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	account, err := iah.db.GetAccount(inv.AccountID)
+	if err != nil {
+		log.Println(err)
+		util.ErrorJSON(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	if !account.Active() {
+		util.ErrorJSON(w, accountSuspendedMessage, http.StatusForbidden)
+		return
+	}
+
+	if err := iah.policy.Check(body.Password, inv.Email); err != nil {
+		var pe auth.PolicyError
+		if errors.As(err, &pe) {
+			util.ErrorJSON(w, pe.Error(), http.StatusBadRequest)
+			return
+		}
+		log.Println(err)
+		util.ErrorJSON(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	passwordHash, err := iah.hasher.Hash(body.Password)
+	if err != nil {
+		log.Println(err)
+		util.ErrorJSON(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	member, err := iah.db.AcceptInvite(inv.InviteID, passwordHash, time.Now())
+	if err == sql.ErrNoRows {
+		// Accepted by a concurrent request, or revoked or expired while the password was being hashed
+		util.ErrorJSON(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+	if err == database.ErrEmailTaken {
+		util.ErrorJSON(w, err.Error(), http.StatusConflict)
+		return
+	}
+	if err != nil {
+		log.Println(err)
+		util.ErrorJSON(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	log.Printf("invite_id=%v accepted, created member_id=%v in account_id=%v", inv.InviteID, member.MemberID, member.AccountID)
+	iah.audit.Record(r, audit.Entry{AccountID: member.AccountID, Actor: member.Email, Action: model.AuditInviteAccept, Outcome: model.AuditSuccess,
+		Detail: "as " + string(member.Role)})
+
+	session, err := iah.sm.CreateSession(account, member)
+	if err != nil {
+		log.Println(err)
+		util.ErrorJSON(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	if err := json.NewEncoder(w).Encode(loginResponseBody{session.SessionID}); err != nil {
+		log.Println(err)
+		return
+	}
+}
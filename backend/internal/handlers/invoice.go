@@ -0,0 +1,143 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"github.com/ibeckermayer/teleport-interview/backend/internal/auth"
+	"github.com/ibeckermayer/teleport-interview/backend/internal/billing"
+	"github.com/ibeckermayer/teleport-interview/backend/internal/database"
+	"github.com/ibeckermayer/teleport-interview/backend/internal/model"
+	"github.com/ibeckermayer/teleport-interview/backend/internal/util"
+)
+
+// InvoicesHandler handles GET calls to "api/invoices"
+type InvoicesHandler struct {
+	sm *auth.SessionManager
+	db *database.Database
+}
+
+// NewInvoicesHandler creates a new InvoicesHandler
+func NewInvoicesHandler(sm *auth.SessionManager, db *database.Database) *InvoicesHandler {
+	return &InvoicesHandler{sm, db}
+}
+
+type invoiceSummary struct {
+	InvoiceID  string    `json:"invoiceID"`
+	Period     string    `json:"period"`
+	TotalCents int64     `json:"totalCents"`
+	CreatedAt  time.Time `json:"createdAt"`
+}
+
+type invoicesResponseBody struct {
+	Invoices []invoiceSummary `json:"invoices"`
+}
+
+// Handles "api/invoices" GET requests. Should be wrapped with WithSessionAuth and WithAPIHeaders
+func (ih *InvoicesHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	session, err := ih.sm.FromContext(r.Context())
+	if err != nil {
+		log.Println(err)
+		util.ErrorJSON(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	invoices, err := ih.db.GetInvoices(session.Account.AccountID)
+	if err != nil {
+		log.Println(err)
+		util.ErrorJSON(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	respBody := invoicesResponseBody{Invoices: make([]invoiceSummary, 0, len(invoices))}
+	for _, inv := range invoices {
+		respBody.Invoices = append(respBody.Invoices, invoiceSummary{inv.InvoiceID, inv.Period, inv.TotalCents, inv.CreatedAt})
+	}
+
+	if err := json.NewEncoder(w).Encode(respBody); err != nil {
+		log.Println(err)
+		return
+	}
+}
+
+// InvoiceHandler handles GET calls to "api/invoices/{invoiceID}"
+type InvoiceHandler struct {
+	sm *auth.SessionManager
+	db *database.Database
+}
+
+// NewInvoiceHandler creates a new InvoiceHandler
+func NewInvoiceHandler(sm *auth.SessionManager, db *database.Database) *InvoiceHandler {
+	return &InvoiceHandler{sm, db}
+}
+
+type invoiceLineItem struct {
+	Description    string     `json:"description"`
+	Plan           model.Plan `json:"plan"`
+	OnTrial        bool       `json:"onTrial"`
+	UserDays       int64      `json:"userDays"`
+	UnitPriceCents int64      `json:"unitPriceCents"`
+	AmountCents    int64      `json:"amountCents"`
+}
+
+type invoiceResponseBody struct {
+	invoiceSummary
+	LineItems []invoiceLineItem `json:"lineItems"`
+}
+
+// Handles "api/invoices/{invoiceID}" GET requests. Responds with JSON by default, or with a downloadable
+// CSV file if the "format" query parameter is "csv". Should be wrapped with WithSessionAuth and WithAPIHeaders
+func (ih *InvoiceHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	session, err := ih.sm.FromContext(r.Context())
+	if err != nil {
+		log.Println(err)
+		util.ErrorJSON(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	format := r.URL.Query().Get("format")
+	if format != "" && format != "json" && format != "csv" {
+		log.Printf("unsupported invoice format %q", format)
+		util.ErrorJSON(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+
+	inv, err := ih.db.GetInvoice(session.Account.AccountID, mux.Vars(r)["invoiceID"])
+	if err != nil {
+		log.Println(err)
+		if err == sql.ErrNoRows {
+			util.ErrorJSON(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+			return
+		}
+		util.ErrorJSON(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	if format == "csv" {
+		w.Header().Set("Content-Type", "text/csv")
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"invoice-%v.csv\"", inv.Period))
+		if err := billing.WriteCSV(w, inv); err != nil {
+			log.Println(err)
+		}
+		return
+	}
+
+	respBody := invoiceResponseBody{
+		invoiceSummary{inv.InvoiceID, inv.Period, inv.TotalCents, inv.CreatedAt},
+		make([]invoiceLineItem, 0, len(inv.LineItems)),
+	}
+	for _, item := range inv.LineItems {
+		respBody.LineItems = append(respBody.LineItems, invoiceLineItem{item.Description, item.Plan, item.OnTrial, item.UserDays, item.UnitPriceCents, item.AmountCents})
+	}
+
+	if err := json.NewEncoder(w).Encode(respBody); err != nil {
+		log.Println(err)
+		return
+	}
+}
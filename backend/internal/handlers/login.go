@@ -5,21 +5,142 @@ import (
 	"encoding/json"
 	"log"
 	"net/http"
+	"sync"
+	"time"
 
+	"github.com/ibeckermayer/teleport-interview/backend/internal/audit"
 	"github.com/ibeckermayer/teleport-interview/backend/internal/auth"
 	"github.com/ibeckermayer/teleport-interview/backend/internal/database"
+	"github.com/ibeckermayer/teleport-interview/backend/internal/model"
 	"github.com/ibeckermayer/teleport-interview/backend/internal/util"
 )
 
 // LoginHandler handles calls to "/api/login". Implements http.Handler
 type LoginHandler struct {
-	sm *auth.SessionManager
-	db *database.Database
+	sm       *auth.SessionManager
+	cs       *auth.ChallengeStore
+	db       *database.Database
+	throttle auth.LoginThrottleConfig
+	hasher   auth.PasswordHasher
+	audit    *audit.Log
+	mtx      sync.Mutex     // serializes checking and reserving login attempts
+	pending  map[string]int // the number of attempts in progress for each throttle key, guarded by mtx
 }
 
 // NewLoginHandler creates a new LoginHandler
-func NewLoginHandler(sm *auth.SessionManager, db *database.Database) *LoginHandler {
-	return &LoginHandler{sm, db}
+func NewLoginHandler(sm *auth.SessionManager, cs *auth.ChallengeStore, db *database.Database, throttle auth.LoginThrottleConfig, hasher auth.PasswordHasher, al *audit.Log) *LoginHandler {
+	return &LoginHandler{sm: sm, cs: cs, db: db, throttle: throttle, hasher: hasher, audit: al, pending: make(map[string]int)}
+}
+
+// loginAttempt is a login attempt reserved by beginAttempt. Until it ends it's counted as a failure
+// against its keys, so attempts made in parallel can't get past the delays and lockouts that the same
+// attempts made one after another would run into.
+type loginAttempt struct {
+	lh     *LoginHandler
+	keys   []string
+	failed bool
+}
+
+// beginAttempt returns the longest time the client must wait before attempting to log in as any of keys,
+// or if it may try now, reserves the attempt. The check and the reservation are made together under lh.mtx,
+// as of when it's acquired, so that failures recorded while waiting for it aren't in the future.
+// The attempt must be ended once it has succeeded or failed.
+func (lh *LoginHandler) beginAttempt(keys []string) (*loginAttempt, time.Duration, error) {
+	lh.mtx.Lock()
+	defer lh.mtx.Unlock()
+	now := time.Now()
+
+	var wait time.Duration
+	for _, key := range keys {
+		t, err := lh.db.GetLoginThrottle(key)
+		if err != nil {
+			return nil, 0, err
+		}
+		for i := 0; i < lh.pending[key]; i++ {
+			t = lh.throttle.RecordFailure(t, now)
+		}
+		if d := lh.throttle.RetryAfter(t, now); d > wait {
+			wait = d
+		}
+	}
+	if wait > 0 {
+		return nil, wait, nil
+	}
+	for _, key := range keys {
+		lh.pending[key]++
+	}
+	return &loginAttempt{lh: lh, keys: keys}, 0, nil
+}
+
+// beginReauth reserves an attempt by a logged in member to re-enter their password or a two-factor code to
+// confirm a change to their credentials. It counts against the same keys as logging in as them from the
+// client's IP, so that a stolen session can't be used to guess the password any faster than logging in.
+// If the client must wait, or the attempt can't be reserved, it responds and returns nil.
+func (lh *LoginHandler) beginReauth(w http.ResponseWriter, r *http.Request, member model.Member) *loginAttempt {
+	throttleKeys := []string{auth.EmailThrottleKey(member.Email), auth.IPThrottleKey(util.ClientIP(r))}
+	attempt, wait, err := lh.beginAttempt(throttleKeys)
+	if err != nil {
+		log.Println(err)
+		util.ErrorJSON(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return nil
+	}
+	if wait > 0 {
+		log.Printf("throttled password check for %v", throttleKeys)
+		util.TooManyRequests(w, wait)
+		return nil
+	}
+	return attempt
+}
+
+// fail marks the attempt as failed, to be recorded against its keys when it ends
+func (a *loginAttempt) fail() {
+	a.failed = true
+}
+
+// end releases the attempt's reservation, recording a failure against each of its keys if it failed
+func (a *loginAttempt) end() {
+	lh := a.lh
+	lh.mtx.Lock()
+	defer lh.mtx.Unlock()
+	now := time.Now()
+	for _, key := range a.keys {
+		if lh.pending[key]--; lh.pending[key] <= 0 {
+			delete(lh.pending, key)
+		}
+		if !a.failed {
+			continue
+		}
+		wasLocked := false
+		t, err := lh.db.UpdateLoginThrottle(key, func(t model.LoginThrottle) model.LoginThrottle {
+			wasLocked = t.LockedUntil != nil && now.Before(*t.LockedUntil)
+			return lh.throttle.RecordFailure(t, now)
+		})
+		if err != nil {
+			log.Printf("failed to record failed login for %v: %v", key, err)
+			continue
+		}
+		if !wasLocked && t.LockedUntil != nil {
+			log.Printf("login locked out for %v until %v", key, *t.LockedUntil)
+		}
+	}
+}
+
+// rehashPassword replaces member's password hash with one made with the current hashing algorithm and
+// parameters. Only called once password has been checked against the old hash.
+func (lh *LoginHandler) rehashPassword(member model.Member, password string) {
+	newHash, err := lh.hasher.Hash(password)
+	if err != nil {
+		log.Printf("failed to rehash password for member_id=%v: %v", member.MemberID, err)
+		return
+	}
+	rehashed, err := lh.db.RehashPassword(member.MemberID, member.PasswordHash, newHash)
+	if err != nil {
+		log.Printf("failed to rehash password for member_id=%v: %v", member.MemberID, err)
+		return
+	}
+	if rehashed {
+		log.Printf("rehashed password for member_id=%v", member.MemberID)
+	}
 }
 
 type loginRequestBody struct {
@@ -27,10 +148,20 @@ type loginRequestBody struct {
 	Password string `json:"password"`
 }
 
+// accountSuspendedMessage is the error message for logins to accounts that are suspended or pending deletion
+const accountSuspendedMessage = "this account has been suspended, contact support"
+
 type loginResponseBody struct {
 	SessionID auth.SessionID `json:"sessionID"`
 }
 
+// loginChallengeResponseBody is returned instead of a loginResponseBody when the member has two-factor
+// authentication enabled. The challenge must be completed at "api/login/2fa" to get a sessionID.
+type loginChallengeResponseBody struct {
+	ChallengeID auth.ChallengeID `json:"challengeID"`
+	Expires     time.Time        `json:"expires"`
+}
+
 // Handles user login
 func (lh *LoginHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	var body loginRequestBody
@@ -41,13 +172,32 @@ func (lh *LoginHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	account, err := lh.db.GetAccountByEmail(body.Email)
+	// Refuse to check the password at all if this email or IP has failed too often recently
+	emailKey := auth.EmailThrottleKey(body.Email)
+	throttleKeys := []string{emailKey, auth.IPThrottleKey(util.ClientIP(r))}
+	attempt, wait, err := lh.beginAttempt(throttleKeys)
+	if err != nil {
+		log.Println(err)
+		util.ErrorJSON(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	if wait > 0 {
+		log.Printf("throttled login attempt for %v", throttleKeys)
+		lh.audit.Record(r, audit.Entry{Actor: body.Email, Action: model.AuditLogin, Outcome: model.AuditFailure, Detail: "throttled"})
+		util.TooManyRequests(w, wait)
+		return
+	}
+	defer attempt.end()
+
+	member, err := lh.db.GetMemberByEmail(body.Email)
 
-	// Handle errors from attempting to retrieve the account from the database
+	// Handle errors from attempting to retrieve the member from the database
 	if err != nil {
 		log.Println(err)
 		if err == sql.ErrNoRows {
 			// No record with the given email address exists
+			attempt.fail()
+			lh.audit.Record(r, audit.Entry{Actor: body.Email, Action: model.AuditLogin, Outcome: model.AuditFailure, Detail: "unknown email"})
 			util.ErrorJSON(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
 			return
 		}
@@ -55,15 +205,85 @@ func (lh *LoginHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Account retrieved, check password
-	if !auth.CheckPasswordHash(body.Password, account.PasswordHash) {
+	// Member retrieved, check password
+	if !auth.CheckPasswordHash(body.Password, member.PasswordHash) {
 		// Invalid password, unauthorized
+		attempt.fail()
+		lh.audit.Record(r, audit.Entry{AccountID: member.AccountID, Actor: body.Email, Action: model.AuditLogin, Outcome: model.AuditFailure, Detail: "wrong password"})
 		util.ErrorJSON(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
 		return
 	}
 
-	// Valid password, create new session
-	session, err := lh.sm.CreateSession(account)
+	// Valid password. This is the only time it's known in plaintext, so upgrade its hash now if it was made
+	// with outdated parameters. Hashing is deliberately slow, so it's done without holding up the login.
+	if lh.hasher.NeedsRehash(member.PasswordHash) {
+		go lh.rehashPassword(member, body.Password)
+	}
+
+	lh.continueLogin(w, r, member, emailKey, "password")
+}
+
+// continueLogin responds with a login challenge if member has two-factor authentication enabled,
+// otherwise it completes the login. Called once the member's password (or IdP login) has been checked,
+// method says which for the audit log.
+func (lh *LoginHandler) continueLogin(w http.ResponseWriter, r *http.Request, member model.Member, emailKey, method string) {
+	account, err := lh.db.GetAccount(member.AccountID)
+	if err != nil {
+		log.Println(err)
+		util.ErrorJSON(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	if !account.Active() {
+		lh.audit.Record(r, audit.Entry{AccountID: member.AccountID, Actor: member.Email, Action: model.AuditLogin, Outcome: model.AuditFailure, Detail: method + ", account " + string(account.Status)})
+		util.ErrorJSON(w, accountSuspendedMessage, http.StatusForbidden)
+		return
+	}
+
+	totp, err := lh.db.GetTOTP(member.MemberID)
+	if err != nil && err != sql.ErrNoRows {
+		log.Println(err)
+		util.ErrorJSON(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	if err == nil && totp.Enabled {
+		// Failed attempts aren't forgotten until the challenge is completed, so that the password alone
+		// can't be used to keep guessing codes
+		challenge, err := lh.cs.CreateChallenge(member)
+		if err != nil {
+			log.Println(err)
+			util.ErrorJSON(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+		lh.audit.Record(r, audit.Entry{AccountID: member.AccountID, Actor: member.Email, Action: model.AuditLogin, Outcome: model.AuditSuccess, Detail: method + ", second factor required"})
+		if err := json.NewEncoder(w).Encode(loginChallengeResponseBody{challenge.ChallengeID, challenge.Expires}); err != nil {
+			log.Println(err)
+		}
+		return
+	}
+
+	lh.audit.Record(r, audit.Entry{AccountID: member.AccountID, Actor: member.Email, Action: model.AuditLogin, Outcome: model.AuditSuccess, Detail: method})
+	lh.completeLogin(w, member, emailKey)
+}
+
+// completeLogin forgets the failed attempts for emailKey and creates a new session for member
+func (lh *LoginHandler) completeLogin(w http.ResponseWriter, member model.Member, emailKey string) {
+	if _, err := lh.db.DeleteLoginThrottle(emailKey); err != nil {
+		log.Println(err)
+	}
+
+	account, err := lh.db.GetAccount(member.AccountID)
+	if err != nil {
+		log.Println(err)
+		util.ErrorJSON(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	if !account.Active() {
+		// Suspended since continueLogin, while the member was entering their second factor
+		util.ErrorJSON(w, accountSuspendedMessage, http.StatusForbidden)
+		return
+	}
+
+	session, err := lh.sm.CreateSession(account, member)
 	if err != nil {
 		log.Println(err)
 		util.ErrorJSON(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
@@ -75,3 +295,97 @@ func (lh *LoginHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 }
+
+// LoginChallengeHandler handles calls to "/api/login/2fa", the second step of logging in as a member
+// with two-factor authentication enabled. It shares its LoginHandler's failed attempt tracking.
+type LoginChallengeHandler struct {
+	lh *LoginHandler
+}
+
+// NewLoginChallengeHandler creates a new LoginChallengeHandler
+func NewLoginChallengeHandler(lh *LoginHandler) *LoginChallengeHandler {
+	return &LoginChallengeHandler{lh}
+}
+
+type loginChallengeRequestBody struct {
+	ChallengeID auth.ChallengeID `json:"challengeID"`
+	Code        string           `json:"code"` // a TOTP code or recovery code
+}
+
+// Handles completing a login challenge
+func (lch *LoginChallengeHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	lh := lch.lh
+	var body loginChallengeRequestBody
+
+	err := util.DecodeJSONBody(w, r, &body)
+	if err != nil {
+		util.HandleJSONdecodeError(w, err)
+		return
+	}
+
+	challenge, err := lh.cs.GetChallenge(body.ChallengeID)
+	if err != nil {
+		// Expired, already used or discarded after too many wrong codes; the user has to start over
+		log.Println(err)
+		util.ErrorJSON(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return
+	}
+
+	// Wrong codes count towards the same limits as wrong passwords
+	emailKey := auth.EmailThrottleKey(challenge.Member.Email)
+	throttleKeys := []string{emailKey, auth.IPThrottleKey(util.ClientIP(r))}
+	attempt, wait, err := lh.beginAttempt(throttleKeys)
+	if err != nil {
+		log.Println(err)
+		util.ErrorJSON(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	if wait > 0 {
+		log.Printf("throttled login attempt for %v", throttleKeys)
+		lh.audit.Record(r, audit.Entry{AccountID: challenge.Member.AccountID, Actor: challenge.Member.Email, Action: model.AuditLoginSecondFactor, Outcome: model.AuditFailure, Detail: "throttled"})
+		util.TooManyRequests(w, wait)
+		return
+	}
+	defer attempt.end()
+
+	totp, err := lh.db.GetTOTP(challenge.Member.MemberID)
+	if err != nil && err != sql.ErrNoRows {
+		log.Println(err)
+		util.ErrorJSON(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	ok := false
+	if err == nil && totp.Enabled {
+		ok, err = checkSecondFactor(lh.db, totp, body.Code, time.Now())
+		if err != nil {
+			log.Println(err)
+			util.ErrorJSON(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+	}
+	if !ok {
+		lh.cs.FailChallenge(challenge.ChallengeID)
+		attempt.fail()
+		lh.audit.Record(r, audit.Entry{AccountID: challenge.Member.AccountID, Actor: challenge.Member.Email, Action: model.AuditLoginSecondFactor, Outcome: model.AuditFailure, Detail: "wrong code"})
+		util.ErrorJSON(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return
+	}
+
+	if !lh.cs.CompleteChallenge(challenge.ChallengeID) {
+		// Completed by a concurrent request
+		util.ErrorJSON(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return
+	}
+
+	// The member (their role, say) may have changed since the password was checked
+	member, err := lh.db.GetMember(challenge.Member.MemberID)
+	if err != nil {
+		log.Println(err)
+		util.ErrorJSON(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	lh.audit.Record(r, audit.Entry{AccountID: member.AccountID, Actor: member.Email, Action: model.AuditLoginSecondFactor, Outcome: model.AuditSuccess})
+	lh.completeLogin(w, member, emailKey)
+}
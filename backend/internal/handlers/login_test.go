@@ -0,0 +1,84 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ibeckermayer/teleport-interview/backend/internal/audit"
+	"github.com/ibeckermayer/teleport-interview/backend/internal/auth"
+	"github.com/ibeckermayer/teleport-interview/backend/internal/database"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+func TestParallelLoginsAreThrottled(t *testing.T) {
+	db, err := database.New(database.Config{Env: "test", File: filepath.Join(t.TempDir(), "test.db"), PasswordHasher: auth.PasswordHasher{BcryptCost: 4}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.CreateAccount("acct", "owner@example.com", "correct horse battery staple"); err != nil {
+		t.Fatal(err)
+	}
+	al := audit.NewLog(db)
+	lh := NewLoginHandler(auth.NewSessionManager(time.Hour, al), auth.NewChallengeStore(time.Minute), db, auth.LoginThrottleConfig{
+		FreeFailures:          3,
+		BaseDelay:             time.Minute,
+		MaxDelay:              time.Hour,
+		EmailLockoutThreshold: 10,
+		IPLockoutThreshold:    100,
+		LockoutDuration:       time.Hour,
+	}, auth.PasswordHasher{BcryptCost: 4}, al)
+	login := func(password string) int {
+		req := httptest.NewRequest("POST", "/api/login", strings.NewReader(`{"email": "owner@example.com", "password": "`+password+`"}`))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		lh.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	// Every attempt is made before any has finished, but no more than the free failures plus the one
+	// that starts the delay may check the password
+	const attempts = 20
+	statuses := make(chan int, attempts)
+	wg := sync.WaitGroup{}
+	for i := 0; i < attempts; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			statuses <- login("wrong")
+		}()
+	}
+	wg.Wait()
+	close(statuses)
+	checked := 0
+	for status := range statuses {
+		switch status {
+		case http.StatusUnauthorized:
+			checked++
+		case http.StatusTooManyRequests:
+		default:
+			t.Fatalf("unexpected status %v", status)
+		}
+	}
+	if checked > 4 {
+		t.Fatalf("expected at most 4 passwords to be checked but %v were", checked)
+	}
+
+	if status := login("correct horse battery staple"); status != http.StatusTooManyRequests {
+		t.Fatalf("expected the correct password to be throttled too but got %v", status)
+	}
+
+	// An operator unlocks the email address and the IP
+	for _, emailOrIP := range []string{"owner@example.com", "192.0.2.1"} {
+		if unlocked, err := db.UnlockLogin(emailOrIP); err != nil || !unlocked {
+			t.Fatalf("expected %v to be unlocked but got %v, %v", emailOrIP, unlocked, err)
+		}
+	}
+	if status := login("correct horse battery staple"); status != http.StatusOK {
+		t.Fatalf("expected the login to succeed once unlocked but got %v", status)
+	}
+}
@@ -4,18 +4,21 @@ import (
 	"log"
 	"net/http"
 
+	"github.com/ibeckermayer/teleport-interview/backend/internal/audit"
 	"github.com/ibeckermayer/teleport-interview/backend/internal/auth"
+	"github.com/ibeckermayer/teleport-interview/backend/internal/model"
 	"github.com/ibeckermayer/teleport-interview/backend/internal/util"
 )
 
 // LogoutHandler handles calls to "/api/logout". Implements HandlerWithSession
 type LogoutHandler struct {
-	sm *auth.SessionManager
+	sm    *auth.SessionManager
+	audit *audit.Log
 }
 
 // NewLogoutHandler creates a new LogoutHandler
-func NewLogoutHandler(sm *auth.SessionManager) *LogoutHandler {
-	return &LogoutHandler{sm}
+func NewLogoutHandler(sm *auth.SessionManager, al *audit.Log) *LogoutHandler {
+	return &LogoutHandler{sm, al}
 }
 
 func (lh *LogoutHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
@@ -30,6 +33,7 @@ func (lh *LogoutHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	if !lh.sm.DeleteSession(session.SessionID) {
 		log.Println("logout attempted but could not find session")
 	}
+	lh.audit.Record(r, audit.Entry{AccountID: session.Account.AccountID, Actor: session.Actor(), Action: model.AuditLogout, Outcome: model.AuditSuccess})
 
 	w.WriteHeader(http.StatusNoContent)
 }
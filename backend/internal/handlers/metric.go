@@ -2,10 +2,14 @@ package handlers
 
 import (
 	"encoding/json"
+	"fmt"
 	"log"
+	"net"
 	"net/http"
+	"strconv"
 	"time"
 
+	"github.com/ibeckermayer/teleport-interview/backend/internal/alert"
 	"github.com/ibeckermayer/teleport-interview/backend/internal/auth"
 	"github.com/ibeckermayer/teleport-interview/backend/internal/database"
 	"github.com/ibeckermayer/teleport-interview/backend/internal/model"
@@ -14,21 +18,65 @@ import (
 
 // MetricsPostHandler handles POST calls to "api/metrics"
 type MetricsPostHandler struct {
-	db *database.Database
+	db     *database.Database
+	alerts *alert.Alerter
 }
 
 // NewMetricsPostHandler creates a new MetricsPostHandler
-func NewMetricsPostHandler(db *database.Database) *MetricsPostHandler {
-	return &MetricsPostHandler{db}
+func NewMetricsPostHandler(db *database.Database, alerts *alert.Alerter) *MetricsPostHandler {
+	return &MetricsPostHandler{db, alerts}
 }
 
 type metricsPostRequestBody struct {
-	AccountID string    `json:"account_id"`
-	UserID    string    `json:"user_id"`
-	Timestamp time.Time `json:"timestamp"`
+	AccountID  string                 `json:"account_id"`
+	UserID     string                 `json:"user_id"`
+	Timestamp  time.Time              `json:"timestamp"`
+	EventType  model.MetricEventType  `json:"event_type"` // defaults to "login"
+	DeviceID   string                 `json:"device_id"`
+	IP         string                 `json:"ip"`
+	Attributes map[string]interface{} `json:"attributes"`
 }
 
-// Handles "/api/metrics" POST requests. Should be wrapped with WithAPIkeyAuth middlewear
+// maxDeviceIDLength is the longest device_id a metric can have
+const maxDeviceIDLength = 64
+
+// metric validates body and converts it to the model.Metric to save. The error is safe to return to the client.
+func (body metricsPostRequestBody) metric() (model.Metric, error) {
+	if body.EventType == "" {
+		body.EventType = model.MetricLogin
+	}
+	if err := body.EventType.ValidateAttributes(body.Attributes); err != nil {
+		return model.Metric{}, err
+	}
+	if len(body.DeviceID) > maxDeviceIDLength {
+		return model.Metric{}, fmt.Errorf("device_id is longer than %v characters", maxDeviceIDLength)
+	}
+	if body.IP != "" {
+		ip := net.ParseIP(body.IP)
+		if ip == nil {
+			return model.Metric{}, fmt.Errorf("ip %q isn't an IP address", body.IP)
+		}
+		body.IP = ip.String()
+	}
+	attributes := []byte("{}")
+	if len(body.Attributes) > 0 {
+		var err error
+		if attributes, err = json.Marshal(body.Attributes); err != nil {
+			return model.Metric{}, err
+		}
+	}
+	return model.Metric{
+		AccountID:  body.AccountID,
+		UserID:     body.UserID,
+		Timestamp:  body.Timestamp,
+		EventType:  body.EventType,
+		DeviceID:   body.DeviceID,
+		IP:         body.IP,
+		Attributes: string(attributes),
+	}, nil
+}
+
+// Handles "/api/metrics" POST requests. Should be wrapped with WithDeviceAuth middlewear
 func (mph *MetricsPostHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	var body metricsPostRequestBody
 
@@ -38,26 +86,52 @@ func (mph *MetricsPostHandler) ServeHTTP(w http.ResponseWriter, r *http.Request)
 		util.HandleJSONdecodeError(w, err)
 		return
 	}
+	metric, err := body.metric()
+	if err != nil {
+		util.ErrorJSON(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 
 	// Save the metric to the database
 	// TODO: should metric be saved regardless of whether CreateUser below fails?
-	if err := mph.db.CreateMetric(body.AccountID, body.UserID, body.Timestamp); err != nil {
+	if err := mph.db.CreateMetric(metric, time.Now()); err != nil {
 		log.Println(err)
 		util.ErrorJSON(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
 		return
 	}
 
+	// A failed login doesn't mean the user exists, so it mustn't take a seat
+	if !metric.EventType.Seen() {
+		return
+	}
+
 	// Check if this user exists
 	_, err = mph.db.GetUser(body.UserID)
 	if err != nil {
 		// If user DNE, save new user to the database
-		if err := mph.db.CreateUser(body.UserID, body.AccountID); err != nil {
+		user, plan, totalUsers, err := mph.db.CreateUser(body.UserID, body.AccountID)
+		if err != nil {
 			log.Println(err)
 			util.ErrorJSON(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+
+		createEvent(mph.db, body.AccountID, model.EventUserCreated, userEventData{user.UserID, user.IsActive, plan, totalUsers})
+		if totalUsers == model.PlanMaxUsers[plan] {
+			createEvent(mph.db, body.AccountID, model.EventUserLimitReached, userEventData{user.UserID, user.IsActive, plan, totalUsers})
 		}
+		mph.alerts.CheckAsync(body.AccountID)
 	}
 }
 
+// userEventData is the data sent with user.created and user.limit_reached events
+type userEventData struct {
+	UserID     string     `json:"user_id"`
+	IsActive   bool       `json:"is_active"`
+	Plan       model.Plan `json:"plan"`
+	TotalUsers int        `json:"total_users"`
+}
+
 // MetricsGetHandler handles GET calls to "api/metrics"
 type MetricsGetHandler struct {
 	sm *auth.SessionManager
@@ -70,9 +144,44 @@ func NewMetricsGetHandler(sm *auth.SessionManager, db *database.Database) *Metri
 }
 
 type metricsGetResponseBody struct {
-	Plan       model.Plan `json:"plan"`
-	MaxUsers   int        `json:"maxUsers"`
-	TotalUsers int        `json:"totalUsers"`
+	Plan        model.Plan `json:"plan"`
+	MaxUsers    int        `json:"maxUsers"`
+	TotalUsers  int        `json:"totalUsers"`
+	TrialEndsAt *time.Time `json:"trialEndsAt"` // null unless the account is on a trial
+}
+
+// parseMetricFilter parses the filter from an "api/metrics/summary" or "api/metrics/events" request's query string
+func parseMetricFilter(r *http.Request) (database.MetricFilter, bool) {
+	q := r.URL.Query()
+	filter := database.MetricFilter{
+		EventType: model.MetricEventType(q.Get("event_type")),
+		UserID:    q.Get("user_id"),
+		DeviceID:  q.Get("device_id"),
+		Before:    q.Get("before"),
+	}
+	if filter.EventType != "" && !filter.EventType.Valid() {
+		return filter, false
+	}
+	if v := q.Get("ip"); v != "" {
+		ip := net.ParseIP(v)
+		if ip == nil {
+			return filter, false
+		}
+		filter.IP = ip.String()
+	}
+	for _, p := range []struct {
+		name string
+		dst  **time.Time
+	}{{"since", &filter.Since}, {"until", &filter.Until}} {
+		if v := q.Get(p.name); v != "" {
+			t, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				return filter, false
+			}
+			*p.dst = &t
+		}
+	}
+	return filter, true
 }
 
 // Handles "api/metrics" GET requests. Should be wrapped with WithSessionAuth and WithAPIHeaders
@@ -95,6 +204,139 @@ func (mgh *MetricsGetHandler) ServeHTTP(w http.ResponseWriter, r *http.Request)
 		session.Account.Plan,
 		model.PlanMaxUsers[session.Account.Plan],
 		totalUsers,
+		session.Account.TrialEndsAt,
+	}
+
+	if err := json.NewEncoder(w).Encode(respBody); err != nil {
+		log.Println(err)
+		return
+	}
+}
+
+// MetricsSummaryGetHandler handles GET calls to "api/metrics/summary"
+type MetricsSummaryGetHandler struct {
+	sm *auth.SessionManager
+	db *database.Database
+}
+
+// NewMetricsSummaryGetHandler creates a new MetricsSummaryGetHandler
+func NewMetricsSummaryGetHandler(sm *auth.SessionManager, db *database.Database) *MetricsSummaryGetHandler {
+	return &MetricsSummaryGetHandler{sm, db}
+}
+
+type metricsSummaryGetResponseBody struct {
+	Events map[model.MetricEventType]int `json:"events"` // the number of metrics of each event type matching the query
+	Users  int                           `json:"users"`  // the number of distinct users those metrics were sent for
+}
+
+// Handles "api/metrics/summary" GET requests, returning how many of the account's metrics there are of each event
+// type, optionally filtered by "event_type", "user_id", "device_id", "ip", "since" and "until" (RFC 3339 times).
+// Counting scans the account's metrics, so this is kept apart from "api/metrics", which the dashboard polls.
+// Should be wrapped with WithSessionAuth and WithAPIHeaders
+func (msgh *MetricsSummaryGetHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	session, err := msgh.sm.FromContext(r.Context())
+	if err != nil {
+		log.Println(err)
+		util.ErrorJSON(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	filter, ok := parseMetricFilter(r)
+	if !ok {
+		util.ErrorJSON(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+	counts, err := msgh.db.CountMetrics(session.Account.AccountID, filter)
+	if err != nil {
+		log.Println(err)
+		util.ErrorJSON(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	if err := json.NewEncoder(w).Encode(metricsSummaryGetResponseBody{counts.Events, counts.Users}); err != nil {
+		log.Println(err)
+		return
+	}
+}
+
+type metricJSON struct {
+	MetricID   string                `json:"metricID"`
+	UserID     string                `json:"userID"`
+	Timestamp  time.Time             `json:"timestamp"`
+	EventType  model.MetricEventType `json:"eventType"`
+	DeviceID   string                `json:"deviceID"`
+	IP         string                `json:"ip"`
+	Attributes json.RawMessage       `json:"attributes"`
+}
+
+func newMetricJSON(m model.Metric) metricJSON {
+	return metricJSON{m.MetricID, m.UserID, m.Timestamp, m.EventType, m.DeviceID, m.IP, json.RawMessage(m.Attributes)}
+}
+
+const (
+	// defaultMetricPageSize and maxMetricPageSize are the default and largest number of metrics MetricEventsGetHandler returns at once
+	defaultMetricPageSize = 50
+	maxMetricPageSize     = 200
+)
+
+// MetricEventsGetHandler handles GET calls to "api/metrics/events"
+type MetricEventsGetHandler struct {
+	sm *auth.SessionManager
+	db *database.Database
+}
+
+// NewMetricEventsGetHandler creates a new MetricEventsGetHandler
+func NewMetricEventsGetHandler(sm *auth.SessionManager, db *database.Database) *MetricEventsGetHandler {
+	return &MetricEventsGetHandler{sm, db}
+}
+
+type metricEventsGetResponseBody struct {
+	Events     []metricJSON `json:"events"`
+	NextBefore *string      `json:"nextBefore"` // pass as "before" to get the next page, null on the last page
+}
+
+// Handles "api/metrics/events" GET requests, returning the account's metrics most recently timestamped first,
+// filtered like "api/metrics/summary". Pages hold up to "limit" metrics (default 50, at most 200); the next page is
+// fetched with "before" set to the previous page's nextBefore. Should be wrapped with WithSessionAuth and WithAPIHeaders
+func (megh *MetricEventsGetHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	session, err := megh.sm.FromContext(r.Context())
+	if err != nil {
+		log.Println(err)
+		util.ErrorJSON(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	filter, ok := parseMetricFilter(r)
+	if !ok {
+		util.ErrorJSON(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+	limit := defaultMetricPageSize
+	if v := r.URL.Query().Get("limit"); v != "" {
+		l, err := strconv.Atoi(v)
+		if err != nil || l < 1 || l > maxMetricPageSize {
+			util.ErrorJSON(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+			return
+		}
+		limit = l
+	}
+
+	// Fetch one more than asked for to know whether there's another page
+	metrics, err := megh.db.GetMetrics(session.Account.AccountID, filter, limit+1)
+	if err != nil {
+		log.Println(err)
+		util.ErrorJSON(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	respBody := metricEventsGetResponseBody{Events: make([]metricJSON, 0, limit)}
+	if len(metrics) > limit {
+		metrics = metrics[:limit]
+		next := metrics[limit-1].MetricID
+		respBody.NextBefore = &next
+	}
+	for _, m := range metrics {
+		respBody.Events = append(respBody.Events, newMetricJSON(m))
 	}
 
 	if err := json.NewEncoder(w).Encode(respBody); err != nil {
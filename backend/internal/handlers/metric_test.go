@@ -0,0 +1,216 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ibeckermayer/teleport-interview/backend/internal/alert"
+	"github.com/ibeckermayer/teleport-interview/backend/internal/auth"
+	"github.com/ibeckermayer/teleport-interview/backend/internal/database"
+	"github.com/ibeckermayer/teleport-interview/backend/internal/model"
+	"github.com/ibeckermayer/teleport-interview/backend/internal/notify"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// newTestAccount creates an empty database in a temporary directory with one FREE account, and returns them
+func newTestAccount(t *testing.T) (*database.Database, string) {
+	t.Helper()
+	db, err := database.New(database.Config{Env: "test", File: filepath.Join(t.TempDir(), "test.db"), PasswordHasher: auth.PasswordHasher{BcryptCost: 4}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.CreateAccount("acct", "owner@example.com", "correct horse battery staple"); err != nil {
+		t.Fatal(err)
+	}
+	return db, "acct"
+}
+
+// postMetric sends body to a MetricsPostHandler for db, returning the response status
+func postMetric(t *testing.T, db *database.Database, body string) int {
+	t.Helper()
+	h := NewMetricsPostHandler(db, alert.NewAlerter(db, notify.NewLogNotifier()))
+	req := httptest.NewRequest("POST", "/api/metrics", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	return w.Code
+}
+
+func TestMetricsPost(t *testing.T) {
+	tests := []struct {
+		name       string
+		body       string
+		wantStatus int
+		want       *model.Metric // the metric saved, ignoring its ID and timestamp, if any
+		wantUser   bool
+	}{
+		{
+			name:       "login",
+			body:       `{"account_id":"acct","user_id":"u","timestamp":"2020-01-01T00:00:00Z","event_type":"login","attributes":{"mfa":true}}`,
+			wantStatus: http.StatusOK,
+			want:       &model.Metric{UserID: "u", EventType: model.MetricLogin, Attributes: `{"mfa":true}`},
+			wantUser:   true,
+		},
+		{
+			name:       "metrics without an event type are logins",
+			body:       `{"account_id":"acct","user_id":"u","timestamp":"2020-01-01T00:00:00Z"}`,
+			wantStatus: http.StatusOK,
+			want:       &model.Metric{UserID: "u", EventType: model.MetricLogin, Attributes: "{}"},
+			wantUser:   true,
+		},
+		{
+			name:       "failed logins don't create users",
+			body:       `{"account_id":"acct","user_id":"u","timestamp":"2020-01-01T00:00:00Z","event_type":"login_failure","attributes":{"reason":"bad password"}}`,
+			wantStatus: http.StatusOK,
+			want:       &model.Metric{UserID: "u", EventType: model.MetricLoginFailure, Attributes: `{"reason":"bad password"}`},
+		},
+		{
+			name:       "IP addresses are stored in canonical form",
+			body:       `{"account_id":"acct","user_id":"u","timestamp":"2020-01-01T00:00:00Z","device_id":"laptop","ip":"2001:DB8:0:0::1"}`,
+			wantStatus: http.StatusOK,
+			want:       &model.Metric{UserID: "u", EventType: model.MetricLogin, DeviceID: "laptop", IP: "2001:db8::1", Attributes: "{}"},
+			wantUser:   true,
+		},
+		{
+			name:       "unknown event type",
+			body:       `{"account_id":"acct","user_id":"u","timestamp":"2020-01-01T00:00:00Z","event_type":"signup"}`,
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "missing required attribute",
+			body:       `{"account_id":"acct","user_id":"u","timestamp":"2020-01-01T00:00:00Z","event_type":"login_failure"}`,
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "attribute of the wrong kind",
+			body:       `{"account_id":"acct","user_id":"u","timestamp":"2020-01-01T00:00:00Z","attributes":{"mfa":"yes"}}`,
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "attribute that isn't a scalar",
+			body:       `{"account_id":"acct","user_id":"u","timestamp":"2020-01-01T00:00:00Z","attributes":{"tags":["a"]}}`,
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "device ID too long",
+			body:       `{"account_id":"acct","user_id":"u","timestamp":"2020-01-01T00:00:00Z","device_id":"` + strings.Repeat("d", maxDeviceIDLength+1) + `"}`,
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "invalid IP address",
+			body:       `{"account_id":"acct","user_id":"u","timestamp":"2020-01-01T00:00:00Z","ip":"10.0.0"}`,
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "unknown field",
+			body:       `{"account_id":"acct","user_id":"u","timestamp":"2020-01-01T00:00:00Z","user":"u"}`,
+			wantStatus: http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, accountID := newTestAccount(t)
+			if status := postMetric(t, db, tt.body); status != tt.wantStatus {
+				t.Fatalf("got status %v, want %v", status, tt.wantStatus)
+			}
+
+			metrics, err := db.GetMetrics(accountID, database.MetricFilter{}, 10)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.want == nil {
+				if len(metrics) != 0 {
+					t.Errorf("expected no metric to be saved but got %+v", metrics)
+				}
+			} else {
+				if len(metrics) != 1 {
+					t.Fatalf("expected 1 metric to be saved but got %+v", metrics)
+				}
+				got := metrics[0]
+				if got.UserID != tt.want.UserID || got.EventType != tt.want.EventType || got.DeviceID != tt.want.DeviceID ||
+					got.IP != tt.want.IP || got.Attributes != tt.want.Attributes {
+					t.Errorf("got metric %+v, want %+v", got, *tt.want)
+				}
+			}
+
+			if _, err := db.GetUser("u"); (err == nil) != tt.wantUser {
+				t.Errorf("got user error %v, want user created %v", err, tt.wantUser)
+			}
+		})
+	}
+}
+
+func TestMetricsPostFillsSeats(t *testing.T) {
+	old := model.PlanMaxUsers
+	model.PlanMaxUsers = map[model.Plan]int{model.FREE: 2, model.ENTERPRISE: 4}
+	t.Cleanup(func() { model.PlanMaxUsers = old })
+	db, accountID := newTestAccount(t)
+
+	for _, userID := range []string{"u0", "u1", "u2", "u0"} {
+		body := `{"account_id":"` + accountID + `","user_id":"` + userID + `","timestamp":"2020-01-01T00:00:00Z"}`
+		if status := postMetric(t, db, body); status != http.StatusOK {
+			t.Fatalf("got status %v posting a metric for %v", status, userID)
+		}
+	}
+
+	for _, tt := range []struct {
+		userID     string
+		wantActive bool
+	}{
+		{"u0", true},
+		{"u1", true},
+		{"u2", false},
+	} {
+		u, err := db.GetUser(tt.userID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if u.IsActive != tt.wantActive {
+			t.Errorf("got %v active %v, want %v", tt.userID, u.IsActive, tt.wantActive)
+		}
+	}
+	if n, err := db.CountUsers(accountID); err != nil || n != 3 {
+		t.Errorf("expected 3 users but got %v, %v", n, err)
+	}
+}
+
+func TestParseMetricFilter(t *testing.T) {
+	since := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		query  string
+		want   database.MetricFilter
+		wantOK bool
+	}{
+		{query: "", want: database.MetricFilter{}, wantOK: true},
+		{query: "event_type=logout&user_id=u&device_id=d&before=m", want: database.MetricFilter{EventType: model.MetricLogout, UserID: "u", DeviceID: "d", Before: "m"}, wantOK: true},
+		{query: "ip=2001:DB8::1", want: database.MetricFilter{IP: "2001:db8::1"}, wantOK: true},
+		{query: "since=2020-01-01T00:00:00Z", want: database.MetricFilter{Since: &since}, wantOK: true},
+		{query: "event_type=signup"},
+		{query: "ip=10.0.0"},
+		{query: "since=yesterday"},
+		{query: "until=2020-01-01"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/api/metrics/events?"+tt.query, nil)
+			got, ok := parseMetricFilter(r)
+			if ok != tt.wantOK {
+				t.Fatalf("got ok %v, want %v", ok, tt.wantOK)
+			}
+			if !ok {
+				return
+			}
+			if got.EventType != tt.want.EventType || got.UserID != tt.want.UserID || got.DeviceID != tt.want.DeviceID ||
+				got.IP != tt.want.IP || got.Before != tt.want.Before || got.Until != nil ||
+				(got.Since == nil) != (tt.want.Since == nil) || (got.Since != nil && !got.Since.Equal(*tt.want.Since)) {
+				t.Errorf("got filter %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
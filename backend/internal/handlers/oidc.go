@@ -0,0 +1,125 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/ibeckermayer/teleport-interview/backend/internal/audit"
+	"github.com/ibeckermayer/teleport-interview/backend/internal/auth"
+	"github.com/ibeckermayer/teleport-interview/backend/internal/model"
+	"github.com/ibeckermayer/teleport-interview/backend/internal/oidc"
+	"github.com/ibeckermayer/teleport-interview/backend/internal/util"
+)
+
+// OIDCLoginHandler handles calls to "api/login/oidc"
+type OIDCLoginHandler struct {
+	provider *oidc.Provider
+	ls       *oidc.LoginStore
+}
+
+// NewOIDCLoginHandler creates a new OIDCLoginHandler
+func NewOIDCLoginHandler(provider *oidc.Provider, ls *oidc.LoginStore) *OIDCLoginHandler {
+	return &OIDCLoginHandler{provider, ls}
+}
+
+type oidcLoginResponseBody struct {
+	URL   string `json:"url"`   // the IdP's login page, to send the browser to
+	State string `json:"state"` // must be kept by the browser and checked against the state the IdP redirects back with
+}
+
+// Handles "api/login/oidc" POST requests, starting a login with the IdP. Should be wrapped with WithAPIHeaders
+func (olh *OIDCLoginHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	login, err := olh.ls.CreateLogin()
+	if err != nil {
+		log.Println(err)
+		util.ErrorJSON(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	respBody := oidcLoginResponseBody{olh.provider.AuthCodeURL(login.State, login.Nonce, login.CodeVerifier), login.State}
+	if err := json.NewEncoder(w).Encode(respBody); err != nil {
+		log.Println(err)
+		return
+	}
+}
+
+// OIDCCallbackHandler handles calls to "api/login/oidc/callback", the end of a login with the IdP. It finishes
+// the login with its LoginHandler, so accounts with two-factor authentication enabled still need a code.
+type OIDCCallbackHandler struct {
+	lh       *LoginHandler
+	provider *oidc.Provider
+	ls       *oidc.LoginStore
+}
+
+// NewOIDCCallbackHandler creates a new OIDCCallbackHandler
+func NewOIDCCallbackHandler(lh *LoginHandler, provider *oidc.Provider, ls *oidc.LoginStore) *OIDCCallbackHandler {
+	return &OIDCCallbackHandler{lh, provider, ls}
+}
+
+type oidcCallbackRequestBody struct {
+	Code  string `json:"code"`
+	State string `json:"state"`
+}
+
+// Handles "api/login/oidc/callback" POST requests with the code and state the IdP redirected the browser back
+// with. Responds like "api/login". Should be wrapped with WithAPIHeaders
+func (och *OIDCCallbackHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var body oidcCallbackRequestBody
+	if err := util.DecodeJSONBody(w, r, &body); err != nil {
+		util.HandleJSONdecodeError(w, err)
+		return
+	}
+
+	login, err := och.ls.FinishLogin(body.State)
+	if err != nil {
+		util.ErrorJSON(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return
+	}
+
+	rawIDToken, err := och.provider.Exchange(body.Code, login.CodeVerifier)
+	if err != nil {
+		log.Printf("OIDC code exchange failed: %v", err)
+		util.ErrorJSON(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return
+	}
+
+	claims, err := och.provider.VerifyIDToken(rawIDToken, login.Nonce, time.Now())
+	if errors.Is(err, oidc.ErrInvalidIDToken) {
+		log.Println(err)
+		util.ErrorJSON(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return
+	}
+	if err != nil {
+		log.Println(err)
+		util.ErrorJSON(w, http.StatusText(http.StatusBadGateway), http.StatusBadGateway)
+		return
+	}
+
+	// The IdP vouches for who the user is, but the email it has for them is only as good as its own checks
+	if claims.Email == "" || !claims.EmailVerified {
+		log.Printf("OIDC login for sub=%v refused, no verified email", claims.Subject)
+		och.lh.audit.Record(r, audit.Entry{Actor: claims.Email, Action: model.AuditLogin, Outcome: model.AuditFailure, Detail: "single sign-on, email not verified by the IdP"})
+		util.ErrorJSON(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+		return
+	}
+
+	member, err := och.lh.db.GetMemberByEmail(claims.Email)
+	if err == sql.ErrNoRows {
+		log.Printf("OIDC login for sub=%v refused, no member with its email", claims.Subject)
+		och.lh.audit.Record(r, audit.Entry{Actor: claims.Email, Action: model.AuditLogin, Outcome: model.AuditFailure, Detail: "single sign-on, unknown email"})
+		util.ErrorJSON(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+		return
+	}
+	if err != nil {
+		log.Println(err)
+		util.ErrorJSON(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	log.Printf("OIDC login for member_id=%v as sub=%v", member.MemberID, claims.Subject)
+
+	och.lh.continueLogin(w, r, member, auth.EmailThrottleKey(member.Email), "single sign-on")
+}
@@ -0,0 +1,315 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/ibeckermayer/teleport-interview/backend/internal/auth"
+	"github.com/ibeckermayer/teleport-interview/backend/internal/database"
+	"github.com/ibeckermayer/teleport-interview/backend/internal/model"
+	"github.com/ibeckermayer/teleport-interview/backend/internal/notify"
+	"github.com/ibeckermayer/teleport-interview/backend/internal/util"
+)
+
+const (
+	// passwordResetTTL is how long a password reset token can be used for after it's emailed
+	passwordResetTTL = time.Hour
+
+	// maxPasswordResets is the most unexpired password reset tokens a member can have at once, so that
+	// "api/password/forgot" can't be used to flood someone's inbox
+	maxPasswordResets = 3
+)
+
+// notifyAsync sends n in the background, logging any error
+func notifyAsync(notifier notify.Notifier, n notify.Notification) {
+	go func() {
+		if err := notifier.Notify(n); err != nil {
+			log.Printf("failed to send %q notification to %v: %v", n.Subject, n.To, err)
+		}
+	}()
+}
+
+// ForgotPasswordHandler handles calls to "api/password/forgot"
+type ForgotPasswordHandler struct {
+	db        *database.Database
+	notifier  notify.Notifier
+	publicURL string // the URL the app is served at, which reset links point to
+}
+
+// NewForgotPasswordHandler creates a new ForgotPasswordHandler
+func NewForgotPasswordHandler(db *database.Database, notifier notify.Notifier, publicURL string) *ForgotPasswordHandler {
+	return &ForgotPasswordHandler{db, notifier, publicURL}
+}
+
+type forgotPasswordRequestBody struct {
+	Email string `json:"email"`
+}
+
+// sendReset emails a new password reset link to the member with email, if there is one
+func (fh *ForgotPasswordHandler) sendReset(email string) {
+	member, err := fh.db.GetMemberByEmail(email)
+	if err == sql.ErrNoRows {
+		log.Printf("password reset requested for unknown email %q", email)
+		return
+	}
+	if err != nil {
+		log.Println(err)
+		return
+	}
+
+	now := time.Now()
+	outstanding, err := fh.db.CountPasswordResets(member.MemberID, now)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	if outstanding >= maxPasswordResets {
+		log.Printf("not sending password reset for member_id=%v, they already have %v outstanding", member.MemberID, outstanding)
+		return
+	}
+
+	token, err := auth.NewKey()
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	pr := model.PasswordReset{
+		TokenHash: auth.HashKey(token),
+		MemberID:  member.MemberID,
+		CreatedAt: now,
+		ExpiresAt: now.Add(passwordResetTTL),
+	}
+	if err := fh.db.CreatePasswordReset(pr); err != nil {
+		log.Println(err)
+		return
+	}
+
+	link := fh.publicURL + "/reset-password?token=" + url.QueryEscape(string(token))
+	n := notify.Notification{
+		To:      member.Email,
+		Subject: "Reset your password",
+		Body: fmt.Sprintf("Someone asked to reset the password for your account. To choose a new password, go to:\n\n%v\n\n"+
+			"The link expires in %v minutes and can only be used once. If you didn't ask to reset your password, you can ignore this email.",
+			link, passwordResetTTL.Minutes()),
+	}
+	if err := fh.notifier.Notify(n); err != nil {
+		log.Printf("failed to send password reset to member_id=%v: %v", member.MemberID, err)
+	}
+}
+
+// Handles "api/password/forgot" POST requests. Should be wrapped with WithAPIHeaders
+func (fh *ForgotPasswordHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var body forgotPasswordRequestBody
+	if err := util.DecodeJSONBody(w, r, &body); err != nil {
+		util.HandleJSONdecodeError(w, err)
+		return
+	}
+
+	// Respond the same way, and just as quickly, whether or not a member has this email,
+	// so that this can't be used to find out which emails have accounts
+	go fh.sendReset(body.Email)
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// ResetPasswordHandler handles calls to "api/password/reset"
+type ResetPasswordHandler struct {
+	sm       *auth.SessionManager
+	cs       *auth.ChallengeStore
+	db       *database.Database
+	notifier notify.Notifier
+	policy   auth.PasswordPolicy
+	hasher   auth.PasswordHasher
+}
+
+// NewResetPasswordHandler creates a new ResetPasswordHandler
+func NewResetPasswordHandler(sm *auth.SessionManager, cs *auth.ChallengeStore, db *database.Database, notifier notify.Notifier, policy auth.PasswordPolicy, hasher auth.PasswordHasher) *ResetPasswordHandler {
+	return &ResetPasswordHandler{sm, cs, db, notifier, policy, hasher}
+}
+
+type resetPasswordRequestBody struct {
+	Token    string `json:"token"`
+	Password string `json:"password"`
+}
+
+// Handles "api/password/reset" POST requests, setting a new password with a token from "api/password/forgot".
+// Should be wrapped with WithAPIHeaders
+func (rh *ResetPasswordHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var body resetPasswordRequestBody
+	if err := util.DecodeJSONBody(w, r, &body); err != nil {
+		util.HandleJSONdecodeError(w, err)
+		return
+	}
+
+	// Check the token before hashing the password, which is deliberately slow
+	tokenHash := auth.HashKey(auth.Key(body.Token))
+	pr, err := rh.db.GetPasswordReset(tokenHash, time.Now())
+	if err == sql.ErrNoRows {
+		util.ErrorJSON(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		log.Println(err)
+		util.ErrorJSON(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	member, err := rh.db.GetMember(pr.MemberID)
+	if err != nil {
+		log.Println(err)
+		util.ErrorJSON(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	if err := rh.policy.Check(body.Password, member.Email); err != nil {
+		var pe auth.PolicyError
+		if errors.As(err, &pe) {
+			util.ErrorJSON(w, pe.Error(), http.StatusBadRequest)
+			return
+		}
+		log.Println(err)
+		util.ErrorJSON(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	passwordHash, err := rh.hasher.Hash(body.Password)
+	if err != nil {
+		log.Println(err)
+		util.ErrorJSON(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	member, err = rh.db.ResetPassword(tokenHash, passwordHash, time.Now())
+	if err == sql.ErrNoRows {
+		// Used by a concurrent request, or expired while the password was being hashed
+		util.ErrorJSON(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		log.Println(err)
+		util.ErrorJSON(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	// Whoever knew the old password shouldn't stay logged in, and the member has proven they
+	// control the email address so any lockout from failed logins can be lifted
+	n := rh.sm.DeleteMemberSessions(member.MemberID)
+	rh.cs.DeleteMemberChallenges(member.MemberID)
+	if _, err := rh.db.DeleteLoginThrottle(auth.EmailThrottleKey(member.Email)); err != nil {
+		log.Println(err)
+	}
+	log.Printf("password reset for member_id=%v, revoked %v sessions", member.MemberID, n)
+
+	notifyAsync(rh.notifier, notify.Notification{
+		To:      member.Email,
+		Subject: "Your password was changed",
+		Body:    "The password for your account was just reset and every device logged in to it was logged out. If you didn't do this, reset your password again right away.",
+	})
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ChangePasswordHandler handles calls to "api/account/password"
+type ChangePasswordHandler struct {
+	sm       *auth.SessionManager
+	cs       *auth.ChallengeStore
+	db       *database.Database
+	notifier notify.Notifier
+	policy   auth.PasswordPolicy
+	hasher   auth.PasswordHasher
+	lh       *LoginHandler // whose throttle wrong passwords count against
+}
+
+// NewChangePasswordHandler creates a new ChangePasswordHandler
+func NewChangePasswordHandler(sm *auth.SessionManager, cs *auth.ChallengeStore, db *database.Database, notifier notify.Notifier, policy auth.PasswordPolicy, hasher auth.PasswordHasher, lh *LoginHandler) *ChangePasswordHandler {
+	return &ChangePasswordHandler{sm, cs, db, notifier, policy, hasher, lh}
+}
+
+type changePasswordRequestBody struct {
+	CurrentPassword string `json:"currentPassword"`
+	NewPassword     string `json:"newPassword"`
+}
+
+// Handles "api/account/password" POST requests, changing the password of the logged in member. Every other
+// session for the member is logged out. Wrong current passwords count towards the member's login throttle.
+// Should be wrapped with WithSessionAuth and WithAPIHeaders
+func (cph *ChangePasswordHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	session, err := cph.sm.FromContext(r.Context())
+	if err != nil {
+		log.Println(err)
+		util.ErrorJSON(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	var body changePasswordRequestBody
+	if err := util.DecodeJSONBody(w, r, &body); err != nil {
+		util.HandleJSONdecodeError(w, err)
+		return
+	}
+
+	// The session's cached member may be stale, check against the current password hash
+	member, err := cph.db.GetMember(session.Member.MemberID)
+	if err != nil {
+		log.Println(err)
+		util.ErrorJSON(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	attempt := cph.lh.beginReauth(w, r, member)
+	if attempt == nil {
+		return
+	}
+	defer attempt.end()
+	if !auth.CheckPasswordHash(body.CurrentPassword, member.PasswordHash) {
+		attempt.fail()
+		util.ErrorJSON(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+		return
+	}
+
+	if body.NewPassword == body.CurrentPassword {
+		util.ErrorJSON(w, "new password must be different from the current password", http.StatusBadRequest)
+		return
+	}
+	if err := cph.policy.Check(body.NewPassword, member.Email); err != nil {
+		var pe auth.PolicyError
+		if errors.As(err, &pe) {
+			util.ErrorJSON(w, pe.Error(), http.StatusBadRequest)
+			return
+		}
+		log.Println(err)
+		util.ErrorJSON(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	passwordHash, err := cph.hasher.Hash(body.NewPassword)
+	if err != nil {
+		log.Println(err)
+		util.ErrorJSON(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	member, err = cph.db.SetPassword(member.MemberID, passwordHash, time.Now())
+	if err != nil {
+		log.Println(err)
+		util.ErrorJSON(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	// Log out everywhere else and refresh this session's copy of the member
+	n := cph.sm.DeleteOtherSessions(member.MemberID, session.SessionID)
+	cph.cs.DeleteMemberChallenges(member.MemberID)
+	session.Member = member
+	cph.sm.UpdateSession(session)
+	log.Printf("password changed for member_id=%v, revoked %v other sessions", member.MemberID, n)
+
+	notifyAsync(cph.notifier, notify.Notification{
+		To:      member.Email,
+		Subject: "Your password was changed",
+		Body:    "The password for your account was just changed and every other device logged in to it was logged out. If you didn't do this, reset your password right away.",
+	})
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,63 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/ibeckermayer/teleport-interview/backend/internal/auth"
+	"github.com/ibeckermayer/teleport-interview/backend/internal/database"
+	"github.com/ibeckermayer/teleport-interview/backend/internal/model"
+	"github.com/ibeckermayer/teleport-interview/backend/internal/util"
+)
+
+// PlanHistoryHandler handles GET calls to "api/account/plan-history"
+type PlanHistoryHandler struct {
+	sm *auth.SessionManager
+	db *database.Database
+}
+
+// NewPlanHistoryHandler creates a new PlanHistoryHandler
+func NewPlanHistoryHandler(sm *auth.SessionManager, db *database.Database) *PlanHistoryHandler {
+	return &PlanHistoryHandler{sm, db}
+}
+
+type planChange struct {
+	OldPlan   model.Plan             `json:"oldPlan"`
+	NewPlan   model.Plan             `json:"newPlan"`
+	Reason    model.PlanChangeReason `json:"reason"`
+	ChangedBy string                 `json:"changedBy"`
+	ChangedAt time.Time              `json:"changedAt"`
+}
+
+type planHistoryResponseBody struct {
+	Changes []planChange `json:"changes"`
+}
+
+// Handles "api/account/plan-history" GET requests. Should be wrapped with WithSessionAuth and WithAPIHeaders
+func (phh *PlanHistoryHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	session, err := phh.sm.FromContext(r.Context())
+	if err != nil {
+		log.Println(err)
+		util.ErrorJSON(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	history, err := phh.db.GetPlanHistory(session.Account.AccountID)
+	if err != nil {
+		log.Println(err)
+		util.ErrorJSON(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	respBody := planHistoryResponseBody{Changes: make([]planChange, 0, len(history))}
+	for _, h := range history {
+		respBody.Changes = append(respBody.Changes, planChange{h.OldPlan, h.NewPlan, h.Reason, h.ChangedBy, h.ChangedAt})
+	}
+
+	if err := json.NewEncoder(w).Encode(respBody); err != nil {
+		log.Println(err)
+		return
+	}
+}
@@ -0,0 +1,296 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/ibeckermayer/teleport-interview/backend/internal/auth"
+	"github.com/ibeckermayer/teleport-interview/backend/internal/database"
+	"github.com/ibeckermayer/teleport-interview/backend/internal/model"
+	"github.com/ibeckermayer/teleport-interview/backend/internal/util"
+)
+
+// checkSecondFactor checks a TOTP code or an unused recovery code for a member with two-factor
+// authentication enabled, using it up if it's valid so that it can't be used again
+func checkSecondFactor(db *database.Database, totp model.MemberTOTP, code string, now time.Time) (bool, error) {
+	code = strings.TrimSpace(code)
+	if step, ok := auth.CheckTOTPCode(totp.Secret, code, now, totp.LastUsedStep); ok {
+		return db.UseTOTPStep(totp.MemberID, step)
+	}
+	return db.UseRecoveryCode(totp.MemberID, auth.HashRecoveryCode(code), now)
+}
+
+// TwoFactorGetHandler handles GET calls to "api/2fa"
+type TwoFactorGetHandler struct {
+	sm *auth.SessionManager
+	db *database.Database
+}
+
+// NewTwoFactorGetHandler creates a new TwoFactorGetHandler
+func NewTwoFactorGetHandler(sm *auth.SessionManager, db *database.Database) *TwoFactorGetHandler {
+	return &TwoFactorGetHandler{sm, db}
+}
+
+type twoFactorGetResponseBody struct {
+	Enabled                bool `json:"enabled"`
+	RecoveryCodesRemaining int  `json:"recoveryCodesRemaining"`
+}
+
+// Handles "api/2fa" GET requests. Should be wrapped with WithSessionAuth and WithAPIHeaders
+func (tgh *TwoFactorGetHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	session, err := tgh.sm.FromContext(r.Context())
+	if err != nil {
+		log.Println(err)
+		util.ErrorJSON(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	var respBody twoFactorGetResponseBody
+	totp, err := tgh.db.GetTOTP(session.Member.MemberID)
+	if err != nil && err != sql.ErrNoRows {
+		log.Println(err)
+		util.ErrorJSON(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	if err == nil && totp.Enabled {
+		respBody.Enabled = true
+		if respBody.RecoveryCodesRemaining, err = tgh.db.CountRecoveryCodes(session.Member.MemberID); err != nil {
+			log.Println(err)
+			util.ErrorJSON(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	if err := json.NewEncoder(w).Encode(respBody); err != nil {
+		log.Println(err)
+		return
+	}
+}
+
+// TwoFactorEnrollHandler handles POST calls to "api/2fa/enroll"
+type TwoFactorEnrollHandler struct {
+	sm *auth.SessionManager
+	db *database.Database
+}
+
+// NewTwoFactorEnrollHandler creates a new TwoFactorEnrollHandler
+func NewTwoFactorEnrollHandler(sm *auth.SessionManager, db *database.Database) *TwoFactorEnrollHandler {
+	return &TwoFactorEnrollHandler{sm, db}
+}
+
+type twoFactorEnrollResponseBody struct {
+	Secret string `json:"secret"`
+	URI    string `json:"uri"` // otpauth:// URI for authenticator apps, usually shown as a QR code
+}
+
+// Handles "api/2fa/enroll" POST requests, generating a new TOTP secret which isn't used for logins until
+// it's verified with "api/2fa/verify". Should be wrapped with WithSessionAuth and WithAPIHeaders
+func (teh *TwoFactorEnrollHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	session, err := teh.sm.FromContext(r.Context())
+	if err != nil {
+		log.Println(err)
+		util.ErrorJSON(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	secret, err := auth.NewTOTPSecret()
+	if err != nil {
+		log.Println(err)
+		util.ErrorJSON(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	saved, err := teh.db.SetPendingTOTP(session.Member.MemberID, secret, time.Now())
+	if err != nil {
+		log.Println(err)
+		util.ErrorJSON(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	if !saved {
+		// Two-factor authentication is already enabled, it has to be disabled before re-enrolling
+		util.ErrorJSON(w, http.StatusText(http.StatusConflict), http.StatusConflict)
+		return
+	}
+
+	respBody := twoFactorEnrollResponseBody{secret, auth.TOTPURI(session.Member.Email, secret)}
+	if err := json.NewEncoder(w).Encode(respBody); err != nil {
+		log.Println(err)
+		return
+	}
+}
+
+// TwoFactorVerifyHandler handles POST calls to "api/2fa/verify"
+type TwoFactorVerifyHandler struct {
+	sm *auth.SessionManager
+	db *database.Database
+}
+
+// NewTwoFactorVerifyHandler creates a new TwoFactorVerifyHandler
+func NewTwoFactorVerifyHandler(sm *auth.SessionManager, db *database.Database) *TwoFactorVerifyHandler {
+	return &TwoFactorVerifyHandler{sm, db}
+}
+
+type twoFactorCodeRequestBody struct {
+	Code string `json:"code"`
+}
+
+type twoFactorVerifyResponseBody struct {
+	RecoveryCodes []string `json:"recoveryCodes"` // only ever returned here, the member must save them
+}
+
+// Handles "api/2fa/verify" POST requests, enabling two-factor authentication once the first code from the
+// pending secret checks out. Should be wrapped with WithSessionAuth and WithAPIHeaders
+func (tvh *TwoFactorVerifyHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	session, err := tvh.sm.FromContext(r.Context())
+	if err != nil {
+		log.Println(err)
+		util.ErrorJSON(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	var body twoFactorCodeRequestBody
+	if err := util.DecodeJSONBody(w, r, &body); err != nil {
+		util.HandleJSONdecodeError(w, err)
+		return
+	}
+
+	totp, err := tvh.db.GetTOTP(session.Member.MemberID)
+	if err == sql.ErrNoRows || (err == nil && totp.Enabled) {
+		// Nothing pending to verify
+		util.ErrorJSON(w, http.StatusText(http.StatusConflict), http.StatusConflict)
+		return
+	}
+	if err != nil {
+		log.Println(err)
+		util.ErrorJSON(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	now := time.Now()
+	step, ok := auth.CheckTOTPCode(totp.Secret, strings.TrimSpace(body.Code), now, totp.LastUsedStep)
+	if !ok {
+		util.ErrorJSON(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+
+	codes, err := auth.NewRecoveryCodes(auth.RecoveryCodeCount)
+	if err != nil {
+		log.Println(err)
+		util.ErrorJSON(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	hashes := make([]string, len(codes))
+	for i, code := range codes {
+		hashes[i] = auth.HashRecoveryCode(code)
+	}
+
+	enabled, err := tvh.db.EnableTOTP(session.Member.MemberID, step, hashes, now)
+	if err != nil {
+		log.Println(err)
+		util.ErrorJSON(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	if !enabled {
+		// A concurrent request enabled it first
+		util.ErrorJSON(w, http.StatusText(http.StatusConflict), http.StatusConflict)
+		return
+	}
+	log.Printf("two-factor authentication enabled for member_id=%v", session.Member.MemberID)
+
+	if err := json.NewEncoder(w).Encode(twoFactorVerifyResponseBody{codes}); err != nil {
+		log.Println(err)
+		return
+	}
+}
+
+// TwoFactorDeleteHandler handles DELETE calls to "api/2fa"
+type TwoFactorDeleteHandler struct {
+	sm *auth.SessionManager
+	db *database.Database
+	lh *LoginHandler // whose throttle wrong passwords and codes count against
+}
+
+// NewTwoFactorDeleteHandler creates a new TwoFactorDeleteHandler
+func NewTwoFactorDeleteHandler(sm *auth.SessionManager, db *database.Database, lh *LoginHandler) *TwoFactorDeleteHandler {
+	return &TwoFactorDeleteHandler{sm, db, lh}
+}
+
+type twoFactorDeleteRequestBody struct {
+	Password string `json:"password"`
+	Code     string `json:"code"` // a TOTP code or recovery code
+}
+
+// Handles "api/2fa" DELETE requests, disabling two-factor authentication. Requires the member's password and
+// a current code so that a stolen session alone can't weaken the account. Wrong passwords and codes count towards
+// the member's login throttle. Should be wrapped with WithSessionAuth and WithAPIHeaders
+func (tdh *TwoFactorDeleteHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	session, err := tdh.sm.FromContext(r.Context())
+	if err != nil {
+		log.Println(err)
+		util.ErrorJSON(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	var body twoFactorDeleteRequestBody
+	if err := util.DecodeJSONBody(w, r, &body); err != nil {
+		util.HandleJSONdecodeError(w, err)
+		return
+	}
+
+	totp, err := tdh.db.GetTOTP(session.Member.MemberID)
+	if err == sql.ErrNoRows {
+		util.ErrorJSON(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Println(err)
+		util.ErrorJSON(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	// A pending secret can be discarded without a code, since it was never used for logins
+	if totp.Enabled {
+		member, err := tdh.db.GetMember(session.Member.MemberID)
+		if err != nil {
+			log.Println(err)
+			util.ErrorJSON(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+		attempt := tdh.lh.beginReauth(w, r, member)
+		if attempt == nil {
+			return
+		}
+		defer attempt.end()
+		if !auth.CheckPasswordHash(body.Password, member.PasswordHash) {
+			attempt.fail()
+			util.ErrorJSON(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+			return
+		}
+		ok, err := checkSecondFactor(tdh.db, totp, body.Code, time.Now())
+		if err != nil {
+			log.Println(err)
+			util.ErrorJSON(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+		if !ok {
+			attempt.fail()
+			util.ErrorJSON(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+			return
+		}
+	}
+
+	if err := tdh.db.DeleteTOTP(session.Member.MemberID); err != nil {
+		log.Println(err)
+		util.ErrorJSON(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	if totp.Enabled {
+		log.Printf("two-factor authentication disabled for member_id=%v", session.Member.MemberID)
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -5,6 +5,8 @@ import (
 	"log"
 	"net/http"
 
+	"github.com/ibeckermayer/teleport-interview/backend/internal/alert"
+	"github.com/ibeckermayer/teleport-interview/backend/internal/audit"
 	"github.com/ibeckermayer/teleport-interview/backend/internal/auth"
 	"github.com/ibeckermayer/teleport-interview/backend/internal/database"
 	"github.com/ibeckermayer/teleport-interview/backend/internal/model"
@@ -13,18 +15,27 @@ import (
 
 // UpgradeHandler handles calls to "api/upgrade"
 type UpgradeHandler struct {
-	sm *auth.SessionManager
-	db *database.Database
+	sm     *auth.SessionManager
+	db     *database.Database
+	alerts *alert.Alerter
+	audit  *audit.Log
 }
 
 // NewUpgradeHandler creates a new UpgradeHandler
-func NewUpgradeHandler(sm *auth.SessionManager, db *database.Database) *UpgradeHandler {
-	return &UpgradeHandler{sm, db}
+func NewUpgradeHandler(sm *auth.SessionManager, db *database.Database, alerts *alert.Alerter, al *audit.Log) *UpgradeHandler {
+	return &UpgradeHandler{sm, db, alerts, al}
 }
 
 type upgradHandlerResponseBody metricsGetResponseBody
 
-// Handles "api/upgrade" calls. Should be wrapped with WithAPIHeaders and WithSessionAuth
+// upgradeEventData is the data sent with account.upgraded events
+type upgradeEventData struct {
+	Plan       model.Plan `json:"plan"`
+	TotalUsers int        `json:"total_users"`
+	UpgradedBy string     `json:"upgraded_by"`
+}
+
+// Handles "api/upgrade" calls. Should be wrapped with WithAPIHeaders, WithSessionAuth and RequirePermission
 func (uh *UpgradeHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	session, err := uh.sm.FromContext(r.Context())
 	if err != nil {
@@ -34,22 +45,35 @@ func (uh *UpgradeHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Upgrade the account and set its excess users to active, grabbing the total number of users in the process
-	totalUsers, err := uh.db.UpgradeAccount(session.Account.AccountID)
+	totalUsers, err := uh.db.UpgradeAccount(session.Account.AccountID, session.Actor())
+	if err != nil {
+		log.Println(err)
+		uh.audit.Record(r, audit.Entry{AccountID: session.Account.AccountID, Actor: session.Actor(), Action: model.AuditUpgrade, Outcome: model.AuditFailure, Detail: "from " + string(session.Account.Plan)})
+		util.ErrorJSON(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	// Update the sessions of every member of the account in the session manager
+	account, err := uh.db.GetAccount(session.Account.AccountID)
 	if err != nil {
 		log.Println(err)
 		util.ErrorJSON(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
 		return
 	}
+	uh.audit.Record(r, audit.Entry{AccountID: account.AccountID, Actor: session.Actor(), Action: model.AuditUpgrade, Outcome: model.AuditSuccess,
+		Detail: string(session.Account.Plan) + " to " + string(account.Plan)})
+	session.Account = account
+	uh.sm.UpdateAccount(account)
 
-	// Update the session in the session manager
-	session.Account.Plan = model.ENTERPRISE
-	uh.sm.UpdateSession(session)
+	createEvent(uh.db, account.AccountID, model.EventAccountUpgraded, upgradeEventData{account.Plan, totalUsers, session.Actor()})
+	uh.alerts.CheckAsync(account.AccountID)
 
 	// Build and send response body
 	respBody := upgradHandlerResponseBody{
 		session.Account.Plan,
 		model.PlanMaxUsers[session.Account.Plan],
 		totalUsers,
+		session.Account.TrialEndsAt,
 	}
 
 	if err := json.NewEncoder(w).Encode(respBody); err != nil {
@@ -0,0 +1,144 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/ibeckermayer/teleport-interview/backend/internal/alert"
+	"github.com/ibeckermayer/teleport-interview/backend/internal/auth"
+	"github.com/ibeckermayer/teleport-interview/backend/internal/database"
+	"github.com/ibeckermayer/teleport-interview/backend/internal/util"
+)
+
+// maxUsageAlerts is the most usage alert thresholds an account may have
+const maxUsageAlerts = 10
+
+type usageAlertJSON struct {
+	Percent int        `json:"percent"`
+	Fired   bool       `json:"fired"`
+	FiredAt *time.Time `json:"firedAt"`
+}
+
+type usageAlertsResponseBody struct {
+	Alerts []usageAlertJSON `json:"alerts"`
+}
+
+// writeUsageAlerts responds with accountID's current usage alerts
+func writeUsageAlerts(w http.ResponseWriter, db *database.Database, accountID string) {
+	alerts, err := db.GetUsageAlerts(accountID)
+	if err != nil {
+		log.Println(err)
+		util.ErrorJSON(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	respBody := usageAlertsResponseBody{Alerts: make([]usageAlertJSON, 0, len(alerts))}
+	for _, a := range alerts {
+		respBody.Alerts = append(respBody.Alerts, usageAlertJSON{a.Percent, a.Fired, a.FiredAt})
+	}
+
+	if err := json.NewEncoder(w).Encode(respBody); err != nil {
+		log.Println(err)
+		return
+	}
+}
+
+// UsageAlertsGetHandler handles GET calls to "api/alerts"
+type UsageAlertsGetHandler struct {
+	sm *auth.SessionManager
+	db *database.Database
+}
+
+// NewUsageAlertsGetHandler creates a new UsageAlertsGetHandler
+func NewUsageAlertsGetHandler(sm *auth.SessionManager, db *database.Database) *UsageAlertsGetHandler {
+	return &UsageAlertsGetHandler{sm, db}
+}
+
+// Handles "api/alerts" GET requests. Should be wrapped with WithSessionAuth and WithAPIHeaders
+func (uagh *UsageAlertsGetHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	session, err := uagh.sm.FromContext(r.Context())
+	if err != nil {
+		log.Println(err)
+		util.ErrorJSON(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	writeUsageAlerts(w, uagh.db, session.Account.AccountID)
+}
+
+// UsageAlertsPutHandler handles PUT calls to "api/alerts"
+type UsageAlertsPutHandler struct {
+	sm     *auth.SessionManager
+	db     *database.Database
+	alerts *alert.Alerter
+}
+
+// NewUsageAlertsPutHandler creates a new UsageAlertsPutHandler
+func NewUsageAlertsPutHandler(sm *auth.SessionManager, db *database.Database, alerts *alert.Alerter) *UsageAlertsPutHandler {
+	return &UsageAlertsPutHandler{sm, db, alerts}
+}
+
+type usageAlertsPutRequestBody struct {
+	Percents []int `json:"percents"`
+}
+
+// validatePercents checks that percents are a reasonable set of thresholds and returns them sorted and de-duplicated
+func validatePercents(percents []int) ([]int, error) {
+	if len(percents) > maxUsageAlerts {
+		return nil, errors.New("too many usage alert thresholds")
+	}
+	seen := make(map[int]bool)
+	valid := []int{}
+	for _, p := range percents {
+		if p < 1 || p > 1000 {
+			return nil, errors.New("usage alert thresholds must be between 1 and 1000 percent")
+		}
+		if !seen[p] {
+			seen[p] = true
+			valid = append(valid, p)
+		}
+	}
+	sort.Ints(valid)
+	return valid, nil
+}
+
+// Handles "api/alerts" PUT requests, replacing the account's usage alert thresholds. Should be wrapped
+// with WithSessionAuth, RequirePermission and WithAPIHeaders
+func (uaph *UsageAlertsPutHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	session, err := uaph.sm.FromContext(r.Context())
+	if err != nil {
+		log.Println(err)
+		util.ErrorJSON(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	var body usageAlertsPutRequestBody
+	if err := util.DecodeJSONBody(w, r, &body); err != nil {
+		util.HandleJSONdecodeError(w, err)
+		return
+	}
+
+	percents, err := validatePercents(body.Percents)
+	if err != nil {
+		log.Println(err)
+		util.ErrorJSON(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+
+	if err := uaph.db.SetUsageAlerts(session.Account.AccountID, percents); err != nil {
+		log.Println(err)
+		util.ErrorJSON(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	// New thresholds may already have been crossed
+	if err := uaph.alerts.Check(session.Account.AccountID); err != nil {
+		log.Println(err)
+	}
+
+	writeUsageAlerts(w, uaph.db, session.Account.AccountID)
+}
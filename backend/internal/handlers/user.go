@@ -0,0 +1,214 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"github.com/ibeckermayer/teleport-interview/backend/internal/alert"
+	"github.com/ibeckermayer/teleport-interview/backend/internal/audit"
+	"github.com/ibeckermayer/teleport-interview/backend/internal/auth"
+	"github.com/ibeckermayer/teleport-interview/backend/internal/database"
+	"github.com/ibeckermayer/teleport-interview/backend/internal/model"
+	"github.com/ibeckermayer/teleport-interview/backend/internal/util"
+)
+
+type userJSON struct {
+	UserID        string     `json:"userID"`
+	IsActive      bool       `json:"isActive"`
+	CreatedAt     time.Time  `json:"createdAt"`
+	UpdatedAt     time.Time  `json:"updatedAt"`
+	DeactivatedAt *time.Time `json:"deactivatedAt"` // null unless a member deactivated the user
+	LastSeenAt    *time.Time `json:"lastSeenAt"`
+}
+
+func newUserJSON(u model.User) userJSON {
+	return userJSON{u.UserID, u.IsActive, u.CreatedAt, u.UpdatedAt, u.DeactivatedAt, u.LastSeenAt}
+}
+
+type userExpiryJSON struct {
+	UserID     string                 `json:"userID"`
+	Action     model.UserExpiryAction `json:"action"`
+	LastSeenAt *time.Time             `json:"lastSeenAt"`
+	CreatedAt  time.Time              `json:"createdAt"`
+}
+
+func newUserExpiryJSON(e model.UserExpiry) userExpiryJSON {
+	return userExpiryJSON{e.UserID, e.Action, e.LastSeenAt, e.CreatedAt}
+}
+
+// UserExportHandler handles GET calls to "api/users/{userID}/export"
+type UserExportHandler struct {
+	sm    *auth.SessionManager
+	db    *database.Database
+	audit *audit.Log
+}
+
+// NewUserExportHandler creates a new UserExportHandler
+func NewUserExportHandler(sm *auth.SessionManager, db *database.Database, al *audit.Log) *UserExportHandler {
+	return &UserExportHandler{sm, db, al}
+}
+
+type userExportResponseBody struct {
+	AccountID  string           `json:"accountID"`
+	UserID     string           `json:"userID"`
+	User       *userJSON        `json:"user"` // null if the user was never created for the account
+	Metrics    []metricJSON     `json:"metrics"`
+	Expiries   []userExpiryJSON `json:"expiries"`
+	ExportedAt time.Time        `json:"exportedAt"`
+}
+
+// Handles "api/users/{userID}/export" GET requests, returning everything the account's devices have sent about
+// the user, so that it can be passed on to them. Should be wrapped with WithSessionAuth, RequirePermission and
+// WithAPIHeaders
+func (ueh *UserExportHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	session, err := ueh.sm.FromContext(r.Context())
+	if err != nil {
+		log.Println(err)
+		util.ErrorJSON(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	userID := mux.Vars(r)["userID"]
+	export, err := ueh.db.ExportUser(session.Account.AccountID, userID)
+	if err == sql.ErrNoRows {
+		util.ErrorJSON(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Println(err)
+		util.ErrorJSON(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	respBody := userExportResponseBody{
+		AccountID:  session.Account.AccountID,
+		UserID:     userID,
+		Metrics:    make([]metricJSON, 0, len(export.Metrics)),
+		Expiries:   make([]userExpiryJSON, 0, len(export.Expiries)),
+		ExportedAt: time.Now(),
+	}
+	if export.User != nil {
+		uj := newUserJSON(*export.User)
+		respBody.User = &uj
+	}
+	for _, m := range export.Metrics {
+		respBody.Metrics = append(respBody.Metrics, newMetricJSON(m))
+	}
+	for _, e := range export.Expiries {
+		respBody.Expiries = append(respBody.Expiries, newUserExpiryJSON(e))
+	}
+	// The user_id isn't recorded, the audit log can't be erased
+	ueh.audit.Record(r, audit.Entry{AccountID: session.Account.AccountID, Actor: session.Actor(), Action: model.AuditUserExport, Outcome: model.AuditSuccess,
+		Detail: fmt.Sprintf("%v metrics", len(export.Metrics))})
+
+	if err := json.NewEncoder(w).Encode(respBody); err != nil {
+		log.Println(err)
+		return
+	}
+}
+
+// UserEraseHandler handles DELETE calls to "api/users/{userID}"
+type UserEraseHandler struct {
+	sm     *auth.SessionManager
+	db     *database.Database
+	alerts *alert.Alerter
+	audit  *audit.Log
+}
+
+// NewUserEraseHandler creates a new UserEraseHandler
+func NewUserEraseHandler(sm *auth.SessionManager, db *database.Database, alerts *alert.Alerter, al *audit.Log) *UserEraseHandler {
+	return &UserEraseHandler{sm, db, alerts, al}
+}
+
+// Handles "api/users/{userID}" DELETE requests, erasing everything the account's devices have sent about the
+// user. The seat it used is freed, so the oldest inactive user becomes active if the account was over its limit.
+// A device sending a metric for the user afterwards creates it again as a new user. Should be wrapped with
+// WithSessionAuth, RequirePermission and WithAPIHeaders
+func (ueh *UserEraseHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	session, err := ueh.sm.FromContext(r.Context())
+	if err != nil {
+		log.Println(err)
+		util.ErrorJSON(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	metrics, err := ueh.db.EraseUser(session.Account.AccountID, mux.Vars(r)["userID"], time.Now())
+	if err == sql.ErrNoRows {
+		util.ErrorJSON(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Println(err)
+		util.ErrorJSON(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	ueh.alerts.CheckAsync(session.Account.AccountID)
+	// The user_id isn't recorded, the audit log can't be erased
+	ueh.audit.Record(r, audit.Entry{AccountID: session.Account.AccountID, Actor: session.Actor(), Action: model.AuditUserErase, Outcome: model.AuditSuccess,
+		Detail: fmt.Sprintf("%v metrics", metrics)})
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// UserActivateHandler handles POST calls to "api/users/{userID}/activate" or, if it deactivates users,
+// "api/users/{userID}/deactivate"
+type UserActivateHandler struct {
+	sm       *auth.SessionManager
+	db       *database.Database
+	alerts   *alert.Alerter
+	audit    *audit.Log
+	activate bool // false if the handler deactivates users
+}
+
+// NewUserActivateHandler creates a new UserActivateHandler which activates users, or deactivates them if
+// activate is false
+func NewUserActivateHandler(sm *auth.SessionManager, db *database.Database, alerts *alert.Alerter, al *audit.Log, activate bool) *UserActivateHandler {
+	return &UserActivateHandler{sm, db, alerts, al, activate}
+}
+
+// Handles "api/users/{userID}/activate" and "api/users/{userID}/deactivate" POST requests, returning the updated
+// user. Activating a user takes a free seat on the account's plan, and fails with a 409 if there isn't one.
+// Deactivating one frees its seat for the oldest user waiting, and keeps it inactive until it's activated again.
+// Should be wrapped with WithSessionAuth, RequirePermission and WithAPIHeaders
+func (uah *UserActivateHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	session, err := uah.sm.FromContext(r.Context())
+	if err != nil {
+		log.Println(err)
+		util.ErrorJSON(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	user, err := uah.db.SetUserActive(session.Account.AccountID, mux.Vars(r)["userID"], uah.activate, time.Now())
+	if err == sql.ErrNoRows {
+		util.ErrorJSON(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return
+	}
+	if err == database.ErrNoFreeSeats {
+		util.ErrorJSON(w, fmt.Sprintf("the %v plan allows %v active users, deactivate one first", session.Account.Plan, model.PlanMaxUsers[session.Account.Plan]),
+			http.StatusConflict)
+		return
+	}
+	if err != nil {
+		log.Println(err)
+		util.ErrorJSON(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	action := model.AuditUserDeactivate
+	if uah.activate {
+		action = model.AuditUserActivate
+	}
+	uah.alerts.CheckAsync(session.Account.AccountID)
+	uah.audit.Record(r, audit.Entry{AccountID: session.Account.AccountID, Actor: session.Actor(), Action: action, Outcome: model.AuditSuccess})
+
+	if err := json.NewEncoder(w).Encode(newUserJSON(user)); err != nil {
+		log.Println(err)
+		return
+	}
+}
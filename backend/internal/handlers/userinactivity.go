@@ -0,0 +1,133 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/ibeckermayer/teleport-interview/backend/internal/audit"
+	"github.com/ibeckermayer/teleport-interview/backend/internal/auth"
+	"github.com/ibeckermayer/teleport-interview/backend/internal/database"
+	"github.com/ibeckermayer/teleport-interview/backend/internal/model"
+	"github.com/ibeckermayer/teleport-interview/backend/internal/util"
+)
+
+const (
+	// minInactivityDays and maxInactivityDays bound an account's inactivity policy, when it has one
+	minInactivityDays = 7
+	maxInactivityDays = 3650
+
+	// userExpiriesLimit is how many of the inactivity job's most recent changes are returned
+	userExpiriesLimit = 100
+)
+
+type userInactivityResponseBody struct {
+	InactivityDays int              `json:"inactivityDays"` // 0 if users never expire
+	Changes        []userExpiryJSON `json:"changes"`
+}
+
+// writeUserInactivity responds with accountID's inactivity policy and what the inactivity job recently did
+func writeUserInactivity(w http.ResponseWriter, db *database.Database, accountID string) {
+	account, err := db.GetAccount(accountID)
+	if err != nil {
+		log.Println(err)
+		util.ErrorJSON(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	changes, err := db.GetUserExpiries(accountID, userExpiriesLimit)
+	if err != nil {
+		log.Println(err)
+		util.ErrorJSON(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	respBody := userInactivityResponseBody{account.InactivityDays, make([]userExpiryJSON, 0, len(changes))}
+	for _, c := range changes {
+		respBody.Changes = append(respBody.Changes, newUserExpiryJSON(c))
+	}
+
+	if err := json.NewEncoder(w).Encode(respBody); err != nil {
+		log.Println(err)
+		return
+	}
+}
+
+// UserInactivityGetHandler handles GET calls to "api/account/user-inactivity"
+type UserInactivityGetHandler struct {
+	sm *auth.SessionManager
+	db *database.Database
+}
+
+// NewUserInactivityGetHandler creates a new UserInactivityGetHandler
+func NewUserInactivityGetHandler(sm *auth.SessionManager, db *database.Database) *UserInactivityGetHandler {
+	return &UserInactivityGetHandler{sm, db}
+}
+
+// Handles "api/account/user-inactivity" GET requests, returning the account's inactivity policy and the 100 most
+// recent users the inactivity job expired or promoted, newest first. Should be wrapped with WithSessionAuth,
+// RequirePermission and WithAPIHeaders
+func (uigh *UserInactivityGetHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	session, err := uigh.sm.FromContext(r.Context())
+	if err != nil {
+		log.Println(err)
+		util.ErrorJSON(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	writeUserInactivity(w, uigh.db, session.Account.AccountID)
+}
+
+// UserInactivityPutHandler handles PUT calls to "api/account/user-inactivity"
+type UserInactivityPutHandler struct {
+	sm    *auth.SessionManager
+	db    *database.Database
+	audit *audit.Log
+}
+
+// NewUserInactivityPutHandler creates a new UserInactivityPutHandler
+func NewUserInactivityPutHandler(sm *auth.SessionManager, db *database.Database, al *audit.Log) *UserInactivityPutHandler {
+	return &UserInactivityPutHandler{sm, db, al}
+}
+
+type userInactivityPutRequestBody struct {
+	InactivityDays int `json:"inactivityDays"`
+}
+
+// Handles "api/account/user-inactivity" PUT requests, setting how many days the account's users may go without
+// a metric before the inactivity job deactivates them to free their seats, or 0 to never expire them. Should be
+// wrapped with WithSessionAuth, RequirePermission and WithAPIHeaders
+func (uiph *UserInactivityPutHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	session, err := uiph.sm.FromContext(r.Context())
+	if err != nil {
+		log.Println(err)
+		util.ErrorJSON(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	var body userInactivityPutRequestBody
+	if err := util.DecodeJSONBody(w, r, &body); err != nil {
+		util.HandleJSONdecodeError(w, err)
+		return
+	}
+	if body.InactivityDays != 0 && (body.InactivityDays < minInactivityDays || body.InactivityDays > maxInactivityDays) {
+		util.ErrorJSON(w, fmt.Sprintf("inactivityDays must be 0 or between %v and %v", minInactivityDays, maxInactivityDays), http.StatusBadRequest)
+		return
+	}
+
+	account, err := uiph.db.SetInactivityDays(session.Account.AccountID, body.InactivityDays, time.Now())
+	if err != nil {
+		log.Println(err)
+		util.ErrorJSON(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	uiph.sm.UpdateAccount(account)
+	detail := "off"
+	if account.InactivityDays > 0 {
+		detail = fmt.Sprintf("%v days", account.InactivityDays)
+	}
+	uiph.audit.Record(r, audit.Entry{AccountID: account.AccountID, Actor: session.Actor(), Action: model.AuditUserInactivity, Outcome: model.AuditSuccess, Detail: detail})
+
+	writeUserInactivity(w, uiph.db, account.AccountID)
+}
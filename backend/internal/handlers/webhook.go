@@ -0,0 +1,258 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"github.com/ibeckermayer/teleport-interview/backend/internal/auth"
+	"github.com/ibeckermayer/teleport-interview/backend/internal/database"
+	"github.com/ibeckermayer/teleport-interview/backend/internal/model"
+	"github.com/ibeckermayer/teleport-interview/backend/internal/util"
+	"github.com/ibeckermayer/teleport-interview/backend/internal/webhook"
+)
+
+// maxDeliveries is the number of recent deliveries returned by WebhookDeliveriesHandler
+const maxDeliveries = 100
+
+// createEvent queues an event for the account's webhooks. Failing to queue an event is logged
+// but shouldn't fail the request that caused it.
+func createEvent(db *database.Database, accountID string, eventType model.EventType, data interface{}) {
+	if err := db.CreateEvent(accountID, eventType, data); err != nil {
+		log.Printf("failed to create %v event for account_id=%v: %v", eventType, accountID, err)
+	}
+}
+
+type webhookJSON struct {
+	WebhookID string    `json:"webhookID"`
+	URL       string    `json:"url"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// WebhooksGetHandler handles GET calls to "api/webhooks"
+type WebhooksGetHandler struct {
+	sm *auth.SessionManager
+	db *database.Database
+}
+
+// NewWebhooksGetHandler creates a new WebhooksGetHandler
+func NewWebhooksGetHandler(sm *auth.SessionManager, db *database.Database) *WebhooksGetHandler {
+	return &WebhooksGetHandler{sm, db}
+}
+
+type webhooksGetResponseBody struct {
+	Webhooks []webhookJSON `json:"webhooks"`
+}
+
+// Handles "api/webhooks" GET requests. Should be wrapped with WithSessionAuth and WithAPIHeaders
+func (wgh *WebhooksGetHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	session, err := wgh.sm.FromContext(r.Context())
+	if err != nil {
+		log.Println(err)
+		util.ErrorJSON(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	hooks, err := wgh.db.GetWebhooks(session.Account.AccountID)
+	if err != nil {
+		log.Println(err)
+		util.ErrorJSON(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	respBody := webhooksGetResponseBody{Webhooks: make([]webhookJSON, 0, len(hooks))}
+	for _, hook := range hooks {
+		respBody.Webhooks = append(respBody.Webhooks, webhookJSON{hook.WebhookID, hook.URL, hook.CreatedAt})
+	}
+
+	if err := json.NewEncoder(w).Encode(respBody); err != nil {
+		log.Println(err)
+		return
+	}
+}
+
+// WebhooksPostHandler handles POST calls to "api/webhooks"
+type WebhooksPostHandler struct {
+	sm *auth.SessionManager
+	db *database.Database
+}
+
+// NewWebhooksPostHandler creates a new WebhooksPostHandler
+func NewWebhooksPostHandler(sm *auth.SessionManager, db *database.Database) *WebhooksPostHandler {
+	return &WebhooksPostHandler{sm, db}
+}
+
+type webhooksPostRequestBody struct {
+	URL string `json:"url"`
+}
+
+type webhooksPostResponseBody struct {
+	webhookJSON
+	Secret string `json:"secret"` // only ever returned here, the account must save it to verify signatures
+}
+
+// Handles "api/webhooks" POST requests, registering a new webhook. Should be wrapped with WithSessionAuth, RequirePermission
+// and WithAPIHeaders
+func (wph *WebhooksPostHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	session, err := wph.sm.FromContext(r.Context())
+	if err != nil {
+		log.Println(err)
+		util.ErrorJSON(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	var body webhooksPostRequestBody
+	if err := util.DecodeJSONBody(w, r, &body); err != nil {
+		util.HandleJSONdecodeError(w, err)
+		return
+	}
+
+	if err := webhook.ValidateURL(body.URL); err != nil {
+		log.Println(err)
+		util.ErrorJSON(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+
+	secret, err := auth.NewKey()
+	if err != nil {
+		log.Println(err)
+		util.ErrorJSON(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	hook, err := wph.db.CreateWebhook(session.Account.AccountID, body.URL, string(secret))
+	if err != nil {
+		log.Println(err)
+		util.ErrorJSON(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	respBody := webhooksPostResponseBody{webhookJSON{hook.WebhookID, hook.URL, hook.CreatedAt}, hook.Secret}
+	if err := json.NewEncoder(w).Encode(respBody); err != nil {
+		log.Println(err)
+		return
+	}
+}
+
+// WebhookDeleteHandler handles DELETE calls to "api/webhooks/{webhookID}"
+type WebhookDeleteHandler struct {
+	sm *auth.SessionManager
+	db *database.Database
+}
+
+// NewWebhookDeleteHandler creates a new WebhookDeleteHandler
+func NewWebhookDeleteHandler(sm *auth.SessionManager, db *database.Database) *WebhookDeleteHandler {
+	return &WebhookDeleteHandler{sm, db}
+}
+
+// Handles "api/webhooks/{webhookID}" DELETE requests. Should be wrapped with WithSessionAuth, RequirePermission and WithAPIHeaders
+func (wdh *WebhookDeleteHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	session, err := wdh.sm.FromContext(r.Context())
+	if err != nil {
+		log.Println(err)
+		util.ErrorJSON(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	deleted, err := wdh.db.DeleteWebhook(session.Account.AccountID, mux.Vars(r)["webhookID"])
+	if err != nil {
+		log.Println(err)
+		util.ErrorJSON(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	if !deleted {
+		util.ErrorJSON(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// WebhookDeliveriesHandler handles GET calls to "api/webhooks/deliveries"
+type WebhookDeliveriesHandler struct {
+	sm *auth.SessionManager
+	db *database.Database
+}
+
+// NewWebhookDeliveriesHandler creates a new WebhookDeliveriesHandler
+func NewWebhookDeliveriesHandler(sm *auth.SessionManager, db *database.Database) *WebhookDeliveriesHandler {
+	return &WebhookDeliveriesHandler{sm, db}
+}
+
+type webhookAttemptJSON struct {
+	StatusCode  int       `json:"statusCode"`
+	Error       string    `json:"error"`
+	AttemptedAt time.Time `json:"attemptedAt"`
+}
+
+type webhookDeliveryJSON struct {
+	DeliveryID    string               `json:"deliveryID"`
+	EventID       string               `json:"eventID"`
+	WebhookID     string               `json:"webhookID"`
+	EventType     model.EventType      `json:"eventType"`
+	Status        model.DeliveryStatus `json:"status"`
+	NextAttemptAt *time.Time           `json:"nextAttemptAt"` // null unless the delivery is pending
+	CreatedAt     time.Time            `json:"createdAt"`
+	DeliveredAt   *time.Time           `json:"deliveredAt"`
+	Attempts      []webhookAttemptJSON `json:"attempts"`
+}
+
+type webhookDeliveriesResponseBody struct {
+	Deliveries []webhookDeliveryJSON `json:"deliveries"`
+}
+
+// Handles "api/webhooks/deliveries" GET requests, returning the account's most recent deliveries and
+// every attempt made to send them. Should be wrapped with WithSessionAuth and WithAPIHeaders
+func (wdh *WebhookDeliveriesHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	session, err := wdh.sm.FromContext(r.Context())
+	if err != nil {
+		log.Println(err)
+		util.ErrorJSON(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	deliveries, err := wdh.db.GetDeliveries(session.Account.AccountID, maxDeliveries)
+	if err != nil {
+		log.Println(err)
+		util.ErrorJSON(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	respBody := webhookDeliveriesResponseBody{Deliveries: make([]webhookDeliveryJSON, 0, len(deliveries))}
+	for _, d := range deliveries {
+		attempts, err := wdh.db.GetWebhookAttempts(d.DeliveryID)
+		if err != nil {
+			log.Println(err)
+			util.ErrorJSON(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+
+		dj := webhookDeliveryJSON{
+			DeliveryID:  d.DeliveryID,
+			EventID:     d.EventID,
+			WebhookID:   d.WebhookID,
+			EventType:   d.EventType,
+			Status:      d.Status,
+			CreatedAt:   d.CreatedAt,
+			DeliveredAt: d.DeliveredAt,
+			Attempts:    make([]webhookAttemptJSON, 0, len(attempts)),
+		}
+		if d.Status == model.DeliveryPending {
+			next := d.NextAttemptAt
+			dj.NextAttemptAt = &next
+		}
+		for _, a := range attempts {
+			dj.Attempts = append(dj.Attempts, webhookAttemptJSON{a.StatusCode, a.Error, a.AttemptedAt})
+		}
+		respBody.Deliveries = append(respBody.Deliveries, dj)
+	}
+
+	if err := json.NewEncoder(w).Encode(respBody); err != nil {
+		log.Println(err)
+		return
+	}
+}
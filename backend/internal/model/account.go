@@ -20,21 +20,53 @@ var PlanMaxUsers = map[Plan]int{
 	ENTERPRISE: 1000,
 }
 
+// AccountStatus is where an account is in its lifecycle
+type AccountStatus string
+
+const (
+	// AccountActive accounts can be used as normal
+	AccountActive = AccountStatus("active")
+	// AccountSuspended accounts have been suspended by staff; their members can't log in and their devices
+	// can't send metrics, but nothing is deleted
+	AccountSuspended = AccountStatus("suspended")
+	// AccountPendingDeletion accounts are suspended and will be purged, along with all of their data, once
+	// DeleteAfter has passed
+	AccountPendingDeletion = AccountStatus("pending_deletion")
+)
+
 // AccountTableSQL is the SQL statement for creating a table corresponding to the Account model
 var AccountTableSQL = `CREATE TABLE IF NOT EXISTS account (
 	account_id CHARACTER(36) PRIMARY KEY,
 	plan VARCHAR(50) NOT NULL,
-	email VARCHAR(320) UNIQUE NOT NULL,
-	password_hash CHARACTER(60) NOT NULL,
 	created_at DATETIME NOT NULL,
-	updated_at DATETIME NOT NULL);`
+	updated_at DATETIME NOT NULL,
+	trial_ends_at DATETIME,
+	status VARCHAR(20) NOT NULL DEFAULT 'active',
+	delete_after DATETIME,
+	inactivity_days INTEGER NOT NULL DEFAULT 0);`
 
-// Account represents a row in the "account" table.
+// Account represents a row in the "account" table. The people who can log in to it are its Members.
+// Every other table with an account_id references it with ON DELETE CASCADE, except audit_event.
 type Account struct {
-	AccountID    string    `db:"account_id"`
-	Plan         Plan      `db:"plan"` // One of "FREE" or "ENTERPRISE"
-	Email        string    `db:"email"`
-	PasswordHash string    `db:"password_hash"`
-	CreatedAt    time.Time `db:"created_at"`
-	UpdatedAt    time.Time `db:"updated_at"`
+	AccountID   string        `db:"account_id"`
+	Plan        Plan          `db:"plan"` // One of "FREE" or "ENTERPRISE"
+	CreatedAt   time.Time     `db:"created_at"`
+	UpdatedAt   time.Time     `db:"updated_at"`
+	TrialEndsAt *time.Time    `db:"trial_ends_at"` // nil unless the account is on a trial of the ENTERPRISE plan
+	Status      AccountStatus `db:"status"`
+	DeleteAfter *time.Time    `db:"delete_after"` // nil unless Status is AccountPendingDeletion
+	// InactivityDays is how many days without a metric before an active user is deactivated to free its seat,
+	// 0 if users never expire
+	InactivityDays int `db:"inactivity_days"`
+}
+
+// OnTrial reports whether the account is currently on a trial of the ENTERPRISE plan
+func (a Account) OnTrial() bool {
+	return a.TrialEndsAt != nil
+}
+
+// Active reports whether the account is neither suspended nor pending deletion, so that its members can log
+// in and its devices can send metrics
+func (a Account) Active() bool {
+	return a.Status != AccountSuspended && a.Status != AccountPendingDeletion
 }
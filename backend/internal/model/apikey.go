@@ -3,10 +3,14 @@ package model
 // APIkeyTableSQL is the SQL statement for creating a table corresponding to the APIkey model
 var APIkeyTableSQL = `CREATE TABLE IF NOT EXISTS apikey (
 	key_hash CHARACTER(64) PRIMARY KEY,
-	account_id CHARACTER(36));`
+	account_id CHARACTER(36) REFERENCES account(account_id) ON DELETE CASCADE,
+	signing_key_id VARCHAR(24) NOT NULL DEFAULT '',
+	require_signed BOOLEAN NOT NULL DEFAULT 0);`
 
 // APIkey represents a row in the "apikey" table
 type APIkey struct {
-	KeyHash   string `db:"key_hash"`
-	AccountID string `db:"account_id"`
+	KeyHash       string `db:"key_hash"`
+	AccountID     string `db:"account_id"`
+	SigningKeyID  string `db:"signing_key_id"` // empty until a signing key is issued for the key
+	RequireSigned bool   `db:"require_signed"` // whether requests sending the key itself are refused
 }
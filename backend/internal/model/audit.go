@@ -0,0 +1,107 @@
+package model
+
+import "time"
+
+// AuditAction is the kind of attempt an AuditEvent records
+type AuditAction string
+
+const (
+	// AuditLogin is a login with a password or single sign-on, up to the second factor if the account needs one
+	AuditLogin = AuditAction("login")
+	// AuditLoginSecondFactor is the second step of a login to an account with two-factor authentication
+	AuditLoginSecondFactor = AuditAction("login.2fa")
+	// AuditLogout is a dashboard session being logged out
+	AuditLogout = AuditAction("logout")
+	// AuditSessionAuth is a request authenticated with a session token. Only rejected requests are recorded.
+	AuditSessionAuth = AuditAction("session.auth")
+	// AuditAPIkeyAuth is a request authenticated with an API key or signed with one. Only rejected requests are recorded.
+	AuditAPIkeyAuth = AuditAction("apikey.auth")
+	// AuditDeviceAuth is a request authenticated with a device certificate. Only rejected requests are recorded.
+	AuditDeviceAuth = AuditAction("device.auth")
+	// AuditUpgrade is an account upgrading its plan
+	AuditUpgrade = AuditAction("account.upgrade")
+	// AuditInvite is an owner inviting someone to join their account
+	AuditInvite = AuditAction("invite.create")
+	// AuditInviteRevoke is an owner revoking a pending invite
+	AuditInviteRevoke = AuditAction("invite.revoke")
+	// AuditInviteAccept is someone accepting an invite, which creates their member and logs them in
+	AuditInviteAccept = AuditAction("invite.accept")
+	// AuditUserExport is a member exporting everything stored about one of the account's users
+	AuditUserExport = AuditAction("user.export")
+	// AuditUserErase is a member erasing everything stored about one of the account's users
+	AuditUserErase = AuditAction("user.erase")
+	// AuditUserActivate is a member activating one of the account's users
+	AuditUserActivate = AuditAction("user.activate")
+	// AuditUserDeactivate is a member deactivating one of the account's users
+	AuditUserDeactivate = AuditAction("user.deactivate")
+	// AuditUserInactivity is a member changing how long the account's users may go unseen before they're expired
+	AuditUserInactivity = AuditAction("account.user_inactivity")
+	// AuditSigningKeyIssue is a member issuing a new signing key for the account's API key
+	AuditSigningKeyIssue = AuditAction("apikey.signing_key")
+	// AuditStaffAuth is a request to the admin API authenticated with a staff key. Only rejected requests are recorded.
+	AuditStaffAuth = AuditAction("staff.auth")
+	// AuditAdminPlanChange is staff forcing an account onto a plan
+	AuditAdminPlanChange = AuditAction("admin.plan")
+	// AuditAdminAPIkeyReset is staff replacing an account's API key
+	AuditAdminAPIkeyReset = AuditAction("admin.apikey.reset")
+	// AuditAdminSuspend is staff suspending an account
+	AuditAdminSuspend = AuditAction("admin.suspend")
+	// AuditAdminDelete is staff scheduling an account to be purged
+	AuditAdminDelete = AuditAction("admin.delete")
+	// AuditAdminReactivate is staff reactivating a suspended account, or one pending deletion
+	AuditAdminReactivate = AuditAction("admin.reactivate")
+	// AuditAccountPurge is an account pending deletion being purged along with all of its data, by the system
+	AuditAccountPurge = AuditAction("account.purge")
+	// AuditImpersonate is staff starting a session as one of an account's members
+	AuditImpersonate = AuditAction("admin.impersonate")
+	// AuditAdminUnlockLogin is staff forgetting the failed logins for an email address or client IP
+	AuditAdminUnlockLogin = AuditAction("admin.login.unlock")
+)
+
+// AuditOutcome is whether the attempt an AuditEvent records succeeded
+type AuditOutcome string
+
+const (
+	// AuditSuccess attempts succeeded
+	AuditSuccess = AuditOutcome("success")
+	// AuditFailure attempts were refused or failed
+	AuditFailure = AuditOutcome("failure")
+)
+
+// AuditEventTableSQL is the SQL statement for creating a table corresponding to the AuditEvent model. The
+// triggers stop events being changed or deleted through the app; the hash chain detects it if they're
+// changed any other way.
+var AuditEventTableSQL = `CREATE TABLE IF NOT EXISTS audit_event (
+	seq INTEGER PRIMARY KEY,
+	account_id CHARACTER(36) NOT NULL,
+	actor VARCHAR(320) NOT NULL,
+	action VARCHAR(32) NOT NULL,
+	outcome VARCHAR(16) NOT NULL,
+	detail VARCHAR(256) NOT NULL,
+	ip VARCHAR(64) NOT NULL,
+	user_agent VARCHAR(256) NOT NULL,
+	created_at DATETIME NOT NULL,
+	prev_hash CHARACTER(64) NOT NULL,
+	hash CHARACTER(64) NOT NULL);
+CREATE INDEX IF NOT EXISTS audit_event_account_seq ON audit_event (account_id, seq);
+CREATE TRIGGER IF NOT EXISTS audit_event_no_update BEFORE UPDATE ON audit_event
+	BEGIN SELECT RAISE(ABORT, 'audit_event is append-only'); END;
+CREATE TRIGGER IF NOT EXISTS audit_event_no_delete BEFORE DELETE ON audit_event
+	BEGIN SELECT RAISE(ABORT, 'audit_event is append-only'); END;`
+
+// AuditEvent represents a row in the "audit_event" table, a security relevant attempt to authenticate or
+// change an account. AccountID is empty if the attempt couldn't be tied to an account. Each event's Hash
+// covers its own fields and the previous event's hash, chaining every event to the ones before it.
+type AuditEvent struct {
+	Seq       int64        `db:"seq"`
+	AccountID string       `db:"account_id"`
+	Actor     string       `db:"actor"` // who made the attempt, e.g. an email address or "apikey:<hash prefix>"
+	Action    AuditAction  `db:"action"`
+	Outcome   AuditOutcome `db:"outcome"`
+	Detail    string       `db:"detail"`
+	IP        string       `db:"ip"`
+	UserAgent string       `db:"user_agent"`
+	CreatedAt time.Time    `db:"created_at"`
+	PrevHash  string       `db:"prev_hash"`
+	Hash      string       `db:"hash"`
+}
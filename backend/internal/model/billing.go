@@ -0,0 +1,85 @@
+package model
+
+import "time"
+
+// PlanUserMonthlyPriceCents is the price, in cents, of keeping one user active for a full month on each plan.
+// A plan at its user limit for a whole month therefore costs PlanMaxUsers[plan] * PlanUserMonthlyPriceCents[plan].
+var PlanUserMonthlyPriceCents = map[Plan]int64{
+	FREE:       0,
+	ENTERPRISE: 100,
+}
+
+// UsageSnapshotTableSQL is the SQL statement for creating a table corresponding to the UsageSnapshot model
+var UsageSnapshotTableSQL = `CREATE TABLE IF NOT EXISTS usage_snapshot (
+	account_id CHARACTER(36) NOT NULL REFERENCES account(account_id) ON DELETE CASCADE,
+	day CHARACTER(10) NOT NULL,
+	plan VARCHAR(50) NOT NULL,
+	on_trial INTEGER NOT NULL,
+	active_users INTEGER NOT NULL,
+	total_users INTEGER NOT NULL,
+	seen_users INTEGER NOT NULL,
+	created_at DATETIME NOT NULL,
+	PRIMARY KEY (account_id, day));`
+
+// UsageSnapshot represents a row in the "usage_snapshot" table, the billable state of an account on a given (UTC) day
+type UsageSnapshot struct {
+	AccountID   string    `db:"account_id"`
+	Day         string    `db:"day"` // formatted as DayFormat
+	Plan        Plan      `db:"plan"`
+	OnTrial     bool      `db:"on_trial"`
+	ActiveUsers int64     `db:"active_users"` // users with is_active set
+	TotalUsers  int64     `db:"total_users"`
+	SeenUsers   int64     `db:"seen_users"` // distinct users that sent a metric other than a failed login during the day
+	CreatedAt   time.Time `db:"created_at"`
+}
+
+// InvoiceTableSQL is the SQL statement for creating a table corresponding to the Invoice model
+var InvoiceTableSQL = `CREATE TABLE IF NOT EXISTS invoice (
+	invoice_id CHARACTER(36) PRIMARY KEY,
+	account_id CHARACTER(36) NOT NULL REFERENCES account(account_id) ON DELETE CASCADE,
+	period CHARACTER(7) NOT NULL,
+	total_cents INTEGER NOT NULL,
+	created_at DATETIME NOT NULL,
+	UNIQUE (account_id, period));`
+
+// Invoice represents a row in the "invoice" table, an account's bill for one calendar month
+type Invoice struct {
+	InvoiceID  string            `db:"invoice_id"`
+	AccountID  string            `db:"account_id"`
+	Period     string            `db:"period"` // formatted as PeriodFormat
+	TotalCents int64             `db:"total_cents"`
+	CreatedAt  time.Time         `db:"created_at"`
+	LineItems  []InvoiceLineItem `db:"-"`
+}
+
+// InvoiceLineItemTableSQL is the SQL statement for creating a table corresponding to the InvoiceLineItem model
+var InvoiceLineItemTableSQL = `CREATE TABLE IF NOT EXISTS invoice_line_item (
+	line_item_id CHARACTER(36) PRIMARY KEY,
+	invoice_id CHARACTER(36) NOT NULL REFERENCES invoice(invoice_id) ON DELETE CASCADE,
+	description VARCHAR(200) NOT NULL,
+	plan VARCHAR(50) NOT NULL,
+	on_trial INTEGER NOT NULL,
+	user_days INTEGER NOT NULL,
+	unit_price_cents INTEGER NOT NULL,
+	amount_cents INTEGER NOT NULL);`
+
+// InvoiceLineItem represents a row in the "invoice_line_item" table. UserDays is the sum of
+// active users over every day of the period spent on Plan, and UnitPriceCents is the price of one
+// active user for the full month.
+type InvoiceLineItem struct {
+	LineItemID     string `db:"line_item_id"`
+	InvoiceID      string `db:"invoice_id"`
+	Description    string `db:"description"`
+	Plan           Plan   `db:"plan"`
+	OnTrial        bool   `db:"on_trial"`
+	UserDays       int64  `db:"user_days"`
+	UnitPriceCents int64  `db:"unit_price_cents"`
+	AmountCents    int64  `db:"amount_cents"`
+}
+
+const (
+	// DayFormat is the time layout of UsageSnapshot.Day
+	DayFormat = "2006-01-02"
+	// PeriodFormat is the time layout of Invoice.Period
+	PeriodFormat = "2006-01"
+)
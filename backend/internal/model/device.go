@@ -0,0 +1,38 @@
+package model
+
+import "time"
+
+// DeviceCATableSQL is the SQL statement for creating a table corresponding to the DeviceCA model
+var DeviceCATableSQL = `CREATE TABLE IF NOT EXISTS device_ca (
+	account_id CHARACTER(36) PRIMARY KEY REFERENCES account(account_id) ON DELETE CASCADE,
+	cert_pem TEXT NOT NULL,
+	key_pem TEXT NOT NULL,
+	created_at DATETIME NOT NULL);`
+
+// DeviceCA represents a row in the "device_ca" table, the certificate authority an account's device
+// certificates are issued by. It's created the first time the account registers a device.
+type DeviceCA struct {
+	AccountID string    `db:"account_id"`
+	CertPEM   string    `db:"cert_pem"`
+	KeyPEM    string    `db:"key_pem"`
+	CreatedAt time.Time `db:"created_at"`
+}
+
+// DeviceTableSQL is the SQL statement for creating a table corresponding to the Device model
+var DeviceTableSQL = `CREATE TABLE IF NOT EXISTS device (
+	serial VARCHAR(32) PRIMARY KEY,
+	account_id CHARACTER(36) NOT NULL REFERENCES account(account_id) ON DELETE CASCADE,
+	name VARCHAR(64) NOT NULL,
+	created_at DATETIME NOT NULL,
+	expires_at DATETIME NOT NULL,
+	revoked_at DATETIME);`
+
+// Device represents a row in the "device" table, a client certificate issued to one of an account's devices
+type Device struct {
+	Serial    string     `db:"serial"` // the certificate's serial number, as lower case hex
+	AccountID string     `db:"account_id"`
+	Name      string     `db:"name"`
+	CreatedAt time.Time  `db:"created_at"`
+	ExpiresAt time.Time  `db:"expires_at"`
+	RevokedAt *time.Time `db:"revoked_at"`
+}
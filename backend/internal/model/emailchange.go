@@ -0,0 +1,23 @@
+package model
+
+import "time"
+
+// EmailChangeTableSQL is the SQL statement for creating a table corresponding to the EmailChange model
+var EmailChangeTableSQL = `CREATE TABLE IF NOT EXISTS email_change (
+	token_hash CHARACTER(64) PRIMARY KEY,
+	member_id CHARACTER(36) NOT NULL REFERENCES member(member_id) ON DELETE CASCADE,
+	new_email VARCHAR(320) NOT NULL,
+	created_at DATETIME NOT NULL,
+	expires_at DATETIME NOT NULL,
+	used_at DATETIME);`
+
+// EmailChange represents a row in the "email_change" table, a request to change a member's email address
+// that takes effect once the single-use token emailed to the new address is used. Only the token's hash is stored.
+type EmailChange struct {
+	TokenHash string     `db:"token_hash"`
+	MemberID  string     `db:"member_id"`
+	NewEmail  string     `db:"new_email"`
+	CreatedAt time.Time  `db:"created_at"`
+	ExpiresAt time.Time  `db:"expires_at"`
+	UsedAt    *time.Time `db:"used_at"` // nil until the token is used
+}
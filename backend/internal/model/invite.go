@@ -0,0 +1,31 @@
+package model
+
+import "time"
+
+// InviteTableSQL is the SQL statement for creating a table corresponding to the Invite model
+var InviteTableSQL = `CREATE TABLE IF NOT EXISTS invite (
+	invite_id CHARACTER(36) PRIMARY KEY,
+	account_id CHARACTER(36) NOT NULL REFERENCES account(account_id) ON DELETE CASCADE,
+	email VARCHAR(320) NOT NULL,
+	role VARCHAR(10) NOT NULL,
+	invited_by CHARACTER(36) NOT NULL,
+	created_at DATETIME NOT NULL,
+	expires_at DATETIME NOT NULL,
+	accepted_at DATETIME,
+	revoked_at DATETIME);
+CREATE INDEX IF NOT EXISTS invite_account_id ON invite (account_id);`
+
+// Invite represents a row in the "invite" table, an invitation emailed to someone to join an account as a
+// member with Role. It's pending until it's accepted, revoked or expires. The token in the emailed link is
+// signed rather than stored, see auth.InviteSigner.
+type Invite struct {
+	InviteID   string     `db:"invite_id"`
+	AccountID  string     `db:"account_id"`
+	Email      string     `db:"email"`
+	Role       Role       `db:"role"`
+	InvitedBy  string     `db:"invited_by"` // member_id of the owner who sent it
+	CreatedAt  time.Time  `db:"created_at"`
+	ExpiresAt  time.Time  `db:"expires_at"`
+	AcceptedAt *time.Time `db:"accepted_at"`
+	RevokedAt  *time.Time `db:"revoked_at"`
+}
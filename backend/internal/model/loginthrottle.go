@@ -0,0 +1,19 @@
+package model
+
+import "time"
+
+// LoginThrottleTableSQL is the SQL statement for creating a table corresponding to the LoginThrottle model
+var LoginThrottleTableSQL = `CREATE TABLE IF NOT EXISTS login_throttle (
+	throttle_key VARCHAR(400) PRIMARY KEY,
+	failures INTEGER NOT NULL,
+	last_failure_at DATETIME NOT NULL,
+	locked_until DATETIME);`
+
+// LoginThrottle represents a row in the "login_throttle" table, the recent failed login attempts for
+// one email address or client IP. Key is prefixed with "email:" or "ip:" accordingly.
+type LoginThrottle struct {
+	Key           string     `db:"throttle_key"`
+	Failures      int        `db:"failures"`
+	LastFailureAt time.Time  `db:"last_failure_at"`
+	LockedUntil   *time.Time `db:"locked_until"` // nil unless the key has been locked out
+}
@@ -0,0 +1,43 @@
+package model
+
+import "time"
+
+// Role is what a member is allowed to do in their account's dashboard
+type Role string
+
+const (
+	// RoleOwner can do everything, including managing the account's members
+	RoleOwner = Role("owner")
+	// RoleAdmin can do everything an owner can except manage members
+	RoleAdmin = Role("admin")
+	// RoleViewer can only view the account's dashboard
+	RoleViewer = Role("viewer")
+)
+
+// Valid reports whether r is one of the defined roles
+func (r Role) Valid() bool {
+	return r == RoleOwner || r == RoleAdmin || r == RoleViewer
+}
+
+// MemberTableSQL is the SQL statement for creating a table corresponding to the Member model
+var MemberTableSQL = `CREATE TABLE IF NOT EXISTS member (
+	member_id CHARACTER(36) PRIMARY KEY,
+	account_id CHARACTER(36) NOT NULL REFERENCES account(account_id) ON DELETE CASCADE,
+	email VARCHAR(320) UNIQUE NOT NULL,
+	password_hash CHARACTER(60) NOT NULL,
+	role VARCHAR(10) NOT NULL,
+	created_at DATETIME NOT NULL,
+	updated_at DATETIME NOT NULL);
+CREATE INDEX IF NOT EXISTS member_account_id ON member (account_id);`
+
+// Member represents a row in the "member" table, a person who can log in to an account's dashboard.
+// An account is created with a single owner.
+type Member struct {
+	MemberID     string    `db:"member_id"`
+	AccountID    string    `db:"account_id"`
+	Email        string    `db:"email"`
+	PasswordHash string    `db:"password_hash"`
+	Role         Role      `db:"role"` // One of "owner", "admin" or "viewer"
+	CreatedAt    time.Time `db:"created_at"`
+	UpdatedAt    time.Time `db:"updated_at"`
+}
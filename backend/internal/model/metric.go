@@ -1,19 +1,146 @@
 package model
 
-import "time"
+import (
+	"fmt"
+	"time"
+)
+
+// MetricEventType is the kind of event a metric records
+type MetricEventType string
+
+const (
+	// MetricLogin is a user logging in. Metrics sent without an event type are logins.
+	MetricLogin = MetricEventType("login")
+	// MetricLogout is a user logging out
+	MetricLogout = MetricEventType("logout")
+	// MetricLoginFailure is a failed attempt to log in as a user. It doesn't create the user or count as seeing it.
+	MetricLoginFailure = MetricEventType("login_failure")
+)
+
+// Valid reports whether t is one of the known event types
+func (t MetricEventType) Valid() bool {
+	_, ok := MetricAttributeSchemas[t]
+	return ok
+}
+
+// Seen reports whether an event of type t means its user was active
+func (t MetricEventType) Seen() bool {
+	return t != MetricLoginFailure
+}
+
+const (
+	// MaxMetricAttributes is the most attributes a metric can have
+	MaxMetricAttributes = 16
+	// MaxMetricAttributeKey and MaxMetricAttributeValue are the longest an attribute's name and string value can be
+	MaxMetricAttributeKey   = 64
+	MaxMetricAttributeValue = 256
+)
+
+// AttributeKind is the JSON type an attribute's value must have
+type AttributeKind string
+
+const (
+	// AttributeString values are JSON strings
+	AttributeString = AttributeKind("string")
+	// AttributeNumber values are JSON numbers
+	AttributeNumber = AttributeKind("number")
+	// AttributeBool values are JSON booleans
+	AttributeBool = AttributeKind("bool")
+)
+
+// AttributeSpec describes one attribute an event type knows about
+type AttributeSpec struct {
+	Kind     AttributeKind
+	Required bool
+}
+
+// MetricAttributeSchemas are the attributes each event type knows about. Known attributes must have the right
+// kind, and required ones must be present; any others are kept as long as they're strings, numbers or booleans.
+var MetricAttributeSchemas = map[MetricEventType]map[string]AttributeSpec{
+	MetricLogin: {
+		"method":         {Kind: AttributeString},
+		"client_version": {Kind: AttributeString},
+		"mfa":            {Kind: AttributeBool},
+	},
+	MetricLogout: {
+		"reason":          {Kind: AttributeString},
+		"session_seconds": {Kind: AttributeNumber},
+	},
+	MetricLoginFailure: {
+		"reason": {Kind: AttributeString, Required: true},
+		"method": {Kind: AttributeString},
+	},
+}
+
+// attributeKind returns the kind of a value decoded by encoding/json, or false if it isn't a scalar
+func attributeKind(v interface{}) (AttributeKind, bool) {
+	switch v.(type) {
+	case string:
+		return AttributeString, true
+	case float64:
+		return AttributeNumber, true
+	case bool:
+		return AttributeBool, true
+	}
+	return "", false
+}
+
+// ValidateAttributes checks attrs, as decoded by encoding/json, against t's schema and the size limits on
+// attributes. The error describes the first problem found and is safe to return to the client.
+func (t MetricEventType) ValidateAttributes(attrs map[string]interface{}) error {
+	schema, ok := MetricAttributeSchemas[t]
+	if !ok {
+		return fmt.Errorf("unknown event_type %q", t)
+	}
+	if len(attrs) > MaxMetricAttributes {
+		return fmt.Errorf("at most %v attributes are allowed", MaxMetricAttributes)
+	}
+	for name, v := range attrs {
+		if name == "" || len(name) > MaxMetricAttributeKey {
+			return fmt.Errorf("attribute names must be between 1 and %v characters", MaxMetricAttributeKey)
+		}
+		kind, ok := attributeKind(v)
+		if !ok {
+			return fmt.Errorf("attribute %q must be a string, number or boolean", name)
+		}
+		if s, isString := v.(string); isString && len(s) > MaxMetricAttributeValue {
+			return fmt.Errorf("attribute %q is longer than %v characters", name, MaxMetricAttributeValue)
+		}
+		if spec, known := schema[name]; known && spec.Kind != kind {
+			return fmt.Errorf("attribute %q of a %v event must be a %v", name, t, spec.Kind)
+		}
+	}
+	for name, spec := range schema {
+		if _, ok := attrs[name]; spec.Required && !ok {
+			return fmt.Errorf("a %v event requires the %q attribute", t, name)
+		}
+	}
+	return nil
+}
 
 // MetricTableSQL is the SQL statement for createing a table corresponding to the Metric model
 var MetricTableSQL = `CREATE TABLE IF NOT EXISTS metric (
 	metric_id CHARACTER(36) PRIMARY KEY,
-	account_id CHARACTER(36),
+	account_id CHARACTER(36) REFERENCES account(account_id) ON DELETE CASCADE,
 	user_id CHARACTER(36),
-	timestamp DATETIME
-);`
+	timestamp DATETIME,
+	event_type VARCHAR(20) NOT NULL DEFAULT 'login',
+	device_id VARCHAR(64) NOT NULL DEFAULT '',
+	ip VARCHAR(45) NOT NULL DEFAULT '',
+	attributes TEXT NOT NULL DEFAULT '{}'
+);
+CREATE INDEX IF NOT EXISTS metric_account_id ON metric (account_id);
+CREATE INDEX IF NOT EXISTS metric_account_event_type ON metric (account_id, event_type);
+CREATE INDEX IF NOT EXISTS metric_account_timestamp ON metric (account_id, timestamp);`
 
-// Metric represents a row in the "metric" table
+// Metric represents a row in the "metric" table, one event sent by one of an account's devices
 type Metric struct {
-	MetricID  string    `db:"metric_id"`
-	AccountID string    `db:"account_id"`
-	UserID    string    `db:"user_id"`
-	Timestamp time.Time `db:"timestamp"`
+	MetricID   string          `db:"metric_id"`
+	AccountID  string          `db:"account_id"`
+	UserID     string          `db:"user_id"`
+	Timestamp  time.Time       `db:"timestamp"`
+	EventType  MetricEventType `db:"event_type"`
+	DeviceID   string          `db:"device_id"`  // the sending device's own identifier, "" if it didn't send one
+	IP         string          `db:"ip"`         // the IP the event came from according to the device, "" if it didn't send one
+	Attributes string          `db:"attributes"` // a JSON object of the event's attributes, see MetricAttributeSchemas
 }
@@ -0,0 +1,21 @@
+package model
+
+import "time"
+
+// PasswordResetTableSQL is the SQL statement for creating a table corresponding to the PasswordReset model
+var PasswordResetTableSQL = `CREATE TABLE IF NOT EXISTS password_reset (
+	token_hash CHARACTER(64) PRIMARY KEY,
+	member_id CHARACTER(36) NOT NULL REFERENCES member(member_id) ON DELETE CASCADE,
+	created_at DATETIME NOT NULL,
+	expires_at DATETIME NOT NULL,
+	used_at DATETIME);`
+
+// PasswordReset represents a row in the "password_reset" table, a single-use token emailed to a member
+// that lets them set a new password without the old one. Only the token's hash is stored.
+type PasswordReset struct {
+	TokenHash string     `db:"token_hash"`
+	MemberID  string     `db:"member_id"`
+	CreatedAt time.Time  `db:"created_at"`
+	ExpiresAt time.Time  `db:"expires_at"`
+	UsedAt    *time.Time `db:"used_at"` // nil until the token is used
+}
@@ -0,0 +1,73 @@
+package model
+
+// Permission is something a member may be allowed to do in their account's dashboard, named "<resource>:<action>"
+type Permission string
+
+const (
+	// PermMetricsRead lets a member view the account's usage
+	PermMetricsRead = Permission("metrics:read")
+	// PermBillingRead lets a member view the account's plan history and invoices
+	PermBillingRead = Permission("billing:read")
+	// PermPlanChange lets a member change the account's plan
+	PermPlanChange = Permission("plan:change")
+	// PermAlertsRead lets a member view the account's usage alert thresholds
+	PermAlertsRead = Permission("alerts:read")
+	// PermAlertsManage lets a member change the account's usage alert thresholds
+	PermAlertsManage = Permission("alerts:manage")
+	// PermWebhooksRead lets a member view the account's webhooks and their deliveries
+	PermWebhooksRead = Permission("webhooks:read")
+	// PermWebhooksManage lets a member register and delete the account's webhooks
+	PermWebhooksManage = Permission("webhooks:manage")
+	// PermDevicesRead lets a member view the account's devices
+	PermDevicesRead = Permission("devices:read")
+	// PermKeysManage lets a member issue and revoke the credentials devices and API clients use, like device certificates
+	PermKeysManage = Permission("keys:manage")
+	// PermUsersManage lets a member export and erase what's stored about the account's users, activate or
+	// deactivate them, and set how long they may go unseen before they're expired
+	PermUsersManage = Permission("users:manage")
+	// PermAuditRead lets a member view the account's audit log
+	PermAuditRead = Permission("audit:read")
+	// PermMembersManage lets a member invite people to join the account and manage its members
+	PermMembersManage = Permission("members:manage")
+	// PermSelfManage lets a member change their own password, email address and two-factor authentication
+	PermSelfManage = Permission("self:manage")
+)
+
+// readPermissions are granted to every role
+var readPermissions = []Permission{PermMetricsRead, PermBillingRead, PermAlertsRead, PermWebhooksRead, PermDevicesRead, PermAuditRead}
+
+// selfPermissions are granted to every role, they only act on the calling member
+var selfPermissions = []Permission{PermSelfManage}
+
+// adminPermissions are granted to owners and admins
+var adminPermissions = []Permission{PermPlanChange, PermAlertsManage, PermWebhooksManage, PermKeysManage, PermUsersManage}
+
+// rolePermissions is the permissions granted to each role
+var rolePermissions = map[Role]map[Permission]bool{
+	RoleOwner:  permissionSet(readPermissions, selfPermissions, adminPermissions, []Permission{PermMembersManage}),
+	RoleAdmin:  permissionSet(readPermissions, selfPermissions, adminPermissions),
+	RoleViewer: permissionSet(readPermissions, selfPermissions),
+}
+
+// readOnly is the set of readPermissions, which are all that impersonated sessions may use
+var readOnly = permissionSet(readPermissions)
+
+func permissionSet(lists ...[]Permission) map[Permission]bool {
+	set := map[Permission]bool{}
+	for _, list := range lists {
+		for _, p := range list {
+			set[p] = true
+		}
+	}
+	return set
+}
+
+// Can reports whether r is granted p
+func (r Role) Can(p Permission) bool {
+	return rolePermissions[r][p]
+}
+
+// ReadOnly reports whether p only lets a member view the account, and never change anything
+func (p Permission) ReadOnly() bool {
+	return readOnly[p]
+}
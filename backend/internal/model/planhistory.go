@@ -0,0 +1,42 @@
+package model
+
+import "time"
+
+// PlanChangeReason describes why an account's plan was changed
+type PlanChangeReason string
+
+const (
+	// PlanChangeCreated is recorded when an account is created, with the plan it starts on and no OldPlan
+	PlanChangeCreated = PlanChangeReason("CREATED")
+	// PlanChangeUpgrade is recorded when an account owner upgrades through the dashboard
+	PlanChangeUpgrade = PlanChangeReason("UPGRADE")
+	// PlanChangeTrialExpired is recorded when an account's trial ends and it reverts to FREE
+	PlanChangeTrialExpired = PlanChangeReason("TRIAL_EXPIRED")
+	// PlanChangeAdmin is recorded when staff force a plan change through the admin API
+	PlanChangeAdmin = PlanChangeReason("ADMIN")
+)
+
+// PlanChangedBySystem is the ChangedBy value for plan changes made by the server itself rather than a person
+const PlanChangedBySystem = "system"
+
+// AccountPlanHistoryTableSQL is the SQL statement for creating a table corresponding to the AccountPlanHistory model
+var AccountPlanHistoryTableSQL = `CREATE TABLE IF NOT EXISTS account_plan_history (
+	plan_history_id CHARACTER(36) PRIMARY KEY,
+	account_id CHARACTER(36) NOT NULL REFERENCES account(account_id) ON DELETE CASCADE,
+	old_plan VARCHAR(50) NOT NULL,
+	new_plan VARCHAR(50) NOT NULL,
+	reason VARCHAR(50) NOT NULL,
+	changed_by VARCHAR(320) NOT NULL,
+	changed_at DATETIME NOT NULL);`
+
+// AccountPlanHistory represents a row in the "account_plan_history" table. Rows are only ever
+// inserted, in the same transaction as the plan change they record.
+type AccountPlanHistory struct {
+	PlanHistoryID string           `db:"plan_history_id"`
+	AccountID     string           `db:"account_id"`
+	OldPlan       Plan             `db:"old_plan"`
+	NewPlan       Plan             `db:"new_plan"`
+	Reason        PlanChangeReason `db:"reason"`
+	ChangedBy     string           `db:"changed_by"` // email of the member or "staff:<email>" that made the change, or PlanChangedBySystem
+	ChangedAt     time.Time        `db:"changed_at"`
+}
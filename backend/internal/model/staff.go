@@ -0,0 +1,19 @@
+package model
+
+import "time"
+
+// StaffTableSQL is the SQL statement for creating a table corresponding to the Staff model
+var StaffTableSQL = `CREATE TABLE IF NOT EXISTS staff (
+	staff_id CHARACTER(36) PRIMARY KEY,
+	email VARCHAR(320) UNIQUE NOT NULL,
+	key_hash CHARACTER(64) UNIQUE NOT NULL,
+	created_at DATETIME NOT NULL);`
+
+// Staff represents a row in the "staff" table, one of our support staff who can call the admin API with their
+// key. Staff aren't members of any account, and their keys are never accepted by the customer API.
+type Staff struct {
+	StaffID   string    `db:"staff_id"`
+	Email     string    `db:"email"`
+	KeyHash   string    `db:"key_hash"`
+	CreatedAt time.Time `db:"created_at"`
+}
@@ -0,0 +1,41 @@
+package model
+
+import "time"
+
+// MemberTOTPTableSQL is the SQL statement for creating a table corresponding to the MemberTOTP model
+var MemberTOTPTableSQL = `CREATE TABLE IF NOT EXISTS member_totp (
+	member_id CHARACTER(36) PRIMARY KEY REFERENCES member(member_id) ON DELETE CASCADE,
+	secret VARCHAR(64) NOT NULL,
+	enabled BOOLEAN NOT NULL,
+	last_used_step INTEGER NOT NULL,
+	created_at DATETIME NOT NULL,
+	enabled_at DATETIME);`
+
+// MemberTOTP represents a row in the "member_totp" table, a member's TOTP two-factor authentication
+// secret. The secret is pending until the member verifies its first code, after which Enabled is set
+// and logins require a code.
+type MemberTOTP struct {
+	MemberID     string     `db:"member_id"`
+	Secret       string     `db:"secret"` // base32 encoded, as shown to authenticator apps
+	Enabled      bool       `db:"enabled"`
+	LastUsedStep int64      `db:"last_used_step"` // time step of the last accepted code, so codes can't be replayed
+	CreatedAt    time.Time  `db:"created_at"`
+	EnabledAt    *time.Time `db:"enabled_at"`
+}
+
+// RecoveryCodeTableSQL is the SQL statement for creating a table corresponding to the RecoveryCode model
+var RecoveryCodeTableSQL = `CREATE TABLE IF NOT EXISTS recovery_code (
+	member_id CHARACTER(36) NOT NULL REFERENCES member(member_id) ON DELETE CASCADE,
+	code_hash CHARACTER(64) NOT NULL,
+	created_at DATETIME NOT NULL,
+	used_at DATETIME,
+	PRIMARY KEY (member_id, code_hash));`
+
+// RecoveryCode represents a row in the "recovery_code" table, a single-use code that can stand in for a
+// TOTP code when logging in. Only the code's hash is stored.
+type RecoveryCode struct {
+	MemberID  string     `db:"member_id"`
+	CodeHash  string     `db:"code_hash"`
+	CreatedAt time.Time  `db:"created_at"`
+	UsedAt    *time.Time `db:"used_at"` // nil until the code is used
+}
@@ -0,0 +1,24 @@
+package model
+
+import "time"
+
+// DefaultUsageAlertPercents are the usage alert thresholds new accounts start with
+var DefaultUsageAlertPercents = []int{80, 100}
+
+// UsageAlertTableSQL is the SQL statement for creating a table corresponding to the UsageAlert model
+var UsageAlertTableSQL = `CREATE TABLE IF NOT EXISTS usage_alert (
+	account_id CHARACTER(36) NOT NULL REFERENCES account(account_id) ON DELETE CASCADE,
+	percent INTEGER NOT NULL,
+	fired INTEGER NOT NULL,
+	fired_at DATETIME,
+	PRIMARY KEY (account_id, percent));`
+
+// UsageAlert represents a row in the "usage_alert" table, a threshold (as a percentage of PlanMaxUsers)
+// at which the account is notified about its number of users. Fired is set when usage crosses the
+// threshold and cleared when it drops back below, so that each crossing notifies only once.
+type UsageAlert struct {
+	AccountID string     `db:"account_id"`
+	Percent   int        `db:"percent"`
+	Fired     bool       `db:"fired"`
+	FiredAt   *time.Time `db:"fired_at"`
+}
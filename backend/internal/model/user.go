@@ -5,11 +5,14 @@ import "time"
 // UserTableSQL is the SQL statement for createing a table corresponding to the User model
 var UserTableSQL = `CREATE TABLE IF NOT EXISTS user (
 	user_id CHARACTER(36) PRIMARY KEY,
-	account_id CHARACTER(36),
+	account_id CHARACTER(36) REFERENCES account(account_id) ON DELETE CASCADE,
 	is_active INTEGER,
 	created_at DATETIME,
-	updated_at DATETIME
-);`
+	updated_at DATETIME,
+	deactivated_at DATETIME,
+	last_seen_at DATETIME
+);
+CREATE INDEX IF NOT EXISTS user_account_id ON user (account_id);`
 
 // User represents a row in the "user" table
 type User struct {
@@ -18,4 +21,9 @@ type User struct {
 	IsActive  bool      `db:"is_active"`
 	CreatedAt time.Time `db:"created_at"`
 	UpdatedAt time.Time `db:"updated_at"`
+	// DeactivatedAt is when a member deactivated the user, nil if they haven't. Such users aren't promoted
+	// when a seat frees up, only a member can activate them again.
+	DeactivatedAt *time.Time `db:"deactivated_at"`
+	// LastSeenAt is when a metric for the user was last received, nil for users created before it was tracked
+	LastSeenAt *time.Time `db:"last_seen_at"`
 }
@@ -0,0 +1,35 @@
+package model
+
+import "time"
+
+// UserExpiryAction is what the inactivity job did to a user
+type UserExpiryAction string
+
+const (
+	// UserExpired users were deactivated for going longer than the account's InactivityDays without a metric
+	UserExpired = UserExpiryAction("expired")
+	// UserPromoted users were waiting for a seat and took a free one, usually an expired user's, when the job ran
+	// or when a metric for an expired user put it back in line
+	UserPromoted = UserExpiryAction("promoted")
+)
+
+// UserExpiryTableSQL is the SQL statement for creating a table corresponding to the UserExpiry model
+var UserExpiryTableSQL = `CREATE TABLE IF NOT EXISTS user_expiry (
+	user_expiry_id CHARACTER(36) PRIMARY KEY,
+	account_id CHARACTER(36) NOT NULL REFERENCES account(account_id) ON DELETE CASCADE,
+	user_id CHARACTER(36) NOT NULL,
+	action VARCHAR(20) NOT NULL,
+	last_seen_at DATETIME,
+	created_at DATETIME NOT NULL);
+CREATE INDEX IF NOT EXISTS user_expiry_account_id ON user_expiry (account_id, created_at);`
+
+// UserExpiry represents a row in the "user_expiry" table, one change the inactivity job made to a user's seat,
+// kept so that the account can review what the job did
+type UserExpiry struct {
+	UserExpiryID string           `db:"user_expiry_id"`
+	AccountID    string           `db:"account_id"`
+	UserID       string           `db:"user_id"`
+	Action       UserExpiryAction `db:"action"`
+	LastSeenAt   *time.Time       `db:"last_seen_at"` // when the user was last seen at the time
+	CreatedAt    time.Time        `db:"created_at"`
+}
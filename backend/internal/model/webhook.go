@@ -0,0 +1,95 @@
+package model
+
+import "time"
+
+// EventType is the type of an event delivered to webhooks
+type EventType string
+
+const (
+	// EventUserCreated is sent when a metric arrives for a user_id the account hasn't seen before
+	EventUserCreated = EventType("user.created")
+	// EventUserLimitReached is sent when an account's user count reaches its plan's limit
+	EventUserLimitReached = EventType("user.limit_reached")
+	// EventAccountUpgraded is sent when an account upgrades its plan
+	EventAccountUpgraded = EventType("account.upgraded")
+)
+
+// DeliveryStatus is the state of a WebhookDelivery
+type DeliveryStatus string
+
+const (
+	// DeliveryPending deliveries are waiting for their first or next attempt
+	DeliveryPending = DeliveryStatus("PENDING")
+	// DeliveryDelivered deliveries were acknowledged with a 2xx response
+	DeliveryDelivered = DeliveryStatus("DELIVERED")
+	// DeliveryFailed deliveries ran out of attempts and will not be retried
+	DeliveryFailed = DeliveryStatus("FAILED")
+)
+
+// WebhookTableSQL is the SQL statement for creating a table corresponding to the Webhook model
+var WebhookTableSQL = `CREATE TABLE IF NOT EXISTS webhook (
+	webhook_id CHARACTER(36) PRIMARY KEY,
+	account_id CHARACTER(36) NOT NULL REFERENCES account(account_id) ON DELETE CASCADE,
+	url VARCHAR(2048) NOT NULL,
+	secret VARCHAR(64) NOT NULL,
+	created_at DATETIME NOT NULL);`
+
+// Webhook represents a row in the "webhook" table, a URL an account has registered to receive events.
+// Secret is the key used to sign the events sent to URL, and is only ever shown to the account once.
+type Webhook struct {
+	WebhookID string    `db:"webhook_id"`
+	AccountID string    `db:"account_id"`
+	URL       string    `db:"url"`
+	Secret    string    `db:"secret"`
+	CreatedAt time.Time `db:"created_at"`
+}
+
+// WebhookDeliveryTableSQL is the SQL statement for creating a table corresponding to the WebhookDelivery model
+var WebhookDeliveryTableSQL = `CREATE TABLE IF NOT EXISTS webhook_delivery (
+	delivery_id CHARACTER(36) PRIMARY KEY,
+	event_id CHARACTER(36) NOT NULL,
+	webhook_id CHARACTER(36) NOT NULL,
+	account_id CHARACTER(36) NOT NULL REFERENCES account(account_id) ON DELETE CASCADE,
+	event_type VARCHAR(50) NOT NULL,
+	payload TEXT NOT NULL,
+	status VARCHAR(20) NOT NULL,
+	attempts INTEGER NOT NULL,
+	next_attempt_at DATETIME NOT NULL,
+	last_error TEXT NOT NULL,
+	created_at DATETIME NOT NULL,
+	delivered_at DATETIME);`
+
+// WebhookDelivery represents a row in the "webhook_delivery" table, the outbox of events waiting to be
+// (or already) sent to a webhook. One event produces one delivery for each of the account's webhooks.
+type WebhookDelivery struct {
+	DeliveryID    string         `db:"delivery_id"`
+	EventID       string         `db:"event_id"`
+	WebhookID     string         `db:"webhook_id"`
+	AccountID     string         `db:"account_id"`
+	EventType     EventType      `db:"event_type"`
+	Payload       string         `db:"payload"` // the JSON body sent to the webhook
+	Status        DeliveryStatus `db:"status"`
+	Attempts      int            `db:"attempts"`
+	NextAttemptAt time.Time      `db:"next_attempt_at"`
+	LastError     string         `db:"last_error"`
+	CreatedAt     time.Time      `db:"created_at"`
+	DeliveredAt   *time.Time     `db:"delivered_at"`
+}
+
+// WebhookAttemptTableSQL is the SQL statement for creating a table corresponding to the WebhookAttempt model
+var WebhookAttemptTableSQL = `CREATE TABLE IF NOT EXISTS webhook_attempt (
+	attempt_id CHARACTER(36) PRIMARY KEY,
+	delivery_id CHARACTER(36) NOT NULL REFERENCES webhook_delivery(delivery_id) ON DELETE CASCADE,
+	status_code INTEGER NOT NULL,
+	error TEXT NOT NULL,
+	attempted_at DATETIME NOT NULL);
+CREATE INDEX IF NOT EXISTS webhook_attempt_delivery_id ON webhook_attempt (delivery_id);`
+
+// WebhookAttempt represents a row in the "webhook_attempt" table, the log of every attempt to send a WebhookDelivery
+type WebhookAttempt struct {
+	AttemptID   string    `db:"attempt_id"`
+	DeliveryID  string    `db:"delivery_id"`
+	StatusCode  int       `db:"status_code"` // 0 if no response was received
+	Error       string    `db:"error"`
+	AttemptedAt time.Time `db:"attempted_at"`
+}
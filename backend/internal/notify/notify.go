@@ -0,0 +1,117 @@
+// Package notify sends plain text notifications (emails) to people. The Notifier interface lets
+// deployments choose how: over SMTP in production, or to a file or just the log for local development.
+package notify
+
+import (
+	"fmt"
+	"log"
+	"net/smtp"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Notification is a plain text message addressed to a single email address
+type Notification struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Notifier delivers Notifications
+type Notifier interface {
+	Notify(n Notification) error
+}
+
+// LogNotifier "delivers" notifications by writing them to the log
+type LogNotifier struct{}
+
+// NewLogNotifier creates a new *LogNotifier
+func NewLogNotifier() *LogNotifier {
+	return &LogNotifier{}
+}
+
+// Notify logs n
+func (ln *LogNotifier) Notify(n Notification) error {
+	log.Printf("notification to=%v subject=%q body=%q", n.To, n.Subject, n.Body)
+	return nil
+}
+
+// FileNotifier "delivers" notifications by appending them to a file, so that links in them
+// (like password resets) can be followed during local development
+type FileNotifier struct {
+	path string
+	mtx  sync.Mutex // keeps concurrent notifications from interleaving
+}
+
+// NewFileNotifier creates a new *FileNotifier that appends to the file at path, creating it if needed
+func NewFileNotifier(path string) *FileNotifier {
+	return &FileNotifier{path: path}
+}
+
+// Notify appends n to the file
+func (fn *FileNotifier) Notify(n Notification) error {
+	fn.mtx.Lock()
+	defer fn.mtx.Unlock()
+
+	f, err := os.OpenFile(fn.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(f, "To: %v\nSubject: %v\nDate: %v\n\n%v\n\n", n.To, n.Subject, time.Now().Format(time.RFC1123Z), n.Body)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// SMTPConfig configures an SMTPNotifier. Username and Password may be empty if the
+// server doesn't require authentication.
+type SMTPConfig struct {
+	Addr     string // host:port of the SMTP server
+	From     string // address notifications are sent from
+	Username string
+	Password string
+}
+
+// SMTPNotifier delivers notifications as emails through an SMTP server
+type SMTPNotifier struct {
+	cfg SMTPConfig
+}
+
+// NewSMTPNotifier creates a new *SMTPNotifier
+func NewSMTPNotifier(cfg SMTPConfig) *SMTPNotifier {
+	return &SMTPNotifier{cfg}
+}
+
+// stripNewlines prevents header injection through values that end up in email headers
+func stripNewlines(s string) string {
+	return strings.NewReplacer("\r", "", "\n", "").Replace(s)
+}
+
+// Notify sends n as a plain text email
+func (sn *SMTPNotifier) Notify(n Notification) error {
+	var auth smtp.Auth
+	if sn.cfg.Username != "" {
+		host := sn.cfg.Addr
+		if i := strings.LastIndex(host, ":"); i >= 0 {
+			host = host[:i]
+		}
+		auth = smtp.PlainAuth("", sn.cfg.Username, sn.cfg.Password, host)
+	}
+
+	to := stripNewlines(n.To)
+	var msg strings.Builder
+	fmt.Fprintf(&msg, "From: %v\r\n", stripNewlines(sn.cfg.From))
+	fmt.Fprintf(&msg, "To: %v\r\n", to)
+	fmt.Fprintf(&msg, "Subject: %v\r\n", stripNewlines(n.Subject))
+	fmt.Fprintf(&msg, "Date: %v\r\n", time.Now().Format(time.RFC1123Z))
+	msg.WriteString("MIME-Version: 1.0\r\n")
+	msg.WriteString("Content-Type: text/plain; charset=UTF-8\r\n")
+	msg.WriteString("\r\n")
+	msg.WriteString(strings.ReplaceAll(n.Body, "\n", "\r\n"))
+	msg.WriteString("\r\n")
+
+	return smtp.SendMail(sn.cfg.Addr, auth, sn.cfg.From, []string{to}, []byte(msg.String()))
+}
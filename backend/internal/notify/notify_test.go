@@ -0,0 +1,130 @@
+package notify
+
+import (
+	"io/ioutil"
+	"net"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// fakeSMTPServer is a minimal SMTP server that accepts a single message and sends its
+// envelope recipient and data on the returned channels
+func fakeSMTPServer(t *testing.T) (string, chan string, chan string) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rcpts, datas := make(chan string, 1), make(chan string, 1)
+
+	go func() {
+		defer ln.Close()
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		tc := textproto.NewConn(conn)
+		defer tc.Close()
+
+		tc.PrintfLine("220 localhost fake SMTP")
+		for {
+			line, err := tc.ReadLine()
+			if err != nil {
+				return
+			}
+			cmd := strings.ToUpper(strings.Fields(line + " ")[0])
+			switch cmd {
+			case "EHLO", "HELO":
+				tc.PrintfLine("250 localhost")
+			case "MAIL":
+				tc.PrintfLine("250 OK")
+			case "RCPT":
+				rcpts <- line
+				tc.PrintfLine("250 OK")
+			case "DATA":
+				tc.PrintfLine("354 send data")
+				data, err := tc.ReadDotBytes()
+				if err != nil {
+					return
+				}
+				datas <- string(data)
+				tc.PrintfLine("250 OK")
+			case "QUIT":
+				tc.PrintfLine("221 bye")
+				return
+			default:
+				tc.PrintfLine("502 not implemented")
+			}
+		}
+	}()
+
+	return ln.Addr().String(), rcpts, datas
+}
+
+func TestSMTPNotifier(t *testing.T) {
+	addr, rcpts, datas := fakeSMTPServer(t)
+	n := NewSMTPNotifier(SMTPConfig{Addr: addr, From: "noreply@localhost"})
+
+	err := n.Notify(Notification{To: "dev@goteleport.com", Subject: "Subject", Body: "line one\nline two"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if rcpt := <-rcpts; !strings.Contains(rcpt, "<dev@goteleport.com>") {
+		t.Fatalf("unexpected RCPT command %q", rcpt)
+	}
+	data := <-datas
+	for _, want := range []string{"To: dev@goteleport.com\n", "Subject: Subject\n", "line one\nline two"} {
+		if !strings.Contains(data, want) {
+			t.Fatalf("expected message to contain %q but got %q", want, data)
+		}
+	}
+}
+
+func TestSMTPNotifierHeaderInjection(t *testing.T) {
+	addr, _, datas := fakeSMTPServer(t)
+	n := NewSMTPNotifier(SMTPConfig{Addr: addr, From: "noreply@localhost"})
+
+	err := n.Notify(Notification{To: "dev@goteleport.com", Subject: "Subject\r\nBcc: attacker@example.com", Body: "body"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if data := <-datas; strings.Contains(data, "\nBcc:") {
+		t.Fatalf("subject injected a header into %q", data)
+	}
+}
+
+func TestLogNotifier(t *testing.T) {
+	if err := NewLogNotifier().Notify(Notification{To: "dev@goteleport.com", Subject: "Subject", Body: "body"}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFileNotifier(t *testing.T) {
+	dir, err := ioutil.TempDir("", "notify")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "notifications.txt")
+	n := NewFileNotifier(path)
+
+	for _, subject := range []string{"First", "Second"} {
+		if err := n.Notify(Notification{To: "dev@goteleport.com", Subject: subject, Body: "body"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"To: dev@goteleport.com\n", "Subject: First\n", "Subject: Second\n", "\n\nbody\n"} {
+		if !strings.Contains(string(b), want) {
+			t.Fatalf("expected file to contain %q but got %q", want, b)
+		}
+	}
+}
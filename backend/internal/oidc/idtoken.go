@@ -0,0 +1,245 @@
+package oidc
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+)
+
+const (
+	// clockSkew is how far the IdP's clock may be from ours when checking an ID token's times
+	clockSkew = time.Minute
+
+	// keyRefreshInterval is how often the IdP's signing keys may be refetched when an ID token is signed
+	// with a key that isn't cached, so that tokens with made up key IDs can't be used to hammer the IdP
+	keyRefreshInterval = time.Minute
+)
+
+// ErrInvalidIDToken is returned by VerifyIDToken when an ID token is malformed, isn't signed by the IdP,
+// or wasn't issued to the app for this login
+var ErrInvalidIDToken = errors.New("invalid ID token")
+
+// Claims are the ID token claims (https://openid.net/specs/openid-connect-core-1_0.html#IDToken)
+// the app uses
+type Claims struct {
+	Issuer        string   `json:"iss"`
+	Subject       string   `json:"sub"`
+	Audience      audience `json:"aud"`
+	AuthorizedBy  string   `json:"azp"`
+	Expires       int64    `json:"exp"`
+	IssuedAt      int64    `json:"iat"`
+	Nonce         string   `json:"nonce"`
+	Email         string   `json:"email"`
+	EmailVerified boolish  `json:"email_verified"`
+}
+
+// audience is a JWT "aud" claim, which can be a single string or an array of them
+type audience []string
+
+func (a *audience) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err == nil {
+		*a = audience{s}
+		return nil
+	}
+	var ss []string
+	if err := json.Unmarshal(b, &ss); err != nil {
+		return err
+	}
+	*a = audience(ss)
+	return nil
+}
+
+func (a audience) contains(s string) bool {
+	for _, aud := range a {
+		if aud == s {
+			return true
+		}
+	}
+	return false
+}
+
+// boolish is a boolean claim, which some IdPs send as the string "true" or "false"
+type boolish bool
+
+func (b *boolish) UnmarshalJSON(data []byte) error {
+	var v bool
+	if err := json.Unmarshal(data, &v); err == nil {
+		*b = boolish(v)
+		return nil
+	}
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	*b = boolish(s == "true")
+	return nil
+}
+
+type jwtHeader struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+}
+
+// VerifyIDToken checks that rawIDToken is signed by one of the IdP's keys, was issued by the IdP to the app
+// for the login with nonce, and hasn't expired, and returns its claims
+func (p *Provider) VerifyIDToken(rawIDToken, nonce string, now time.Time) (Claims, error) {
+	parts := strings.Split(rawIDToken, ".")
+	if len(parts) != 3 {
+		return Claims{}, ErrInvalidIDToken
+	}
+	var header jwtHeader
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return Claims{}, ErrInvalidIDToken
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return Claims{}, ErrInvalidIDToken
+	}
+
+	key, err := p.signingKey(header.Kid, now)
+	if err != nil {
+		return Claims{}, err
+	}
+	if !verifySignature(header.Alg, key, parts[0]+"."+parts[1], sig) {
+		return Claims{}, ErrInvalidIDToken
+	}
+
+	var claims Claims
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return Claims{}, ErrInvalidIDToken
+	}
+	if claims.Issuer != p.meta.Issuer {
+		return Claims{}, fmt.Errorf("%w: issued by %q", ErrInvalidIDToken, claims.Issuer)
+	}
+	if !claims.Audience.contains(p.cfg.ClientID) || (len(claims.Audience) > 1 && claims.AuthorizedBy != p.cfg.ClientID) {
+		return Claims{}, fmt.Errorf("%w: issued to %v", ErrInvalidIDToken, claims.Audience)
+	}
+	if now.After(time.Unix(claims.Expires, 0).Add(clockSkew)) {
+		return Claims{}, fmt.Errorf("%w: expired", ErrInvalidIDToken)
+	}
+	if time.Unix(claims.IssuedAt, 0).After(now.Add(clockSkew)) {
+		return Claims{}, fmt.Errorf("%w: issued in the future", ErrInvalidIDToken)
+	}
+	if subtle.ConstantTimeCompare([]byte(claims.Nonce), []byte(nonce)) != 1 {
+		return Claims{}, fmt.Errorf("%w: nonce doesn't match", ErrInvalidIDToken)
+	}
+	return claims, nil
+}
+
+func decodeSegment(seg string, dst interface{}) error {
+	b, err := base64.RawURLEncoding.DecodeString(seg)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, dst)
+}
+
+// verifySignature checks a JWS signature (https://tools.ietf.org/html/rfc7518#section-3). Only RS256 and ES256
+// are accepted; in particular "none" and the HMAC algorithms, which an attacker could use to forge tokens
+// signed with a public key, never are.
+func verifySignature(alg string, key interface{}, signingInput string, sig []byte) bool {
+	sum := sha256.Sum256([]byte(signingInput))
+	switch alg {
+	case "RS256":
+		pub, ok := key.(*rsa.PublicKey)
+		return ok && rsa.VerifyPKCS1v15(pub, crypto.SHA256, sum[:], sig) == nil
+	case "ES256":
+		pub, ok := key.(*ecdsa.PublicKey)
+		if !ok || pub.Curve != elliptic.P256() || len(sig) != 64 {
+			return false
+		}
+		r := new(big.Int).SetBytes(sig[:32])
+		s := new(big.Int).SetBytes(sig[32:])
+		return ecdsa.Verify(pub, sum[:], r, s)
+	default:
+		return false
+	}
+}
+
+// signingKey returns the IdP's signing key with ID kid, fetching the IdP's keys if it isn't cached
+// (e.g. because the IdP rotated its keys)
+func (p *Provider) signingKey(kid string, now time.Time) (interface{}, error) {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+
+	if key, ok := p.keys[kid]; ok {
+		return key, nil
+	}
+	if p.keys != nil && now.Sub(p.keysFetched) < keyRefreshInterval {
+		return nil, fmt.Errorf("%w: unknown key ID %q", ErrInvalidIDToken, kid)
+	}
+
+	keys, err := p.fetchKeys()
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch IdP signing keys: %v", err)
+	}
+	p.keys = keys
+	p.keysFetched = now
+
+	if key, ok := p.keys[kid]; ok {
+		return key, nil
+	}
+	return nil, fmt.Errorf("%w: unknown key ID %q", ErrInvalidIDToken, kid)
+}
+
+// jwk is a JSON Web Key (https://tools.ietf.org/html/rfc7517), with the fields of RSA and EC public keys
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// fetchKeys fetches the IdP's JSON Web Key Set, skipping keys that aren't for signatures or have
+// types the app doesn't support
+func (p *Provider) fetchKeys() (map[string]interface{}, error) {
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := getJSON(p.client, p.meta.JWKSURI, &set); err != nil {
+		return nil, err
+	}
+
+	keys := make(map[string]interface{})
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		switch k.Kty {
+		case "RSA":
+			n, errN := base64.RawURLEncoding.DecodeString(k.N)
+			e, errE := base64.RawURLEncoding.DecodeString(k.E)
+			if errN != nil || errE != nil || len(e) > 4 {
+				continue
+			}
+			keys[k.Kid] = &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+		case "EC":
+			x, errX := base64.RawURLEncoding.DecodeString(k.X)
+			y, errY := base64.RawURLEncoding.DecodeString(k.Y)
+			if k.Crv != "P-256" || errX != nil || errY != nil {
+				continue
+			}
+			pub := &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+			if !pub.Curve.IsOnCurve(pub.X, pub.Y) {
+				continue
+			}
+			keys[k.Kid] = pub
+		}
+	}
+	return keys, nil
+}
@@ -0,0 +1,166 @@
+// Package oidc implements the client side of OpenID Connect's authorization code flow with PKCE
+// (https://openid.net/specs/openid-connect-core-1_0.html#CodeFlowAuth, https://tools.ietf.org/html/rfc7636),
+// for logging into accounts with an external identity provider (IdP).
+package oidc
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Config is the app's registration as a client of an IdP
+type Config struct {
+	Issuer       string // the IdP's issuer URL, which its discovery document is found under
+	ClientID     string
+	ClientSecret string // empty for public clients, which rely on PKCE alone
+	RedirectURL  string // where the IdP sends the browser back to with a code
+}
+
+// maxResponseSize limits how much of any response from the IdP is read
+const maxResponseSize = 1 << 20
+
+// metadata is the part of the IdP's discovery document
+// (https://openid.net/specs/openid-connect-discovery-1_0.html#ProviderMetadata) the app uses
+type metadata struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// Provider is an IdP the app logs accounts in with. Like SessionManager, the app should only ever create
+// one of these per IdP and pass it around as a pointer, so that the IdP's signing keys are only fetched when they change.
+type Provider struct {
+	cfg    Config
+	client *http.Client
+	meta   metadata
+
+	keys        map[string]interface{} // signing keys by key ID, *rsa.PublicKey or *ecdsa.PublicKey
+	keysFetched time.Time
+	mtx         sync.Mutex // mutex for keys and keysFetched
+}
+
+// Discover fetches the IdP's discovery document from cfg.Issuer and returns a *Provider for it
+func Discover(cfg Config, client *http.Client) (*Provider, error) {
+	issuer := strings.TrimSuffix(cfg.Issuer, "/")
+	var meta metadata
+	if err := getJSON(client, issuer+"/.well-known/openid-configuration", &meta); err != nil {
+		return nil, fmt.Errorf("OIDC discovery failed: %v", err)
+	}
+	if meta.Issuer != cfg.Issuer && meta.Issuer != issuer {
+		return nil, fmt.Errorf("OIDC discovery failed: issuer %q doesn't match configured issuer %q", meta.Issuer, cfg.Issuer)
+	}
+	if meta.AuthorizationEndpoint == "" || meta.TokenEndpoint == "" || meta.JWKSURI == "" {
+		return nil, errors.New("OIDC discovery failed: discovery document is missing required endpoints")
+	}
+	return &Provider{cfg: cfg, client: client, meta: meta}, nil
+}
+
+// AuthCodeURL returns the URL of the IdP's authorization endpoint to send the browser to for a login with
+// state, nonce and the PKCE code verifier (which is sent as its S256 challenge)
+func (p *Provider) AuthCodeURL(state, nonce, codeVerifier string) string {
+	v := url.Values{}
+	v.Set("response_type", "code")
+	v.Set("client_id", p.cfg.ClientID)
+	v.Set("redirect_uri", p.cfg.RedirectURL)
+	v.Set("scope", "openid email")
+	v.Set("state", state)
+	v.Set("nonce", nonce)
+	v.Set("code_challenge", CodeChallenge(codeVerifier))
+	v.Set("code_challenge_method", "S256")
+
+	sep := "?"
+	if strings.Contains(p.meta.AuthorizationEndpoint, "?") {
+		sep = "&"
+	}
+	return p.meta.AuthorizationEndpoint + sep + v.Encode()
+}
+
+type tokenResponse struct {
+	IDToken string `json:"id_token"`
+}
+
+// Exchange redeems an authorization code at the IdP's token endpoint and returns the raw ID token,
+// which must be checked with VerifyIDToken before it's trusted
+func (p *Provider) Exchange(code, codeVerifier string) (string, error) {
+	v := url.Values{}
+	v.Set("grant_type", "authorization_code")
+	v.Set("code", code)
+	v.Set("redirect_uri", p.cfg.RedirectURL)
+	v.Set("code_verifier", codeVerifier)
+	if p.cfg.ClientSecret == "" {
+		v.Set("client_id", p.cfg.ClientID)
+	}
+
+	req, err := http.NewRequest("POST", p.meta.TokenEndpoint, strings.NewReader(v.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if p.cfg.ClientSecret != "" {
+		// client_secret_basic, the default client authentication method (https://tools.ietf.org/html/rfc6749#section-2.3.1)
+		req.SetBasicAuth(url.QueryEscape(p.cfg.ClientID), url.QueryEscape(p.cfg.ClientSecret))
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxResponseSize))
+	if err != nil {
+		return "", err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("token endpoint responded with status %v: %s", resp.StatusCode, body)
+	}
+
+	var tr tokenResponse
+	if err := json.Unmarshal(body, &tr); err != nil {
+		return "", err
+	}
+	if tr.IDToken == "" {
+		return "", errors.New("token endpoint response has no id_token")
+	}
+	return tr.IDToken, nil
+}
+
+// getJSON GETs url and decodes its JSON response body into dst
+func getJSON(client *http.Client, url string, dst interface{}) error {
+	resp, err := client.Get(url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %v responded with status %v", url, resp.StatusCode)
+	}
+	return json.NewDecoder(io.LimitReader(resp.Body, maxResponseSize)).Decode(dst)
+}
+
+// randomString returns a base64url encoded string of n cryptographically secure random bytes
+func randomString(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// CodeChallenge returns the S256 PKCE code challenge for codeVerifier
+func CodeChallenge(codeVerifier string) string {
+	sum := sha256.Sum256([]byte(codeVerifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
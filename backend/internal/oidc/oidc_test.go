@@ -0,0 +1,335 @@
+package oidc
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+const (
+	testClientID     = "dashboard"
+	testClientSecret = "s3cret"
+	testRedirectURL  = "https://localhost:8000/sso"
+	testEmail        = "dev@goteleport.com"
+)
+
+// mockIdP is a minimal OpenID Connect provider. Its authorization endpoint logs in as testEmail straight
+// away and redirects back with a code, which its token endpoint only redeems with the right PKCE code verifier.
+type mockIdP struct {
+	t      *testing.T
+	server *httptest.Server
+
+	mtx   sync.Mutex
+	kid   string
+	key   crypto.Signer
+	alg   string
+	codes map[string]url.Values // authorization request parameters by code
+}
+
+func newMockIdP(t *testing.T) *mockIdP {
+	idp := &mockIdP{t: t, codes: map[string]url.Values{}}
+	idp.rotateKey("key-1", "RS256")
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 idp.server.URL,
+			"authorization_endpoint": idp.server.URL + "/authorize",
+			"token_endpoint":         idp.server.URL + "/token",
+			"jwks_uri":               idp.server.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", idp.serveJWKS)
+	mux.HandleFunc("/authorize", idp.serveAuthorize)
+	mux.HandleFunc("/token", idp.serveToken)
+	idp.server = httptest.NewServer(mux)
+	return idp
+}
+
+// rotateKey replaces the IdP's signing key with a new one
+func (idp *mockIdP) rotateKey(kid, alg string) {
+	var key crypto.Signer
+	var err error
+	if alg == "ES256" {
+		key, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	} else {
+		key, err = rsa.GenerateKey(rand.Reader, 2048)
+	}
+	if err != nil {
+		idp.t.Fatal(err)
+	}
+	idp.mtx.Lock()
+	defer idp.mtx.Unlock()
+	idp.kid, idp.key, idp.alg = kid, key, alg
+}
+
+func b64(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func (idp *mockIdP) serveJWKS(w http.ResponseWriter, r *http.Request) {
+	idp.mtx.Lock()
+	defer idp.mtx.Unlock()
+	var key map[string]string
+	switch pub := idp.key.Public().(type) {
+	case *rsa.PublicKey:
+		key = map[string]string{"kty": "RSA", "kid": idp.kid, "use": "sig", "n": b64(pub.N.Bytes()), "e": b64(big.NewInt(int64(pub.E)).Bytes())}
+	case *ecdsa.PublicKey:
+		key = map[string]string{"kty": "EC", "kid": idp.kid, "crv": "P-256", "x": b64(pub.X.FillBytes(make([]byte, 32))), "y": b64(pub.Y.FillBytes(make([]byte, 32)))}
+	}
+	encryption := map[string]string{"kty": "RSA", "kid": "enc", "use": "enc", "n": "AQAB", "e": "AQAB"}
+	json.NewEncoder(w).Encode(map[string]interface{}{"keys": []interface{}{encryption, key}})
+}
+
+func (idp *mockIdP) serveAuthorize(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	if q.Get("client_id") != testClientID || q.Get("redirect_uri") != testRedirectURL || q.Get("code_challenge_method") != "S256" {
+		http.Error(w, "bad authorization request", http.StatusBadRequest)
+		return
+	}
+	code, _ := randomString(16)
+	idp.mtx.Lock()
+	idp.codes[code] = q
+	idp.mtx.Unlock()
+	http.Redirect(w, r, testRedirectURL+"?"+url.Values{"code": {code}, "state": {q.Get("state")}}.Encode(), http.StatusFound)
+}
+
+func (idp *mockIdP) serveToken(w http.ResponseWriter, r *http.Request) {
+	id, secret, _ := r.BasicAuth()
+	if id != testClientID || secret != testClientSecret {
+		http.Error(w, `{"error":"invalid_client"}`, http.StatusUnauthorized)
+		return
+	}
+	r.ParseForm()
+	idp.mtx.Lock()
+	q, ok := idp.codes[r.PostForm.Get("code")]
+	delete(idp.codes, r.PostForm.Get("code"))
+	idp.mtx.Unlock()
+	if !ok || r.PostForm.Get("grant_type") != "authorization_code" || r.PostForm.Get("redirect_uri") != q.Get("redirect_uri") ||
+		CodeChallenge(r.PostForm.Get("code_verifier")) != q.Get("code_challenge") {
+		http.Error(w, `{"error":"invalid_grant"}`, http.StatusBadRequest)
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]string{
+		"access_token": "unused",
+		"token_type":   "Bearer",
+		"id_token":     idp.sign(idp.claims(q.Get("nonce"))),
+	})
+}
+
+// claims returns valid ID token claims for a login with nonce
+func (idp *mockIdP) claims(nonce string) map[string]interface{} {
+	return map[string]interface{}{
+		"iss":            idp.server.URL,
+		"sub":            "user-1",
+		"aud":            testClientID,
+		"exp":            time.Now().Add(time.Hour).Unix(),
+		"iat":            time.Now().Unix(),
+		"nonce":          nonce,
+		"email":          testEmail,
+		"email_verified": true,
+	}
+}
+
+// sign returns claims as a JWT signed with the IdP's current key
+func (idp *mockIdP) sign(claims map[string]interface{}) string {
+	idp.mtx.Lock()
+	defer idp.mtx.Unlock()
+	header, _ := json.Marshal(map[string]string{"alg": idp.alg, "kid": idp.kid, "typ": "JWT"})
+	payload, _ := json.Marshal(claims)
+	input := b64(header) + "." + b64(payload)
+	sum := sha256.Sum256([]byte(input))
+
+	var sig []byte
+	switch key := idp.key.(type) {
+	case *rsa.PrivateKey:
+		sig, _ = rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, sum[:])
+	case *ecdsa.PrivateKey:
+		r, s, _ := ecdsa.Sign(rand.Reader, key, sum[:])
+		sig = append(r.FillBytes(make([]byte, 32)), s.FillBytes(make([]byte, 32))...)
+	}
+	return input + "." + b64(sig)
+}
+
+func testConfig(idp *mockIdP) Config {
+	return Config{Issuer: idp.server.URL, ClientID: testClientID, ClientSecret: testClientSecret, RedirectURL: testRedirectURL}
+}
+
+// authorize follows a login's AuthCodeURL and returns the code and state the IdP redirects back with
+func authorize(t *testing.T, p *Provider, login Login) (string, string) {
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	resp, err := client.Get(p.AuthCodeURL(login.State, login.Nonce, login.CodeVerifier))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	loc, err := url.Parse(resp.Header.Get("Location"))
+	if err != nil || resp.StatusCode != http.StatusFound {
+		t.Fatalf("expected a redirect from the authorization endpoint, got %v %v", resp.StatusCode, err)
+	}
+	return loc.Query().Get("code"), loc.Query().Get("state")
+}
+
+func TestLoginFlow(t *testing.T) {
+	idp := newMockIdP(t)
+	defer idp.server.Close()
+	p, err := Discover(testConfig(idp), http.DefaultClient)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ls := NewLoginStore(time.Minute)
+
+	login, err := ls.CreateLogin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	code, state := authorize(t, p, login)
+
+	finished, err := ls.FinishLogin(state)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ls.FinishLogin(state); err != ErrLoginDNE {
+		t.Fatal("expected a login to only be finishable once")
+	}
+
+	if _, err := p.Exchange(code, "wrong verifier"); err == nil {
+		t.Fatal("expected the IdP to refuse a code exchange with the wrong PKCE verifier")
+	}
+
+	// The IdP deleted the code after the failed attempt, so log in again
+	code, _ = authorize(t, p, login)
+	rawIDToken, err := p.Exchange(code, finished.CodeVerifier)
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims, err := p.VerifyIDToken(rawIDToken, finished.Nonce, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claims.Email != testEmail || !claims.EmailVerified || claims.Subject != "user-1" {
+		t.Fatalf("unexpected claims %+v", claims)
+	}
+}
+
+func TestDiscoverIssuerMismatch(t *testing.T) {
+	idp := newMockIdP(t)
+	defer idp.server.Close()
+	cfg := testConfig(idp)
+	cfg.Issuer = strings.Replace(idp.server.URL, "127.0.0.1", "localhost", 1)
+	if _, err := Discover(cfg, http.DefaultClient); err == nil {
+		t.Fatal("expected discovery to fail when the document's issuer doesn't match")
+	}
+}
+
+func TestVerifyIDTokenRejects(t *testing.T) {
+	idp := newMockIdP(t)
+	defer idp.server.Close()
+	p, err := Discover(testConfig(idp), http.DefaultClient)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	modified := func(key string, value interface{}) string {
+		claims := idp.claims("nonce")
+		claims[key] = value
+		return idp.sign(claims)
+	}
+	valid := idp.sign(idp.claims("nonce"))
+	parts := strings.Split(valid, ".")
+	payload, _ := base64.RawURLEncoding.DecodeString(parts[1])
+
+	// alg "none" and HS256 keyed with something the attacker knows must never be accepted
+	none := b64([]byte(`{"alg":"none","kid":"key-1"}`)) + "." + parts[1] + "."
+	hsInput := b64([]byte(`{"alg":"HS256","kid":"key-1"}`)) + "." + parts[1]
+	mac := hmac.New(sha256.New, []byte("key-1"))
+	mac.Write([]byte(hsInput))
+	hs256 := hsInput + "." + b64(mac.Sum(nil))
+
+	cases := map[string]string{
+		"wrong nonce":       modified("nonce", "other"),
+		"wrong audience":    modified("aud", "other-client"),
+		"multiple audience": modified("aud", []string{"other-client", testClientID}),
+		"wrong issuer":      modified("iss", "https://attacker.example.com"),
+		"expired":           modified("exp", time.Now().Add(-2*time.Minute).Unix()),
+		"future":            modified("iat", time.Now().Add(2*time.Minute).Unix()),
+		"tampered":          parts[0] + "." + b64(append(payload[:len(payload)-1], ' ', '}')) + "." + parts[2],
+		"alg none":          none,
+		"alg HS256":         hs256,
+		"malformed":         "not.a.jwt.at-all",
+	}
+	for name, token := range cases {
+		if _, err := p.VerifyIDToken(token, "nonce", time.Now()); !errors.Is(err, ErrInvalidIDToken) {
+			t.Fatalf("%v: expected ErrInvalidIDToken, got %v", name, err)
+		}
+	}
+
+	if _, err := p.VerifyIDToken(valid, "nonce", time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	// azp makes multiple audiences acceptable
+	claims := idp.claims("nonce")
+	claims["aud"], claims["azp"] = []string{"other-client", testClientID}, testClientID
+	claims["email_verified"] = "true"
+	c, err := p.VerifyIDToken(idp.sign(claims), "nonce", time.Now())
+	if err != nil || !c.EmailVerified {
+		t.Fatalf("expected azp to allow multiple audiences, got %v", err)
+	}
+}
+
+func TestVerifyIDTokenKeyRotation(t *testing.T) {
+	idp := newMockIdP(t)
+	defer idp.server.Close()
+	p, err := Discover(testConfig(idp), http.DefaultClient)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+	if _, err := p.VerifyIDToken(idp.sign(idp.claims("nonce")), "nonce", now); err != nil {
+		t.Fatal(err)
+	}
+
+	idp.rotateKey("key-2", "ES256")
+	rotated := idp.sign(idp.claims("nonce"))
+
+	// Keys aren't refetched more than once every keyRefreshInterval
+	if _, err := p.VerifyIDToken(rotated, "nonce", now.Add(time.Second)); !errors.Is(err, ErrInvalidIDToken) {
+		t.Fatalf("expected the new key not to be fetched yet, got %v", err)
+	}
+	if _, err := p.VerifyIDToken(rotated, "nonce", now.Add(keyRefreshInterval)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLoginStoreExpiry(t *testing.T) {
+	ls := NewLoginStore(time.Millisecond)
+	login, err := ls.CreateLogin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if login.State == login.Nonce || login.Nonce == login.CodeVerifier {
+		t.Fatal("expected state, nonce and code verifier to be independent")
+	}
+	time.Sleep(5 * time.Millisecond)
+
+	ls.Prune(time.Now())
+	if _, err := ls.FinishLogin(login.State); err != ErrLoginDNE {
+		t.Fatal("expected an expired login to be pruned")
+	}
+}
@@ -0,0 +1,85 @@
+package oidc
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrLoginDNE is returned when a caller attempts to finish a login that doesn't exist, has expired
+// or was already finished
+var ErrLoginDNE = errors.New("the OIDC login does not exist")
+
+// Login is a login that's been sent to the IdP and is waiting for it to redirect back with a code
+type Login struct {
+	State        string // sent to the IdP and back, to tie its response to this login
+	Nonce        string // sent to the IdP and back in the ID token, to tie the token to this login
+	CodeVerifier string // PKCE secret, only its hash is sent to the IdP until the code is exchanged
+	Expires      time.Time
+}
+
+// LoginStore is an in-memory store of pending logins, keyed by state. Like SessionManager,
+// the app should only ever create one of these and pass it around as a pointer
+type LoginStore struct {
+	store   map[string]Login
+	timeout time.Duration // absolute timeout for individual logins
+	mtx     sync.Mutex    // mutex for store
+}
+
+// NewLoginStore creates a new *LoginStore
+func NewLoginStore(timeout time.Duration) *LoginStore {
+	return &LoginStore{
+		store:   make(map[string]Login),
+		timeout: timeout,
+	}
+}
+
+// CreateLogin creates a new pending login with random state, nonce and code verifier, expiring ls.timeout from now.
+// It will return an error if the system's secure random number generator fails to function correctly.
+func (ls *LoginStore) CreateLogin() (Login, error) {
+	var values [3]string
+	for i := range values {
+		s, err := randomString(32)
+		if err != nil {
+			return Login{}, err
+		}
+		values[i] = s
+	}
+
+	l := Login{values[0], values[1], values[2], time.Now().Add(ls.timeout)}
+
+	ls.mtx.Lock()
+	defer ls.mtx.Unlock()
+	ls.store[l.State] = l
+
+	return l, nil
+}
+
+// FinishLogin deletes and returns the pending login with state, so that it can only be finished once.
+// Returns ErrLoginDNE if it doesn't exist or has expired.
+func (ls *LoginStore) FinishLogin(state string) (Login, error) {
+	ls.mtx.Lock()
+	defer ls.mtx.Unlock()
+
+	l, ok := ls.store[state]
+	if !ok {
+		return Login{}, ErrLoginDNE
+	}
+	delete(ls.store, state)
+	if time.Now().After(l.Expires) {
+		return Login{}, ErrLoginDNE
+	}
+	return l, nil
+}
+
+// Prune deletes every login that expired before now
+func (ls *LoginStore) Prune(now time.Time) {
+	ls.mtx.Lock()
+	defer ls.mtx.Unlock()
+
+	for state, l := range ls.store {
+		if now.After(l.Expires) {
+			delete(ls.store, state)
+		}
+	}
+}
@@ -0,0 +1,248 @@
+// Package pki issues and checks the client certificates devices authenticate to the server with. Every
+// account has its own certificate authority (CA), so a device's certificate can only ever vouch for the
+// account whose CA signed it.
+package pki
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"time"
+)
+
+const (
+	// CAValidity is how long an account's CA certificate is valid for
+	CAValidity = 10 * 365 * 24 * time.Hour
+
+	// DeviceCertValidity is how long a device certificate is valid for
+	DeviceCertValidity = 365 * 24 * time.Hour
+
+	// CRLValidity is how long a CRL is valid for before clients should fetch a new one
+	CRLValidity = 24 * time.Hour
+
+	// backdate allows for devices whose clocks are slightly behind the server's
+	backdate = 5 * time.Minute
+)
+
+var (
+	// ErrNotDeviceCert is returned by VerifyDeviceCert for certificates that weren't issued to a device by an account's CA
+	ErrNotDeviceCert = errors.New("not a device certificate")
+
+	// ErrBadCSR is returned by ParseCSR for certificate signing requests that can't be used
+	ErrBadCSR = errors.New("invalid certificate signing request")
+)
+
+// CA is an account's certificate authority
+type CA struct {
+	Cert *x509.Certificate
+	Key  crypto.Signer
+}
+
+// randomSerial returns a random 128 bit certificate serial number
+func randomSerial() (*big.Int, error) {
+	return rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+}
+
+// SerialString formats a certificate serial number the way devices are identified by, as lower case hex
+func SerialString(serial *big.Int) string {
+	return hex.EncodeToString(serial.Bytes())
+}
+
+// ParseSerial parses a serial number formatted by SerialString
+func ParseSerial(s string) (*big.Int, error) {
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	return new(big.Int).SetBytes(b), nil
+}
+
+// NewCA creates a new CA for accountID, returning its certificate and private key PEM encoded
+func NewCA(accountID string, now time.Time) (certPEM, keyPEM []byte, err error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	serial, err := randomSerial()
+	if err != nil {
+		return nil, nil, err
+	}
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: "teleport-interview device CA", Organization: []string{accountID}},
+		NotBefore:             now.Add(-backdate),
+		NotAfter:              now.Add(CAValidity),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		MaxPathLenZero:        true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	if err != nil {
+		return nil, nil, err
+	}
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}), nil
+}
+
+// ParseCA parses a CA from its PEM encoded certificate and private key
+func ParseCA(certPEM, keyPEM []byte) (CA, error) {
+	certBlock, _ := pem.Decode(certPEM)
+	keyBlock, _ := pem.Decode(keyPEM)
+	if certBlock == nil || keyBlock == nil {
+		return CA{}, errors.New("CA certificate or key isn't PEM encoded")
+	}
+	cert, err := x509.ParseCertificate(certBlock.Bytes)
+	if err != nil {
+		return CA{}, err
+	}
+	key, err := x509.ParsePKCS8PrivateKey(keyBlock.Bytes)
+	if err != nil {
+		return CA{}, err
+	}
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return CA{}, errors.New("CA key can't sign")
+	}
+	return CA{cert, signer}, nil
+}
+
+// AccountID returns the ID of the account the CA belongs to
+func (ca CA) AccountID() string {
+	if len(ca.Cert.Subject.Organization) != 1 {
+		return ""
+	}
+	return ca.Cert.Subject.Organization[0]
+}
+
+// CertPEM returns the CA's certificate PEM encoded, for devices to verify nothing else is vouching for their account
+func (ca CA) CertPEM() string {
+	return string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.Cert.Raw}))
+}
+
+// NewDeviceKey generates a private key for a device that didn't send a certificate signing request,
+// returning it PEM encoded
+func NewDeviceKey() (crypto.Signer, []byte, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	return key, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), nil
+}
+
+// ParseCSR parses a PEM encoded certificate signing request and returns its public key, so that a device's
+// private key never has to leave it. Only the public key is used, the rest of the request is ignored.
+func ParseCSR(csrPEM []byte) (crypto.PublicKey, error) {
+	block, _ := pem.Decode(csrPEM)
+	if block == nil || block.Type != "CERTIFICATE REQUEST" {
+		return nil, ErrBadCSR
+	}
+	csr, err := x509.ParseCertificateRequest(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrBadCSR, err)
+	}
+	if err := csr.CheckSignature(); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrBadCSR, err)
+	}
+	switch pub := csr.PublicKey.(type) {
+	case *ecdsa.PublicKey:
+	case *rsa.PublicKey:
+		if pub.Size() < 2048/8 {
+			return nil, fmt.Errorf("%w: RSA keys must be at least 2048 bits", ErrBadCSR)
+		}
+	default:
+		return nil, fmt.Errorf("%w: unsupported key type", ErrBadCSR)
+	}
+	return csr.PublicKey, nil
+}
+
+// IssueDeviceCert issues a client certificate for a device called name with public key pub, signed by ca.
+// crlURL is where the CA's CRL can be fetched from. Returns the PEM encoded certificate and its serial number.
+func (ca CA) IssueDeviceCert(name string, pub crypto.PublicKey, crlURL string, now time.Time) ([]byte, *big.Int, error) {
+	serial, err := randomSerial()
+	if err != nil {
+		return nil, nil, err
+	}
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: name, Organization: []string{ca.AccountID()}},
+		NotBefore:             now.Add(-backdate),
+		NotAfter:              now.Add(DeviceCertValidity),
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		CRLDistributionPoints: []string{crlURL},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.Cert, pub, ca.Key)
+	if err != nil {
+		return nil, nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), serial, nil
+}
+
+// DeviceAccountID returns the account a device certificate claims to belong to. The claim can't be trusted
+// until the certificate has been checked with VerifyDeviceCert against that account's CA.
+func DeviceAccountID(cert *x509.Certificate) (string, error) {
+	if len(cert.Subject.Organization) != 1 || cert.Subject.Organization[0] == "" {
+		return "", ErrNotDeviceCert
+	}
+	return cert.Subject.Organization[0], nil
+}
+
+// VerifyDeviceCert checks that cert was issued by ca for client authentication and is valid at now.
+// It doesn't check whether cert has been revoked.
+func (ca CA) VerifyDeviceCert(cert *x509.Certificate, now time.Time) error {
+	if accountID, err := DeviceAccountID(cert); err != nil || accountID != ca.AccountID() || cert.IsCA {
+		return ErrNotDeviceCert
+	}
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.Cert)
+	_, err := cert.Verify(x509.VerifyOptions{
+		Roots:       roots,
+		CurrentTime: now,
+		KeyUsages:   []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	})
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrNotDeviceCert, err)
+	}
+	return nil
+}
+
+// Revoked is a revoked device certificate
+type Revoked struct {
+	Serial    *big.Int
+	RevokedAt time.Time
+}
+
+// CreateCRL returns a DER encoded CRL, signed by ca, listing revoked. The CRL's number is the current time in
+// seconds, so that it increases with every CRL the CA issues.
+func (ca CA) CreateCRL(revoked []Revoked, now time.Time) ([]byte, error) {
+	entries := make([]pkix.RevokedCertificate, len(revoked))
+	for i, r := range revoked {
+		entries[i] = pkix.RevokedCertificate{SerialNumber: r.Serial, RevocationTime: r.RevokedAt.UTC()}
+	}
+	template := &x509.RevocationList{
+		RevokedCertificates: entries,
+		Number:              big.NewInt(now.Unix()),
+		ThisUpdate:          now,
+		NextUpdate:          now.Add(CRLValidity),
+	}
+	return x509.CreateRevocationList(rand.Reader, template, ca.Cert, ca.Key)
+}
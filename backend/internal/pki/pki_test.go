@@ -0,0 +1,177 @@
+package pki
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"testing"
+	"time"
+)
+
+const (
+	testAccountID  = "5b3e8e5c-6b0a-4c47-a3e5-a8b5e1f7e7a1"
+	otherAccountID = "0d1f7d8e-2c6a-4b1e-9f3a-2d5c8b7e6f10"
+	testCRLURL     = "https://localhost:8000/api/devices/crl/" + testAccountID
+)
+
+func newTestCA(t *testing.T, accountID string, now time.Time) CA {
+	t.Helper()
+	certPEM, keyPEM, err := NewCA(accountID, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca, err := ParseCA(certPEM, keyPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ca.AccountID() != accountID {
+		t.Fatalf("expected CA for %v, got %v", accountID, ca.AccountID())
+	}
+	return ca
+}
+
+func parseCert(t *testing.T, certPEM []byte) *x509.Certificate {
+	t.Helper()
+	block, _ := pem.Decode(certPEM)
+	if block == nil {
+		t.Fatal("certificate isn't PEM encoded")
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert
+}
+
+func TestIssueDeviceCert(t *testing.T) {
+	now := time.Now()
+	ca := newTestCA(t, testAccountID, now)
+
+	key, _, err := NewDeviceKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	certPEM, serial, err := ca.IssueDeviceCert("thermostat", key.Public(), testCRLURL, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert := parseCert(t, certPEM)
+
+	if cert.SerialNumber.Cmp(serial) != 0 {
+		t.Fatalf("expected serial %v, got %v", serial, cert.SerialNumber)
+	}
+	if parsed, err := ParseSerial(SerialString(serial)); err != nil || parsed.Cmp(serial) != 0 {
+		t.Fatalf("expected %v to round trip, got %v, %v", SerialString(serial), parsed, err)
+	}
+	if cert.Subject.CommonName != "thermostat" {
+		t.Fatalf("expected common name thermostat, got %v", cert.Subject.CommonName)
+	}
+	if len(cert.CRLDistributionPoints) != 1 || cert.CRLDistributionPoints[0] != testCRLURL {
+		t.Fatalf("expected CRL distribution point %v, got %v", testCRLURL, cert.CRLDistributionPoints)
+	}
+	if accountID, err := DeviceAccountID(cert); err != nil || accountID != testAccountID {
+		t.Fatalf("expected account %v, got %v, %v", testAccountID, accountID, err)
+	}
+
+	if err := ca.VerifyDeviceCert(cert, now); err != nil {
+		t.Fatalf("expected certificate to verify, got %v", err)
+	}
+	if err := ca.VerifyDeviceCert(cert, now.Add(DeviceCertValidity+time.Minute)); !errors.Is(err, ErrNotDeviceCert) {
+		t.Fatalf("expected expired certificate to fail with ErrNotDeviceCert, got %v", err)
+	}
+	if err := ca.VerifyDeviceCert(ca.Cert, now); !errors.Is(err, ErrNotDeviceCert) {
+		t.Fatalf("expected the CA's own certificate to fail with ErrNotDeviceCert, got %v", err)
+	}
+}
+
+func TestVerifyDeviceCertOtherAccount(t *testing.T) {
+	now := time.Now()
+	ca := newTestCA(t, testAccountID, now)
+	other := newTestCA(t, otherAccountID, now)
+
+	key, _, err := NewDeviceKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	certPEM, _, err := other.IssueDeviceCert("thermostat", key.Public(), testCRLURL, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert := parseCert(t, certPEM)
+	if err := ca.VerifyDeviceCert(cert, now); !errors.Is(err, ErrNotDeviceCert) {
+		t.Fatalf("expected another account's certificate to fail with ErrNotDeviceCert, got %v", err)
+	}
+
+	// A CA that claims to be for the account doesn't help unless it's the account's own
+	impostor := newTestCA(t, testAccountID, now)
+	certPEM, _, err = impostor.IssueDeviceCert("thermostat", key.Public(), testCRLURL, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert = parseCert(t, certPEM)
+	if err := ca.VerifyDeviceCert(cert, now); !errors.Is(err, ErrNotDeviceCert) {
+		t.Fatalf("expected a certificate from another CA to fail with ErrNotDeviceCert, got %v", err)
+	}
+}
+
+func TestParseCSR(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{Subject: pkix.Name{CommonName: "ignored"}}, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	csrPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: der})
+
+	pub, err := ParseCSR(csrPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !key.PublicKey.Equal(pub) {
+		t.Fatal("expected the CSR's public key")
+	}
+
+	if _, err := ParseCSR([]byte("not a csr")); !errors.Is(err, ErrBadCSR) {
+		t.Fatalf("expected ErrBadCSR, got %v", err)
+	}
+	der[len(der)-1] ^= 0xff // corrupt the signature
+	if _, err := ParseCSR(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: der})); !errors.Is(err, ErrBadCSR) {
+		t.Fatalf("expected ErrBadCSR for a bad signature, got %v", err)
+	}
+}
+
+func TestCreateCRL(t *testing.T) {
+	now := time.Now()
+	ca := newTestCA(t, testAccountID, now)
+
+	key, _, err := NewDeviceKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, serial, err := ca.IssueDeviceCert("thermostat", key.Public(), testCRLURL, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	der, err := ca.CreateCRL([]Revoked{{serial, now}}, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	crl, err := x509.ParseCRL(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ca.Cert.CheckCRLSignature(crl); err != nil {
+		t.Fatalf("expected CRL to be signed by the CA, got %v", err)
+	}
+	revoked := crl.TBSCertList.RevokedCertificates
+	if len(revoked) != 1 || revoked[0].SerialNumber.Cmp(serial) != 0 {
+		t.Fatalf("expected CRL to list %v, got %v", serial, revoked)
+	}
+}
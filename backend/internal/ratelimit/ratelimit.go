@@ -0,0 +1,276 @@
+// Package ratelimit throttles requests with token buckets. Each route's limits, and what its requests
+// are keyed by (API key, account, session or client IP), come from a Config, with optional overrides
+// for each plan. Responses carry RateLimit-Limit, RateLimit-Remaining and RateLimit-Reset headers.
+package ratelimit
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"github.com/ibeckermayer/teleport-interview/backend/internal/model"
+	"github.com/ibeckermayer/teleport-interview/backend/internal/util"
+)
+
+// KeyBy is what a route's requests are rate limited by
+type KeyBy string
+
+const (
+	// ByIP limits each client IP
+	ByIP = KeyBy("ip")
+	// BySession limits each dashboard session
+	BySession = KeyBy("session")
+	// ByAccount limits each account, across all of its sessions or API keys
+	ByAccount = KeyBy("account")
+	// ByAPIkey limits each API key
+	ByAPIkey = KeyBy("apikey")
+)
+
+// Limit is a token bucket's refill Rate (in requests per second) and size
+type Limit struct {
+	Rate  float64 `json:"rate"`
+	Burst int     `json:"burst"`
+}
+
+// RouteLimit is the limit for a route, keyed by KeyBy, with overrides for accounts on particular plans
+type RouteLimit struct {
+	Limit
+	KeyBy KeyBy                `json:"key"`
+	Plans map[model.Plan]Limit `json:"plans,omitempty"`
+}
+
+// Config is the rate limits for every route. Routes are named by method and path template,
+// e.g. "POST /api/metrics"; routes without an entry use Default. PerIP limits each client IP across
+// every route before the request is authenticated; a zero PerIP, e.g. "perIP": {} in a config file, turns
+// that off.
+type Config struct {
+	Default RouteLimit            `json:"default"`
+	Routes  map[string]RouteLimit `json:"routes"`
+	PerIP   Limit                 `json:"perIP"`
+}
+
+// DefaultConfig returns the limits used when no config file is given. They're generous enough for the
+// dashboard polling "GET /api/metrics" every 300ms.
+func DefaultConfig() Config {
+	return Config{
+		Default: RouteLimit{Limit: Limit{Rate: 5, Burst: 20}, KeyBy: ByAccount},
+		Routes: map[string]RouteLimit{
+			"POST /api/login":               {Limit: Limit{Rate: 1, Burst: 10}, KeyBy: ByIP},
+			"POST /api/login/2fa":           {Limit: Limit{Rate: 1, Burst: 10}, KeyBy: ByIP},
+			"POST /api/login/oidc":          {Limit: Limit{Rate: 1, Burst: 10}, KeyBy: ByIP},
+			"POST /api/login/oidc/callback": {Limit: Limit{Rate: 1, Burst: 10}, KeyBy: ByIP},
+			// Each forgot password request may send an email
+			"POST /api/password/forgot": {Limit: Limit{Rate: 0.1, Burst: 5}, KeyBy: ByIP},
+			"POST /api/password/reset":  {Limit: Limit{Rate: 1, Burst: 10}, KeyBy: ByIP},
+			// Both check the current password, so they're limited like logins
+			"POST /api/account/password": {Limit: Limit{Rate: 1, Burst: 10}, KeyBy: ByAccount},
+			"POST /api/account/email":    {Limit: Limit{Rate: 1, Burst: 10}, KeyBy: ByAccount},
+			"GET /api/metrics":           {Limit: Limit{Rate: 10, Burst: 20}, KeyBy: BySession},
+			"POST /api/metrics": {
+				Limit: Limit{Rate: 50, Burst: 100},
+				KeyBy: ByAPIkey,
+				Plans: map[model.Plan]Limit{model.ENTERPRISE: {Rate: 500, Burst: 1000}},
+			},
+		},
+		// As high as the highest route limit, so it only stops floods of requests that never get as far
+		// as their route's limit, like ones with a bad API key or session
+		PerIP: Limit{Rate: 500, Burst: 1000},
+	}
+}
+
+// LoadConfig reads a JSON Config from path. Routes not in the file keep their DefaultConfig limits, as does
+// PerIP if the file doesn't have it.
+func LoadConfig(path string) (Config, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return Config{}, err
+	}
+	cfg := DefaultConfig()
+	fileCfg := Config{}
+	if err := json.Unmarshal(b, &fileCfg); err != nil {
+		return Config{}, err
+	}
+	// A zero PerIP is allowed, so whether the file has one is told apart from its value
+	var filePerIP struct {
+		PerIP *Limit `json:"perIP"`
+	}
+	if err := json.Unmarshal(b, &filePerIP); err != nil {
+		return Config{}, err
+	}
+	if fileCfg.Default.KeyBy != "" {
+		cfg.Default = fileCfg.Default
+	}
+	for route, limit := range fileCfg.Routes {
+		cfg.Routes[route] = limit
+	}
+	if filePerIP.PerIP != nil {
+		cfg.PerIP = *filePerIP.PerIP
+	}
+	return cfg, cfg.validate()
+}
+
+func (l Limit) validate() error {
+	if l.Rate <= 0 || l.Burst < 1 {
+		return errors.New("rate limits must have a positive rate and a burst of at least 1")
+	}
+	return nil
+}
+
+func (rl RouteLimit) validate() error {
+	limits := []Limit{rl.Limit}
+	for _, l := range rl.Plans {
+		limits = append(limits, l)
+	}
+	for _, l := range limits {
+		if err := l.validate(); err != nil {
+			return err
+		}
+	}
+	switch rl.KeyBy {
+	case ByIP, BySession, ByAccount, ByAPIkey:
+		return nil
+	}
+	return fmt.Errorf("unknown rate limit key %q", rl.KeyBy)
+}
+
+func (cfg Config) validate() error {
+	if err := cfg.Default.validate(); err != nil {
+		return err
+	}
+	for route, rl := range cfg.Routes {
+		if err := rl.validate(); err != nil {
+			return fmt.Errorf("route %v: %v", route, err)
+		}
+	}
+	if cfg.PerIP == (Limit{}) {
+		return nil
+	}
+	if err := cfg.PerIP.validate(); err != nil {
+		return fmt.Errorf("perIP: %v", err)
+	}
+	return nil
+}
+
+// Identity is who a request was made by. Fields the request can't be attributed to are empty.
+type Identity struct {
+	SessionID string
+	AccountID string
+	APIkey    string // a hash of the API key, never the key itself
+	Plan      model.Plan
+}
+
+// IdentifyFunc returns the Identity of the client that made r
+type IdentifyFunc func(r *http.Request) Identity
+
+// Limiter is middleware that applies a Config's rate limits
+type Limiter struct {
+	cfg      Config
+	store    Store
+	identify IdentifyFunc
+}
+
+// NewLimiter creates a new *Limiter that keeps its buckets in store and attributes requests with identify
+func NewLimiter(cfg Config, store Store, identify IdentifyFunc) *Limiter {
+	return &Limiter{cfg, store, identify}
+}
+
+// routeName returns the name r's route has in a Config
+func routeName(r *http.Request) string {
+	path := r.URL.Path
+	if route := mux.CurrentRoute(r); route != nil {
+		if tmpl, err := route.GetPathTemplate(); err == nil {
+			path = tmpl
+		}
+	}
+	return r.Method + " " + path
+}
+
+// bucketKey returns the key of the bucket r should take a token from, falling back to the client's IP
+// when the request can't be attributed to what the route is keyed by
+func bucketKey(route string, keyBy KeyBy, id Identity, r *http.Request) string {
+	switch {
+	case keyBy == BySession && id.SessionID != "":
+		return route + "|session:" + id.SessionID
+	case keyBy == ByAccount && id.AccountID != "":
+		return route + "|account:" + id.AccountID
+	case keyBy == ByAPIkey && id.APIkey != "":
+		return route + "|apikey:" + id.APIkey
+	}
+	return route + "|ip:" + util.ClientIP(r)
+}
+
+func durationHeader(d time.Duration) string {
+	return strconv.FormatInt(int64((d+time.Second-1)/time.Second), 10)
+}
+
+// Wrap rate limits next, responding with a 429 once the client's bucket is empty. For routes keyed by
+// session, account or API key, Wrap must run after the middleware that authenticates them.
+func (l *Limiter) Wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		route := routeName(r)
+		rl, ok := l.cfg.Routes[route]
+		if !ok {
+			rl = l.cfg.Default
+		}
+
+		id := l.identify(r)
+		limit := rl.Limit
+		if planLimit, ok := rl.Plans[id.Plan]; ok {
+			limit = planLimit
+		}
+
+		res, err := l.store.Take(bucketKey(route, rl.KeyBy, id, r), limit, time.Now())
+		if err != nil {
+			// Fail open, an unavailable store shouldn't take the API down
+			log.Println(err)
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		w.Header().Set("RateLimit-Limit", strconv.Itoa(limit.Burst))
+		w.Header().Set("RateLimit-Remaining", strconv.Itoa(res.Remaining))
+		w.Header().Set("RateLimit-Reset", durationHeader(res.Reset))
+		if !res.Allowed {
+			log.Printf("rate limited %v for account_id=%v, ip=%v", route, id.AccountID, util.ClientIP(r))
+			util.TooManyRequests(w, res.RetryAfter)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// WrapIP limits next by client IP alone, with one bucket per IP shared by every route, responding with
+// a 429 once it's empty. Unlike Wrap it must run before authentication, so that requests with a bad
+// API key or session, which never reach Wrap, are limited too. It doesn't set the RateLimit headers,
+// which are left to Wrap's more specific limits.
+func (l *Limiter) WrapIP(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if l.cfg.PerIP == (Limit{}) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		ip := util.ClientIP(r)
+		res, err := l.store.Take("ip:"+ip, l.cfg.PerIP, time.Now())
+		if err != nil {
+			log.Println(err)
+			next.ServeHTTP(w, r)
+			return
+		}
+		if !res.Allowed {
+			log.Printf("rate limited %v before authentication for ip=%v", routeName(r), ip)
+			util.TooManyRequests(w, res.RetryAfter)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
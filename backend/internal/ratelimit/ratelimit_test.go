@@ -0,0 +1,208 @@
+package ratelimit
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"github.com/ibeckermayer/teleport-interview/backend/internal/model"
+)
+
+func TestMemoryStoreRefill(t *testing.T) {
+	ms := NewMemoryStore()
+	limit := Limit{Rate: 1, Burst: 2}
+	now := time.Now()
+
+	for i := 0; i < 2; i++ {
+		if res, _ := ms.Take("key", limit, now); !res.Allowed {
+			t.Fatalf("request %v should have been allowed", i)
+		}
+	}
+	res, _ := ms.Take("key", limit, now)
+	if res.Allowed || res.RetryAfter != time.Second {
+		t.Fatalf("expected to be limited for 1s but got %+v", res)
+	}
+	if res, _ := ms.Take("otherKey", limit, now); !res.Allowed {
+		t.Fatal("keys should have separate buckets")
+	}
+	if res, _ := ms.Take("key", limit, now.Add(time.Second)); !res.Allowed || res.Remaining != 0 || res.Reset != 2*time.Second {
+		t.Fatalf("expected one token to have refilled but got %+v", res)
+	}
+}
+
+func TestMemoryStorePrune(t *testing.T) {
+	ms := NewMemoryStore()
+	now := time.Now()
+	ms.Take("key", Limit{Rate: 1, Burst: 1}, now)
+	ms.Prune(now.Add(time.Second))
+	if len(ms.buckets) != 0 {
+		t.Fatal("expected idle bucket to be pruned")
+	}
+}
+
+func testServer(cfg Config, id Identity) *httptest.Server {
+	l := NewLimiter(cfg, NewMemoryStore(), func(r *http.Request) Identity { return id })
+	router := mux.NewRouter()
+	router.Handle("/api/things/{thingID}", l.Wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})))
+	return httptest.NewTLSServer(router)
+}
+
+func TestWrapLimitsRoute(t *testing.T) {
+	cfg := Config{
+		Default: RouteLimit{Limit: Limit{Rate: 100, Burst: 100}, KeyBy: ByIP},
+		Routes: map[string]RouteLimit{
+			"GET /api/things/{thingID}": {Limit: Limit{Rate: 0.001, Burst: 2}, KeyBy: ByAccount},
+		},
+	}
+	ts := testServer(cfg, Identity{AccountID: "accountID"})
+	defer ts.Close()
+
+	for i, expected := range []int{http.StatusOK, http.StatusOK, http.StatusTooManyRequests} {
+		// Different thingIDs share the route's bucket
+		resp, err := ts.Client().Get(ts.URL + "/api/things/" + string(rune('a'+i)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.StatusCode != expected {
+			t.Fatalf("request %v: expected %v but got %v", i, expected, resp.StatusCode)
+		}
+		if resp.Header.Get("RateLimit-Limit") != "2" {
+			t.Fatalf("expected RateLimit-Limit 2 but got %q", resp.Header.Get("RateLimit-Limit"))
+		}
+		if expected == http.StatusTooManyRequests && resp.Header.Get("Retry-After") == "" {
+			t.Fatal("expected a Retry-After header")
+		}
+	}
+}
+
+func TestWrapPlanLimit(t *testing.T) {
+	cfg := Config{
+		Default: RouteLimit{
+			Limit: Limit{Rate: 0.001, Burst: 1},
+			KeyBy: ByAccount,
+			Plans: map[model.Plan]Limit{model.ENTERPRISE: {Rate: 0.001, Burst: 5}},
+		},
+	}
+	ts := testServer(cfg, Identity{AccountID: "accountID", Plan: model.ENTERPRISE})
+	defer ts.Close()
+
+	resp, err := ts.Client().Get(ts.URL + "/api/things/a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Header.Get("RateLimit-Limit") != "5" || resp.Header.Get("RateLimit-Remaining") != "4" {
+		t.Fatalf("expected the ENTERPRISE limit but got limit=%v remaining=%v", resp.Header.Get("RateLimit-Limit"), resp.Header.Get("RateLimit-Remaining"))
+	}
+}
+
+func TestLoadConfig(t *testing.T) {
+	f, err := ioutil.TempFile("", "ratelimit-*.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	f.WriteString(`{"routes": {"POST /api/login": {"rate": 2, "burst": 4, "key": "ip"}}}`)
+	f.Close()
+
+	cfg, err := LoadConfig(f.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Routes["POST /api/login"].Burst != 4 {
+		t.Fatal("config file did not override the login route")
+	}
+	if _, ok := cfg.Routes["POST /api/metrics"]; !ok || cfg.Default.KeyBy != ByAccount {
+		t.Fatal("routes missing from the config file should keep their defaults")
+	}
+}
+
+func TestLoadConfigInvalid(t *testing.T) {
+	f, err := ioutil.TempFile("", "ratelimit-*.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	f.WriteString(`{"routes": {"POST /api/login": {"rate": 2, "burst": 4, "key": "browser"}}}`)
+	f.Close()
+
+	if _, err := LoadConfig(f.Name()); err == nil {
+		t.Fatal("expected an error for an unknown key")
+	}
+}
+
+func TestLoadConfigPerIP(t *testing.T) {
+	tests := []struct {
+		file    string
+		want    Limit
+		wantErr bool
+	}{
+		{`{}`, DefaultConfig().PerIP, false},
+		{`{"perIP": {"rate": 2, "burst": 4}}`, Limit{Rate: 2, Burst: 4}, false},
+		{`{"perIP": {}}`, Limit{}, false},
+		{`{"perIP": {"rate": 2}}`, Limit{}, true},
+	}
+	for _, tt := range tests {
+		f, err := ioutil.TempFile("", "ratelimit-*.json")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.Remove(f.Name())
+		f.WriteString(tt.file)
+		f.Close()
+
+		cfg, err := LoadConfig(f.Name())
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("%v: expected an error", tt.file)
+			}
+			continue
+		}
+		if err != nil || cfg.PerIP != tt.want {
+			t.Errorf("%v: got %+v, %v, want %+v", tt.file, cfg.PerIP, err, tt.want)
+		}
+	}
+}
+
+func TestWrapIPRunsBeforeAuth(t *testing.T) {
+	cfg := Config{
+		Default: RouteLimit{Limit: Limit{Rate: 0.001, Burst: 1}, KeyBy: ByAPIkey},
+		PerIP:   Limit{Rate: 0.001, Burst: 3},
+	}
+	l := NewLimiter(cfg, NewMemoryStore(), func(r *http.Request) Identity { return Identity{APIkey: r.Header.Get("Authorization")} })
+	router := mux.NewRouter()
+	router.Use(l.WrapIP)
+	router.Handle("/api/things", l.Wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})))
+	// Stands in for auth middleware refusing a bad key before Wrap runs
+	router.Handle("/api/auth", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	ts := httptest.NewTLSServer(router)
+	defer ts.Close()
+
+	for i, tc := range []struct {
+		path     string
+		expected int
+	}{
+		{"/api/auth", http.StatusUnauthorized},
+		{"/api/things", http.StatusOK},
+		// The route's own bucket for this key is empty
+		{"/api/things", http.StatusTooManyRequests},
+		// and now so is the IP's, which the failed requests took from too
+		{"/api/auth", http.StatusTooManyRequests},
+	} {
+		req, _ := http.NewRequest("GET", ts.URL+tc.path, nil)
+		req.Header.Set("Authorization", "key")
+		resp, err := ts.Client().Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.StatusCode != tc.expected {
+			t.Fatalf("request %v: expected %v but got %v", i, tc.expected, resp.StatusCode)
+		}
+	}
+}
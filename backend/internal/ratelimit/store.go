@@ -0,0 +1,87 @@
+package ratelimit
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// Result is the outcome of taking a token from a bucket
+type Result struct {
+	Allowed    bool
+	Remaining  int           // whole tokens left in the bucket after this request
+	Reset      time.Duration // until the bucket is full again
+	RetryAfter time.Duration // until the next token is available; zero if Allowed
+}
+
+// Store holds token buckets. MemoryStore keeps them in process; a shared implementation
+// (e.g. backed by Redis) can be swapped in when the server runs as more than one instance.
+type Store interface {
+	// Take removes a token from key's bucket, which refills at limit.Rate up to limit.Burst tokens
+	Take(key string, limit Limit, now time.Time) (Result, error)
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// MemoryStore is an in-memory Store. The app should only ever create one of these and pass it around as a pointer
+type MemoryStore struct {
+	buckets map[string]*bucket
+	mtx     sync.Mutex
+}
+
+// NewMemoryStore creates a new *MemoryStore
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{buckets: make(map[string]*bucket)}
+}
+
+// refill tops up b for the time elapsed since it was last touched
+func (b *bucket) refill(limit Limit, now time.Time) {
+	if elapsed := now.Sub(b.last).Seconds(); elapsed > 0 {
+		b.tokens = math.Min(float64(limit.Burst), b.tokens+elapsed*limit.Rate)
+	}
+	b.last = now
+}
+
+func secondsToDuration(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second))
+}
+
+// Take removes a token from key's bucket, creating a full bucket for keys it hasn't seen
+func (ms *MemoryStore) Take(key string, limit Limit, now time.Time) (Result, error) {
+	ms.mtx.Lock()
+	defer ms.mtx.Unlock()
+
+	b, ok := ms.buckets[key]
+	if !ok {
+		b = &bucket{tokens: float64(limit.Burst), last: now}
+		ms.buckets[key] = b
+	}
+	b.refill(limit, now)
+
+	res := Result{}
+	if b.tokens >= 1 {
+		b.tokens--
+		res.Allowed = true
+	} else {
+		res.RetryAfter = secondsToDuration((1 - b.tokens) / limit.Rate)
+	}
+	res.Remaining = int(b.tokens)
+	res.Reset = secondsToDuration((float64(limit.Burst) - b.tokens) / limit.Rate)
+
+	return res, nil
+}
+
+// Prune forgets buckets that haven't been touched since before. Their next request gets a full
+// bucket, so only buckets that would have refilled by now should be pruned.
+func (ms *MemoryStore) Prune(before time.Time) {
+	ms.mtx.Lock()
+	defer ms.mtx.Unlock()
+	for key, b := range ms.buckets {
+		if b.last.Before(before) {
+			delete(ms.buckets, key)
+		}
+	}
+}
@@ -2,21 +2,61 @@ package server
 
 import (
 	"bytes"
+	"context"
+	"database/sql"
 	"encoding/json"
 	"errors"
 	"io/ioutil"
 	"log"
+	"math/big"
 	"net/http"
 	"strings"
+	"time"
 
+	"github.com/ibeckermayer/teleport-interview/backend/internal/audit"
 	"github.com/ibeckermayer/teleport-interview/backend/internal/auth"
+	"github.com/ibeckermayer/teleport-interview/backend/internal/model"
+	"github.com/ibeckermayer/teleport-interview/backend/internal/pki"
+	"github.com/ibeckermayer/teleport-interview/backend/internal/ratelimit"
 	"github.com/ibeckermayer/teleport-interview/backend/internal/util"
+	"github.com/ibeckermayer/teleport-interview/backend/signing"
 )
 
 var (
 	errBadAccountID = errors.New("account ID improperly formatted")
 )
 
+// Package specific contextKey type
+type contextKey string
+
+// apikeyContextKey is the key WithAPIkeyAuth stores the request's model.APIkey under
+var apikeyContextKey = contextKey("teleport-interview-apikey")
+
+// deviceContextKey is the key WithClientCertAuth stores the request's model.Device under
+var deviceContextKey = contextKey("teleport-interview-device")
+
+// deviceFromContext gets the model.Device that authenticated a WithClientCertAuth-wrapped request
+func deviceFromContext(ctx context.Context) (model.Device, bool) {
+	device, ok := ctx.Value(deviceContextKey).(model.Device)
+	return device, ok
+}
+
+// apikeyFromContext gets the model.APIkey that authenticated a WithAPIkeyAuth-wrapped request
+func apikeyFromContext(ctx context.Context) (model.APIkey, bool) {
+	apikey, ok := ctx.Value(apikeyContextKey).(model.APIkey)
+	return apikey, ok
+}
+
+// apikeyActor identifies an API key in the audit log by a prefix of its hash
+func apikeyActor(apikey model.APIkey) string {
+	return "apikey:" + apikey.KeyHash[:8]
+}
+
+// deviceActor identifies a device in the audit log by its certificate's serial number
+func deviceActor(serial *big.Int) string {
+	return "device:" + pki.SerialString(serial)
+}
+
 func getAPIkey(r *http.Request) (auth.Key, error) {
 	s, err := auth.GetBearerToken(r)
 	return auth.Key(s), err
@@ -83,14 +123,17 @@ func getAccountIDfromBody(w http.ResponseWriter, r *http.Request) (string, error
 
 // WithAPIkeyAuth is a middlewear function for protecting handlers for routes that require an API key.
 // APIkey protected requests require that the sender send an API key in the Authorization header, as well
-// as its corresponding account_id field in the request's body.
+// as its corresponding account_id field in the request's body. Requests for accounts that aren't active, and
+// for keys that require signed requests, are refused once authenticated.
 func (srv *Server) WithAPIkeyAuth(next http.Handler) http.Handler {
+	next = srv.rejectInactiveAccounts(next)
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		// Get the api key from the Authorization header
 		key, err := getAPIkey(r)
 		if err != nil {
 			// Could not get APIkey, return 401
 			log.Println(err)
+			srv.audit.Record(r, audit.Entry{Action: model.AuditAPIkeyAuth, Outcome: model.AuditFailure, Detail: err.Error()})
 			util.ErrorJSON(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
 			return
 		}
@@ -106,6 +149,7 @@ func (srv *Server) WithAPIkeyAuth(next http.Handler) http.Handler {
 		apikey, err := srv.db.GetAPIkey(accountID)
 		if err != nil {
 			log.Printf("could not find apikey for account_id=%v", accountID)
+			srv.audit.Record(r, audit.Entry{AccountID: accountID, Action: model.AuditAPIkeyAuth, Outcome: model.AuditFailure, Detail: "account has no API key"})
 			// w.WriteHeader(http.StatusForbidden)
 			util.ErrorJSON(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
 			return
@@ -114,16 +158,270 @@ func (srv *Server) WithAPIkeyAuth(next http.Handler) http.Handler {
 		// Check that key hashes match
 		if !auth.CheckKeyHash(key, apikey.KeyHash) {
 			log.Printf("recieved invalid api key for account %v", accountID)
+			srv.audit.Record(r, audit.Entry{AccountID: accountID, Action: model.AuditAPIkeyAuth, Outcome: model.AuditFailure, Detail: "wrong API key"})
 			// w.WriteHeader(http.StatusForbidden)
 			util.ErrorJSON(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
 			return
 		}
 
-		// Request authorized, call next
+		// The key is right, but the account has said it's only to be used to sign requests
+		if apikey.RequireSigned {
+			log.Printf("refused bearer api key for account %v, which requires signed requests", accountID)
+			srv.audit.Record(r, audit.Entry{AccountID: accountID, Actor: apikeyActor(apikey), Action: model.AuditAPIkeyAuth, Outcome: model.AuditFailure, Detail: "bearer key, signed requests required"})
+			util.ErrorJSON(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+			return
+		}
+
+		// Request authorized, add the key to the context and call next
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), apikeyContextKey, apikey)))
+	})
+}
+
+// WithClientCertAuth is a middlewear function for protecting handlers for routes that devices can
+// authenticate to with a client certificate. The certificate must have been issued by its account's CA,
+// not be expired or revoked, and the account must match the account_id field in the request's body and be active.
+func (srv *Server) WithClientCertAuth(next http.Handler) http.Handler {
+	next = srv.rejectInactiveAccounts(next)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.TLS == nil || len(r.TLS.PeerCertificates) == 0 {
+			log.Println("request has no client certificate")
+			srv.audit.Record(r, audit.Entry{Action: model.AuditDeviceAuth, Outcome: model.AuditFailure, Detail: "no client certificate"})
+			util.ErrorJSON(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+			return
+		}
+		cert := r.TLS.PeerCertificates[0]
+
+		// The certificate says which account's CA to check it against, it's only trusted once it has been
+		certAccountID, err := pki.DeviceAccountID(cert)
+		if err != nil {
+			log.Println(err)
+			srv.audit.Record(r, audit.Entry{Action: model.AuditDeviceAuth, Outcome: model.AuditFailure, Detail: err.Error()})
+			util.ErrorJSON(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+			return
+		}
+		row, err := srv.db.GetDeviceCA(certAccountID)
+		if err == sql.ErrNoRows {
+			log.Printf("client certificate for account_id=%v, which has no device CA", certAccountID)
+			srv.audit.Record(r, audit.Entry{AccountID: certAccountID, Action: model.AuditDeviceAuth, Outcome: model.AuditFailure, Detail: "account has no device CA"})
+			util.ErrorJSON(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+			return
+		}
+		if err != nil {
+			log.Println(err)
+			util.ErrorJSON(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+		ca, err := pki.ParseCA([]byte(row.CertPEM), []byte(row.KeyPEM))
+		if err != nil {
+			log.Println(err)
+			util.ErrorJSON(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+		if err := ca.VerifyDeviceCert(cert, time.Now()); err != nil {
+			log.Printf("invalid client certificate for account_id=%v: %v", certAccountID, err)
+			srv.audit.Record(r, audit.Entry{AccountID: certAccountID, Actor: deviceActor(cert.SerialNumber), Action: model.AuditDeviceAuth, Outcome: model.AuditFailure, Detail: err.Error()})
+			util.ErrorJSON(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+			return
+		}
+
+		// Every certificate the CA issued has a device, which records whether it's been revoked
+		device, err := srv.db.GetDevice(pki.SerialString(cert.SerialNumber))
+		if err != nil || device.AccountID != certAccountID || device.RevokedAt != nil {
+			log.Printf("client certificate serial=%v for account_id=%v is revoked or unknown", pki.SerialString(cert.SerialNumber), certAccountID)
+			srv.audit.Record(r, audit.Entry{AccountID: certAccountID, Actor: deviceActor(cert.SerialNumber), Action: model.AuditDeviceAuth, Outcome: model.AuditFailure, Detail: "revoked or unknown certificate"})
+			util.ErrorJSON(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+			return
+		}
+
+		// Get accoutID from the request body
+		accountID, err := getAccountIDfromBody(w, r)
+		if err != nil {
+			// getAccountIDfromBody takes care of error handling and logging, return immediately
+			return
+		}
+		if accountID != certAccountID {
+			log.Printf("device serial=%v of account_id=%v sent a request for account_id=%v", device.Serial, certAccountID, accountID)
+			srv.audit.Record(r, audit.Entry{AccountID: certAccountID, Actor: deviceActor(cert.SerialNumber), Action: model.AuditDeviceAuth, Outcome: model.AuditFailure, Detail: "request for account_id " + accountID})
+			util.ErrorJSON(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+			return
+		}
+
+		// Request authorized, add the device to the context and call next
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), deviceContextKey, device)))
+	})
+}
+
+// WithSignedAPIkeyAuth is a middlewear function for protecting handlers for routes that require an API key,
+// for clients that sign their requests (see package signing) with the key's signing key rather than sending
+// the key itself. Like
+// WithAPIkeyAuth, the account is identified by the account_id field in the request's body, and must be active.
+func (srv *Server) WithSignedAPIkeyAuth(next http.Handler) http.Handler {
+	next = srv.rejectInactiveAccounts(next)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		headers, err := signing.ParseHeaders(r)
+		if err != nil {
+			log.Println(err)
+			srv.audit.Record(r, audit.Entry{Action: model.AuditAPIkeyAuth, Outcome: model.AuditFailure, Detail: err.Error()})
+			util.ErrorJSON(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+			return
+		}
+
+		// Get accoutID from the request body
+		accountID, err := getAccountIDfromBody(w, r)
+		if err != nil {
+			// getAccountIDfromBody takes care of error handling and logging, return immediately
+			return
+		}
+		// getAccountIDfromBody has already read and size limited the body, this reads it back from memory
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			log.Println(err)
+			util.ErrorJSON(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+		r.Body = ioutil.NopCloser(bytes.NewBuffer(body))
+
+		apikey, err := srv.db.GetAPIkey(accountID)
+		if err != nil {
+			log.Printf("could not find apikey for account_id=%v", accountID)
+			srv.audit.Record(r, audit.Entry{AccountID: accountID, Action: model.AuditAPIkeyAuth, Outcome: model.AuditFailure, Detail: "signed request, account has no API key"})
+			util.ErrorJSON(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+			return
+		}
+
+		if apikey.SigningKeyID == "" {
+			log.Printf("recieved signed request for account %v, whose api key has no signing key", accountID)
+			srv.audit.Record(r, audit.Entry{AccountID: accountID, Action: model.AuditAPIkeyAuth, Outcome: model.AuditFailure, Detail: "signed request, key has no signing key"})
+			util.ErrorJSON(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+			return
+		}
+		if err := headers.Check(srv.keys.Key(apikey.KeyHash, apikey.SigningKeyID), r.Method, r.URL.RequestURI(), body, time.Now()); err != nil {
+			log.Printf("recieved invalid signed request for account %v: %v", accountID, err)
+			srv.audit.Record(r, audit.Entry{AccountID: accountID, Action: model.AuditAPIkeyAuth, Outcome: model.AuditFailure, Detail: "signed request, " + err.Error()})
+			util.ErrorJSON(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+			return
+		}
+
+		// Only requests with a valid signature get to use up a nonce, so nobody else can fill the cache
+		if err := srv.nonces.Use(apikey.KeyHash, headers.Nonce, headers.Time()); err != nil {
+			log.Printf("refused replayed request for account %v", accountID)
+			srv.audit.Record(r, audit.Entry{AccountID: accountID, Actor: apikeyActor(apikey), Action: model.AuditAPIkeyAuth, Outcome: model.AuditFailure, Detail: "signed request, " + err.Error()})
+			util.ErrorJSON(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+			return
+		}
+
+		// Request authorized, add the key to the context and call next
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), apikeyContextKey, apikey)))
+	})
+}
+
+// WithDeviceAuth authenticates a device by whichever method it uses: requests that came with a client
+// certificate with WithClientCertAuth, signed requests with WithSignedAPIkeyAuth, and any others with WithAPIkeyAuth,
+// which refuses them if the account requires its API key's requests to be signed.
+func (srv *Server) WithDeviceAuth(next http.Handler) http.Handler {
+	certAuth, signedAuth, apikeyAuth := srv.WithClientCertAuth(next), srv.WithSignedAPIkeyAuth(next), srv.WithAPIkeyAuth(next)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.TLS != nil && len(r.TLS.PeerCertificates) > 0:
+			certAuth.ServeHTTP(w, r)
+		case signing.IsSigned(r):
+			signedAuth.ServeHTTP(w, r)
+		default:
+			apikeyAuth.ServeHTTP(w, r)
+		}
+	})
+}
+
+// rejectInactiveAccounts responds 403 to requests from devices of accounts that are suspended or pending
+// deletion. Must be wrapped with one of the API key or device middlewear.
+func (srv *Server) rejectInactiveAccounts(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var accountID, actor string
+		action := model.AuditAPIkeyAuth
+		if apikey, ok := apikeyFromContext(r.Context()); ok {
+			accountID, actor = apikey.AccountID, apikeyActor(apikey)
+		} else if device, ok := deviceFromContext(r.Context()); ok {
+			accountID, actor, action = device.AccountID, "device:"+device.Serial, model.AuditDeviceAuth
+		}
+
+		account, err := srv.db.GetAccount(accountID)
+		if err != nil {
+			log.Println(err)
+			util.ErrorJSON(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+		if !account.Active() {
+			log.Printf("refused request from %v, account_id=%v is %v", actor, accountID, account.Status)
+			srv.audit.Record(r, audit.Entry{AccountID: accountID, Actor: actor, Action: action, Outcome: model.AuditFailure, Detail: "account " + string(account.Status)})
+			util.ErrorJSON(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+			return
+		}
+
 		next.ServeHTTP(w, r)
 	})
 }
 
+// WithStaffAuth is a middlewear function for protecting admin API handlers. Requests must send a staff key in
+// the Authorization header. Customer session tokens and API keys are never accepted, and staff keys aren't
+// accepted anywhere else.
+func (srv *Server) WithStaffAuth(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, err := auth.GetBearerToken(r)
+		if err != nil {
+			log.Println(err)
+			srv.audit.Record(r, audit.Entry{Action: model.AuditStaffAuth, Outcome: model.AuditFailure, Detail: err.Error()})
+			util.ErrorJSON(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return
+		}
+
+		staff, err := srv.db.GetStaffByKeyHash(auth.HashKey(auth.Key(token)))
+		if err == sql.ErrNoRows {
+			log.Println("recieved unknown staff key")
+			srv.audit.Record(r, audit.Entry{Action: model.AuditStaffAuth, Outcome: model.AuditFailure, Detail: "unknown staff key"})
+			util.ErrorJSON(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return
+		}
+		if err != nil {
+			log.Println(err)
+			util.ErrorJSON(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+
+		next.ServeHTTP(w, r.WithContext(auth.ContextWithStaff(r.Context(), staff)))
+	})
+}
+
+// identify attributes a request to the session or API key that authenticated it, for rate limiting.
+// Requests that haven't passed through WithSessionAuth or one of the API key or device middlewear get an empty Identity.
+// Devices are limited like API keys, each device having its own bucket.
+func (srv *Server) identify(r *http.Request) ratelimit.Identity {
+	if session, err := srv.sm.FromContext(r.Context()); err == nil {
+		return ratelimit.Identity{
+			SessionID: string(session.SessionID),
+			AccountID: session.Account.AccountID,
+			Plan:      session.Account.Plan,
+		}
+	}
+
+	if apikey, ok := apikeyFromContext(r.Context()); ok {
+		id := ratelimit.Identity{AccountID: apikey.AccountID, APIkey: apikey.KeyHash}
+		if account, err := srv.db.GetAccount(apikey.AccountID); err == nil {
+			id.Plan = account.Plan
+		}
+		return id
+	}
+
+	if device, ok := deviceFromContext(r.Context()); ok {
+		id := ratelimit.Identity{AccountID: device.AccountID, APIkey: "device:" + device.Serial}
+		if account, err := srv.db.GetAccount(device.AccountID); err == nil {
+			id.Plan = account.Plan
+		}
+		return id
+	}
+
+	return ratelimit.Identity{}
+}
+
 // WithAPIHeaders adds security headers to the wrapped handler
 func WithAPIHeaders(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -0,0 +1,271 @@
+package server
+
+import (
+	"database/sql"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/ibeckermayer/teleport-interview/backend/internal/billing"
+	"github.com/ibeckermayer/teleport-interview/backend/internal/model"
+	"github.com/ibeckermayer/teleport-interview/backend/internal/webhook"
+	"github.com/ibeckermayer/teleport-interview/backend/signing"
+)
+
+const (
+	trialCheckInterval = time.Minute
+	billingInterval    = time.Hour
+	webhookInterval    = 5 * time.Second
+	webhookTimeout     = 10 * time.Second
+	webhookBatchSize   = 100
+	webhookWorkers     = 10
+	loginCleanupPeriod = time.Hour
+	rateLimitPrune     = 10 * time.Minute
+	auditVerifyPeriod  = time.Hour
+	purgeInterval      = time.Hour
+	inactivityInterval = time.Hour
+	alertRetryInterval = 5 * time.Minute
+
+	// loginChallengeTimeout is how long a user has to enter their two-factor code after entering their password
+	loginChallengeTimeout = 5 * time.Minute
+
+	// oidcLoginTimeout is how long a user has to log in at the IdP, and oidcTimeout how long the IdP has to respond
+	oidcLoginTimeout = 10 * time.Minute
+	oidcTimeout      = 10 * time.Second
+
+	// signedRequestNonces is how many signed requests' nonces are remembered, enough for over 300 signed
+	// requests a second within signing.MaxSkew
+	signedRequestNonces = 100000
+)
+
+// startJobs starts the server's periodic background jobs
+func (srv *Server) startJobs() {
+	go runEvery(trialCheckInterval, "expire trials", srv.expireTrials)
+	go runEvery(billingInterval, "billing", srv.runBilling)
+	go runEvery(webhookInterval, "deliver webhooks", srv.deliverWebhooks)
+	go runEvery(loginCleanupPeriod, "forget stale failed logins", srv.forgetStaleLogins)
+	go runEvery(rateLimitPrune, "prune rate limit buckets", srv.pruneRateLimits)
+	go runEvery(loginChallengeTimeout, "prune login challenges", srv.pruneLoginChallenges)
+	go runEvery(loginCleanupPeriod, "delete expired account tokens", srv.deleteExpiredTokens)
+	go runEvery(auditVerifyPeriod, "verify audit log", srv.verifyAuditLog)
+	go runEvery(purgeInterval, "purge deleted accounts", srv.purgeDeletedAccounts)
+	go runEvery(inactivityInterval, "expire inactive users", srv.expireInactiveUsers)
+	go runEvery(alertRetryInterval, "retry usage alert notifications", srv.alerts.Retry)
+	go runEvery(signing.MaxSkew, "prune signed request nonces", srv.pruneNonces)
+	if srv.ls != nil {
+		go runEvery(oidcLoginTimeout, "prune OIDC logins", srv.pruneOIDCLogins)
+	}
+}
+
+// runEvery calls job immediately and then once every interval, logging any error it returns.
+// It never returns, so callers should run it in its own goroutine.
+func runEvery(interval time.Duration, name string, job func() error) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if err := job(); err != nil {
+			log.Printf("background job %q failed: %v", name, err)
+		}
+		<-ticker.C
+	}
+}
+
+// expireTrials reverts accounts whose trial has ended to the FREE plan and refreshes
+// the Account cached in any of their sessions
+func (srv *Server) expireTrials() error {
+	expired, err := srv.db.ExpireTrials(time.Now())
+	for _, account := range expired {
+		log.Printf("trial ended for account_id=%v, reverted to %v plan", account.AccountID, account.Plan)
+		srv.sm.UpdateAccount(account)
+		srv.alerts.CheckAsync(account.AccountID)
+	}
+	return err
+}
+
+// runBilling snapshots today's usage for every account, then invoices every account for any
+// completed month it has usage snapshots but no invoice for
+func (srv *Server) runBilling() error {
+	now := time.Now()
+	if err := srv.db.SnapshotUsage(now); err != nil {
+		return err
+	}
+
+	periods, err := srv.db.GetUninvoicedPeriods(billing.Period(now))
+	if err != nil {
+		return err
+	}
+
+	for _, p := range periods {
+		snapshots, err := srv.db.GetUsageSnapshots(p.AccountID, p.Period)
+		if err != nil {
+			return err
+		}
+		inv, err := billing.BuildInvoice(p.AccountID, p.Period, snapshots, now)
+		if err != nil {
+			return err
+		}
+		if err := srv.db.CreateInvoice(inv); err != nil {
+			return err
+		}
+		log.Printf("created invoice_id=%v for account_id=%v, period=%v", inv.InvoiceID, inv.AccountID, inv.Period)
+	}
+
+	return nil
+}
+
+// deliverWebhooks attempts to send every webhook delivery that's due, recording the outcome of each attempt.
+// Up to webhookWorkers deliveries are sent at once, so that a batch of slow receivers takes at most
+// webhookBatchSize/webhookWorkers timeouts. The outcomes are recorded one at a time, as SQLite only allows
+// one writer.
+func (srv *Server) deliverWebhooks() error {
+	deliveries, err := srv.db.GetDueDeliveries(time.Now(), webhookBatchSize)
+	if err != nil {
+		return err
+	}
+
+	hooks := make(map[string]model.Webhook)
+	for _, d := range deliveries {
+		if _, ok := hooks[d.WebhookID]; ok {
+			continue
+		}
+		hook, err := srv.db.GetWebhook(d.WebhookID)
+		if err != nil && err != sql.ErrNoRows {
+			return err
+		}
+		hooks[d.WebhookID] = hook
+	}
+
+	type result struct {
+		delivery model.WebhookDelivery
+		code     int
+		err      error
+	}
+	results := make(chan result, len(deliveries))
+	sem := make(chan struct{}, webhookWorkers)
+	for _, d := range deliveries {
+		hook := hooks[d.WebhookID]
+		if hook.WebhookID == "" {
+			// Webhook was deleted after the delivery was selected, don't retry
+			d.Attempts = webhook.MaxAttempts - 1
+			results <- result{d, 0, errors.New("webhook deleted")}
+			continue
+		}
+
+		sem <- struct{}{}
+		go func(d model.WebhookDelivery) {
+			defer func() { <-sem }()
+			code, err := srv.sender.Send(hook, d, time.Now())
+			results <- result{d, code, err}
+		}(d)
+	}
+
+	for range deliveries {
+		r := <-results
+		if err := srv.recordWebhookAttempt(r.delivery, r.code, r.err); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// recordWebhookAttempt records the outcome of sending d
+func (srv *Server) recordWebhookAttempt(d model.WebhookDelivery, code int, err error) error {
+	var sendErr *webhook.SendError
+	if errors.As(err, &sendErr) && sendErr.Err != nil {
+		log.Printf("webhook delivery_id=%v failed: %v", d.DeliveryID, sendErr.Err)
+	}
+	d, attempt := webhook.RecordAttempt(d, code, err, time.Now())
+	if err := srv.db.RecordWebhookAttempt(d, attempt); err != nil {
+		return err
+	}
+	if d.Status == model.DeliveryFailed {
+		log.Printf("giving up on webhook delivery_id=%v after %v attempts: %v", d.DeliveryID, d.Attempts, d.LastError)
+	}
+	return nil
+}
+
+// forgetStaleLogins deletes failed login records that are too old to affect future logins
+func (srv *Server) forgetStaleLogins() error {
+	return srv.db.DeleteStaleLoginThrottles(time.Now().Add(-srv.cfg.LoginThrottle.LockoutDuration))
+}
+
+// pruneRateLimits forgets rate limit buckets that haven't been used in long enough to have refilled
+func (srv *Server) pruneRateLimits() error {
+	srv.rlstore.Prune(time.Now().Add(-rateLimitPrune))
+	return nil
+}
+
+// pruneLoginChallenges forgets login challenges that expired without being completed
+func (srv *Server) pruneLoginChallenges() error {
+	srv.cs.Prune(time.Now())
+	return nil
+}
+
+// pruneOIDCLogins forgets logins with the IdP that expired without being finished
+func (srv *Server) pruneOIDCLogins() error {
+	srv.ls.Prune(time.Now())
+	return nil
+}
+
+// verifyAuditLog checks the audit log's hash chain, logging its head so that a copy of it is kept outside the
+// database, to compare against if the most recent events are ever removed
+func (srv *Server) verifyAuditLog() error {
+	head, err := srv.audit.Verify()
+	if err != nil {
+		return err
+	}
+	log.Printf("audit log verified up to seq=%v, hash=%v", head.Seq, head.Hash)
+	return nil
+}
+
+// pruneNonces forgets the nonces of signed requests that are too old to be replayed
+func (srv *Server) pruneNonces() error {
+	srv.nonces.Prune(time.Now())
+	return nil
+}
+
+// deleteExpiredTokens deletes password reset and email change tokens, and invites, that can no longer be used
+func (srv *Server) deleteExpiredTokens() error {
+	now := time.Now()
+	if err := srv.db.DeleteExpiredPasswordResets(now); err != nil {
+		return err
+	}
+	if err := srv.db.DeleteExpiredEmailChanges(now); err != nil {
+		return err
+	}
+	return srv.db.DeleteExpiredInvites(now)
+}
+
+// purgeDeletedAccounts deletes accounts whose deletion grace period has passed, and all of their data, logging
+// out any sessions that are somehow left. Each purge is recorded in the audit log, which keeps the account's events.
+func (srv *Server) purgeDeletedAccounts() error {
+	now := time.Now()
+	purged, err := srv.db.PurgeAccounts(now)
+	for _, accountID := range purged {
+		srv.sm.DeleteAccountSessions(accountID)
+		log.Printf("purged account_id=%v and all of its data", accountID)
+		event := model.AuditEvent{AccountID: accountID, Actor: "system", Action: model.AuditAccountPurge, Outcome: model.AuditSuccess, CreatedAt: now.UTC()}
+		if _, err := srv.audit.Append(event); err != nil {
+			log.Printf("failed to record purge of account_id=%v: %v", accountID, err)
+		}
+	}
+	return err
+}
+
+// expireInactiveUsers deactivates users that haven't been seen for longer than their account's inactivity
+// policy allows, and promotes waiting users into the seats they free. The database records each change for the
+// account to review.
+func (srv *Server) expireInactiveUsers() error {
+	changes, err := srv.db.ExpireInactiveUsers(time.Now())
+	counts := make(map[string]map[model.UserExpiryAction]int)
+	for _, c := range changes {
+		if counts[c.AccountID] == nil {
+			counts[c.AccountID] = make(map[model.UserExpiryAction]int)
+		}
+		counts[c.AccountID][c.Action]++
+	}
+	for accountID, n := range counts {
+		log.Printf("expired %v and promoted %v users of account_id=%v", n[model.UserExpired], n[model.UserPromoted], accountID)
+	}
+	return err
+}
@@ -1,57 +1,226 @@
 package server
 
 import (
+	"crypto/tls"
 	"fmt"
 	"log"
 	"net/http"
 	"time"
 
 	"github.com/gorilla/mux"
+	"github.com/ibeckermayer/teleport-interview/backend/internal/alert"
+	"github.com/ibeckermayer/teleport-interview/backend/internal/audit"
 	"github.com/ibeckermayer/teleport-interview/backend/internal/auth"
 	"github.com/ibeckermayer/teleport-interview/backend/internal/database"
 	"github.com/ibeckermayer/teleport-interview/backend/internal/handlers"
+	"github.com/ibeckermayer/teleport-interview/backend/internal/model"
+	"github.com/ibeckermayer/teleport-interview/backend/internal/notify"
+	"github.com/ibeckermayer/teleport-interview/backend/internal/oidc"
+	"github.com/ibeckermayer/teleport-interview/backend/internal/ratelimit"
+	"github.com/ibeckermayer/teleport-interview/backend/internal/webhook"
+	"github.com/ibeckermayer/teleport-interview/backend/signing"
 )
 
 // Config is the top level config object.
 type Config struct {
-	Port           int           // -port; default 8000
-	CertFilePath   string        // -cert; default "../certs/localhost.crt"
-	KeyFilePath    string        // -key ; default "../certs/localhost.key"
-	SessionTimeout time.Duration // -sesh; default 12h
-	Env            string        // -env; default "prod"
+	Port           int                      // -port; default 8000
+	CertFilePath   string                   // -cert; default "../certs/localhost.crt"
+	KeyFilePath    string                   // -key ; default "../certs/localhost.key"
+	SessionTimeout time.Duration            // -sesh; default 12h
+	Env            string                   // -env; default "prod"
+	TrialDuration  time.Duration            // -trial; default 336h
+	PublicURL      string                   // -public-url; default "https://localhost:<port>"
+	SMTP           notify.SMTPConfig        // -smtp-addr, -smtp-from, -smtp-user and $SMTP_PASSWORD; notifications are only logged if Addr is empty
+	NotifyFile     string                   // -notify-file; notifications are appended to this file instead of logged if SMTP.Addr is empty
+	LoginThrottle  auth.LoginThrottleConfig // -login-lockout-threshold, -login-ip-lockout-threshold, -login-lockout-duration
+	RateLimit      ratelimit.Config         // -ratelimit-config; default ratelimit.DefaultConfig()
+	PasswordPolicy auth.PasswordPolicy      // -password-min-length, -password-min-strength, -breached-passwords
+	PasswordHasher auth.PasswordHasher      // -password-hash, -bcrypt-cost, -argon2-time, -argon2-memory, -argon2-threads
+	OIDC           oidc.Config              // -oidc-issuer, -oidc-client-id, -oidc-redirect-url and $OIDC_CLIENT_SECRET; single sign-on is disabled if Issuer is empty
+	InviteSecret   []byte                   // $INVITE_SECRET; a random one is generated if empty, so pending invites don't survive a restart
+	SigningSecret  []byte                   // $SIGNING_SECRET; a random one is generated if empty, so signing keys don't survive a restart
+	DeletionGrace  time.Duration            // -deletion-grace; default 720h
+	DBFile         string                   // where the database is stored; default "./teleport-interview-<env>.db"
 }
 
 // Server object initializes route handlers and external connections, and serves application
 type Server struct {
-	cfg    Config
-	router *mux.Router
-	sm     *auth.SessionManager
-	db     *database.Database
+	cfg     Config
+	router  *mux.Router
+	sm      *auth.SessionManager
+	cs      *auth.ChallengeStore
+	ls      *oidc.LoginStore
+	db      *database.Database
+	sender  *webhook.Sender
+	alerts  *alert.Alerter
+	audit   *audit.Log
+	nonces  *signing.NonceCache
+	keys    auth.SigningKeys
+	rlstore *ratelimit.MemoryStore
+	limiter *ratelimit.Limiter
 }
 
 // New initializes routes and handlers and returns a ready-to-run server
 func New(cfg Config) (*Server, error) {
-	dbcfg := database.Config{Env: cfg.Env}
+	dbcfg := database.Config{Env: cfg.Env, File: cfg.DBFile, TrialDuration: cfg.TrialDuration, PasswordPolicy: cfg.PasswordPolicy, PasswordHasher: cfg.PasswordHasher}
 	db, err := database.New(dbcfg)
 	if err != nil {
 		return &Server{}, err
 	}
-	srv := &Server{cfg, mux.NewRouter(), auth.NewSessionManager(cfg.SessionTimeout), db}
+	al := audit.NewLog(db)
+	srv := &Server{
+		cfg:    cfg,
+		router: mux.NewRouter(),
+		sm:     auth.NewSessionManager(cfg.SessionTimeout, al),
+		cs:     auth.NewChallengeStore(loginChallengeTimeout),
+		db:     db,
+		audit:  al,
+		sender: webhook.NewSender(webhook.NewClient(webhookTimeout)),
+		nonces: signing.NewNonceCache(signedRequestNonces),
+	}
+
+	var notifier notify.Notifier = notify.NewLogNotifier()
+	if cfg.SMTP.Addr != "" {
+		notifier = notify.NewSMTPNotifier(cfg.SMTP)
+	} else if cfg.NotifyFile != "" {
+		notifier = notify.NewFileNotifier(cfg.NotifyFile)
+	}
+	srv.alerts = alert.NewAlerter(db, notifier)
+
+	inviteSecret := cfg.InviteSecret
+	if len(inviteSecret) == 0 {
+		if inviteSecret, err = auth.NewInviteSecret(); err != nil {
+			return &Server{}, err
+		}
+		log.Println("INVITE_SECRET isn't set, using a random one; links in pending invites will stop working when the server restarts")
+	}
+	signer := auth.NewInviteSigner(inviteSecret)
+	signingSecret := cfg.SigningSecret
+	if len(signingSecret) == 0 {
+		if signingSecret, err = auth.NewSigningSecret(); err != nil {
+			return &Server{}, err
+		}
+		log.Println("SIGNING_SECRET isn't set, using a random one; signing keys will stop working when the server restarts")
+	}
+	srv.keys = auth.NewSigningKeys(signingSecret)
+	srv.rlstore = ratelimit.NewMemoryStore()
+	srv.limiter = ratelimit.NewLimiter(cfg.RateLimit, srv.rlstore, srv.identify)
+	// Every route is limited by client IP before any of its authentication middleware looks up a key or
+	// session, and again by its own limit, keyed by what authenticated it, once it has
+	srv.router.Use(srv.limiter.WrapIP)
 
-	loginHandler := WithAPIHeaders(handlers.NewLoginHandler(srv.sm, srv.db))
+	lh := handlers.NewLoginHandler(srv.sm, srv.cs, srv.db, cfg.LoginThrottle, cfg.PasswordHasher, srv.audit)
+	loginHandler := WithAPIHeaders(srv.limiter.Wrap(lh))
 	srv.router.Handle("/api/login", loginHandler).Methods("POST")
 
-	logoutHandler := WithAPIHeaders(srv.sm.WithSessionAuth(handlers.NewLogoutHandler(srv.sm)))
-	srv.router.Handle("/api/logout", logoutHandler).Methods("DELETE")
+	loginChallengeHandler := WithAPIHeaders(srv.limiter.Wrap(handlers.NewLoginChallengeHandler(lh)))
+	srv.router.Handle("/api/login/2fa", loginChallengeHandler).Methods("POST")
+
+	if cfg.OIDC.Issuer != "" {
+		provider, err := oidc.Discover(cfg.OIDC, &http.Client{Timeout: oidcTimeout})
+		if err != nil {
+			return &Server{}, err
+		}
+		srv.ls = oidc.NewLoginStore(oidcLoginTimeout)
+
+		oidcLoginHandler := WithAPIHeaders(srv.limiter.Wrap(handlers.NewOIDCLoginHandler(provider, srv.ls)))
+		srv.router.Handle("/api/login/oidc", oidcLoginHandler).Methods("POST")
+
+		oidcCallbackHandler := WithAPIHeaders(srv.limiter.Wrap(handlers.NewOIDCCallbackHandler(lh, provider, srv.ls)))
+		srv.router.Handle("/api/login/oidc/callback", oidcCallbackHandler).Methods("POST")
+	}
+
+	forgotPasswordHandler := WithAPIHeaders(srv.limiter.Wrap(handlers.NewForgotPasswordHandler(srv.db, notifier, cfg.PublicURL)))
+	srv.router.Handle("/api/password/forgot", forgotPasswordHandler).Methods("POST")
+
+	resetPasswordHandler := WithAPIHeaders(srv.limiter.Wrap(handlers.NewResetPasswordHandler(srv.sm, srv.cs, srv.db, notifier, cfg.PasswordPolicy, cfg.PasswordHasher)))
+	srv.router.Handle("/api/password/reset", resetPasswordHandler).Methods("POST")
 
-	metricsPostHandler := WithAPIHeaders(srv.WithAPIkeyAuth(handlers.NewMetricsPostHandler(srv.db)))
+	metricsPostHandler := WithAPIHeaders(srv.WithDeviceAuth(srv.limiter.Wrap(handlers.NewMetricsPostHandler(srv.db, srv.alerts))))
 	srv.router.Handle("/api/metrics", metricsPostHandler).Methods("POST")
 
-	metricsGetHandler := WithAPIHeaders(srv.sm.WithSessionAuth(handlers.NewMetricsGetHandler(srv.sm, srv.db)))
-	srv.router.Handle("/api/metrics", metricsGetHandler).Methods("GET")
+	// Public, the person accepting doesn't have a session yet and proves they were invited with the token
+	inviteAcceptHandler := WithAPIHeaders(srv.limiter.Wrap(handlers.NewInviteAcceptHandler(srv.sm, srv.db, signer, cfg.PasswordPolicy, cfg.PasswordHasher, srv.audit)))
+	srv.router.Handle("/api/invites/accept", inviteAcceptHandler).Methods("POST")
+
+	// Not JSON, and public so that anything checking a device's certificate can fetch it
+	deviceCRLHandler := srv.limiter.Wrap(handlers.NewDeviceCRLHandler(srv.db))
+	srv.router.Handle("/api/devices/crl/{accountID}", deviceCRLHandler).Methods("GET")
+
+	// Routes called by logged in members, and the permission their role must be granted to call each one (see
+	// model.Role.Can). Routes without a permission only read or end the calling member's own session, so any
+	// member, and staff impersonating one, may call them.
+	sessionRoutes := []struct {
+		method  string
+		path    string
+		perm    model.Permission
+		handler http.Handler
+	}{
+		{"DELETE", "/api/logout", "", handlers.NewLogoutHandler(srv.sm, srv.audit)},
+		{"POST", "/api/account/password", model.PermSelfManage, handlers.NewChangePasswordHandler(srv.sm, srv.cs, srv.db, notifier, cfg.PasswordPolicy, cfg.PasswordHasher, lh)},
+		{"POST", "/api/account/email", model.PermSelfManage, handlers.NewChangeEmailHandler(srv.sm, srv.db, notifier, cfg.PublicURL, lh)},
+		{"POST", "/api/account/email/verify", model.PermSelfManage, handlers.NewVerifyEmailHandler(srv.sm, srv.cs, srv.db, notifier)},
+		{"GET", "/api/2fa", "", handlers.NewTwoFactorGetHandler(srv.sm, srv.db)},
+		{"DELETE", "/api/2fa", model.PermSelfManage, handlers.NewTwoFactorDeleteHandler(srv.sm, srv.db, lh)},
+		{"POST", "/api/2fa/enroll", model.PermSelfManage, handlers.NewTwoFactorEnrollHandler(srv.sm, srv.db)},
+		{"POST", "/api/2fa/verify", model.PermSelfManage, handlers.NewTwoFactorVerifyHandler(srv.sm, srv.db)},
+
+		{"GET", "/api/metrics", model.PermMetricsRead, handlers.NewMetricsGetHandler(srv.sm, srv.db)},
+		{"GET", "/api/metrics/summary", model.PermMetricsRead, handlers.NewMetricsSummaryGetHandler(srv.sm, srv.db)},
+		{"GET", "/api/metrics/events", model.PermMetricsRead, handlers.NewMetricEventsGetHandler(srv.sm, srv.db)},
+		{"PATCH", "/api/upgrade", model.PermPlanChange, handlers.NewUpgradeHandler(srv.sm, srv.db, srv.alerts, srv.audit)},
+		{"GET", "/api/account/plan-history", model.PermBillingRead, handlers.NewPlanHistoryHandler(srv.sm, srv.db)},
+		{"GET", "/api/invoices", model.PermBillingRead, handlers.NewInvoicesHandler(srv.sm, srv.db)},
+		{"GET", "/api/invoices/{invoiceID}", model.PermBillingRead, handlers.NewInvoiceHandler(srv.sm, srv.db)},
+		{"GET", "/api/alerts", model.PermAlertsRead, handlers.NewUsageAlertsGetHandler(srv.sm, srv.db)},
+		{"PUT", "/api/alerts", model.PermAlertsManage, handlers.NewUsageAlertsPutHandler(srv.sm, srv.db, srv.alerts)},
+		{"GET", "/api/webhooks", model.PermWebhooksRead, handlers.NewWebhooksGetHandler(srv.sm, srv.db)},
+		{"POST", "/api/webhooks", model.PermWebhooksManage, handlers.NewWebhooksPostHandler(srv.sm, srv.db)},
+		{"GET", "/api/webhooks/deliveries", model.PermWebhooksRead, handlers.NewWebhookDeliveriesHandler(srv.sm, srv.db)},
+		{"DELETE", "/api/webhooks/{webhookID}", model.PermWebhooksManage, handlers.NewWebhookDeleteHandler(srv.sm, srv.db)},
+		{"GET", "/api/account/user-inactivity", model.PermMetricsRead, handlers.NewUserInactivityGetHandler(srv.sm, srv.db)},
+		{"PUT", "/api/account/user-inactivity", model.PermUsersManage, handlers.NewUserInactivityPutHandler(srv.sm, srv.db, srv.audit)},
+		{"GET", "/api/users/{userID}/export", model.PermUsersManage, handlers.NewUserExportHandler(srv.sm, srv.db, srv.audit)},
+		{"DELETE", "/api/users/{userID}", model.PermUsersManage, handlers.NewUserEraseHandler(srv.sm, srv.db, srv.alerts, srv.audit)},
+		{"POST", "/api/users/{userID}/activate", model.PermUsersManage, handlers.NewUserActivateHandler(srv.sm, srv.db, srv.alerts, srv.audit, true)},
+		{"POST", "/api/users/{userID}/deactivate", model.PermUsersManage, handlers.NewUserActivateHandler(srv.sm, srv.db, srv.alerts, srv.audit, false)},
+		{"GET", "/api/audit", model.PermAuditRead, handlers.NewAuditGetHandler(srv.sm, srv.db)},
+		{"GET", "/api/devices", model.PermDevicesRead, handlers.NewDevicesGetHandler(srv.sm, srv.db)},
+		{"POST", "/api/devices", model.PermKeysManage, handlers.NewDevicesPostHandler(srv.sm, srv.db, cfg.PublicURL)},
+		{"DELETE", "/api/devices/{serial}", model.PermKeysManage, handlers.NewDeviceDeleteHandler(srv.sm, srv.db)},
+		{"POST", "/api/apikey/signing-key", model.PermKeysManage, handlers.NewSigningKeyHandler(srv.sm, srv.db, srv.keys, srv.audit)},
+		{"GET", "/api/invites", model.PermMembersManage, handlers.NewInvitesGetHandler(srv.sm, srv.db)},
+		{"POST", "/api/invites", model.PermMembersManage, handlers.NewInvitesPostHandler(srv.sm, srv.db, signer, notifier, cfg.PublicURL, srv.audit)},
+		{"DELETE", "/api/invites/{inviteID}", model.PermMembersManage, handlers.NewInviteDeleteHandler(srv.sm, srv.db, srv.audit)},
+	}
+	for _, route := range sessionRoutes {
+		h := srv.limiter.Wrap(route.handler)
+		if route.perm != "" {
+			h = srv.sm.RequirePermission(route.perm, h)
+		}
+		srv.router.Handle(route.path, WithAPIHeaders(srv.sm.WithSessionAuth(h))).Methods(route.method)
+	}
 
-	upgradeHandler := WithAPIHeaders(srv.sm.WithSessionAuth(handlers.NewUpgradeHandler(srv.sm, srv.db)))
-	srv.router.Handle("/api/upgrade", upgradeHandler).Methods("PATCH")
+	// The admin API, for our support staff. It's a separate realm: WithStaffAuth only accepts staff keys, which
+	// aren't accepted anywhere else, so customer sessions and API keys can never reach it.
+	adminRoutes := []struct {
+		method  string
+		path    string
+		handler http.Handler
+	}{
+		{"GET", "/admin/api/accounts", handlers.NewAdminAccountsHandler(srv.db)},
+		{"GET", "/admin/api/accounts/{accountID}", handlers.NewAdminAccountHandler(srv.db)},
+		{"PUT", "/admin/api/accounts/{accountID}/plan", handlers.NewAdminPlanHandler(srv.sm, srv.db, srv.alerts, srv.audit)},
+		{"POST", "/admin/api/accounts/{accountID}/apikey", handlers.NewAdminAPIkeyHandler(srv.db, srv.audit)},
+		{"POST", "/admin/api/accounts/{accountID}/suspend", handlers.NewAdminStatusHandler(srv.sm, srv.db, srv.audit, model.AccountSuspended, cfg.DeletionGrace)},
+		{"POST", "/admin/api/accounts/{accountID}/reactivate", handlers.NewAdminStatusHandler(srv.sm, srv.db, srv.audit, model.AccountActive, cfg.DeletionGrace)},
+		{"DELETE", "/admin/api/accounts/{accountID}", handlers.NewAdminStatusHandler(srv.sm, srv.db, srv.audit, model.AccountPendingDeletion, cfg.DeletionGrace)},
+		{"POST", "/admin/api/accounts/{accountID}/impersonate", handlers.NewAdminImpersonateHandler(srv.sm, srv.db, srv.audit)},
+		{"POST", "/admin/api/logins/unlock", handlers.NewAdminUnlockLoginHandler(srv.db, srv.audit)},
+	}
+	for _, route := range adminRoutes {
+		srv.router.Handle(route.path, WithAPIHeaders(srv.WithStaffAuth(srv.limiter.Wrap(route.handler)))).Methods(route.method)
+	}
 
 	// NOTE: It's important that this handler be registered after the other handlers, or else
 	// all routes return a 404 (at least in development). TODO: figure out why this is the case.
@@ -61,8 +230,20 @@ func New(cfg Config) (*Server, error) {
 	return srv, nil
 }
 
-// Run starts the server
+// Run starts the server and its background jobs
 func (srv *Server) Run() error {
+	srv.startJobs()
+	server := &http.Server{
+		Addr:    fmt.Sprintf(":%v", srv.cfg.Port),
+		Handler: srv.router,
+		TLSConfig: &tls.Config{
+			MinVersion: tls.VersionTLS12,
+			// Devices may authenticate with a client certificate, but browsers and API key clients don't have
+			// one. Certificates aren't verified during the handshake since each account has its own CA,
+			// WithClientCertAuth verifies them against the CA of the account they claim to belong to.
+			ClientAuth: tls.RequestClientCert,
+		},
+	}
 	log.Printf("Server listening on port %v", srv.cfg.Port)
-	return http.ListenAndServeTLS(fmt.Sprintf(":%v", srv.cfg.Port), srv.cfg.CertFilePath, srv.cfg.KeyFilePath, srv.router)
+	return server.ListenAndServeTLS(srv.cfg.CertFilePath, srv.cfg.KeyFilePath)
 }
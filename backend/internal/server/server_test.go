@@ -0,0 +1,623 @@
+package server
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ibeckermayer/teleport-interview/backend/internal/auth"
+	"github.com/ibeckermayer/teleport-interview/backend/internal/database"
+	"github.com/ibeckermayer/teleport-interview/backend/internal/model"
+	"github.com/ibeckermayer/teleport-interview/backend/internal/ratelimit"
+	"github.com/ibeckermayer/teleport-interview/backend/internal/webhook"
+	"github.com/ibeckermayer/teleport-interview/backend/signing"
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/pborman/uuid"
+)
+
+// newTestServer creates a Server with an empty database in a temporary directory, and limits high enough not
+// to get in the way, and serves it until the test ends
+func newTestServer(t *testing.T) (*Server, *httptest.Server) {
+	t.Helper()
+	srv, err := New(Config{
+		Env:            "test",
+		DBFile:         filepath.Join(t.TempDir(), "test.db"),
+		SessionTimeout: time.Hour,
+		PublicURL:      "https://localhost",
+		RateLimit:      ratelimit.Config{Default: ratelimit.RouteLimit{Limit: ratelimit.Limit{Rate: 1000, Burst: 1000}, KeyBy: ratelimit.ByAccount}},
+		PasswordHasher: auth.PasswordHasher{BcryptCost: 4},
+		DeletionGrace:  time.Hour,
+		LoginThrottle: auth.LoginThrottleConfig{
+			FreeFailures:          3,
+			BaseDelay:             time.Minute,
+			MaxDelay:              time.Hour,
+			EmailLockoutThreshold: 10,
+			IPLockoutThreshold:    100,
+			LockoutDuration:       time.Hour,
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := httptest.NewTLSServer(srv.router)
+	t.Cleanup(ts.Close)
+	return srv, ts
+}
+
+// newTestOwner creates an account and returns its owner
+func newTestOwner(t *testing.T, srv *Server, email string) (model.Account, model.Member) {
+	t.Helper()
+	accountID := uuid.New()
+	if err := srv.db.CreateAccount(accountID, email, "correct horse battery staple"); err != nil {
+		t.Fatal(err)
+	}
+	account, err := srv.db.GetAccount(accountID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	member, err := srv.db.GetMemberByEmail(email)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return account, member
+}
+
+// do sends a request with body to ts as the session, returning the response status
+func do(t *testing.T, ts *httptest.Server, method, path string, session auth.SessionID, body string) int {
+	t.Helper()
+	req, err := http.NewRequest(method, ts.URL+path, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if session != "" {
+		req.Header.Set("Authorization", "Bearer "+string(session))
+	}
+	resp, err := ts.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	return resp.StatusCode
+}
+
+func TestImpersonationIsReadOnly(t *testing.T) {
+	srv, ts := newTestServer(t)
+	account, owner := newTestOwner(t, srv, "owner@example.com")
+	impersonated, err := srv.sm.CreateImpersonationSession(account, owner, "staff:support@example.com", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		method string
+		path   string
+		body   string
+	}{
+		{"POST", "/api/invites", `{"email":"staff@example.com","role":"owner"}`},
+		{"DELETE", "/api/invites/inviteID", ``},
+		{"POST", "/api/account/password", `{"currentPassword":"x","newPassword":"y"}`},
+		{"POST", "/api/account/email", `{"password":"x","newEmail":"staff@example.com"}`},
+		{"POST", "/api/account/email/verify", `{"code":"123456"}`},
+		{"POST", "/api/2fa/enroll", ``},
+		{"POST", "/api/2fa/verify", `{"code":"123456"}`},
+		{"DELETE", "/api/2fa", `{"password":"x"}`},
+		{"PATCH", "/api/upgrade", ``},
+		{"PUT", "/api/alerts", `{"percents":[50]}`},
+		{"POST", "/api/webhooks", `{"url":"https://example.com"}`},
+		{"DELETE", "/api/webhooks/webhookID", ``},
+		{"POST", "/api/devices", `{"name":"device"}`},
+		{"DELETE", "/api/devices/1", ``},
+		{"POST", "/api/apikey/signing-key", `{"requireSigned":true}`},
+		{"PUT", "/api/account/user-inactivity", `{"inactivityDays":30}`},
+		{"GET", "/api/users/userID/export", ``},
+		{"DELETE", "/api/users/userID", ``},
+		{"POST", "/api/users/userID/activate", ``},
+		{"POST", "/api/users/userID/deactivate", ``},
+		{"GET", "/api/invites", ``},
+	} {
+		if got := do(t, ts, tc.method, tc.path, impersonated.SessionID, tc.body); got != http.StatusForbidden {
+			t.Errorf("expected %v %v to be forbidden when impersonating, got %v", tc.method, tc.path, got)
+		}
+	}
+
+	for _, tc := range []struct {
+		method string
+		path   string
+	}{
+		{"GET", "/api/metrics"},
+		{"GET", "/api/metrics/summary?event_type=login"},
+		{"GET", "/api/account/plan-history"},
+		{"GET", "/api/invoices"},
+		{"GET", "/api/alerts"},
+		{"GET", "/api/webhooks"},
+		{"GET", "/api/devices"},
+		{"GET", "/api/audit"},
+		{"GET", "/api/2fa"},
+		{"GET", "/api/account/user-inactivity"},
+		{"DELETE", "/api/logout"},
+	} {
+		// Logging out ends the session, so it goes last
+		if got := do(t, ts, tc.method, tc.path, impersonated.SessionID, ""); got < 200 || got >= 300 {
+			t.Errorf("expected %v %v to be allowed when impersonating, got %v", tc.method, tc.path, got)
+		}
+	}
+
+	// The owner can still do what the impersonation couldn't
+	own, err := srv.sm.CreateSession(account, owner)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := do(t, ts, "PUT", "/api/account/user-inactivity", own.SessionID, `{"inactivityDays":30}`); got != http.StatusOK {
+		t.Errorf("expected the owner's own session to change the inactivity policy, got %v", got)
+	}
+}
+
+func TestRolePermissions(t *testing.T) {
+	srv, ts := newTestServer(t)
+	account, owner := newTestOwner(t, srv, "owner@example.com")
+	passwordHash, err := auth.PasswordHasher{BcryptCost: 4}.Hash("correct horse battery staple")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sessions := map[model.Role]auth.SessionID{}
+	for _, role := range []model.Role{model.RoleOwner, model.RoleAdmin, model.RoleViewer} {
+		member := owner
+		if role != model.RoleOwner {
+			now := time.Now()
+			invite := model.Invite{InviteID: uuid.New(), AccountID: account.AccountID, Email: string(role) + "@example.com", Role: role,
+				InvitedBy: owner.MemberID, CreatedAt: now, ExpiresAt: now.Add(time.Hour)}
+			if err := srv.db.CreateInvite(invite); err != nil {
+				t.Fatal(err)
+			}
+			if member, err = srv.db.AcceptInvite(invite.InviteID, passwordHash, now); err != nil {
+				t.Fatal(err)
+			}
+		}
+		session, err := srv.sm.CreateSession(account, member)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sessions[role] = session.SessionID
+	}
+
+	everyone := []model.Role{model.RoleOwner, model.RoleAdmin, model.RoleViewer}
+	admins := []model.Role{model.RoleOwner, model.RoleAdmin}
+	owners := []model.Role{model.RoleOwner}
+	tests := []struct {
+		method  string
+		path    string
+		body    string
+		allowed []model.Role
+	}{
+		{"GET", "/api/metrics", ``, everyone},
+		{"GET", "/api/account/plan-history", ``, everyone},
+		{"GET", "/api/invoices", ``, everyone},
+		{"GET", "/api/alerts", ``, everyone},
+		{"GET", "/api/webhooks", ``, everyone},
+		{"GET", "/api/devices", ``, everyone},
+		{"GET", "/api/audit", ``, everyone},
+		{"GET", "/api/account/user-inactivity", ``, everyone},
+		{"POST", "/api/2fa/enroll", ``, everyone},
+		{"PATCH", "/api/upgrade", ``, admins},
+		{"PUT", "/api/alerts", `{"percents":[50]}`, admins},
+		{"POST", "/api/webhooks", `{"url":"https://example.com"}`, admins},
+		{"DELETE", "/api/webhooks/webhookID", ``, admins},
+		{"DELETE", "/api/devices/1", ``, admins},
+		{"POST", "/api/apikey/signing-key", `{}`, admins},
+		{"PUT", "/api/account/user-inactivity", `{"inactivityDays":30}`, admins},
+		{"GET", "/api/users/userID/export", ``, admins},
+		{"DELETE", "/api/users/userID", ``, admins},
+		{"POST", "/api/users/userID/activate", ``, admins},
+		{"POST", "/api/users/userID/deactivate", ``, admins},
+		{"GET", "/api/invites", ``, owners},
+		{"POST", "/api/invites", `{"email":"new@example.com","role":"viewer"}`, owners},
+		{"DELETE", "/api/invites/inviteID", ``, owners},
+	}
+	for _, tt := range tests {
+		allowed := map[model.Role]bool{}
+		for _, role := range tt.allowed {
+			allowed[role] = true
+		}
+		for _, role := range everyone {
+			got := do(t, ts, tt.method, tt.path, sessions[role], tt.body)
+			if allowed[role] && (got == http.StatusForbidden || got == http.StatusUnauthorized) {
+				t.Errorf("expected %v %v to be allowed for an %v, got %v", tt.method, tt.path, role, got)
+			}
+			if !allowed[role] && got != http.StatusForbidden {
+				t.Errorf("expected %v %v to be forbidden for an %v, got %v", tt.method, tt.path, role, got)
+			}
+		}
+	}
+}
+
+func TestSignedRequests(t *testing.T) {
+	srv, ts := newTestServer(t)
+	account, owner := newTestOwner(t, srv, "owner@example.com")
+	key, err := auth.NewKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := srv.db.ResetAPIkey(key, account.AccountID); err != nil {
+		t.Fatal(err)
+	}
+	session, err := srv.sm.CreateSession(account, owner)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	issue := func(requireSigned bool) string {
+		t.Helper()
+		req, _ := http.NewRequest("POST", ts.URL+"/api/apikey/signing-key", strings.NewReader(fmt.Sprintf(`{"requireSigned":%v}`, requireSigned)))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+string(session.SessionID))
+		resp, err := ts.Client().Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		var body struct {
+			SigningKey string `json:"signingKey"`
+		}
+		if err := json.NewDecoder(resp.Body).Decode(&body); err != nil || resp.StatusCode != http.StatusOK {
+			t.Fatalf("got %v, %v issuing a signing key", resp.StatusCode, err)
+		}
+		return body.SigningKey
+	}
+	metric := `{"account_id":"` + account.AccountID + `","user_id":"u1","timestamp":"2020-01-01T00:00:00Z"}`
+	post := func(req *http.Request) int {
+		t.Helper()
+		resp, err := ts.Client().Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	signed := func(signingKey string) *http.Request {
+		t.Helper()
+		req, _ := http.NewRequest("POST", ts.URL+"/api/metrics", strings.NewReader(metric))
+		if err := signing.SignRequest(req, signingKey, time.Now()); err != nil {
+			t.Fatal(err)
+		}
+		return req
+	}
+	bearer := func() *http.Request {
+		req, _ := http.NewRequest("POST", ts.URL+"/api/metrics", strings.NewReader(metric))
+		req.Header.Set("Authorization", "Bearer "+string(key))
+		return req
+	}
+
+	// Until a signing key is issued, only the bearer key works
+	if got := post(signed(auth.HashKey(key))); got != http.StatusForbidden {
+		t.Errorf("expected a request signed with the stored key hash to be refused, got %v", got)
+	}
+	if got := post(bearer()); got != http.StatusOK {
+		t.Errorf("expected the bearer key to be accepted, got %v", got)
+	}
+
+	signingKey := issue(false)
+	req := signed(signingKey)
+	replay := req.Clone(req.Context())
+	replay.Body, _ = req.GetBody()
+	if got := post(req); got != http.StatusOK {
+		t.Fatalf("expected a correctly signed request to be accepted, got %v", got)
+	}
+	if got := post(replay); got != http.StatusForbidden {
+		t.Errorf("expected a replayed request to be refused, got %v", got)
+	}
+	if got := post(bearer()); got != http.StatusOK {
+		t.Errorf("expected the bearer key to still be accepted, got %v", got)
+	}
+
+	// Issuing a new signing key replaces the old one, and can turn off bearer requests
+	newSigningKey := issue(true)
+	if got := post(signed(signingKey)); got != http.StatusForbidden {
+		t.Errorf("expected a request signed with the replaced signing key to be refused, got %v", got)
+	}
+	if got := post(signed(newSigningKey)); got != http.StatusOK {
+		t.Errorf("expected a request signed with the new signing key to be accepted, got %v", got)
+	}
+	if got := post(bearer()); got != http.StatusForbidden {
+		t.Errorf("expected the bearer key to be refused once signed requests are required, got %v", got)
+	}
+}
+
+func TestParallelLoginsAreThrottled(t *testing.T) {
+	srv, ts := newTestServer(t)
+	newTestOwner(t, srv, "owner@example.com")
+
+	// Every attempt is made before any has finished, but no more than the free failures plus the one
+	// that starts the delay may check the password
+	const attempts = 20
+	statuses := make(chan int, attempts)
+	wg := sync.WaitGroup{}
+	for i := 0; i < attempts; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			statuses <- do(t, ts, "POST", "/api/login", "", `{"email": "owner@example.com", "password": "wrong"}`)
+		}()
+	}
+	wg.Wait()
+	close(statuses)
+	checked := 0
+	for status := range statuses {
+		switch status {
+		case http.StatusUnauthorized:
+			checked++
+		case http.StatusTooManyRequests:
+		default:
+			t.Fatalf("unexpected status %v", status)
+		}
+	}
+	if checked > 4 {
+		t.Fatalf("expected at most 4 passwords to be checked but %v were", checked)
+	}
+
+	login := `{"email": "owner@example.com", "password": "correct horse battery staple"}`
+	if status := do(t, ts, "POST", "/api/login", "", login); status != http.StatusTooManyRequests {
+		t.Fatalf("expected the correct password to be throttled too but got %v", status)
+	}
+
+	// Staff unlock the email address and the IP through the admin API
+	staffKey, err := auth.NewKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := srv.db.CreateStaff("support@example.com", staffKey); err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		emailOrIP string
+		expected  int
+	}{
+		{"owner@example.com", http.StatusNoContent},
+		{"127.0.0.1", http.StatusNoContent},
+		{"owner@example.com", http.StatusNotFound},
+	} {
+		body := fmt.Sprintf(`{"emailOrIP": %q}`, tc.emailOrIP)
+		if status := do(t, ts, "POST", "/admin/api/logins/unlock", auth.SessionID(staffKey), body); status != tc.expected {
+			t.Fatalf("unlocking %v: expected %v but got %v", tc.emailOrIP, tc.expected, status)
+		}
+	}
+	if status := do(t, ts, "POST", "/admin/api/logins/unlock", "", `{"emailOrIP": "127.0.0.1"}`); status != http.StatusUnauthorized {
+		t.Fatalf("expected unlocking without a staff key to be refused but got %v", status)
+	}
+
+	if status := do(t, ts, "POST", "/api/login", "", login); status != http.StatusOK {
+		t.Fatalf("expected the login to succeed once unlocked but got %v", status)
+	}
+}
+
+func TestPasswordChecksAreThrottled(t *testing.T) {
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		body   string // with the wrong password
+	}{
+		{"disable 2FA", "DELETE", "/api/2fa", `{"password":"wrong","code":"000000"}`},
+		{"change password", "POST", "/api/account/password", `{"currentPassword":"wrong","newPassword":"a much longer passphrase"}`},
+		{"change email", "POST", "/api/account/email", `{"password":"wrong","newEmail":"new@example.com"}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv, ts := newTestServer(t)
+			account, owner := newTestOwner(t, srv, "owner@example.com")
+			now := time.Now()
+			if _, err := srv.db.SetPendingTOTP(owner.MemberID, "JBSWY3DPEHPK3PXP", now); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := srv.db.EnableTOTP(owner.MemberID, 0, nil, now); err != nil {
+				t.Fatal(err)
+			}
+			session, err := srv.sm.CreateSession(account, owner)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// Wrong passwords sent with a session count towards the same limits as those sent to log in
+			throttled := false
+			for i := 0; i < 10 && !throttled; i++ {
+				switch status := do(t, ts, tt.method, tt.path, session.SessionID, tt.body); status {
+				case http.StatusForbidden:
+				case http.StatusTooManyRequests:
+					throttled = true
+				default:
+					t.Fatalf("unexpected status %v", status)
+				}
+			}
+			if !throttled {
+				t.Fatal("expected wrong passwords to be throttled")
+			}
+			login := `{"email": "owner@example.com", "password": "correct horse battery staple"}`
+			if status := do(t, ts, "POST", "/api/login", "", login); status != http.StatusTooManyRequests {
+				t.Fatalf("expected logging in to be throttled too but got %v", status)
+			}
+		})
+	}
+}
+
+func TestSuspendedAccounts(t *testing.T) {
+	tests := []struct {
+		name   string
+		method string
+		path   string // after "/admin/api/accounts/<account ID>"
+	}{
+		{"suspend", "POST", "/suspend"},
+		{"delete", "DELETE", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv, ts := newTestServer(t)
+			account, owner := newTestOwner(t, srv, "owner@example.com")
+			staffKey, err := auth.NewKey()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := srv.db.CreateStaff("support@example.com", staffKey); err != nil {
+				t.Fatal(err)
+			}
+			apiKey, err := auth.NewKey()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := srv.db.ResetAPIkey(apiKey, account.AccountID); err != nil {
+				t.Fatal(err)
+			}
+			apikey, err := srv.db.SetSigningKey(account.AccountID, "signingKeyID", false)
+			if err != nil {
+				t.Fatal(err)
+			}
+			session, err := srv.sm.CreateSession(account, owner)
+			if err != nil {
+				t.Fatal(err)
+			}
+			postMetric := func() int {
+				t.Helper()
+				body := `{"account_id":"` + account.AccountID + `","user_id":"u1","timestamp":"2020-01-01T00:00:00Z"}`
+				req, _ := http.NewRequest("POST", ts.URL+"/api/metrics", strings.NewReader(body))
+				if err := signing.SignRequest(req, srv.keys.Key(apikey.KeyHash, apikey.SigningKeyID), time.Now()); err != nil {
+					t.Fatal(err)
+				}
+				resp, err := ts.Client().Do(req)
+				if err != nil {
+					t.Fatal(err)
+				}
+				resp.Body.Close()
+				return resp.StatusCode
+			}
+			login := `{"email": "owner@example.com", "password": "correct horse battery staple"}`
+			accountPath := "/admin/api/accounts/" + account.AccountID
+
+			if got := do(t, ts, tt.method, accountPath+tt.path, auth.SessionID(staffKey), ""); got != http.StatusOK {
+				t.Fatalf("got status %v from the admin API", got)
+			}
+			for _, check := range []struct {
+				name string
+				got  int
+				want int
+			}{
+				{"using an existing session", do(t, ts, "GET", "/api/metrics", session.SessionID, ""), http.StatusUnauthorized},
+				{"logging in", do(t, ts, "POST", "/api/login", "", login), http.StatusForbidden},
+				{"sending a metric", postMetric(), http.StatusForbidden},
+			} {
+				if check.got != check.want {
+					t.Errorf("%v: got %v, want %v", check.name, check.got, check.want)
+				}
+			}
+
+			if got := do(t, ts, "POST", accountPath+"/reactivate", auth.SessionID(staffKey), ""); got != http.StatusOK {
+				t.Fatalf("got status %v reactivating the account", got)
+			}
+			if got := do(t, ts, "POST", "/api/login", "", login); got != http.StatusOK {
+				t.Errorf("expected logging in to work again once reactivated, got %v", got)
+			}
+			if got := postMetric(); got != http.StatusOK {
+				t.Errorf("expected metrics to be accepted again once reactivated, got %v", got)
+			}
+		})
+	}
+}
+
+func TestDeletedAccountsArePurged(t *testing.T) {
+	srv, ts := newTestServer(t)
+	account, _ := newTestOwner(t, srv, "owner@example.com")
+	staffKey, err := auth.NewKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := srv.db.CreateStaff("support@example.com", staffKey); err != nil {
+		t.Fatal(err)
+	}
+	if got := do(t, ts, "DELETE", "/admin/api/accounts/"+account.AccountID, auth.SessionID(staffKey), ""); got != http.StatusOK {
+		t.Fatalf("got status %v deleting the account", got)
+	}
+
+	// Nothing is purged within the grace period
+	if err := srv.purgeDeletedAccounts(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := srv.db.GetAccount(account.AccountID); err != nil {
+		t.Fatalf("expected the account to be kept during the grace period but got %v", err)
+	}
+
+	now := time.Now()
+	if _, err := srv.db.SetAccountStatus(account.AccountID, model.AccountPendingDeletion, now.Add(-time.Minute), now); err != nil {
+		t.Fatal(err)
+	}
+	if err := srv.purgeDeletedAccounts(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := srv.db.GetAccount(account.AccountID); err != sql.ErrNoRows {
+		t.Fatalf("expected the account to be purged but got %v", err)
+	}
+	events, err := srv.db.GetAuditEvents(account.AccountID, database.AuditFilter{}, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) == 0 || events[0].Action != model.AuditAccountPurge {
+		t.Fatalf("expected the account's audit events to be kept, ending with its purge, but got %+v", events)
+	}
+	if got := do(t, ts, "DELETE", "/admin/api/accounts/"+account.AccountID, auth.SessionID(staffKey), ""); got != http.StatusNotFound {
+		t.Errorf("expected the purged account not to be found, got %v", got)
+	}
+}
+
+func TestWebhooksAreDeliveredConcurrently(t *testing.T) {
+	srv, _ := newTestServer(t)
+	account, _ := newTestOwner(t, srv, "owner@example.com")
+
+	var mtx sync.Mutex
+	inFlight, maxInFlight := 0, 0
+	receiver := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mtx.Lock()
+		inFlight++
+		if inFlight > maxInFlight {
+			maxInFlight = inFlight
+		}
+		mtx.Unlock()
+		time.Sleep(50 * time.Millisecond)
+		mtx.Lock()
+		inFlight--
+		mtx.Unlock()
+	}))
+	defer receiver.Close()
+	// The receiver is on a loopback address, which the server's own client refuses
+	srv.sender = webhook.NewSender(receiver.Client())
+
+	if _, err := srv.db.CreateWebhook(account.AccountID, receiver.URL, "secret"); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3*webhookWorkers; i++ {
+		if err := srv.db.CreateEvent(account.AccountID, model.EventUserCreated, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := srv.deliverWebhooks(); err != nil {
+		t.Fatal(err)
+	}
+	deliveries, err := srv.db.GetDeliveries(account.AccountID, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, d := range deliveries {
+		if d.Status != model.DeliveryDelivered {
+			t.Errorf("expected delivery_id=%v to be %v but it's %v", d.DeliveryID, model.DeliveryDelivered, d.Status)
+		}
+	}
+	if maxInFlight < 2 || maxInFlight > webhookWorkers {
+		t.Errorf("expected between 2 and %v deliveries to be sent at once, but %v were", webhookWorkers, maxInFlight)
+	}
+}
@@ -5,8 +5,11 @@ import (
 	"errors"
 	"io"
 	"log"
+	"net"
 	"net/http"
+	"strconv"
 	"strings"
+	"time"
 )
 
 // BodyMaxSize is the maximum size of a json body
@@ -84,3 +87,21 @@ func ErrorJSON(w http.ResponseWriter, error string, code int) {
 		return
 	}
 }
+
+// TooManyRequests responds with a 429 JSON error and a Retry-After header telling the client
+// how many (whole) seconds to wait before trying again
+func TooManyRequests(w http.ResponseWriter, retryAfter time.Duration) {
+	seconds := int64((retryAfter + time.Second - 1) / time.Second)
+	w.Header().Set("Retry-After", strconv.FormatInt(seconds, 10))
+	ErrorJSON(w, http.StatusText(http.StatusTooManyRequests), http.StatusTooManyRequests)
+}
+
+// ClientIP returns the IP address of the client that sent r. The server isn't deployed
+// behind a proxy, so forwarding headers like X-Forwarded-For are deliberately not trusted.
+func ClientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
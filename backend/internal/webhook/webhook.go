@@ -0,0 +1,269 @@
+// Package webhook signs and sends account events to the URLs accounts register to receive them.
+//
+// Every request is a POST with a JSON Event body and the headers below. Receivers should check the
+// signature, which is the hex encoded HMAC-SHA256 of "<timestamp>.<body>" keyed with the webhook's secret,
+// and reject requests with stale timestamps.
+package webhook
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/pborman/uuid"
+
+	"github.com/ibeckermayer/teleport-interview/backend/internal/model"
+)
+
+const (
+	// SignatureHeader carries "sha256=" followed by the request's signature
+	SignatureHeader = "X-Webhook-Signature"
+	// TimestampHeader carries the unix time at which the request was signed
+	TimestampHeader = "X-Webhook-Timestamp"
+	// EventHeader carries the event's type
+	EventHeader = "X-Webhook-Event"
+	// DeliveryHeader carries the delivery's ID, which is unchanged across retries
+	DeliveryHeader = "X-Webhook-Delivery"
+
+	// MaxAttempts is how many times a delivery is attempted before it is marked as failed
+	MaxAttempts = 10
+
+	baseBackoff = 30 * time.Second
+	maxBackoff  = 6 * time.Hour
+)
+
+var (
+	// ErrBadURL is returned by ValidateURL for URLs that webhooks can't be sent to
+	ErrBadURL = errors.New("webhook url must be an absolute http or https url")
+	// ErrAddressNotAllowed is returned when a webhook's host resolves to an address on the server's own network
+	ErrAddressNotAllowed = errors.New("webhook address is not allowed")
+
+	// privateNets are the IPv4 and IPv6 private address ranges (RFC 1918 and RFC 4193)
+	privateNets = []*net.IPNet{
+		mustParseCIDR("10.0.0.0/8"),
+		mustParseCIDR("172.16.0.0/12"),
+		mustParseCIDR("192.168.0.0/16"),
+		mustParseCIDR("fc00::/7"),
+	}
+)
+
+func mustParseCIDR(s string) *net.IPNet {
+	_, n, err := net.ParseCIDR(s)
+	if err != nil {
+		panic(err)
+	}
+	return n
+}
+
+// Event is the JSON body of every webhook request
+type Event struct {
+	ID        string          `json:"id"`
+	Type      model.EventType `json:"type"`
+	AccountID string          `json:"account_id"`
+	CreatedAt time.Time       `json:"created_at"`
+	Data      interface{}     `json:"data"`
+}
+
+// ValidateURL checks that rawurl is something webhooks can be sent to
+func ValidateURL(rawurl string) error {
+	u, err := url.Parse(rawurl)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return ErrBadURL
+	}
+	return nil
+}
+
+// Sign returns the hex encoded HMAC-SHA256 of "<timestamp>.<body>" keyed with secret
+func Sign(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "%d.", timestamp)
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify checks a signature produced by Sign in constant time
+func Verify(secret, signature string, timestamp int64, body []byte) bool {
+	return hmac.Equal([]byte(Sign(secret, timestamp, body)), []byte(signature))
+}
+
+// Backoff returns how long to wait before retrying a delivery that has failed attempts times,
+// doubling from 30s up to a maximum of 6h
+func Backoff(attempts int) time.Duration {
+	d := baseBackoff
+	for i := 1; i < attempts && d < maxBackoff; i++ {
+		d *= 2
+	}
+	if d > maxBackoff {
+		d = maxBackoff
+	}
+	return d
+}
+
+// AllowedIP reports whether webhooks may be sent to ip, which must not be a loopback, private, link-local or
+// unspecified address so that a webhook can't be used to reach the server itself or services on its network
+func AllowedIP(ip net.IP) bool {
+	if ip.IsLoopback() || ip.IsUnspecified() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() {
+		return false
+	}
+	for _, n := range privateNets {
+		if n.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
+
+// checkAddress is a net.Dialer Control function that refuses to connect to addresses AllowedIP rejects.
+// It's called with the resolved address of each connection, so a hostname can't pass ValidateURL and then
+// resolve to an internal address when the webhook is sent.
+func checkAddress(network, address string, c syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	if ip := net.ParseIP(host); ip == nil || !AllowedIP(ip) {
+		return ErrAddressNotAllowed
+	}
+	return nil
+}
+
+// NewClient creates an *http.Client for sending webhooks that gives up on requests after timeout, only connects
+// to addresses AllowedIP accepts and doesn't follow redirects, which would otherwise be requested from the
+// server wherever they pointed. A redirect response counts as a failed delivery.
+func NewClient(timeout time.Duration) *http.Client {
+	dialer := &net.Dialer{Timeout: timeout, Control: checkAddress}
+	return &http.Client{
+		Timeout: timeout,
+		// No Proxy, since the dialer would then only check the proxy's address
+		Transport: &http.Transport{
+			DialContext:         dialer.DialContext,
+			TLSHandshakeTimeout: timeout,
+			MaxIdleConns:        100,
+			IdleConnTimeout:     90 * time.Second,
+		},
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+// Sender sends signed webhook requests
+type Sender struct {
+	client *http.Client
+}
+
+// NewSender creates a new *Sender that sends requests with client, which should be created with NewClient
+// unless it's only used for tests
+func NewSender(client *http.Client) *Sender {
+	return &Sender{client}
+}
+
+// SendError is returned by Sender.Send when a webhook couldn't be delivered. Its message is a short reason
+// that's safe to show the account, while Err holds the underlying error, if any, for the server's logs.
+type SendError struct {
+	Reason string
+	Err    error
+}
+
+func (e *SendError) Error() string {
+	return e.Reason
+}
+
+func (e *SendError) Unwrap() error {
+	return e.Err
+}
+
+// reason describes why a request failed without revealing anything about the server's network
+func reason(err error) string {
+	var dnsErr *net.DNSError
+	var netErr net.Error
+	switch {
+	case errors.Is(err, ErrAddressNotAllowed):
+		return "address not allowed"
+	case errors.As(err, &dnsErr):
+		return "host not found"
+	case errors.As(err, &netErr) && netErr.Timeout():
+		return "timed out"
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return "connection refused"
+	default:
+		return "connection failed"
+	}
+}
+
+// Send signs and POSTs a delivery's payload to hook.URL. Returns the response's status code (0 if there
+// was no response) and a *SendError unless the receiver responded with a 2xx status.
+func (s *Sender) Send(hook model.Webhook, delivery model.WebhookDelivery, now time.Time) (int, error) {
+	body := []byte(delivery.Payload)
+	req, err := http.NewRequest("POST", hook.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, &SendError{"invalid url", err}
+	}
+
+	timestamp := now.Unix()
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(SignatureHeader, "sha256="+Sign(hook.Secret, timestamp, body))
+	req.Header.Set(TimestampHeader, strconv.FormatInt(timestamp, 10))
+	req.Header.Set(EventHeader, string(delivery.EventType))
+	req.Header.Set(DeliveryHeader, delivery.DeliveryID)
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return 0, &SendError{reason(err), err}
+	}
+	defer resp.Body.Close()
+	// Drain (a bounded amount of) the body so the connection can be reused
+	io.Copy(ioutil.Discard, io.LimitReader(resp.Body, 1<<16))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, &SendError{fmt.Sprintf("responded with status %v", resp.StatusCode), nil}
+	}
+	return resp.StatusCode, nil
+}
+
+// MarshalEvent builds the JSON payload for an event
+func MarshalEvent(e Event) (string, error) {
+	b, err := json.Marshal(e)
+	return string(b), err
+}
+
+// RecordAttempt applies the outcome of sending d (the statusCode and error returned by Sender.Send) to d,
+// returning the updated delivery and a log entry for the attempt. Failed deliveries are retried after
+// Backoff(d.Attempts) until MaxAttempts is reached.
+func RecordAttempt(d model.WebhookDelivery, statusCode int, sendErr error, now time.Time) (model.WebhookDelivery, model.WebhookAttempt) {
+	a := model.WebhookAttempt{
+		AttemptID:   uuid.New(),
+		DeliveryID:  d.DeliveryID,
+		StatusCode:  statusCode,
+		AttemptedAt: now,
+	}
+	d.Attempts++
+
+	if sendErr == nil {
+		d.Status = model.DeliveryDelivered
+		d.LastError = ""
+		d.DeliveredAt = &now
+		return d, a
+	}
+
+	a.Error = sendErr.Error()
+	d.LastError = a.Error
+	if d.Attempts >= MaxAttempts {
+		d.Status = model.DeliveryFailed
+	} else {
+		d.NextAttemptAt = now.Add(Backoff(d.Attempts))
+	}
+	return d, a
+}
@@ -0,0 +1,199 @@
+package webhook
+
+import (
+	"errors"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ibeckermayer/teleport-interview/backend/internal/model"
+)
+
+func testDelivery(t *testing.T) model.WebhookDelivery {
+	payload, err := MarshalEvent(Event{ID: "eventID", Type: model.EventUserCreated, AccountID: "accountID", CreatedAt: time.Now()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return model.WebhookDelivery{
+		DeliveryID: "deliveryID",
+		EventID:    "eventID",
+		EventType:  model.EventUserCreated,
+		Payload:    payload,
+		Status:     model.DeliveryPending,
+	}
+}
+
+func TestSendSigned(t *testing.T) {
+	secret := "secret"
+	delivery := testDelivery(t)
+
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		timestamp, err := strconv.ParseInt(r.Header.Get(TimestampHeader), 10, 64)
+		if err != nil {
+			t.Error(err)
+		}
+		sig := strings.TrimPrefix(r.Header.Get(SignatureHeader), "sha256=")
+		if !Verify(secret, sig, timestamp, body) {
+			t.Error("signature did not verify")
+		}
+		if r.Header.Get(EventHeader) != string(model.EventUserCreated) || r.Header.Get(DeliveryHeader) != "deliveryID" {
+			t.Error("missing event or delivery header")
+		}
+		if string(body) != delivery.Payload {
+			t.Errorf("expected body %v but got %v", delivery.Payload, string(body))
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer ts.Close()
+
+	hook := model.Webhook{URL: ts.URL, Secret: secret}
+	code, err := NewSender(ts.Client()).Send(hook, delivery, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if code != http.StatusNoContent {
+		t.Fatalf("expected %v but got %v", http.StatusNoContent, code)
+	}
+}
+
+func TestSendErrorStatus(t *testing.T) {
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer ts.Close()
+
+	hook := model.Webhook{URL: ts.URL, Secret: "secret"}
+	code, err := NewSender(ts.Client()).Send(hook, testDelivery(t), time.Now())
+	if err == nil {
+		t.Fatal("expected an error for a non-2xx response")
+	}
+	if code != http.StatusServiceUnavailable {
+		t.Fatalf("expected %v but got %v", http.StatusServiceUnavailable, code)
+	}
+}
+
+func TestSendRefusesInternalAddresses(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("request was sent to a loopback address")
+	}))
+	defer ts.Close()
+
+	hook := model.Webhook{URL: ts.URL, Secret: "secret"}
+	_, err := NewSender(NewClient(time.Second)).Send(hook, testDelivery(t), time.Now())
+	if !errors.Is(err, ErrAddressNotAllowed) {
+		t.Fatalf("expected %v but got %v", ErrAddressNotAllowed, err)
+	}
+	// The error is recorded for the account to see, so it mustn't include the address
+	if err.Error() != "address not allowed" {
+		t.Fatalf("expected a generic reason but got %q", err.Error())
+	}
+}
+
+func TestSendErrorReasons(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(100 * time.Millisecond)
+	}))
+	defer ts.Close()
+	closed := httptest.NewServer(http.NotFoundHandler())
+	closed.Close()
+
+	tests := []struct {
+		client *http.Client
+		url    string
+		reason string
+	}{
+		{&http.Client{Timeout: 10 * time.Millisecond}, ts.URL, "timed out"},
+		{http.DefaultClient, closed.URL, "connection refused"},
+		{http.DefaultClient, "http://host.invalid", "host not found"},
+	}
+	for _, tt := range tests {
+		hook := model.Webhook{URL: tt.url, Secret: "secret"}
+		if _, err := NewSender(tt.client).Send(hook, testDelivery(t), time.Now()); err == nil || err.Error() != tt.reason {
+			t.Errorf("expected %q for %v but got %v", tt.reason, tt.url, err)
+		}
+	}
+}
+
+func TestAllowedIP(t *testing.T) {
+	tests := []struct {
+		ip      string
+		allowed bool
+	}{
+		{"93.184.216.34", true},
+		{"2606:2800:220:1:248:1893:25c8:1946", true},
+		{"127.0.0.1", false},
+		{"::1", false},
+		{"10.1.2.3", false},
+		{"172.16.0.1", false},
+		{"192.168.1.1", false},
+		{"fd00::1", false},
+		{"169.254.169.254", false},
+		{"fe80::1", false},
+		{"0.0.0.0", false},
+		{"::", false},
+		{"::ffff:127.0.0.1", false},
+	}
+	for _, tt := range tests {
+		if got := AllowedIP(net.ParseIP(tt.ip)); got != tt.allowed {
+			t.Errorf("expected AllowedIP(%v) to be %v", tt.ip, tt.allowed)
+		}
+	}
+}
+
+func TestVerifyWrongSecret(t *testing.T) {
+	body := []byte(`{"id":"eventID"}`)
+	sig := Sign("secret", 1, body)
+	if Verify("wrong", sig, 1, body) {
+		t.Fatal("signature verified with the wrong secret")
+	}
+	if Verify("secret", sig, 2, body) {
+		t.Fatal("signature verified with the wrong timestamp")
+	}
+}
+
+func TestRecordAttempt(t *testing.T) {
+	now := time.Now()
+	d := testDelivery(t)
+
+	d, a := RecordAttempt(d, http.StatusServiceUnavailable, errTest, now)
+	if d.Status != model.DeliveryPending || d.Attempts != 1 || !d.NextAttemptAt.Equal(now.Add(baseBackoff)) {
+		t.Fatalf("unexpected delivery after first failure %+v", d)
+	}
+	if a.StatusCode != http.StatusServiceUnavailable || a.Error != errTest.Error() {
+		t.Fatalf("unexpected attempt %+v", a)
+	}
+
+	d, _ = RecordAttempt(d, 0, errTest, now)
+	if !d.NextAttemptAt.Equal(now.Add(2 * baseBackoff)) {
+		t.Fatalf("expected backoff to double but next attempt is at %v", d.NextAttemptAt)
+	}
+
+	d, _ = RecordAttempt(d, http.StatusOK, nil, now)
+	if d.Status != model.DeliveryDelivered || d.DeliveredAt == nil || d.LastError != "" {
+		t.Fatalf("unexpected delivery after success %+v", d)
+	}
+}
+
+func TestRecordAttemptGivesUp(t *testing.T) {
+	d := testDelivery(t)
+	for i := 0; i < MaxAttempts; i++ {
+		d, _ = RecordAttempt(d, 0, errTest, time.Now())
+	}
+	if d.Status != model.DeliveryFailed {
+		t.Fatalf("expected %v after %v attempts but got %v", model.DeliveryFailed, MaxAttempts, d.Status)
+	}
+}
+
+func TestBackoffCapped(t *testing.T) {
+	if Backoff(100) != maxBackoff {
+		t.Fatalf("expected %v but got %v", maxBackoff, Backoff(100))
+	}
+}
+
+var errTest = errors.New("test error")
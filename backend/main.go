@@ -2,9 +2,17 @@ package main
 
 import (
 	"flag"
+	"fmt"
 	"log"
+	"os"
+	"strings"
 	"time"
 
+	"github.com/ibeckermayer/teleport-interview/backend/internal/auth"
+	"github.com/ibeckermayer/teleport-interview/backend/internal/database"
+	"github.com/ibeckermayer/teleport-interview/backend/internal/notify"
+	"github.com/ibeckermayer/teleport-interview/backend/internal/oidc"
+	"github.com/ibeckermayer/teleport-interview/backend/internal/ratelimit"
 	"github.com/ibeckermayer/teleport-interview/backend/internal/server"
 	_ "github.com/mattn/go-sqlite3"
 )
@@ -15,6 +23,30 @@ func main() {
 	keyFilePath := flag.String("key", "../certs/localhost.key", "Relative path to the cert's private key")
 	sessionTimeout := flag.String("sesh", "12h", "A parseable duration string (https://golang.org/pkg/time/#ParseDuration) specifying the absolute timeout value for user sessions")
 	env := flag.String("env", "prod", "System environment, can be one of \"dev\" or \"prod\". The env value will determine whether the production or development database is created/used; if \"dev\", the app will seed the database with sample data for manual testing.")
+	trialDuration := flag.String("trial", "336h", "A parseable duration string (https://golang.org/pkg/time/#ParseDuration) specifying how long new accounts get the ENTERPRISE plan for free before reverting to FREE; \"0s\" disables trials")
+	deletionGrace := flag.String("deletion-grace", "720h", "A parseable duration string (https://golang.org/pkg/time/#ParseDuration) specifying how long accounts staff delete are kept, suspended, before they and all of their data are purged")
+	publicURL := flag.String("public-url", "", "The URL the app is served at, used for links in emails; default \"https://localhost:<port>\"")
+	smtpAddr := flag.String("smtp-addr", "", "host:port of the SMTP server used to send notification emails; if empty, notifications are only logged. The SMTP password, if any, is read from the SMTP_PASSWORD environment variable")
+	smtpFrom := flag.String("smtp-from", "noreply@localhost", "Address notification emails are sent from")
+	smtpUser := flag.String("smtp-user", "", "Username for authenticating to the SMTP server, if it requires authentication")
+	notifyFile := flag.String("notify-file", "", "Path to a file notification emails are appended to instead of being logged, when -smtp-addr isn't set")
+	lockoutThreshold := flag.Int("login-lockout-threshold", 10, "Number of failed logins for an email address after which it's temporarily locked out")
+	ipLockoutThreshold := flag.Int("login-ip-lockout-threshold", 50, "Number of failed logins from a client IP after which it's temporarily locked out")
+	lockoutDuration := flag.String("login-lockout-duration", "15m", "A parseable duration string (https://golang.org/pkg/time/#ParseDuration) specifying how long a locked out email address or IP stays locked out, and how long failed logins are remembered")
+	unlock := flag.String("unlock", "", "An email address or client IP to unlock after too many failed logins. The server exits after unlocking instead of serving")
+	addStaff := flag.String("add-staff", "", "Email address of a member of support staff to create an admin API key for. The key is printed once and the server exits instead of serving")
+	rateLimitConfig := flag.String("ratelimit-config", "", "Path to a JSON file of per-route and per-plan rate limits (see ratelimit.Config); routes it doesn't mention keep their default limits")
+	passwordMinLength := flag.Int("password-min-length", 8, "Shortest password accounts may set, in characters")
+	passwordMinStrength := flag.Int("password-min-strength", 2, "Lowest password strength score accounts may set, from 0 (trivially guessable) to 4 (very hard to guess)")
+	breachedPasswords := flag.String("breached-passwords", "", "Path to a Have I Been Pwned passwords list in its SHA-1 \"ordered by hash\" format (https://haveibeenpwned.com/Passwords); passwords in it can't be set. If empty, passwords aren't checked for breaches")
+	passwordHash := flag.String("password-hash", auth.Bcrypt, "Algorithm new password hashes are made with, \"bcrypt\" or \"argon2id\". Existing hashes made with a different algorithm or parameters are upgraded when their account next logs in")
+	bcryptCost := flag.Int("bcrypt-cost", 12, "bcrypt cost parameter for new password hashes, from 4 to 31; each increment doubles the time hashing takes")
+	argon2Time := flag.Uint("argon2-time", auth.DefaultArgon2Time, "argon2id passes over memory for new password hashes")
+	argon2Memory := flag.Uint("argon2-memory", auth.DefaultArgon2Memory, "argon2id memory in KiB for new password hashes")
+	argon2Threads := flag.Uint("argon2-threads", auth.DefaultArgon2Threads, "argon2id parallelism for new password hashes, from 1 to 255")
+	oidcIssuer := flag.String("oidc-issuer", "", "Issuer URL of an OpenID Connect identity provider accounts can log in with instead of a password; if empty, single sign-on is disabled. The client secret, if any, is read from the OIDC_CLIENT_SECRET environment variable")
+	oidcClientID := flag.String("oidc-client-id", "", "Client ID the app is registered with at the -oidc-issuer identity provider")
+	oidcRedirectURL := flag.String("oidc-redirect-url", "", "Redirect URI the app is registered with at the -oidc-issuer identity provider; default \"<public-url>/sso\"")
 	flag.Parse()
 
 	timeout, err := time.ParseDuration(*sessionTimeout)
@@ -22,12 +54,138 @@ func main() {
 		log.Fatalf("failed to parse duration string for command line flag sesh=%v; see https://golang.org/pkg/time/#ParseDuration", *sessionTimeout)
 	}
 
+	trial, err := time.ParseDuration(*trialDuration)
+	if err != nil {
+		log.Fatalf("failed to parse duration string for command line flag trial=%v; see https://golang.org/pkg/time/#ParseDuration", *trialDuration)
+	}
+
+	grace, err := time.ParseDuration(*deletionGrace)
+	if err != nil {
+		log.Fatalf("failed to parse duration string for command line flag deletion-grace=%v; see https://golang.org/pkg/time/#ParseDuration", *deletionGrace)
+	}
+
+	lockout, err := time.ParseDuration(*lockoutDuration)
+	if err != nil {
+		log.Fatalf("failed to parse duration string for command line flag login-lockout-duration=%v; see https://golang.org/pkg/time/#ParseDuration", *lockoutDuration)
+	}
+
+	rateLimits := ratelimit.DefaultConfig()
+	if *rateLimitConfig != "" {
+		rateLimits, err = ratelimit.LoadConfig(*rateLimitConfig)
+		if err != nil {
+			log.Fatalf("failed to load rate limit config %v: %v", *rateLimitConfig, err)
+		}
+	}
+
+	if *argon2Threads == 0 || *argon2Threads > 255 {
+		log.Fatalf("argon2-threads=%v must be from 1 to 255", *argon2Threads)
+	}
+	hasher := auth.PasswordHasher{
+		Algorithm:     *passwordHash,
+		BcryptCost:    *bcryptCost,
+		Argon2Time:    uint32(*argon2Time),
+		Argon2Memory:  uint32(*argon2Memory),
+		Argon2Threads: uint8(*argon2Threads),
+	}
+	if err := hasher.Validate(); err != nil {
+		log.Fatalf("invalid password hashing parameters: %v", err)
+	}
+
+	policy := auth.PasswordPolicy{MinLength: *passwordMinLength, MinStrength: *passwordMinStrength}
+	if *breachedPasswords != "" {
+		breached, err := auth.OpenHashPrefixFile(*breachedPasswords)
+		if err != nil {
+			log.Fatalf("failed to open breached passwords list %v: %v", *breachedPasswords, err)
+		}
+		defer breached.Close()
+		policy.Breached = breached
+	}
+
+	if *publicURL == "" {
+		*publicURL = fmt.Sprintf("https://localhost:%v", *port)
+	}
+
+	if *oidcRedirectURL == "" {
+		*oidcRedirectURL = strings.TrimSuffix(*publicURL, "/") + "/sso"
+	}
+	if *oidcIssuer != "" && *oidcClientID == "" {
+		log.Fatal("-oidc-client-id must be set with -oidc-issuer")
+	}
+
+	if *unlock != "" {
+		if *env == "dev" {
+			log.Fatal("-unlock can't be used with -env=dev, the dev database is reset on every restart")
+		}
+		db, err := database.New(database.Config{Env: *env})
+		if err != nil {
+			log.Fatal(err)
+		}
+		unlocked, err := db.UnlockLogin(*unlock)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if !unlocked {
+			log.Printf("%v had no failed logins", *unlock)
+			return
+		}
+		log.Printf("unlocked logins for %v", *unlock)
+		return
+	}
+
+	if *addStaff != "" {
+		if *env == "dev" {
+			log.Fatal("-add-staff can't be used with -env=dev, the dev database is reset on every restart")
+		}
+		db, err := database.New(database.Config{Env: *env})
+		if err != nil {
+			log.Fatal(err)
+		}
+		key, err := auth.NewKey()
+		if err != nil {
+			log.Fatal(err)
+		}
+		if _, err := db.CreateStaff(*addStaff, key); err != nil {
+			log.Fatal(err)
+		}
+		log.Printf("created admin API key for %v, it won't be shown again: %v", *addStaff, key)
+		return
+	}
+
 	cfg := server.Config{
 		Port:           *port,
 		CertFilePath:   *certFilePath,
 		KeyFilePath:    *keyFilePath,
 		SessionTimeout: timeout,
-		Env:            *env}
+		Env:            *env,
+		TrialDuration:  trial,
+		DeletionGrace:  grace,
+		PublicURL:      strings.TrimSuffix(*publicURL, "/"),
+		SMTP: notify.SMTPConfig{
+			Addr:     *smtpAddr,
+			From:     *smtpFrom,
+			Username: *smtpUser,
+			Password: os.Getenv("SMTP_PASSWORD"),
+		},
+		NotifyFile: *notifyFile,
+		LoginThrottle: auth.LoginThrottleConfig{
+			FreeFailures:          3,
+			BaseDelay:             time.Second,
+			MaxDelay:              30 * time.Second,
+			EmailLockoutThreshold: *lockoutThreshold,
+			IPLockoutThreshold:    *ipLockoutThreshold,
+			LockoutDuration:       lockout,
+		},
+		RateLimit:      rateLimits,
+		PasswordPolicy: policy,
+		PasswordHasher: hasher,
+		InviteSecret:   []byte(os.Getenv("INVITE_SECRET")),
+		SigningSecret:  []byte(os.Getenv("SIGNING_SECRET")),
+		OIDC: oidc.Config{
+			Issuer:       *oidcIssuer,
+			ClientID:     *oidcClientID,
+			ClientSecret: os.Getenv("OIDC_CLIENT_SECRET"),
+			RedirectURL:  *oidcRedirectURL,
+		}}
 	srv, err := server.New(cfg)
 	if err != nil {
 		log.Fatal(err)
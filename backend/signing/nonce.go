@@ -0,0 +1,85 @@
+package signing
+
+import (
+	"container/list"
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrReplayed is returned by NonceCache.Use for nonces that have already been used, or that can't be
+// told apart from ones that have
+var ErrReplayed = errors.New("request nonce has already been used")
+
+type nonceEntry struct {
+	key       string
+	timestamp time.Time
+}
+
+// NonceCache remembers the nonces of recently signed requests so that they can't be replayed. It holds at
+// most size nonces. Once it's full the oldest is forgotten to make room, and from then on requests signed
+// no later than it are refused, since the cache can no longer tell whether they're replays. A cache big
+// enough for every request made within MaxSkew never has to refuse a legitimate request. Like
+// SessionManager, the app should only ever create one of these and pass it around as a pointer
+type NonceCache struct {
+	size    int
+	entries map[string]*list.Element
+	order   *list.List // oldest first
+	floor   time.Time  // requests signed at or before floor are refused
+	mtx     sync.Mutex // mutex for entries, order and floor
+}
+
+// NewNonceCache creates a new *NonceCache holding up to size nonces
+func NewNonceCache(size int) *NonceCache {
+	if size < 1 {
+		size = 1
+	}
+	return &NonceCache{
+		size:    size,
+		entries: make(map[string]*list.Element),
+		order:   list.New(),
+	}
+}
+
+// Use records that the request signed at timestamp with nonce has been made by the client identified by
+// clientID (so different clients' nonces can't collide), returning ErrReplayed if it might have been made before.
+func (nc *NonceCache) Use(clientID, nonce string, timestamp time.Time) error {
+	key := clientID + "|" + nonce
+
+	nc.mtx.Lock()
+	defer nc.mtx.Unlock()
+
+	if _, ok := nc.entries[key]; ok || !timestamp.After(nc.floor) {
+		return ErrReplayed
+	}
+	for nc.order.Len() >= nc.size {
+		nc.evict(nc.order.Front())
+	}
+	nc.entries[key] = nc.order.PushBack(nonceEntry{key, timestamp})
+	return nil
+}
+
+// evict forgets e, raising the floor so that its request can't be replayed
+func (nc *NonceCache) evict(e *list.Element) {
+	entry := nc.order.Remove(e).(nonceEntry)
+	delete(nc.entries, entry.key)
+	if entry.timestamp.After(nc.floor) {
+		nc.floor = entry.timestamp
+	}
+}
+
+// Prune forgets nonces of requests signed more than MaxSkew before now, which would be refused as stale anyway
+func (nc *NonceCache) Prune(now time.Time) {
+	nc.mtx.Lock()
+	defer nc.mtx.Unlock()
+
+	cutoff := now.Add(-MaxSkew)
+	for e := nc.order.Front(); e != nil; {
+		next := e.Next()
+		if e.Value.(nonceEntry).timestamp.Before(cutoff) {
+			entry := nc.order.Remove(e).(nonceEntry)
+			delete(nc.entries, entry.key)
+		}
+		e = next
+	}
+}
@@ -0,0 +1,53 @@
+package signing
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNonceCache(t *testing.T) {
+	now := time.Now()
+	nc := NewNonceCache(2)
+
+	if err := nc.Use("client", "a", now); err != nil {
+		t.Fatal(err)
+	}
+	if err := nc.Use("client", "a", now); err != ErrReplayed {
+		t.Fatalf("expected a reused nonce to be refused, got %v", err)
+	}
+	if err := nc.Use("other", "a", now); err != nil {
+		t.Fatalf("expected another client's nonce not to collide, got %v", err)
+	}
+
+	// The cache is full, so "a" is forgotten and requests signed no later than it are refused
+	if err := nc.Use("client", "b", now.Add(time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	if err := nc.Use("client", "a", now); err != ErrReplayed {
+		t.Fatalf("expected a forgotten nonce to be refused, got %v", err)
+	}
+	if err := nc.Use("client", "c", now); err != ErrReplayed {
+		t.Fatalf("expected a request signed before the floor to be refused, got %v", err)
+	}
+	if err := nc.Use("client", "c", now.Add(2*time.Second)); err != nil {
+		t.Fatalf("expected a request signed after the floor to be accepted, got %v", err)
+	}
+}
+
+func TestNonceCachePrune(t *testing.T) {
+	now := time.Now()
+	nc := NewNonceCache(10)
+	if err := nc.Use("client", "a", now); err != nil {
+		t.Fatal(err)
+	}
+
+	nc.Prune(now.Add(MaxSkew))
+	if err := nc.Use("client", "a", now); err != ErrReplayed {
+		t.Fatalf("expected a nonce that could still be replayed to be kept, got %v", err)
+	}
+
+	nc.Prune(now.Add(MaxSkew + time.Second))
+	if len(nc.entries) != 0 || nc.order.Len() != 0 {
+		t.Fatalf("expected stale nonces to be pruned, %v left", nc.order.Len())
+	}
+}
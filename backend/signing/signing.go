@@ -0,0 +1,156 @@
+// Package signing implements signed API key requests, which can't be replayed the way a bearer API key
+// can if it's captured. Unlike the rest of the backend this package isn't internal, so that Go clients
+// can import it to sign their requests with SignRequest.
+//
+// A signed request carries the headers below instead of an Authorization header. Its signature is the
+// hex encoded HMAC-SHA256 of
+//
+//	<method>\n<request URI>\n<timestamp>\n<nonce>\n<hex encoded SHA-256 of the body>
+//
+// keyed with the signing key issued for the API key, which is shown once when it's issued, like the API key
+// itself. The API key is never sent with a signed request. The server rejects requests whose timestamp is more than MaxSkew
+// from its own clock, and requests that reuse a nonce.
+package signing
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// SignatureHeader carries "sha256=" followed by the request's signature
+	SignatureHeader = "X-Request-Signature"
+	// TimestampHeader carries the unix time at which the request was signed
+	TimestampHeader = "X-Request-Timestamp"
+	// NonceHeader carries a random string that must be unique to the request
+	NonceHeader = "X-Request-Nonce"
+
+	// MaxSkew is how far a request's timestamp may be from the server's clock
+	MaxSkew = 5 * time.Minute
+
+	// MaxNonceLength is the longest nonce the server accepts
+	MaxNonceLength = 64
+
+	signaturePrefix = "sha256="
+)
+
+var (
+	// ErrNotSigned is returned by ParseHeaders for requests without a signature
+	ErrNotSigned = errors.New("request is not signed")
+
+	// ErrBadHeaders is returned by ParseHeaders for requests whose signature headers are malformed
+	ErrBadHeaders = errors.New("malformed request signature headers")
+
+	// ErrStale is returned by Headers.Check for requests whose timestamp is more than MaxSkew from now
+	ErrStale = errors.New("request timestamp is too far from the server's time")
+
+	// ErrBadSignature is returned by Headers.Check for requests whose signature doesn't match
+	ErrBadSignature = errors.New("request signature doesn't match")
+)
+
+// Sign returns the hex encoded signature of a request, keyed with signingKey
+func Sign(signingKey, method, requestURI string, timestamp int64, nonce string, body []byte) string {
+	bodyHash := sha256.Sum256(body)
+	mac := hmac.New(sha256.New, []byte(signingKey))
+	fmt.Fprintf(mac, "%s\n%s\n%d\n%s\n%x", method, requestURI, timestamp, nonce, bodyHash)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// newNonce returns a random 16 byte nonce, hex encoded
+func newNonce() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// SignRequest signs req with signingKey at now, setting its signature headers. req's body is read and replaced
+// so that it can still be sent. Every call uses a new nonce, so a request has to be signed again to be retried.
+func SignRequest(req *http.Request, signingKey string, now time.Time) error {
+	var body []byte
+	if req.Body != nil {
+		b, err := ioutil.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return err
+		}
+		body = b
+		req.Body = ioutil.NopCloser(bytes.NewReader(body))
+		req.GetBody = func() (io.ReadCloser, error) { return ioutil.NopCloser(bytes.NewReader(body)), nil }
+	}
+
+	nonce, err := newNonce()
+	if err != nil {
+		return err
+	}
+	timestamp := now.Unix()
+	signature := Sign(signingKey, req.Method, req.URL.RequestURI(), timestamp, nonce, body)
+
+	req.Header.Set(TimestampHeader, strconv.FormatInt(timestamp, 10))
+	req.Header.Set(NonceHeader, nonce)
+	req.Header.Set(SignatureHeader, signaturePrefix+signature)
+	return nil
+}
+
+// IsSigned reports whether r claims to be a signed request
+func IsSigned(r *http.Request) bool {
+	return r.Header.Get(SignatureHeader) != ""
+}
+
+// Headers are a signed request's signature headers
+type Headers struct {
+	Signature string
+	Timestamp int64
+	Nonce     string
+}
+
+// ParseHeaders returns r's signature headers. Returns ErrNotSigned if r has no signature,
+// or ErrBadHeaders if any of the headers are missing or malformed.
+func ParseHeaders(r *http.Request) (Headers, error) {
+	sig := r.Header.Get(SignatureHeader)
+	if sig == "" {
+		return Headers{}, ErrNotSigned
+	}
+	if !strings.HasPrefix(sig, signaturePrefix) {
+		return Headers{}, ErrBadHeaders
+	}
+	timestamp, err := strconv.ParseInt(r.Header.Get(TimestampHeader), 10, 64)
+	if err != nil {
+		return Headers{}, ErrBadHeaders
+	}
+	nonce := r.Header.Get(NonceHeader)
+	if nonce == "" || len(nonce) > MaxNonceLength {
+		return Headers{}, ErrBadHeaders
+	}
+	return Headers{strings.TrimPrefix(sig, signaturePrefix), timestamp, nonce}, nil
+}
+
+// Time returns the time the request was signed at
+func (h Headers) Time() time.Time {
+	return time.Unix(h.Timestamp, 0)
+}
+
+// Check checks that the request with headers h was signed with signingKey, for method, requestURI and body,
+// within MaxSkew of now. It doesn't check the nonce, which the caller must check hasn't been used before.
+func (h Headers) Check(signingKey, method, requestURI string, body []byte, now time.Time) error {
+	if d := now.Sub(h.Time()); d > MaxSkew || d < -MaxSkew {
+		return ErrStale
+	}
+	expected := Sign(signingKey, method, requestURI, h.Timestamp, h.Nonce, body)
+	if !hmac.Equal([]byte(expected), []byte(h.Signature)) {
+		return ErrBadSignature
+	}
+	return nil
+}
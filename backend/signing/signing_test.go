@@ -0,0 +1,83 @@
+package signing
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testKey = "c2lnbmluZy10ZXN0LWtleS0wMTIzNDU2Nzg5YWJjZGVm"
+
+func signedRequest(t *testing.T, body string, now time.Time) *http.Request {
+	t.Helper()
+	req := httptest.NewRequest("POST", "/api/metrics?x=1", strings.NewReader(body))
+	if err := SignRequest(req, testKey, now); err != nil {
+		t.Fatal(err)
+	}
+	return req
+}
+
+func TestSignRequest(t *testing.T) {
+	now := time.Now()
+	req := signedRequest(t, `{"account_id":"a"}`, now)
+
+	// The body must still be readable after signing
+	body, err := ioutil.ReadAll(req.Body)
+	if err != nil || string(body) != `{"account_id":"a"}` {
+		t.Fatalf("expected body to be preserved, got %q, %v", body, err)
+	}
+
+	h, err := ParseHeaders(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := h.Check(testKey, "POST", "/api/metrics?x=1", body, now); err != nil {
+		t.Fatalf("expected signature to check out, got %v", err)
+	}
+
+	tests := []struct {
+		name       string
+		key        string
+		method     string
+		requestURI string
+		body       string
+		now        time.Time
+		err        error
+	}{
+		{"wrong key", "other", "POST", "/api/metrics?x=1", `{"account_id":"a"}`, now, ErrBadSignature},
+		{"wrong method", testKey, "PUT", "/api/metrics?x=1", `{"account_id":"a"}`, now, ErrBadSignature},
+		{"wrong path", testKey, "POST", "/api/metrics?x=2", `{"account_id":"a"}`, now, ErrBadSignature},
+		{"tampered body", testKey, "POST", "/api/metrics?x=1", `{"account_id":"b"}`, now, ErrBadSignature},
+		{"stale", testKey, "POST", "/api/metrics?x=1", `{"account_id":"a"}`, now.Add(MaxSkew + time.Second), ErrStale},
+		{"future", testKey, "POST", "/api/metrics?x=1", `{"account_id":"a"}`, now.Add(-MaxSkew - time.Second), ErrStale},
+	}
+	for _, tt := range tests {
+		err := h.Check(tt.key, tt.method, tt.requestURI, []byte(tt.body), tt.now)
+		if !errors.Is(err, tt.err) {
+			t.Errorf("%v: expected %v, got %v", tt.name, tt.err, err)
+		}
+	}
+
+	if other := signedRequest(t, `{"account_id":"a"}`, now); other.Header.Get(NonceHeader) == req.Header.Get(NonceHeader) {
+		t.Fatal("expected every signed request to get a new nonce")
+	}
+}
+
+func TestParseHeaders(t *testing.T) {
+	req := httptest.NewRequest("POST", "/api/metrics", nil)
+	if _, err := ParseHeaders(req); err != ErrNotSigned {
+		t.Fatalf("expected ErrNotSigned, got %v", err)
+	}
+
+	for _, header := range []string{SignatureHeader, TimestampHeader, NonceHeader} {
+		req := signedRequest(t, "{}", time.Now())
+		req.Header.Set(header, "not valid"+strings.Repeat("x", MaxNonceLength))
+		if _, err := ParseHeaders(req); err != ErrBadHeaders {
+			t.Errorf("expected ErrBadHeaders for a malformed %v, got %v", header, err)
+		}
+	}
+}
@@ -9,8 +9,10 @@ function checkStatus(response) {
   throw error;
 }
 
-function parseJSON(response) {
-  return response.json();
+async function parseJSON(response) {
+  // Some endpoints respond with no body (e.g. 202 Accepted or 204 No Content)
+  const text = await response.text();
+  return text ? JSON.parse(text) : null;
 }
 
 function getBearerTokenHeader() {
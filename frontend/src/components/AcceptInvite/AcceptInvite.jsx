@@ -0,0 +1,58 @@
+import React, { useContext } from 'react';
+import { Redirect } from 'react-router-dom';
+import api from '../../api';
+import { StoreContext } from '../../store';
+
+// AcceptInvite is opened from the link in an invite email (with a ?token= query parameter). It asks the
+// invited person for a password, then logs them in as a new member of the account.
+const AcceptInvite = () => {
+  const token = new URLSearchParams(window.location.search).get('token');
+  const { store, setStore } = useContext(StoreContext);
+
+  const tryAccept = async e => {
+    // Form validation is handled by html5
+    e.preventDefault();
+    try {
+      const response = await api.post('/invites/accept', {
+        token,
+        password: e.target.password.value,
+      });
+      setStore(response);
+    } catch (error) {
+      // TODO: alert user that the invite has expired or the password is too short, remove console error
+      // eslint-disable-next-line no-console
+      console.error(error);
+    }
+  };
+
+  if (store && store.sessionID) {
+    return <Redirect to="/dashboard" />;
+  }
+
+  if (!token) {
+    return <Redirect to="/login" />;
+  }
+
+  return (
+    <form className="login-form" onSubmit={tryAccept}>
+      <h1>Accept Your Invite</h1>
+
+      <div>
+        <label htmlFor="password">Choose a Password</label>
+        <input
+          type="password"
+          id="password"
+          className="field"
+          autoComplete="new-password"
+          name="password"
+          minLength={8}
+          required
+        />
+      </div>
+
+      <input type="submit" value="Join" className="button block" />
+    </form>
+  );
+};
+
+export default AcceptInvite;
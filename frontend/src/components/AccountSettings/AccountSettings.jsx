@@ -0,0 +1,115 @@
+import React, { useState } from 'react';
+import api from '../../api';
+
+// AccountSettings lets a logged in account change its password or email address
+const AccountSettings = () => {
+  // message is shown above the forms after either of them is submitted
+  const [message, setMessage] = useState(null);
+
+  const showError = async error => {
+    // The API's error messages explain what was wrong with the request (e.g. password too short)
+    try {
+      const body = await error.response.json();
+      setMessage({ isError: true, text: body.error.message });
+    } catch (e) {
+      setMessage({ isError: true, text: 'Something went wrong' });
+    }
+  };
+
+  const changePassword = async e => {
+    // Form validation is handled by html5
+    e.preventDefault();
+    const form = e.target;
+    try {
+      await api.post('/account/password', {
+        currentPassword: form.currentPassword.value,
+        newPassword: form.newPassword.value,
+      });
+      form.reset();
+      setMessage({ isError: false, text: 'Your password has been changed.' });
+    } catch (error) {
+      showError(error);
+    }
+  };
+
+  const changeEmail = async e => {
+    e.preventDefault();
+    const form = e.target;
+    try {
+      await api.post('/account/email', {
+        password: form.password.value,
+        newEmail: form.newEmail.value,
+      });
+      form.reset();
+      setMessage({
+        isError: false,
+        text: 'Check your new email address for a link to confirm the change.',
+      });
+    } catch (error) {
+      showError(error);
+    }
+  };
+
+  return (
+    <div className="plan settings">
+      <header>Account Settings</header>
+
+      {message ? (
+        <div className={`alert ${message.isError ? 'is-error' : 'is-success'}`}>
+          {message.text}
+        </div>
+      ) : null}
+
+      <div className="plan-content">
+        <form onSubmit={changePassword}>
+          <h3>Change Password</h3>
+          <label htmlFor="currentPassword">Current Password</label>
+          <input
+            type="password"
+            id="currentPassword"
+            className="field"
+            autoComplete="current-password"
+            name="currentPassword"
+            required
+          />
+          <label htmlFor="newPassword">New Password</label>
+          <input
+            type="password"
+            id="newPassword"
+            className="field"
+            autoComplete="new-password"
+            name="newPassword"
+            minLength={8}
+            required
+          />
+          <input type="submit" value="Change Password" className="button" />
+        </form>
+
+        <form onSubmit={changeEmail}>
+          <h3>Change Email Address</h3>
+          <label htmlFor="newEmail">New Email Address</label>
+          <input
+            type="email"
+            id="newEmail"
+            className="field"
+            autoComplete="email"
+            name="newEmail"
+            required
+          />
+          <label htmlFor="emailPassword">Current Password</label>
+          <input
+            type="password"
+            id="emailPassword"
+            className="field"
+            autoComplete="current-password"
+            name="password"
+            required
+          />
+          <input type="submit" value="Change Email" className="button" />
+        </form>
+      </div>
+    </div>
+  );
+};
+
+export default AccountSettings;
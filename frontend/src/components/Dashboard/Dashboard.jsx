@@ -2,6 +2,7 @@ import React, { useContext, useState } from 'react';
 import api from '../../api';
 import { StoreContext } from '../../store';
 import { useInterval } from '../../hooks';
+import AccountSettings from '../AccountSettings';
 
 function Dashboard() {
   const { setStore } = useContext(StoreContext);
@@ -14,6 +15,7 @@ function Dashboard() {
     plan: 'ENTERPRISE', // TODO: make global const
     maxUsers: 1000, // TODO: make global const
     totalUsers: 0,
+    trialEndsAt: null,
   });
 
   const [showUpgradedBanner, setShowUpgradedBanner] = useState(false);
@@ -100,6 +102,13 @@ function Dashboard() {
           upgrade your plan to increase the limit.
         </div>
       ) : null}
+      {state.trialEndsAt ? (
+        <div className="alert">
+          Your Enterprise trial ends on{' '}
+          {new Date(state.trialEndsAt).toLocaleDateString()}, upgrade to keep
+          your Enterprise plan.
+        </div>
+      ) : null}
       {showUpgradedBanner ? (
         <div className="alert is-success">
           Your account has been upgraded successfully!
@@ -109,7 +118,9 @@ function Dashboard() {
         {state.plan === 'FREE' ? (
           <header>Startup Plan - $100/Month</header>
         ) : (
-          <header>Enterprise Plan - $1000/Month</header>
+          <header>
+            Enterprise Plan - {state.trialEndsAt ? 'Free Trial' : '$1000/Month'}
+          </header>
         )}
 
         <div className="plan-content">
@@ -130,7 +141,7 @@ function Dashboard() {
         </div>
 
         <footer>
-          {state.plan === 'FREE' ? (
+          {state.plan === 'FREE' || state.trialEndsAt ? (
             <button
               className="button is-success"
               type="button"
@@ -141,6 +152,7 @@ function Dashboard() {
           ) : null}
         </footer>
       </div>
+      <AccountSettings />
     </div>
   );
 }
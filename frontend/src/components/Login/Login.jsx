@@ -1,20 +1,27 @@
-import React, { useContext } from 'react';
+import React, { useContext, useState } from 'react';
 import { Redirect } from 'react-router-dom';
 import api from '../../api';
 import { StoreContext } from '../../store';
+import LoginChallenge from '../LoginChallenge';
 
 const Login = () => {
   const { store, setStore } = useContext(StoreContext);
+  // Set when the account has two-factor authentication enabled and a code is needed to finish logging in
+  const [challengeID, setChallengeID] = useState(null);
 
   const tryLogin = async e => {
     // Form validation is handled by html5
     e.preventDefault();
     try {
-      const newStore = await api.post('/login', {
+      const response = await api.post('/login', {
         email: e.target.email.value,
         password: e.target.password.value,
       });
-      setStore(newStore);
+      if (response.challengeID) {
+        setChallengeID(response.challengeID);
+        return;
+      }
+      setStore(response);
     } catch (error) {
       // TODO: check for Unauthorized and alert user that username/pwd is incorrect, remove console error
       // eslint-disable-next-line no-console
@@ -22,8 +29,15 @@ const Login = () => {
     }
   };
 
-  return store && store.sessionID ? (
-    <Redirect to="/dashboard" />
+  if (store && store.sessionID) {
+    return <Redirect to="/dashboard" />;
+  }
+
+  return challengeID ? (
+    <LoginChallenge
+      challengeID={challengeID}
+      onExpired={() => setChallengeID(null)}
+    />
   ) : (
     <form className="login-form" onSubmit={tryLogin}>
       <h1>Sign Into Your Account</h1>
@@ -57,6 +71,14 @@ const Login = () => {
         value="Login to my Dashboard"
         className="button block"
       />
+
+      <p>
+        <a href="/reset-password">Forgot your password?</a>
+      </p>
+
+      <p>
+        <a href="/sso">Log in with single sign-on</a>
+      </p>
     </form>
   );
 };
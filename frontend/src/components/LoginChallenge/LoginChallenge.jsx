@@ -0,0 +1,53 @@
+import React, { useContext } from 'react';
+import api from '../../api';
+import { StoreContext } from '../../store';
+
+// LoginChallenge asks for a two-factor code to finish logging in to an account with two-factor
+// authentication enabled. onExpired is called when the login has to start over.
+// eslint-disable-next-line react/prop-types
+const LoginChallenge = ({ challengeID, onExpired }) => {
+  const { setStore } = useContext(StoreContext);
+
+  const tryCode = async e => {
+    e.preventDefault();
+    try {
+      const newStore = await api.post('/login/2fa', {
+        challengeID,
+        code: e.target.code.value,
+      });
+      setStore(newStore);
+    } catch (error) {
+      if (error.response && error.response.status === 401) {
+        // The challenge may have expired or had too many wrong codes, start over
+        // TODO: alert user that the code is incorrect instead of always starting over
+        onExpired();
+      }
+      // eslint-disable-next-line no-console
+      console.error(error);
+    }
+  };
+
+  return (
+    <form className="login-form" onSubmit={tryCode}>
+      <h1>Two-Factor Authentication</h1>
+
+      <div>
+        <label htmlFor="code">
+          Enter the code from your authenticator app, or a recovery code
+        </label>
+        <input
+          type="text"
+          id="code"
+          className="field"
+          autoComplete="one-time-code"
+          name="code"
+          required
+        />
+      </div>
+
+      <input type="submit" value="Verify" className="button block" />
+    </form>
+  );
+};
+
+export default LoginChallenge;
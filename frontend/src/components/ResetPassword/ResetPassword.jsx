@@ -0,0 +1,95 @@
+import React, { useState } from 'react';
+import { Redirect } from 'react-router-dom';
+import api from '../../api';
+
+// ResetPassword asks for an email address to send a password reset link to, or, when opened from
+// that link (with a ?token= query parameter), asks for the new password.
+const ResetPassword = () => {
+  const token = new URLSearchParams(window.location.search).get('token');
+  const [sent, setSent] = useState(false);
+  const [reset, setReset] = useState(false);
+
+  const tryForgot = async e => {
+    // Form validation is handled by html5
+    e.preventDefault();
+    try {
+      await api.post('/password/forgot', { email: e.target.email.value });
+      setSent(true);
+    } catch (error) {
+      // eslint-disable-next-line no-console
+      console.error(error);
+    }
+  };
+
+  const tryReset = async e => {
+    e.preventDefault();
+    try {
+      await api.post('/password/reset', {
+        token,
+        password: e.target.password.value,
+      });
+      setReset(true);
+    } catch (error) {
+      // TODO: alert user that the link has expired or the password is too short, remove console error
+      // eslint-disable-next-line no-console
+      console.error(error);
+    }
+  };
+
+  if (reset) {
+    return <Redirect to="/login" />;
+  }
+
+  if (token) {
+    return (
+      <form className="login-form" onSubmit={tryReset}>
+        <h1>Choose a New Password</h1>
+
+        <div>
+          <label htmlFor="password">New Password</label>
+          <input
+            type="password"
+            id="password"
+            className="field"
+            autoComplete="new-password"
+            name="password"
+            minLength={8}
+            required
+          />
+        </div>
+
+        <input type="submit" value="Reset Password" className="button block" />
+      </form>
+    );
+  }
+
+  return sent ? (
+    <div className="login-form">
+      <h1>Check Your Email</h1>
+      <p>
+        If an account uses that address, we&apos;ve sent it a link to reset
+        its password.
+      </p>
+    </div>
+  ) : (
+    <form className="login-form" onSubmit={tryForgot}>
+      <h1>Reset Your Password</h1>
+
+      <div>
+        <label htmlFor="email">Email Address</label>
+        <input
+          type="email"
+          id="email"
+          className="field"
+          autoComplete="username"
+          name="email"
+          required
+        />
+      </div>
+
+      <input type="submit" value="Email Me a Link" className="button block" />
+    </form>
+  );
+};
+
+export default ResetPassword;
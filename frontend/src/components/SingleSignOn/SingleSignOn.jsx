@@ -0,0 +1,89 @@
+import React, { useContext, useEffect, useState } from 'react';
+import { Redirect } from 'react-router-dom';
+import api from '../../api';
+import { StoreContext } from '../../store';
+import LoginChallenge from '../LoginChallenge';
+
+// oidcStateKey is where the state of a login started in this tab is kept until the identity
+// provider redirects back, so that a login someone else started can't be finished here
+const oidcStateKey = 'oidcState';
+
+// SingleSignOn logs in with the identity provider. Opened without a ?code= query parameter it sends the
+// browser to the identity provider's login page, which redirects back here with a code to finish with.
+const SingleSignOn = () => {
+  const { store, setStore } = useContext(StoreContext);
+  const [status, setStatus] = useState('redirecting');
+  // Set when the account has two-factor authentication enabled and a code is needed to finish logging in
+  const [challengeID, setChallengeID] = useState(null);
+
+  useEffect(() => {
+    const start = async () => {
+      const { url, state } = await api.post('/login/oidc');
+      sessionStorage.setItem(oidcStateKey, state);
+      window.location.assign(url);
+    };
+
+    const finish = async (code, state) => {
+      const expected = sessionStorage.getItem(oidcStateKey);
+      sessionStorage.removeItem(oidcStateKey);
+      if (!state || state !== expected) {
+        setStatus('failed');
+        return;
+      }
+      const response = await api.post('/login/oidc/callback', { code, state });
+      if (response.challengeID) {
+        setChallengeID(response.challengeID);
+        return;
+      }
+      setStore(response);
+    };
+
+    const params = new URLSearchParams(window.location.search);
+    if (params.has('error')) {
+      // The identity provider refused the login, or the user cancelled it
+      setStatus('failed');
+      return;
+    }
+    const login = params.has('code')
+      ? finish(params.get('code'), params.get('state'))
+      : start();
+    login.catch(error => {
+      setStatus('failed');
+      // eslint-disable-next-line no-console
+      console.error(error);
+    });
+  }, []);
+
+  if (store && store.sessionID) {
+    return <Redirect to="/dashboard" />;
+  }
+
+  if (challengeID) {
+    return (
+      <LoginChallenge
+        challengeID={challengeID}
+        onExpired={() => {
+          setChallengeID(null);
+          setStatus('failed');
+        }}
+      />
+    );
+  }
+
+  return (
+    <div className="login-form">
+      <h1>
+        {status === 'redirecting'
+          ? 'Logging In With Single Sign-On'
+          : "Single Sign-On Didn't Work"}
+      </h1>
+      {status === 'failed' && (
+        <p>
+          <a href="/login">Back to login</a>
+        </p>
+      )}
+    </div>
+  );
+};
+
+export default SingleSignOn;
@@ -0,0 +1,45 @@
+import React, { useContext, useEffect, useState } from 'react';
+import { Redirect } from 'react-router-dom';
+import api from '../../api';
+import { StoreContext } from '../../store';
+
+// VerifyEmail confirms an email address change when opened from the link emailed to the new
+// address (with a ?token= query parameter). Should be wrapped with <Authenticated>.
+const VerifyEmail = () => {
+  const { setStore } = useContext(StoreContext);
+  const [status, setStatus] = useState('verifying');
+
+  useEffect(() => {
+    const verify = async () => {
+      const token = new URLSearchParams(window.location.search).get('token');
+      try {
+        await api.post('/account/email/verify', { token });
+        setStatus('verified');
+      } catch (error) {
+        if (error.response && error.response.status === 401) {
+          // Session timed out, <Authenticated> will redirect the user to the login page
+          setStore(null);
+          return;
+        }
+        setStatus('failed');
+      }
+    };
+    verify();
+  }, []);
+
+  if (status === 'verified') {
+    return <Redirect to="/dashboard" />;
+  }
+
+  return (
+    <div className="login-form">
+      <h1>
+        {status === 'verifying'
+          ? 'Verifying Your Email Address'
+          : 'This Link Has Expired or Was Already Used'}
+      </h1>
+    </div>
+  );
+};
+
+export default VerifyEmail;
@@ -9,6 +9,10 @@ import {
 import Login from './components/Login';
 import Dashboard from './components/Dashboard';
 import Authenticated from './components/Authenticated';
+import ResetPassword from './components/ResetPassword';
+import VerifyEmail from './components/VerifyEmail';
+import SingleSignOn from './components/SingleSignOn';
+import AcceptInvite from './components/AcceptInvite';
 import './index.css';
 import { AppContext } from './store';
 
@@ -23,6 +27,20 @@ const App = () => {
           <Route path="/login">
             <Login />
           </Route>
+          <Route path="/sso">
+            <SingleSignOn />
+          </Route>
+          <Route path="/reset-password">
+            <ResetPassword />
+          </Route>
+          <Route path="/accept-invite">
+            <AcceptInvite />
+          </Route>
+          <Route path="/verify-email">
+            <Authenticated>
+              <VerifyEmail />
+            </Authenticated>
+          </Route>
           <Route path="/dashboard">
             <Authenticated>
               <Dashboard />